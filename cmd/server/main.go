@@ -2,45 +2,78 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"os"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/zzenonn/go-zenon-api-aws/internal/config"
 	"github.com/zzenonn/go-zenon-api-aws/internal/factory"
 	"github.com/zzenonn/go-zenon-api-aws/internal/logging"
+	"github.com/zzenonn/go-zenon-api-aws/internal/tracing"
 )
 
-// Instantiate and startup go app
-func Run() error {
+// Instantiate and startup go app. Migrations are applied before serving
+// unless autoMigrate is false, in which case they are run with zenonctl.
+// Run returns once the server has shut down after SIGINT or SIGTERM, or with
+// an error if it could not start or stopped serving.
+func Run(autoMigrate bool) error {
 	cfg, err := config.LoadConfig()
 	if err != nil {
-		log.Fatalf("Error loading configuration: %v", err)
+		return fmt.Errorf("error loading configuration: %w", err)
 	}
 
 	logging.InitLogger(cfg)
 	log.Println("starting up the application")
 
+	shutdownTracing, err := tracing.Init(context.Background(), cfg.TraceExporter, cfg.OTLPEndpoint, os.Stdout)
+	if err != nil {
+		return err
+	}
+	// Flush the spans of requests served before shutdown
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Error("Error shutting down tracing: ", err)
+		}
+	}()
+
 	// Factory handles all dependency creation
 	handlerFactory, err := factory.NewHandlerFactory(cfg)
 	if err != nil {
 		return err
 	}
 
-	if err := handlerFactory.MigrateUp(context.Background()); err != nil {
-		return err
+	if autoMigrate {
+		if err := handlerFactory.MigrateUp(context.Background()); err != nil {
+			return err
+		}
+	} else {
+		log.Info("skipping database migrations")
 	}
 
 	mainHandler := handlerFactory.CreateMainHandler()
+
+	// Abort multipart uploads that clients abandoned and delete blobs that
+	// are no longer referenced. The sweeper shares the handlers' file
+	// service, so it waits on uploads they are verifying instead of
+	// verifying them a second time.
+	if cfg.UploadSweepInterval > 0 {
+		go handlerFactory.CreateFileService().RunSweeper(context.Background(), cfg.UploadSweepInterval, cfg.UploadExpiry)
+	}
+
 	mainHandler.MapRoutes()
 
 	return mainHandler.Serve()
 }
 
 func main() {
+	skipMigrate := flag.Bool("skip-migrate", false, "do not apply database migrations on startup")
+	flag.Parse()
 
 	log.Info("the server is up")
 
-	if err := Run(); err != nil {
-		log.Error(err)
+	if err := Run(!*skipMigrate); err != nil {
+		log.Fatal(err)
 	}
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+)
+
+// blobsCollect deletes content-addressed blobs that no file refers to
+func blobsCollect(args []string) error {
+	fs := flag.NewFlagSet("blobs collect", flag.ExitOnError)
+	olderThan := fs.Duration("older-than", 0, "delete blobs unreferenced for longer than this (default BLOB_GRACE_PERIOD)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, handlerFactory, err := loadFactory()
+	if err != nil {
+		return err
+	}
+
+	if *olderThan == 0 {
+		*olderThan = cfg.BlobGracePeriod
+	}
+
+	collected, err := handlerFactory.CreateFileService().CollectBlobs(context.Background(), *olderThan)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("deleted %d unreferenced blobs\n", collected)
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	"github.com/zzenonn/go-zenon-api-aws/internal/integration"
+)
+
+// keysGenerate creates a P-384 key pair for ES384 token signing. It does not
+// load the full configuration because the keys it creates are a prerequisite
+// for config.LoadConfig.
+func keysGenerate(args []string) error {
+	fs := flag.NewFlagSet("keys generate", flag.ExitOnError)
+	outDir := fs.String("out-dir", ".", "directory to write private-key.pem and public-key.pem to")
+	push := fs.Bool("push", false, "store the keys in SSM Parameter Store")
+	overwrite := fs.Bool("overwrite", false, "overwrite existing files and SSM parameters")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	privateDer, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return fmt.Errorf("failed to marshal private key: %w", err)
+	}
+
+	publicDer, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to marshal public key: %w", err)
+	}
+
+	privatePem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privateDer})
+	publicPem := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer})
+
+	if err := writeKeyFile(filepath.Join(*outDir, "private-key.pem"), privatePem, 0600, *overwrite); err != nil {
+		return err
+	}
+
+	if err := writeKeyFile(filepath.Join(*outDir, "public-key.pem"), publicPem, 0644, *overwrite); err != nil {
+		return err
+	}
+
+	fmt.Printf("wrote key pair to %s\n", *outDir)
+
+	if !*push {
+		return nil
+	}
+
+	ctx := context.Background()
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to load AWS SDK config: %v", err)
+	}
+
+	ssmService, err := integration.NewAWSSSMService(awsCfg)
+	if err != nil {
+		return err
+	}
+
+	privatePath, publicPath := config.KeySecretPaths()
+
+	if err := ssmService.PutSecretValue(ctx, privatePath, string(privatePem), *overwrite); err != nil {
+		return err
+	}
+
+	if err := ssmService.PutSecretValue(ctx, publicPath, string(publicPem), *overwrite); err != nil {
+		return err
+	}
+
+	fmt.Printf("pushed key pair to SSM parameters %s and %s\n", privatePath, publicPath)
+	return nil
+}
+
+func writeKeyFile(path string, data []byte, perm os.FileMode, overwrite bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !overwrite {
+		flags |= os.O_EXCL
+	}
+
+	f, err := os.OpenFile(path, flags, perm)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return nil
+}
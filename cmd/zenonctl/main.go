@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	"github.com/zzenonn/go-zenon-api-aws/internal/factory"
+	"github.com/zzenonn/go-zenon-api-aws/internal/logging"
+)
+
+const usage = `zenonctl - administration tool for the go-zenon-api-aws service
+
+Usage:
+  zenonctl <command> <subcommand> [flags]
+
+Commands:
+  migrate up|down|status
+  user    create|disable|enable|reset-password|list|set-role
+  keys    generate
+  token   mint
+  uploads sweep
+  blobs   collect
+
+Run "zenonctl <command> <subcommand> -h" for the flags of a subcommand.
+`
+
+// command runs a subcommand with the remaining command line arguments
+type command func(args []string) error
+
+var commands = map[string]map[string]command{
+	"migrate": {
+		"up":     migrateUp,
+		"down":   migrateDown,
+		"status": migrateStatus,
+	},
+	"user": {
+		"create":         userCreate,
+		"disable":        userDisable,
+		"enable":         userEnable,
+		"reset-password": userResetPassword,
+		"list":           userList,
+		"set-role":       userSetRole,
+	},
+	"keys": {
+		"generate": keysGenerate,
+	},
+	"token": {
+		"mint": tokenMint,
+	},
+	"uploads": {
+		"sweep": uploadsSweep,
+	},
+	"blobs": {
+		"collect": blobsCollect,
+	},
+}
+
+// Run dispatches the command line to the matching subcommand
+func Run(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("missing command\n\n%s", usage)
+	}
+
+	subcommands, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q\n\n%s", args[0], usage)
+	}
+
+	cmd, ok := subcommands[args[1]]
+	if !ok {
+		return fmt.Errorf("unknown subcommand %q for %s\n\n%s", args[1], args[0], usage)
+	}
+
+	return cmd(args[2:])
+}
+
+// loadConfig loads the application configuration and initializes logging
+func loadConfig() (*config.Config, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error loading configuration: %w", err)
+	}
+
+	logging.InitLogger(cfg)
+
+	return cfg, nil
+}
+
+// loadFactory loads the application configuration and builds the handler factory
+func loadFactory() (*config.Config, *factory.HandlerFactory, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	handlerFactory, err := factory.NewHandlerFactory(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, handlerFactory, nil
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "help" {
+		fmt.Print(usage)
+		return
+	}
+
+	if err := Run(os.Args[1:]); err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+}
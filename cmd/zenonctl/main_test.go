@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+)
+
+// captureStdout runs fn and returns what it printed to standard output
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		output <- buf.String()
+	}()
+
+	runErr := fn()
+	w.Close()
+	return <-output, runErr
+}
+
+// offline points the configuration at in-memory backends
+func offline(t *testing.T) {
+	t.Setenv("STORAGE_BACKEND", "memory")
+	t.Setenv("LOG_LEVEL", "error")
+}
+
+func TestRunRejectsUnknownCommands(t *testing.T) {
+	tests := map[string]struct {
+		args []string
+		want string
+	}{
+		"no arguments":       {args: nil, want: "missing command"},
+		"missing subcommand": {args: []string{"user"}, want: "missing command"},
+		"unknown command":    {args: []string{"tables", "list"}, want: `unknown command "tables"`},
+		"unknown subcommand": {args: []string{"user", "delete"}, want: `unknown subcommand "delete" for user`},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := Run(tt.args)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.want)
+			assert.Contains(t, err.Error(), "Usage:")
+		})
+	}
+}
+
+func TestUsageListsEveryCommand(t *testing.T) {
+	for name, subcommands := range commands {
+		var line string
+		for _, l := range strings.Split(usage, "\n") {
+			if fields := strings.Fields(l); len(fields) > 0 && fields[0] == name {
+				line = l
+			}
+		}
+		require.NotEmpty(t, line, "usage does not list %s", name)
+
+		for subcommand := range subcommands {
+			assert.Contains(t, line, subcommand, "usage does not list %s %s", name, subcommand)
+		}
+	}
+}
+
+func TestRunValidatesFlagsBeforeLoadingConfig(t *testing.T) {
+	// An invalid backend makes any attempt to load the configuration fail,
+	// so these errors must come from flag validation
+	t.Setenv("STORAGE_BACKEND", "invalid")
+
+	tests := map[string]struct {
+		args []string
+		want error
+	}{
+		"user create without password":   {args: []string{"user", "create", "-username", "alice"}, want: errors.ErrMissingRequiredFields},
+		"user create with invalid role":  {args: []string{"user", "create", "-username", "alice", "-password", "secret", "-role", "root"}, want: errors.ErrInvalidRole},
+		"user disable without username":  {args: []string{"user", "disable"}, want: errors.ErrMissingRequiredFields},
+		"user enable without username":   {args: []string{"user", "enable"}, want: errors.ErrMissingRequiredFields},
+		"user reset-password without pw": {args: []string{"user", "reset-password", "-username", "alice"}, want: errors.ErrMissingRequiredFields},
+		"user set-role without role":     {args: []string{"user", "set-role", "-username", "alice"}, want: errors.ErrMissingRequiredFields},
+		"token mint without subject":     {args: []string{"token", "mint"}, want: errors.ErrMissingRequiredFields},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, Run(tt.args), tt.want)
+		})
+	}
+}
+
+func TestMigrateDownRequiresConfirmation(t *testing.T) {
+	t.Setenv("STORAGE_BACKEND", "invalid")
+
+	err := Run([]string{"migrate", "down"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "rerun with -yes")
+
+	// Confirming gets as far as loading the configuration
+	err = Run([]string{"migrate", "down", "-yes"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error loading configuration")
+}
+
+func TestRunDispatchesToServices(t *testing.T) {
+	offline(t)
+
+	output, err := captureStdout(t, func() error {
+		return Run([]string{"user", "create", "-username", "alice", "-password", "Secret123!", "-role", "admin"})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "created user alice with role admin\n", output)
+
+	output, err = captureStdout(t, func() error {
+		return Run([]string{"uploads", "sweep", "-older-than", "1h"})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "aborted 0 stale uploads\n", output)
+
+	output, err = captureStdout(t, func() error {
+		return Run([]string{"blobs", "collect"})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "deleted 0 unreferenced blobs\n", output)
+
+	// Each invocation starts with empty in-memory repositories
+	_, err = captureStdout(t, func() error {
+		return Run([]string{"user", "set-role", "-username", "alice", "-role", "user"})
+	})
+	assert.ErrorIs(t, err, errors.ErrUserNotFound)
+}
+
+func TestKeysGenerateAndTokenMint(t *testing.T) {
+	offline(t)
+	dir := t.TempDir()
+
+	output, err := captureStdout(t, func() error {
+		return Run([]string{"keys", "generate", "-out-dir", dir})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "wrote key pair to "+dir+"\n", output)
+
+	privateKey := filepath.Join(dir, "private-key.pem")
+	publicKey := filepath.Join(dir, "public-key.pem")
+
+	info, err := os.Stat(privateKey)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// Existing keys are kept unless overwriting is requested
+	_, err = captureStdout(t, func() error {
+		return Run([]string{"keys", "generate", "-out-dir", dir})
+	})
+	assert.ErrorIs(t, err, os.ErrExist)
+
+	_, err = captureStdout(t, func() error {
+		return Run([]string{"keys", "generate", "-out-dir", dir, "-overwrite"})
+	})
+	require.NoError(t, err)
+
+	t.Setenv("ECDSA_PRIVATE_KEY_FILE", privateKey)
+	t.Setenv("ECDSA_PUBLIC_KEY_FILE", publicKey)
+
+	output, err = captureStdout(t, func() error {
+		return Run([]string{"token", "mint", "-sub", "alice", "-ttl", "5m"})
+	})
+	require.NoError(t, err)
+
+	publicPem, err := os.ReadFile(publicKey)
+	require.NoError(t, err)
+	verifyKey, err := jwt.ParseECPublicKeyFromPEM(publicPem)
+	require.NoError(t, err)
+
+	token, err := jwt.Parse(strings.TrimSpace(output), func(*jwt.Token) (interface{}, error) {
+		return verifyKey, nil
+	})
+	require.NoError(t, err)
+
+	subject, err := token.Claims.GetSubject()
+	require.NoError(t, err)
+	assert.Equal(t, "alice", subject)
+}
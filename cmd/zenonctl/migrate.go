@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+func migrateUp(args []string) error {
+	if err := flag.NewFlagSet("migrate up", flag.ExitOnError).Parse(args); err != nil {
+		return err
+	}
+
+	_, handlerFactory, err := loadFactory()
+	if err != nil {
+		return err
+	}
+
+	return handlerFactory.MigrateUp(context.Background())
+}
+
+func migrateDown(args []string) error {
+	fs := flag.NewFlagSet("migrate down", flag.ExitOnError)
+	confirm := fs.Bool("yes", false, "confirm that tables and their data should be deleted")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if !*confirm {
+		return fmt.Errorf("migrate down deletes tables and all their data; rerun with -yes to confirm")
+	}
+
+	_, handlerFactory, err := loadFactory()
+	if err != nil {
+		return err
+	}
+
+	return handlerFactory.MigrateDown(context.Background())
+}
+
+func migrateStatus(args []string) error {
+	if err := flag.NewFlagSet("migrate status", flag.ExitOnError).Parse(args); err != nil {
+		return err
+	}
+
+	_, handlerFactory, err := loadFactory()
+	if err != nil {
+		return err
+	}
+
+	states, err := handlerFactory.MigrationStatus(context.Background())
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tTABLE\tSTATUS")
+	for _, state := range states {
+		status := "pending"
+		if state.Applied {
+			status = "applied"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", state.Version, state.TableName, status)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+	handlers "github.com/zzenonn/go-zenon-api-aws/internal/transport/http"
+)
+
+// tokenMint signs a token with the configured private key, for debugging
+func tokenMint(args []string) error {
+	fs := flag.NewFlagSet("token mint", flag.ExitOnError)
+	subject := fs.String("sub", "", "subject (username) of the token")
+	ttl := fs.Duration("ttl", time.Hour, "lifetime of the token")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *subject == "" {
+		return errors.ErrMissingRequiredFields
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	token, err := handlers.MintJwtToken(*subject, *ttl, cfg.ECDSAPrivateKey)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(token)
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+)
+
+// uploadsSweep aborts multipart uploads that were left incomplete
+func uploadsSweep(args []string) error {
+	fs := flag.NewFlagSet("uploads sweep", flag.ExitOnError)
+	olderThan := fs.Duration("older-than", 0, "abort uploads started longer ago than this (default UPLOAD_EXPIRY)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, handlerFactory, err := loadFactory()
+	if err != nil {
+		return err
+	}
+
+	if *olderThan == 0 {
+		*olderThan = cfg.UploadExpiry
+	}
+
+	swept, err := handlerFactory.CreateFileService().SweepUploads(context.Background(), *olderThan)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("aborted %d stale uploads\n", swept)
+	return nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"text/tabwriter"
+
+	"github.com/zzenonn/go-zenon-api-aws/internal/audit"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+)
+
+func userCreate(args []string) error {
+	fs := flag.NewFlagSet("user create", flag.ExitOnError)
+	username := fs.String("username", "", "username of the new user")
+	password := fs.String("password", "", "password of the new user")
+	role := fs.String("role", domain.RoleUser, "role of the new user (user or admin)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *username == "" || *password == "" {
+		return errors.ErrMissingRequiredFields
+	}
+
+	if !domain.IsValidRole(*role) {
+		return errors.ErrInvalidRole
+	}
+
+	_, handlerFactory, err := loadFactory()
+	if err != nil {
+		return err
+	}
+
+	userService := handlerFactory.CreateUserService()
+	ctx := adminContext()
+
+	if _, err := userService.CreateUser(ctx, domain.User{
+		Username: username,
+		Password: *password,
+	}); err != nil {
+		return err
+	}
+
+	if err := userService.SetRole(ctx, *username, *role); err != nil {
+		return err
+	}
+
+	fmt.Printf("created user %s with role %s\n", *username, *role)
+	return nil
+}
+
+func userDisable(args []string) error {
+	return setDisabled("user disable", args, true)
+}
+
+func userEnable(args []string) error {
+	return setDisabled("user enable", args, false)
+}
+
+func setDisabled(name string, args []string, disabled bool) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	username := fs.String("username", "", "username of the user")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *username == "" {
+		return errors.ErrMissingRequiredFields
+	}
+
+	_, handlerFactory, err := loadFactory()
+	if err != nil {
+		return err
+	}
+
+	if err := handlerFactory.CreateUserService().SetDisabled(adminContext(), *username, disabled); err != nil {
+		return err
+	}
+
+	if disabled {
+		fmt.Printf("disabled user %s\n", *username)
+	} else {
+		fmt.Printf("enabled user %s\n", *username)
+	}
+	return nil
+}
+
+func userResetPassword(args []string) error {
+	fs := flag.NewFlagSet("user reset-password", flag.ExitOnError)
+	username := fs.String("username", "", "username of the user")
+	password := fs.String("password", "", "new password")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *username == "" || *password == "" {
+		return errors.ErrMissingRequiredFields
+	}
+
+	_, handlerFactory, err := loadFactory()
+	if err != nil {
+		return err
+	}
+
+	if err := handlerFactory.CreateUserService().ResetPassword(adminContext(), *username, *password); err != nil {
+		return err
+	}
+
+	fmt.Printf("reset password for user %s\n", *username)
+	return nil
+}
+
+func userList(args []string) error {
+	fs := flag.NewFlagSet("user list", flag.ExitOnError)
+	pageSize := fs.Int("page-size", 50, "number of users to fetch per page")
+	nextToken := fs.String("next-token", "", "pagination token returned by a previous call")
+	all := fs.Bool("all", false, "fetch every page")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	_, handlerFactory, err := loadFactory()
+	if err != nil {
+		return err
+	}
+
+	userService := handlerFactory.CreateUserService()
+	ctx := adminContext()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "USERNAME\tROLE\tDISABLED\tPROFILE")
+
+	token := *nextToken
+	for {
+		users, next, err := userService.ListUsers(ctx, *pageSize, token)
+		if err != nil {
+			return err
+		}
+
+		for _, u := range users {
+			fmt.Fprintf(w, "%s\t%s\t%t\t%s\n", deref(u.Username), deref(u.Role), u.IsDisabled(), deref(u.ProfilePath))
+		}
+
+		token = next
+		if !*all || token == "" {
+			break
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if token != "" {
+		fmt.Printf("\nnext token: %s\n", token)
+	}
+	return nil
+}
+
+func userSetRole(args []string) error {
+	fs := flag.NewFlagSet("user set-role", flag.ExitOnError)
+	username := fs.String("username", "", "username of the user")
+	role := fs.String("role", "", "new role (user or admin)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *username == "" || *role == "" {
+		return errors.ErrMissingRequiredFields
+	}
+
+	_, handlerFactory, err := loadFactory()
+	if err != nil {
+		return err
+	}
+
+	if err := handlerFactory.CreateUserService().SetRole(adminContext(), *username, *role); err != nil {
+		return err
+	}
+
+	fmt.Printf("set role of user %s to %s\n", *username, *role)
+	return nil
+}
+
+// adminContext returns the context of changes made with zenonctl, which
+// the audit log attributes to the operating system user running it
+func adminContext() context.Context {
+	actor := "zenonctl"
+	if current, err := user.Current(); err == nil {
+		actor += ":" + current.Username
+	}
+	return audit.WithActor(context.Background(), actor)
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return "-"
+	}
+	return *s
+}
@@ -0,0 +1,37 @@
+// Package audit carries who makes a request, and from where, through its
+// context, so that events recorded in the audit log can be attributed
+package audit
+
+import "context"
+
+type actorKey struct{}
+
+type clientKey struct{}
+
+// Client describes where a request comes from
+type Client struct {
+	IP        string
+	UserAgent string
+}
+
+// WithActor returns a copy of ctx carrying the authenticated user
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns the authenticated user in ctx, or "" if there is none
+func Actor(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// WithClient returns a copy of ctx carrying the client of a request
+func WithClient(ctx context.Context, client Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// ClientFrom returns the client in ctx, which is empty outside of requests
+func ClientFrom(ctx context.Context) Client {
+	client, _ := ctx.Value(clientKey{}).(Client)
+	return client
+}
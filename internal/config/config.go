@@ -3,27 +3,168 @@ package config
 import (
 	"context"
 	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
 	"fmt"
 	"log"
+	"maps"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/zzenonn/go-zenon-api-aws/internal/integration"
+	"github.com/zzenonn/go-zenon-api-aws/internal/metrics"
+	"github.com/zzenonn/go-zenon-api-aws/internal/ratelimit"
+	"github.com/zzenonn/go-zenon-api-aws/internal/tracing"
 )
 
 // Config holds the application configuration
 type Config struct {
-	LogLevel        string
-	Port            int
-	ECDSAPrivateKey *ecdsa.PrivateKey
-	ECDSAPublicKey  *ecdsa.PublicKey
-	AwsConfig       aws.Config
-	DynamoDBTable   string
-	S3BucketName    string
+	// LogLevel is "trace", "debug", "info", "warn" or "error", and LogFormat
+	// is "text" or "json"
+	LogLevel  string
+	LogFormat string
+
+	Port             int
+	ECDSAPrivateKey  *ecdsa.PrivateKey
+	ECDSAPublicKey   *ecdsa.PublicKey
+	AwsConfig        aws.Config
+	DynamoDBTable    string
+	FilesTable       string
+	AuditTable       string
+	RateLimitTable   string
+	IdempotencyTable string
+	S3BucketName     string
+
+	// StorageBackend selects the repositories wired in by the factory:
+	// "aws" (DynamoDB and S3) or "memory" (in-process, for offline use)
+	StorageBackend string
+
+	// ProfileStorage selects where profile images and file attachments are
+	// kept: "s3", "filesystem" or "memory". It defaults to the store matching
+	// StorageBackend.
+	ProfileStorage string
+	FilesystemRoot string
+	MaxProfileSize int64
+
+	// Limits on file attachments. A quota of 0 means unlimited.
+	MaxFileSize int64
+	FileQuota   int64
+
+	// Multipart uploads of large attachments. Uploads left incomplete for
+	// longer than UploadExpiry are aborted every UploadSweepInterval; an
+	// interval of 0 disables the background sweeper.
+	MaxUploadSize       int64
+	UploadPartSize      int64
+	UploadExpiry        time.Duration
+	UploadSweepInterval time.Duration
+
+	// With ContentAddressing, identical file attachments share one object,
+	// which is deleted BlobGracePeriod after the last file referring to it
+	ContentAddressing bool
+	BlobGracePeriod   time.Duration
+
+	// Scanner selects the malware scanner uploads pass through: "none",
+	// "noop" (quarantine without scanning) or "clamd"
+	Scanner      string
+	ClamdAddress string
+	ScanTimeout  time.Duration
+
+	// Profile images are scaled down to ProfileMaxDimension and a thumbnail
+	// is stored for each of ProfileRenditionSizes
+	ProfileMaxDimension   int64
+	ProfileRenditionSizes []int
+
+	// ProfileVersions is how many profile image versions are kept per user;
+	// 0 keeps every version
+	ProfileVersions int64
+
+	// URLSigner selects how download URLs are signed: "s3" (presigned S3
+	// URLs), "cloudfront" or "hmac" (URLs served by the API itself)
+	URLSigner  string
+	PresignTTL time.Duration
+
+	// Settings for URLs signed and served by the API itself
+	URLSigningKey []byte
+	PublicBaseURL string
+
+	// Settings for the CloudFront URL signer. With CloudFrontSignedCookies,
+	// clients get signed cookies for their own objects instead of signed URLs.
+	CloudFrontURL           string
+	CloudFrontKeyPairID     string
+	CloudFrontPrivateKey    *rsa.PrivateKey
+	CloudFrontSignedCookies bool
+	CloudFrontCookieDomain  string
+
+	// TraceExporter selects where spans are sent: "none", "otlp" (to
+	// OTLPEndpoint over HTTP) or "stdout"
+	TraceExporter string
+	OTLPEndpoint  string
+
+	// Each readiness check is given HealthCheckTimeout, and the results are
+	// reused for HealthCacheTTL so frequent probes do not load dependencies
+	HealthCheckTimeout time.Duration
+	HealthCacheTTL     time.Duration
+
+	// RateLimitStore selects where request counts are kept: "none" (no rate
+	// limiting), "memory" (per instance) or "dynamodb" (shared by every
+	// replica). RouteRateLimits holds the policies of single routes, keyed
+	// by method and OpenAPI path; every other request counts against
+	// DefaultRateLimit. Client IPs are taken from X-Forwarded-For when
+	// TrustedProxies proxies sit in front of the API. Only the keys in
+	// RateLimitAPIKeys are counted by API key.
+	RateLimitStore   string
+	DefaultRateLimit ratelimit.Policy
+	RouteRateLimits  map[string]ratelimit.Policy
+	RateLimitAPIKeys map[string]bool
+	TrustedProxies   int64
+
+	// IdempotencyStore selects where responses to requests with an
+	// Idempotency-Key are kept: "none" (the header is ignored), "memory" (per
+	// instance) or "dynamodb" (shared by every replica). Responses are
+	// replayed for IdempotencyTTL; a request in progress holds its key for at
+	// most IdempotencyLockTimeout.
+	IdempotencyStore       string
+	IdempotencyTTL         time.Duration
+	IdempotencyLockTimeout time.Duration
+
+	// Timeouts of the HTTP server, as in http.Server; 0 disables one.
+	// RequestTimeout is the deadline of the context handlers work with. On
+	// SIGTERM or SIGINT, readiness fails for ShutdownDrain so that load
+	// balancers stop sending requests, then requests in flight are given
+	// ShutdownTimeout to finish.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	RequestTimeout    time.Duration
+	ShutdownDrain     time.Duration
+	ShutdownTimeout   time.Duration
+
+	// MaxRequestBody is the largest request body accepted by routes without
+	// a limit in RouteBodyLimits, which is keyed by method and OpenAPI path.
+	// A limit of 0 accepts bodies of any size.
+	MaxRequestBody  int64
+	RouteBodyLimits map[string]int64
+
+	// TablePrefix is prepended to every DynamoDB table name so that several
+	// environments (e.g. "dev-", "staging-") can share one AWS account.
+	TablePrefix string
+
+	// DynamoDB table settings applied by migrations
+	DynamoDBBillingMode         string
+	DynamoDBReadCapacity        int64
+	DynamoDBWriteCapacity       int64
+	DynamoDBTableClass          string
+	DynamoDBPointInTimeRecovery bool
+	DynamoDBSSEEnabled          bool
+	DynamoDBSSEKMSKeyID         string
+	DynamoDBTags                map[string]string
 }
 
 // LoadConfig loads the configuration from environment variables and fetches the ECDSA keys from Secret Manager
@@ -40,17 +181,106 @@ func LoadConfig() (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("unable to load AWS SDK config: %v", err)
 	}
+	// Every AWS client built from the config is traced and records call metrics
+	cfg.APIOptions = append(cfg.APIOptions, tracing.AddAWSMiddleware, metrics.AddAWSMiddleware)
 
 	config := &Config{
-		LogLevel:      getEnv("LOG_LEVEL", "info"),
-		Port:          port,
-		AwsConfig:     cfg,
-		DynamoDBTable: getEnv("DYNAMODB_TABLE", "default-table"),
-		S3BucketName:  getEnv("S3_BUCKET_NAME", "default-bucket"),
+		LogLevel:         getEnv("LOG_LEVEL", "info"),
+		LogFormat:        getEnv("LOG_FORMAT", "text"),
+		Port:             port,
+		AwsConfig:        cfg,
+		DynamoDBTable:    getEnv("DYNAMODB_TABLE", "default-table"),
+		FilesTable:       getEnv("DYNAMODB_FILES_TABLE", "files"),
+		AuditTable:       getEnv("DYNAMODB_AUDIT_TABLE", "audit"),
+		RateLimitTable:   getEnv("DYNAMODB_RATE_LIMIT_TABLE", "rate-limits"),
+		IdempotencyTable: getEnv("DYNAMODB_IDEMPOTENCY_TABLE", "idempotency-keys"),
+		S3BucketName:     getEnv("S3_BUCKET_NAME", "default-bucket"),
+		TablePrefix:      getEnv("TABLE_PREFIX", ""),
+
+		StorageBackend: getEnv("STORAGE_BACKEND", "aws"),
+		FilesystemRoot: getEnvRaw("FILESYSTEM_ROOT", "data"),
+		PublicBaseURL:  getEnvRaw("PUBLIC_BASE_URL", fmt.Sprintf("http://localhost:%d", port)),
+
+		TraceExporter: getEnv("TRACE_EXPORTER", tracing.ExporterNone),
+		OTLPEndpoint:  getEnvRaw("OTLP_ENDPOINT", ""),
+
+		DynamoDBBillingMode: getEnv("DYNAMODB_BILLING_MODE", "provisioned"),
+		DynamoDBTableClass:  getEnv("DYNAMODB_TABLE_CLASS", "standard"),
+		DynamoDBSSEKMSKeyID: getEnvRaw("DYNAMODB_SSE_KMS_KEY_ID", ""),
+		DynamoDBTags:        parseTags(getEnvRaw("DYNAMODB_TAGS", "")),
+	}
+
+	switch config.StorageBackend {
+	case "aws", "memory":
+	default:
+		return nil, fmt.Errorf("invalid value for STORAGE_BACKEND: %s", config.StorageBackend)
+	}
+
+	switch config.LogLevel {
+	case "trace", "debug", "info", "warn", "error":
+	default:
+		return nil, fmt.Errorf("invalid value for LOG_LEVEL: %s", config.LogLevel)
+	}
+
+	switch config.LogFormat {
+	case "text", "json":
+	default:
+		return nil, fmt.Errorf("invalid value for LOG_FORMAT: %s", config.LogFormat)
+	}
+
+	switch config.TraceExporter {
+	case tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout:
+	default:
+		return nil, fmt.Errorf("invalid value for TRACE_EXPORTER: %s", config.TraceExporter)
+	}
+
+	if err := config.loadTableSettings(); err != nil {
+		return nil, err
+	}
+
+	if err := config.loadStorageSettings(); err != nil {
+		return nil, err
+	}
+
+	if err := config.loadScannerSettings(); err != nil {
+		return nil, err
+	}
+
+	if err := config.loadHealthSettings(); err != nil {
+		return nil, err
 	}
 
-	// Fetch the ECDSA keys from AWS Secret Manager
-	err = config.loadECDSAKeys(cfg)
+	if err := config.loadRateLimitSettings(); err != nil {
+		return nil, err
+	}
+
+	if err := config.loadIdempotencySettings(); err != nil {
+		return nil, err
+	}
+
+	if err := config.loadServerSettings(); err != nil {
+		return nil, err
+	}
+
+	if config.ProfileStorage == "s3" && config.URLSigner == "cloudfront" {
+		if err := config.loadCloudFrontSettings(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	// Prefer local key files, fall back to throwaway keys when running
+	// offline, and otherwise fetch the ECDSA keys from AWS Secret Manager
+	privateKeyFile := getEnvRaw("ECDSA_PRIVATE_KEY_FILE", "")
+	publicKeyFile := getEnvRaw("ECDSA_PUBLIC_KEY_FILE", "")
+
+	switch {
+	case privateKeyFile != "" && publicKeyFile != "":
+		err = config.loadECDSAKeysFromFiles(privateKeyFile, publicKeyFile)
+	case config.StorageBackend == "memory":
+		err = config.generateECDSAKeys()
+	default:
+		err = config.loadECDSAKeys(cfg)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -58,17 +288,445 @@ func LoadConfig() (*Config, error) {
 	return config, nil
 }
 
-// loadECDSAKeys retrieves the ECDSA private and public keys from Secret Manager
-func (c *Config) loadECDSAKeys(cfg aws.Config) error {
-	secretManagerService, err := integration.NewAWSSSMService(cfg)
+// TableName returns the environment-specific name of a DynamoDB table
+func (c *Config) TableName(name string) string {
+	return c.TablePrefix + name
+}
+
+// loadTableSettings parses the numeric and boolean DynamoDB table settings
+func (c *Config) loadTableSettings() error {
+	var err error
+
+	switch c.DynamoDBBillingMode {
+	case "provisioned", "pay_per_request":
+	default:
+		return fmt.Errorf("invalid value for DYNAMODB_BILLING_MODE: %s", c.DynamoDBBillingMode)
+	}
+
+	switch c.DynamoDBTableClass {
+	case "standard", "standard_infrequent_access":
+	default:
+		return fmt.Errorf("invalid value for DYNAMODB_TABLE_CLASS: %s", c.DynamoDBTableClass)
+	}
+
+	if c.DynamoDBReadCapacity, err = getEnvInt64("DYNAMODB_READ_CAPACITY", 5); err != nil {
+		return err
+	}
+
+	if c.DynamoDBWriteCapacity, err = getEnvInt64("DYNAMODB_WRITE_CAPACITY", 5); err != nil {
+		return err
+	}
+
+	if c.DynamoDBPointInTimeRecovery, err = getEnvBool("DYNAMODB_POINT_IN_TIME_RECOVERY", false); err != nil {
+		return err
+	}
+
+	if c.DynamoDBSSEEnabled, err = getEnvBool("DYNAMODB_SSE_ENABLED", false); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// loadStorageSettings resolves the profile storage backend and URL signing settings
+func (c *Config) loadStorageSettings() error {
+	var err error
+
+	defaultProfileStorage := "s3"
+	if c.StorageBackend == "memory" {
+		defaultProfileStorage = "memory"
+	}
+
+	c.ProfileStorage = getEnv("PROFILE_STORAGE", defaultProfileStorage)
+	switch c.ProfileStorage {
+	case "s3", "filesystem", "memory":
+	default:
+		return fmt.Errorf("invalid value for PROFILE_STORAGE: %s", c.ProfileStorage)
+	}
+
+	if c.PresignTTL, err = getEnvDuration("PRESIGN_TTL", 15*time.Minute); err != nil {
+		return err
+	}
+
+	if c.MaxProfileSize, err = getEnvInt64("MAX_PROFILE_SIZE", 10<<20); err != nil {
+		return err
+	}
+
+	if c.MaxFileSize, err = getEnvInt64("MAX_FILE_SIZE", 25<<20); err != nil {
+		return err
+	}
+
+	if c.FileQuota, err = getEnvInt64("FILE_QUOTA", 100<<20); err != nil {
+		return err
+	}
+
+	if c.MaxUploadSize, err = getEnvInt64("MAX_UPLOAD_SIZE", 5<<30); err != nil {
+		return err
+	}
+
+	// S3 rejects parts smaller than 5 MiB, except for the last one
+	if c.UploadPartSize, err = getEnvInt64("UPLOAD_PART_SIZE", 8<<20); err != nil {
+		return err
+	}
+	if c.UploadPartSize < 5<<20 {
+		return fmt.Errorf("invalid value for UPLOAD_PART_SIZE: must be at least %d", 5<<20)
+	}
+
+	if c.UploadExpiry, err = getEnvDuration("UPLOAD_EXPIRY", 24*time.Hour); err != nil {
+		return err
+	}
+
+	if c.UploadSweepInterval, err = getEnvDuration("UPLOAD_SWEEP_INTERVAL", time.Hour); err != nil {
+		return err
+	}
+
+	if c.ContentAddressing, err = getEnvBool("CONTENT_ADDRESSING", false); err != nil {
+		return err
+	}
+
+	if c.BlobGracePeriod, err = getEnvDuration("BLOB_GRACE_PERIOD", time.Hour); err != nil {
+		return err
+	}
+
+	if c.ProfileMaxDimension, err = getEnvInt64("PROFILE_MAX_DIMENSION", 2048); err != nil {
+		return err
+	}
+
+	if c.ProfileRenditionSizes, err = parseSizes(getEnv("PROFILE_RENDITION_SIZES", "64,256,1024")); err != nil {
+		return err
+	}
+
+	if c.ProfileVersions, err = getEnvInt64("PROFILE_VERSIONS", 5); err != nil {
+		return err
+	}
+	if c.ProfileVersions < 0 {
+		return fmt.Errorf("invalid value for PROFILE_VERSIONS: %d", c.ProfileVersions)
+	}
+
+	// The API can only serve files from the filesystem itself. Memory
+	// storage hands out memory:// URLs whatever the signer.
+	defaultURLSigner := "s3"
+	if c.ProfileStorage != "s3" {
+		defaultURLSigner = "hmac"
+	}
+
+	c.URLSigner = getEnv("URL_SIGNER", defaultURLSigner)
+	switch c.URLSigner {
+	case "s3", "cloudfront", "hmac":
+	default:
+		return fmt.Errorf("invalid value for URL_SIGNER: %s", c.URLSigner)
+	}
+	if c.ProfileStorage == "filesystem" && c.URLSigner != "hmac" {
+		return fmt.Errorf("invalid value for URL_SIGNER: %s is not supported with filesystem storage", c.URLSigner)
+	}
+
+	// S3 rejects presigned URLs that are valid for longer than seven days
+	if c.URLSigner == "s3" && c.PresignTTL > 7*24*time.Hour {
+		return fmt.Errorf("invalid value for PRESIGN_TTL: S3 presigned URLs are valid for at most 7 days")
+	}
+
+	if key := getEnvRaw("URL_SIGNING_KEY", ""); key != "" {
+		c.URLSigningKey = []byte(key)
+		return nil
+	}
+
+	// Without a configured key, signed URLs only work on this instance and
+	// until it restarts
+	if c.ProfileStorage != "memory" && c.URLSigner == "hmac" {
+		log.Println("warning: URL_SIGNING_KEY is not set, generating an ephemeral URL signing key")
+	}
+	c.URLSigningKey = make([]byte, 32)
+	if _, err := rand.Read(c.URLSigningKey); err != nil {
+		return fmt.Errorf("failed to generate URL signing key: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Config) loadScannerSettings() error {
+	var err error
+
+	c.Scanner = getEnv("SCANNER", "none")
+	switch c.Scanner {
+	case "none", "noop", "clamd":
+	default:
+		return fmt.Errorf("invalid value for SCANNER: %s", c.Scanner)
+	}
+
+	c.ClamdAddress = getEnvRaw("CLAMD_ADDRESS", "tcp://localhost:3310")
+
+	if c.ScanTimeout, err = getEnvDuration("SCAN_TIMEOUT", 30*time.Second); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// loadHealthSettings reads the timeout and cache lifetime of readiness checks
+func (c *Config) loadHealthSettings() error {
+	var err error
+
+	if c.HealthCheckTimeout, err = getEnvDuration("HEALTH_CHECK_TIMEOUT", 2*time.Second); err != nil {
+		return err
+	}
+	if c.HealthCheckTimeout <= 0 {
+		return fmt.Errorf("invalid value for HEALTH_CHECK_TIMEOUT: %s", c.HealthCheckTimeout)
+	}
+
+	if c.HealthCacheTTL, err = getEnvDuration("HEALTH_CACHE_TTL", 5*time.Second); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Policies of the routes that are cheapest to abuse: guessing passwords,
+// creating users and storing content
+const defaultRouteRateLimits = "POST /api/v1/users/login=10/1m:ip;" +
+	"POST /api/v1/users=20/1h:subject;" +
+	"PUT /api/v1/users/{username}/profile=30/1h:subject;" +
+	"POST /api/v1/users/{username}/profile/upload-url=30/1h:subject;" +
+	"POST /api/v1/users/{username}/files=120/1h:subject;" +
+	"POST /api/v1/users/{username}/files/uploads=60/1h:subject"
+
+// loadRateLimitSettings reads where request counts are kept and the policies
+// applied to routes
+func (c *Config) loadRateLimitSettings() error {
+	var err error
+
+	defaultStore := "dynamodb"
+	if c.StorageBackend == "memory" {
+		defaultStore = "memory"
+	}
+
+	c.RateLimitStore = getEnv("RATE_LIMIT_STORE", defaultStore)
+	switch c.RateLimitStore {
+	case "none", "memory":
+	case "dynamodb":
+		if c.StorageBackend != "aws" {
+			return fmt.Errorf("invalid value for RATE_LIMIT_STORE: dynamodb requires the aws storage backend")
+		}
+	default:
+		return fmt.Errorf("invalid value for RATE_LIMIT_STORE: %s", c.RateLimitStore)
+	}
+
+	if c.DefaultRateLimit, err = ratelimit.ParsePolicy(getEnv("RATE_LIMIT_DEFAULT", "300/1m:subject")); err != nil {
+		return fmt.Errorf("invalid value for RATE_LIMIT_DEFAULT: %v", err)
+	}
+
+	if c.RouteRateLimits, err = ratelimit.ParseRoutePolicies(getEnvRaw("RATE_LIMIT_ROUTES", defaultRouteRateLimits)); err != nil {
+		return fmt.Errorf("invalid value for RATE_LIMIT_ROUTES: %v", err)
+	}
+
+	c.RateLimitAPIKeys = make(map[string]bool)
+	for _, key := range strings.Split(getEnvRaw("RATE_LIMIT_API_KEYS", ""), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			c.RateLimitAPIKeys[key] = true
+		}
+	}
+
+	if c.TrustedProxies, err = getEnvInt64("TRUSTED_PROXIES", 0); err != nil {
+		return err
+	}
+	if c.TrustedProxies < 0 {
+		return fmt.Errorf("invalid value for TRUSTED_PROXIES: %d", c.TrustedProxies)
+	}
+
+	return nil
+}
+
+// loadIdempotencySettings reads where the responses to idempotent requests
+// are kept and for how long
+func (c *Config) loadIdempotencySettings() error {
+	var err error
+
+	defaultStore := "dynamodb"
+	if c.StorageBackend == "memory" {
+		defaultStore = "memory"
+	}
+
+	c.IdempotencyStore = getEnv("IDEMPOTENCY_STORE", defaultStore)
+	switch c.IdempotencyStore {
+	case "none", "memory":
+	case "dynamodb":
+		if c.StorageBackend != "aws" {
+			return fmt.Errorf("invalid value for IDEMPOTENCY_STORE: dynamodb requires the aws storage backend")
+		}
+	default:
+		return fmt.Errorf("invalid value for IDEMPOTENCY_STORE: %s", c.IdempotencyStore)
+	}
+
+	if c.IdempotencyTTL, err = getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour); err != nil {
+		return err
+	}
+	if c.IdempotencyTTL <= 0 {
+		return fmt.Errorf("invalid value for IDEMPOTENCY_TTL: %s", c.IdempotencyTTL)
+	}
+
+	if c.IdempotencyLockTimeout, err = getEnvDuration("IDEMPOTENCY_LOCK_TIMEOUT", time.Minute); err != nil {
+		return err
+	}
+	if c.IdempotencyLockTimeout <= 0 {
+		return fmt.Errorf("invalid value for IDEMPOTENCY_LOCK_TIMEOUT: %s", c.IdempotencyLockTimeout)
+	}
+
+	return nil
+}
+
+// maxUploadParts is the most parts S3 accepts for one multipart upload
+const maxUploadParts = 10000
+
+// loadServerSettings reads the timeouts of the HTTP server and the limits on
+// request bodies. Upload routes accept bodies as large as the uploads
+// configured above, plus room for the multipart form around them.
+func (c *Config) loadServerSettings() error {
+	durations := []struct {
+		key          string
+		value        *time.Duration
+		defaultValue time.Duration
+	}{
+		{"SERVER_READ_HEADER_TIMEOUT", &c.ReadHeaderTimeout, 10 * time.Second},
+		{"SERVER_READ_TIMEOUT", &c.ReadTimeout, time.Minute},
+		{"SERVER_WRITE_TIMEOUT", &c.WriteTimeout, time.Minute},
+		{"SERVER_IDLE_TIMEOUT", &c.IdleTimeout, 2 * time.Minute},
+		{"REQUEST_TIMEOUT", &c.RequestTimeout, 15 * time.Second},
+		{"SHUTDOWN_DRAIN", &c.ShutdownDrain, 0},
+		{"SHUTDOWN_TIMEOUT", &c.ShutdownTimeout, 15 * time.Second},
+	}
+	for _, d := range durations {
+		var err error
+		if *d.value, err = getEnvDuration(d.key, d.defaultValue); err != nil {
+			return err
+		}
+		if *d.value < 0 {
+			return fmt.Errorf("invalid value for %s: %s", d.key, *d.value)
+		}
+	}
+
+	// Responses to requests that run until their deadline must still be written
+	if c.WriteTimeout > 0 && c.RequestTimeout > 0 && c.WriteTimeout < c.RequestTimeout {
+		return fmt.Errorf("invalid value for SERVER_WRITE_TIMEOUT: must be at least REQUEST_TIMEOUT (%s)", c.RequestTimeout)
+	}
+
+	var err error
+	if c.MaxRequestBody, err = getEnvInt64("MAX_REQUEST_BODY", 1<<20); err != nil {
+		return err
+	}
+	if c.MaxRequestBody < 0 {
+		return fmt.Errorf("invalid value for MAX_REQUEST_BODY: %d", c.MaxRequestBody)
+	}
+
+	const formOverhead = 1 << 20
+	maxPartSize := max(c.UploadPartSize, (c.MaxUploadSize+maxUploadParts-1)/maxUploadParts)
+	c.RouteBodyLimits = map[string]int64{
+		"PUT /api/v1/users/{username}/profile":                           c.MaxProfileSize + formOverhead,
+		"POST /api/v1/users/{username}/files":                            c.MaxFileSize + formOverhead,
+		"PUT /api/v1/users/{username}/files/uploads/{id}/parts/{number}": maxPartSize,
+	}
+
+	routes, err := parseRouteLimits(getEnvRaw("MAX_BODY_ROUTES", ""))
 	if err != nil {
+		return fmt.Errorf("invalid value for MAX_BODY_ROUTES: %v", err)
+	}
+	maps.Copy(c.RouteBodyLimits, routes)
+
+	return nil
+}
+
+// parseRouteLimits reads a semicolon-separated list of body limits of the
+// form "METHOD /route/pattern=BYTES", e.g. "POST /api/v1/users=4096"
+func parseRouteLimits(value string) (map[string]int64, error) {
+	limits := make(map[string]int64)
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		route, limit, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("%q is not of the form ROUTE=BYTES", entry)
+		}
+
+		method, pattern, found := strings.Cut(strings.TrimSpace(route), " ")
+		if !found || !strings.HasPrefix(strings.TrimSpace(pattern), "/") {
+			return nil, fmt.Errorf("route %q is not of the form \"METHOD /pattern\"", route)
+		}
+
+		bytes, err := strconv.ParseInt(strings.TrimSpace(limit), 10, 64)
+		if err != nil || bytes < 0 {
+			return nil, fmt.Errorf("route %q: invalid limit %q", route, limit)
+		}
+		limits[strings.ToUpper(method)+" "+strings.TrimSpace(pattern)] = bytes
+	}
+	return limits, nil
+}
+
+// loadCloudFrontSettings reads the distribution settings of the CloudFront URL
+// signer. The private key is read from a local file if one is configured and
+// otherwise fetched from SSM; URLs are always signed locally.
+func (c *Config) loadCloudFrontSettings(cfg aws.Config) error {
+	var err error
+
+	c.CloudFrontURL = getEnvRaw("CLOUDFRONT_URL", "")
+	if c.CloudFrontURL == "" {
+		return fmt.Errorf("CLOUDFRONT_URL is required with the cloudfront URL signer")
+	}
+
+	c.CloudFrontKeyPairID = getEnvRaw("CLOUDFRONT_KEY_PAIR_ID", "")
+	if c.CloudFrontKeyPairID == "" {
+		return fmt.Errorf("CLOUDFRONT_KEY_PAIR_ID is required with the cloudfront URL signer")
+	}
+
+	if c.CloudFrontSignedCookies, err = getEnvBool("CLOUDFRONT_SIGNED_COOKIES", false); err != nil {
 		return err
 	}
 
+	c.CloudFrontCookieDomain = getEnvRaw("CLOUDFRONT_COOKIE_DOMAIN", "")
+
+	var privateKey []byte
+	if file := getEnvRaw("CLOUDFRONT_PRIVATE_KEY_FILE", ""); file != "" {
+		if privateKey, err = os.ReadFile(file); err != nil {
+			return fmt.Errorf("failed to read CloudFront private key file: %w", err)
+		}
+	} else {
+		secretManagerService, err := integration.NewAWSSSMService(cfg)
+		if err != nil {
+			return err
+		}
+
+		secret, err := secretManagerService.GetSecretValue(context.Background(), getEnvRaw("CLOUDFRONT_PRIVATE_KEY_SECRET_PATH", "/cloudfront/private-key"))
+		if err != nil {
+			return err
+		}
+		privateKey = []byte(secret)
+	}
+
+	if c.CloudFrontPrivateKey, err = jwt.ParseRSAPrivateKeyFromPEM(privateKey); err != nil {
+		return fmt.Errorf("invalid CloudFront private key: %w", err)
+	}
+
+	return nil
+}
+
+// KeySecretPaths returns the SSM parameter paths of the ECDSA private and public keys
+func KeySecretPaths() (string, string) {
 	// Construct secret paths using environment variables
 	privateKeySecretPath := getEnv("ECDSA_PRIVATE_KEY_SECRET_PATH", "/ecdsa/private-key")
 	publicKeySecretPath := getEnv("ECDSA_PUBLIC_KEY_SECRET_PATH", "/ecdsa/public-key")
 
+	return privateKeySecretPath, publicKeySecretPath
+}
+
+// loadECDSAKeys retrieves the ECDSA private and public keys from Secret Manager
+func (c *Config) loadECDSAKeys(cfg aws.Config) error {
+	secretManagerService, err := integration.NewAWSSSMService(cfg)
+	if err != nil {
+		return err
+	}
+
+	privateKeySecretPath, publicKeySecretPath := KeySecretPaths()
+
 	// Fetch the ECDSA private key
 	privateKey, err := secretManagerService.GetSecretValue(context.Background(), privateKeySecretPath)
 	if err != nil {
@@ -98,6 +756,49 @@ func (c *Config) loadECDSAKeys(cfg aws.Config) error {
 	return nil
 }
 
+// loadECDSAKeysFromFiles reads the ECDSA private and public keys from PEM files
+func (c *Config) loadECDSAKeysFromFiles(privateKeyFile string, publicKeyFile string) error {
+	privateKey, err := os.ReadFile(privateKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read private key file: %w", err)
+	}
+
+	ecdsaPrivateKey, err := jwt.ParseECPrivateKeyFromPEM(privateKey)
+	if err != nil {
+		return err
+	}
+	c.ECDSAPrivateKey = ecdsaPrivateKey
+
+	publicKey, err := os.ReadFile(publicKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read public key file: %w", err)
+	}
+
+	ecdsaPublicKey, err := jwt.ParseECPublicKeyFromPEM(publicKey)
+	if err != nil {
+		return err
+	}
+	c.ECDSAPublicKey = ecdsaPublicKey
+
+	return nil
+}
+
+// generateECDSAKeys creates a throwaway key pair. Tokens signed with it stop
+// working when the process exits.
+func (c *Config) generateECDSAKeys() error {
+	log.Println("warning: no ECDSA key files configured, generating an ephemeral key pair")
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate ECDSA key: %w", err)
+	}
+
+	c.ECDSAPrivateKey = privateKey
+	c.ECDSAPublicKey = &privateKey.PublicKey
+
+	return nil
+}
+
 // getEnv reads an environment variable or returns a default value if the variable is not set
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
@@ -105,3 +806,84 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvRaw reads an environment variable without changing its case, for values
+// such as KMS key ARNs and tags where case matters
+func getEnvRaw(key, defaultValue string) string {
+	if value, exists := os.LookupEnv(key); exists {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvInt64 reads an integer environment variable or returns a default value
+func getEnvInt64(key string, defaultValue int64) (int64, error) {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue, nil
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for %s: %v", key, err)
+	}
+	return parsed, nil
+}
+
+// getEnvBool reads a boolean environment variable or returns a default value
+func getEnvBool(key string, defaultValue bool) (bool, error) {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue, nil
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid value for %s: %v", key, err)
+	}
+	return parsed, nil
+}
+
+// getEnvDuration reads a duration environment variable (e.g. "15m") or returns a default value
+func getEnvDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue, nil
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for %s: %v", key, err)
+	}
+	return parsed, nil
+}
+
+// parseTags parses a comma separated list of key=value pairs
+func parseTags(value string) map[string]string {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || key == "" {
+			continue
+		}
+		tags[key] = val
+	}
+	return tags
+}
+
+// parseSizes parses a comma-separated list of positive pixel sizes
+func parseSizes(value string) ([]int, error) {
+	var sizes []int
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		size, err := strconv.Atoi(field)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid value for PROFILE_RENDITION_SIZES: %s", value)
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
@@ -0,0 +1,121 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Audited actions
+const (
+	AuditLogin          = "login"
+	AuditUserCreate     = "user.create"
+	AuditUserUpdate     = "user.update"
+	AuditUserDelete     = "user.delete"
+	AuditPasswordReset  = "user.password_reset"
+	AuditUserDisable    = "user.disable"
+	AuditUserEnable     = "user.enable"
+	AuditRoleChange     = "user.role_change"
+	AuditProfileUpload  = "profile.upload"
+	AuditProfileDelete  = "profile.delete"
+	AuditProfileRestore = "profile.restore"
+	AuditAccessDenied   = "access.denied"
+	AuditMalware        = "upload.malware_detected"
+)
+
+// Outcomes of audited actions
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	AuditDenied  = "denied"
+)
+
+// AuditAnonymous is the actor of events caused by unauthenticated requests
+const AuditAnonymous = "anonymous"
+
+// AuditGenesisHash is the previous hash of the first event in the log
+const AuditGenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// AuditEvent - a security-relevant event in the append-only audit log. Each
+// event carries the hash of the one before it, so changing, removing or
+// reordering events breaks the chain.
+type AuditEvent struct {
+	Sequence int64     `json:"sequence" dynamodbav:"seq"`
+	Time     time.Time `json:"time" dynamodbav:"time"`
+	Actor    string    `json:"actor" dynamodbav:"actor"`
+	Action   string    `json:"action" dynamodbav:"action"`
+	Target   string    `json:"target,omitempty" dynamodbav:"target,omitempty"`
+	Outcome  string    `json:"outcome" dynamodbav:"outcome"`
+	// Detail describes the change, e.g. the new role; Reason why it failed
+	Detail    string `json:"detail,omitempty" dynamodbav:"detail,omitempty"`
+	Reason    string `json:"reason,omitempty" dynamodbav:"reason,omitempty"`
+	ClientIP  string `json:"client_ip,omitempty" dynamodbav:"client_ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty" dynamodbav:"user_agent,omitempty"`
+	RequestID string `json:"request_id,omitempty" dynamodbav:"request_id,omitempty"`
+	PrevHash  string `json:"prev_hash" dynamodbav:"prev_hash"`
+	Hash      string `json:"hash" dynamodbav:"hash"`
+}
+
+// AuditFilter - selects audit events by the fields that are set
+type AuditFilter struct {
+	Actor   string
+	Target  string
+	Action  string
+	Outcome string
+}
+
+// AuditVerification - the result of checking the hash chain of the audit log
+type AuditVerification struct {
+	Valid  bool  `json:"valid"`
+	Events int64 `json:"events"`
+	// HeadHash is the hash of the newest event. Keeping it outside the log
+	// allows checking later that no events were removed from the end.
+	HeadHash string `json:"head_hash"`
+	// BrokenAt is the sequence number of the first event that does not match
+	BrokenAt int64  `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Matches - reports whether the event has every field set in the filter
+func (f AuditFilter) Matches(event AuditEvent) bool {
+	return (f.Actor == "" || f.Actor == event.Actor) &&
+		(f.Target == "" || f.Target == event.Target) &&
+		(f.Action == "" || f.Action == event.Action) &&
+		(f.Outcome == "" || f.Outcome == event.Outcome)
+}
+
+// Chain - links the event to prev, the newest event in the log, which is the
+// zero AuditEvent for the first event, and computes the event's hash
+func (e *AuditEvent) Chain(prev AuditEvent) {
+	e.Sequence = prev.Sequence + 1
+	e.PrevHash = prev.Hash
+	if e.PrevHash == "" {
+		e.PrevHash = AuditGenesisHash
+	}
+	e.Hash = e.ComputeHash()
+}
+
+// ComputeHash - returns the hex SHA-256 of every field of the event except
+// its own hash
+func (e *AuditEvent) ComputeHash() string {
+	// The fields are hashed as a JSON array, so the hash does not depend on
+	// field names or on how the event is stored
+	data, _ := json.Marshal([]any{
+		e.Sequence,
+		e.Time.UTC().Format(time.RFC3339Nano),
+		e.Actor,
+		e.Action,
+		e.Target,
+		e.Outcome,
+		e.Detail,
+		e.Reason,
+		e.ClientIP,
+		e.UserAgent,
+		e.RequestID,
+		e.PrevHash,
+	})
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
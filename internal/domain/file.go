@@ -0,0 +1,32 @@
+package domain
+
+import "time"
+
+// File - metadata of a file attached to a user. The content is kept in object
+// storage under Key.
+type File struct {
+	Username    string    `json:"-" dynamodbav:"pk"`
+	ID          string    `json:"id" dynamodbav:"id"`
+	Name        string    `json:"name" dynamodbav:"name"`
+	Size        int64     `json:"size" dynamodbav:"size"`
+	ContentType string    `json:"content_type" dynamodbav:"content_type"`
+	Checksum    string    `json:"checksum" dynamodbav:"checksum"`
+	Key         string    `json:"-" dynamodbav:"object_key"`
+	CreatedAt   time.Time `json:"created_at" dynamodbav:"created_at"`
+
+	// ContentAddressed is set when Key is a blob shared by every file with
+	// the same checksum
+	ContentAddressed bool `json:"-" dynamodbav:"content_addressed,omitempty"`
+}
+
+// Blob - an object stored once for all files with the same content. Refs
+// counts the files referring to it; unreferenced blobs are deleted after a
+// grace period.
+type Blob struct {
+	Checksum   string     `dynamodbav:"checksum"`
+	Key        string     `dynamodbav:"object_key"`
+	Refs       int64      `dynamodbav:"refs"`
+	Stored     bool       `dynamodbav:"stored"`
+	Deleting   bool       `dynamodbav:"deleting,omitempty"`
+	ReleasedAt *time.Time `dynamodbav:"released_at,omitempty"`
+}
@@ -0,0 +1,8 @@
+package domain
+
+// ScanResult - the verdict of a malware scan
+type ScanResult struct {
+	Infected bool
+	// Signature names the malware found in an infected file
+	Signature string
+}
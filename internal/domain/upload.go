@@ -0,0 +1,71 @@
+package domain
+
+import "time"
+
+// Upload methods a client can use to send a file directly to object storage
+const (
+	UploadMethodPut  = "PUT"
+	UploadMethodPost = "POST"
+)
+
+// PresignedUpload - instructions for uploading a file straight to object storage
+type PresignedUpload struct {
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Key       string            `json:"key"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// ObjectInfo - metadata of a stored object
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// ObjectVersion - one version of an object in a store that keeps every
+// version. A delete marker records that the object was deleted.
+type ObjectVersion struct {
+	Key          string
+	VersionID    string
+	Size         int64
+	LastModified time.Time
+	IsLatest     bool
+	DeleteMarker bool
+}
+
+// MultipartUpload - a resumable upload of a file in numbered parts. Every part
+// except the last is PartSize bytes long. Once the parts are assembled,
+// AssembledAt is set until the file has been verified.
+type MultipartUpload struct {
+	Username    string       `json:"-" dynamodbav:"pk"`
+	ID          string       `json:"id" dynamodbav:"id"`
+	UploadID    string       `json:"-" dynamodbav:"upload_id"`
+	Name        string       `json:"name" dynamodbav:"name"`
+	Size        int64        `json:"size" dynamodbav:"size"`
+	ContentType string       `json:"content_type" dynamodbav:"content_type"`
+	Checksum    string       `json:"checksum,omitempty" dynamodbav:"checksum,omitempty"`
+	PartSize    int64        `json:"part_size" dynamodbav:"part_size"`
+	PartCount   int32        `json:"part_count" dynamodbav:"part_count"`
+	Key         string       `json:"-" dynamodbav:"object_key"`
+	CreatedAt   time.Time    `json:"created_at" dynamodbav:"created_at"`
+	AssembledAt *time.Time   `json:"assembled_at,omitempty" dynamodbav:"assembled_at,omitempty"`
+	Parts       []UploadPart `json:"parts" dynamodbav:"-"`
+}
+
+// UploadPart - a part of a multipart upload that has been stored
+type UploadPart struct {
+	Number int32  `json:"number"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+}
+
+// ObjectUpload - an incomplete multipart upload as seen by object storage
+type ObjectUpload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
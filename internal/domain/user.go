@@ -1,6 +1,25 @@
 package domain
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Roles a user can hold
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Profile visibilities. Public profile images can be fetched without a token,
+// authenticated ones by any signed-in user and private ones only by their
+// owner and admins.
+const (
+	VisibilityPublic        = "public"
+	VisibilityAuthenticated = "authenticated"
+	VisibilityPrivate       = "private"
+)
 
 // User - representation of a user in the system
 type User struct {
@@ -8,6 +27,51 @@ type User struct {
 	Password       string  `json:"-" dynamodbav:"-"`
 	HashedPassword []byte  `json:"-" dynamodbav:"hashed_password,omitempty"`
 	ProfilePath    *string `json:"profile_path,omitempty" dynamodbav:"profile_path,omitempty"`
+	// ProfileChecksum is the hex SHA-256 of the stored profile image
+	ProfileChecksum *string `json:"profile_checksum,omitempty" dynamodbav:"profile_checksum,omitempty"`
+	Role            *string `json:"role,omitempty" dynamodbav:"role,omitempty"`
+	Disabled        *bool   `json:"disabled,omitempty" dynamodbav:"disabled,omitempty"`
+	// ProfileVisibility controls who can see the profile image
+	ProfileVisibility *string `json:"profile_visibility,omitempty" dynamodbav:"profile_visibility,omitempty"`
+}
+
+// ProfileVersion - a profile image that was uploaded and can be restored
+type ProfileVersion struct {
+	ID        string    `json:"id"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	Current   bool      `json:"current"`
+	// Key is the object key of the version's original image
+	Key string `json:"-"`
+}
+
+// IsValidRole - reports whether role is one of the known roles
+func IsValidRole(role string) bool {
+	return role == RoleUser || role == RoleAdmin
+}
+
+// IsValidVisibility - reports whether visibility is one of the known profile visibilities
+func IsValidVisibility(visibility string) bool {
+	return visibility == VisibilityPublic || visibility == VisibilityAuthenticated || visibility == VisibilityPrivate
+}
+
+// Visibility - returns the user's profile visibility. Users who never set
+// one keep the original behaviour of being visible to any signed-in user.
+func (u *User) Visibility() string {
+	if u.ProfileVisibility == nil {
+		return VisibilityAuthenticated
+	}
+	return *u.ProfileVisibility
+}
+
+// IsAdmin - reports whether the user holds the admin role
+func (u *User) IsAdmin() bool {
+	return u.Role != nil && *u.Role == RoleAdmin
+}
+
+// IsDisabled - reports whether the user has been disabled
+func (u *User) IsDisabled() bool {
+	return u.Disabled != nil && *u.Disabled
 }
 
 // HashPassword - hashes the user's password
@@ -9,6 +9,36 @@ var (
 	ErrNotImplemented        = errors.New("this function is not yet implemented")
 	ErrInvalidUser           = errors.New("invalid username or password")
 	ErrMissingRequiredFields = errors.New("missing required fields")
+	ErrInvalidRole           = errors.New("invalid role")
+	ErrUserNotFound          = errors.New("user not found")
+	ErrUserAlreadyExists     = errors.New("user already exists")
+	ErrInvalidPageToken      = errors.New("invalid pagination token")
+	ErrInvalidObjectKey      = errors.New("invalid object key")
+	ErrObjectNotFound        = errors.New("object not found")
+	ErrInvalidSignature      = errors.New("invalid signature")
+	ErrSignatureExpired      = errors.New("signature has expired")
+	ErrUnsupportedMediaType  = errors.New("unsupported media type")
+	ErrFileTooLarge          = errors.New("file is too large")
+	ErrInvalidUpload         = errors.New("uploaded file failed validation")
+	ErrImageTooLarge         = errors.New("image dimensions are too large")
+	ErrInvalidRenditionSize  = errors.New("invalid rendition size")
+	ErrProfileNotFound       = errors.New("user has no profile image")
+	ErrFileNotFound          = errors.New("file not found")
+	ErrQuotaExceeded         = errors.New("storage quota exceeded")
+	ErrUploadNotFound        = errors.New("upload not found")
+	ErrInvalidPart           = errors.New("invalid upload part")
+	ErrIncompleteUpload      = errors.New("upload is missing parts")
+	ErrUploadVerifying       = errors.New("upload is still being verified")
+	ErrChecksumMismatch      = errors.New("checksum does not match uploaded data")
+	ErrInvalidChecksum       = errors.New("checksum must be a hex SHA-256 digest")
+	ErrBlobNotFound          = errors.New("blob not found")
+	ErrBlobInUse             = errors.New("blob is still referenced")
+	ErrBlobDeleted           = errors.New("blob is being deleted")
+	ErrMalwareDetected       = errors.New("file contains malware")
+	ErrScanFailed            = errors.New("file could not be scanned")
+	ErrVersionNotFound       = errors.New("profile version not found")
+	ErrInvalidVisibility     = errors.New("invalid profile visibility")
+	ErrForbidden             = errors.New("permission denied")
 )
 
 // FetchingResourceError generates a formatted error for failed fetching of any resource by its type.
@@ -2,12 +2,25 @@ package factory
 
 import (
 	"context"
+	"fmt"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/idempotency"
+	"github.com/zzenonn/go-zenon-api-aws/internal/imaging"
+	"github.com/zzenonn/go-zenon-api-aws/internal/ratelimit"
 	"github.com/zzenonn/go-zenon-api-aws/internal/repository/db"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/filesystem"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/memory"
 	"github.com/zzenonn/go-zenon-api-aws/internal/repository/objectstore"
+	"github.com/zzenonn/go-zenon-api-aws/internal/scanner"
 	"github.com/zzenonn/go-zenon-api-aws/internal/service"
 	handlers "github.com/zzenonn/go-zenon-api-aws/internal/transport/http"
+	"github.com/zzenonn/go-zenon-api-aws/internal/urlsign"
+
+	log "github.com/sirupsen/logrus"
 )
 
 type TestableHandlerFactory interface {
@@ -20,44 +33,357 @@ type HandlerFactory struct {
 	cfg *config.Config
 	db  *db.DynamoDb
 	s3  *objectstore.S3Store
+
+	// Repositories are created once so that every service shares them.
+	// Profile images and file attachments share one object store.
+	userRepo service.UserRepository
+	fileRepo service.FileRepository
+	audit    service.AuditRepository
+	objects  service.ObjectRepository
+	scanner  service.Scanner
+
+	// fileService is created once so that the handlers and the sweeper see
+	// the same uploads being verified
+	fileService *service.FileService
+
+	// Only set when requests are rate limited
+	rateLimits ratelimit.Store
+	// Only set when Idempotency-Key headers are honoured
+	idempotency idempotency.Store
+
+	// objectVersioning is set when the bucket keeps every object version,
+	// which is then used for profile versions
+	objectVersioning bool
+
+	// Only set when running with the memory storage backend
+	memoryUsers   *memory.UserRepository
+	memoryFiles   *memory.FileRepository
+	memoryAudit   *memory.AuditRepository
+	memoryObjects *memory.ObjectRepository
+
+	// Only set when rate limit counters are kept in memory
+	memoryRateLimits *memory.RateLimitRepository
+	// Only set when idempotent responses are kept in memory
+	memoryIdempotency *memory.IdempotencyRepository
+
+	// Only set when the API serves objects itself through HMAC-signed URLs
+	servedObjects handlers.ObjectDownloader
+	hmacSigner    *urlsign.HMACSigner
+
+	// Only set when objects are served through CloudFront with signed cookies
+	cookieSigner *urlsign.CloudFrontSigner
 }
 
 func (f *HandlerFactory) MigrateUp(ctx context.Context) error {
+	if f.memoryUsers != nil {
+		return f.seedMemory(ctx)
+	}
 	return f.db.MigrateDb(ctx)
 }
 
 func (f *HandlerFactory) MigrateDown(ctx context.Context) error {
+	if f.memoryObjects != nil {
+		f.memoryObjects.Reset()
+	}
+	if f.memoryRateLimits != nil {
+		f.memoryRateLimits.Reset()
+	}
+	if f.memoryIdempotency != nil {
+		f.memoryIdempotency.Reset()
+	}
+	if f.memoryUsers != nil {
+		f.memoryUsers.Reset()
+		f.memoryFiles.Reset()
+		f.memoryAudit.Reset()
+		return nil
+	}
 	return f.db.MigrateDown(ctx)
 }
 
+func (f *HandlerFactory) MigrationStatus(ctx context.Context) ([]db.MigrationState, error) {
+	if f.memoryUsers != nil {
+		return nil, nil
+	}
+	return f.db.MigrationStatus(ctx)
+}
+
 func NewHandlerFactory(cfg *config.Config) (*HandlerFactory, error) {
-	// Initialize shared dependencies once
-	dynamoDb, err := db.NewDatabase(cfg)
-	if err != nil {
-		return nil, err
+	f := &HandlerFactory{
+		cfg: cfg,
 	}
 
-	s3Store := objectstore.NewObjectStore(cfg)
+	switch cfg.StorageBackend {
+	case "memory":
+		userRepo := memory.NewUserRepository()
+		fileRepo := memory.NewFileRepository()
+		auditRepo := memory.NewAuditRepository()
+		f.memoryUsers = &userRepo
+		f.memoryFiles = &fileRepo
+		f.memoryAudit = &auditRepo
+		f.userRepo = &userRepo
+		f.fileRepo = &fileRepo
+		f.audit = &auditRepo
+	case "aws":
+		// Initialize shared dependencies once
+		dynamoDb, err := db.NewDatabase(cfg)
+		if err != nil {
+			return nil, err
+		}
 
-	return &HandlerFactory{
-		cfg: cfg,
-		db:  dynamoDb,
-		s3:  s3Store,
-	}, nil
+		userRepo := db.NewUserRepository(dynamoDb.Client, cfg.TableName(cfg.DynamoDBTable))
+		fileRepo := db.NewFileRepository(dynamoDb.Client, cfg.TableName(cfg.FilesTable))
+		auditRepo := db.NewAuditRepository(dynamoDb.Client, cfg.TableName(cfg.AuditTable))
+		f.db = dynamoDb
+		f.userRepo = &userRepo
+		f.fileRepo = &fileRepo
+		f.audit = &auditRepo
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", cfg.StorageBackend)
+	}
+
+	switch cfg.ProfileStorage {
+	case "memory":
+		objects := memory.NewObjectRepository()
+		f.memoryObjects = &objects
+		f.objects = &objects
+	case "filesystem":
+		f.hmacSigner = urlsign.NewHMACSigner(cfg.URLSigningKey, cfg.PublicBaseURL, cfg.PresignTTL)
+
+		objects, err := filesystem.NewObjectRepository(cfg.FilesystemRoot, f.hmacSigner)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open profile storage: %w", err)
+		}
+		f.servedObjects = &objects
+		f.objects = &objects
+	case "s3":
+		s3Store := objectstore.NewObjectStore(cfg)
+
+		objects := objectstore.NewObjectRepository(s3Store.Client, cfg.S3BucketName, f.newURLSigner(s3Store.Client))
+		f.s3 = s3Store
+		f.objects = &objects
+		if f.hmacSigner != nil {
+			f.servedObjects = &objects
+		}
+
+		// Fall back to versioning by key if the bucket's setting cannot be read
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		versioning, err := objects.VersioningEnabled(ctx)
+		cancel()
+		if err != nil {
+			log.Warn("Failed to read bucket versioning, keeping profile versions under separate keys: ", err)
+		}
+		f.objectVersioning = versioning
+	default:
+		return nil, fmt.Errorf("unknown profile storage: %s", cfg.ProfileStorage)
+	}
+
+	switch cfg.Scanner {
+	case "none":
+	case "noop":
+		f.scanner = scanner.NewNoopScanner()
+	case "clamd":
+		clamd, err := scanner.NewClamdScanner(cfg.ClamdAddress, cfg.ScanTimeout)
+		if err != nil {
+			return nil, err
+		}
+		f.scanner = clamd
+	default:
+		return nil, fmt.Errorf("unknown scanner: %s", cfg.Scanner)
+	}
+
+	switch cfg.RateLimitStore {
+	case "none":
+	case "memory":
+		rateLimits := memory.NewRateLimitRepository()
+		f.memoryRateLimits = &rateLimits
+		f.rateLimits = &rateLimits
+	case "dynamodb":
+		if f.db == nil {
+			return nil, fmt.Errorf("the dynamodb rate limit store requires the aws storage backend")
+		}
+		rateLimits := db.NewRateLimitRepository(f.db.Client, cfg.TableName(cfg.RateLimitTable))
+		f.rateLimits = &rateLimits
+	default:
+		return nil, fmt.Errorf("unknown rate limit store: %s", cfg.RateLimitStore)
+	}
+
+	switch cfg.IdempotencyStore {
+	case "none":
+	case "memory":
+		keys := memory.NewIdempotencyRepository()
+		f.memoryIdempotency = &keys
+		f.idempotency = &keys
+	case "dynamodb":
+		if f.db == nil {
+			return nil, fmt.Errorf("the dynamodb idempotency store requires the aws storage backend")
+		}
+		keys := db.NewIdempotencyRepository(f.db.Client, cfg.TableName(cfg.IdempotencyTable))
+		f.idempotency = &keys
+	default:
+		return nil, fmt.Errorf("unknown idempotency store: %s", cfg.IdempotencyStore)
+	}
+
+	return f, nil
+}
+
+// newURLSigner creates the signer for download URLs of objects in the bucket
+func (f *HandlerFactory) newURLSigner(client *s3.Client) objectstore.URLSigner {
+	switch f.cfg.URLSigner {
+	case "cloudfront":
+		signer := urlsign.NewCloudFrontSigner(f.cfg.CloudFrontURL, f.cfg.CloudFrontKeyPairID, f.cfg.CloudFrontPrivateKey, f.cfg.PresignTTL, f.cfg.CloudFrontSignedCookies)
+		if f.cfg.CloudFrontSignedCookies {
+			f.cookieSigner = signer
+		}
+		return signer
+	case "hmac":
+		f.hmacSigner = urlsign.NewHMACSigner(f.cfg.URLSigningKey, f.cfg.PublicBaseURL, f.cfg.PresignTTL)
+		return f.hmacSigner
+	default:
+		return urlsign.NewS3Signer(client, f.cfg.S3BucketName, f.cfg.PresignTTL)
+	}
+}
+
+// seedMemory creates the default admin user, mirroring the users table migration
+func (f *HandlerFactory) seedMemory(ctx context.Context) error {
+	if _, err := f.memoryUsers.GetUser(ctx, "admin"); err == nil {
+		return nil
+	}
+
+	username := "admin"
+	role := domain.RoleAdmin
+	admin := domain.User{
+		Username: &username,
+		Password: "admin",
+		Role:     &role,
+	}
+
+	if err := admin.HashPassword(); err != nil {
+		return err
+	}
+
+	_, err := f.memoryUsers.CreateUser(ctx, admin)
+	return err
+}
+
+func (f *HandlerFactory) CreateUserService() *service.UserService {
+	imageOptions := imaging.DefaultOptions()
+	imageOptions.MaxDimension = int(f.cfg.ProfileMaxDimension)
+	imageOptions.Sizes = f.cfg.ProfileRenditionSizes
+
+	return service.NewUserService(f.userRepo, f.objects,
+		service.WithMaxProfileSize(f.cfg.MaxProfileSize),
+		service.WithUploadURLTTL(f.cfg.PresignTTL),
+		service.WithImageOptions(imageOptions),
+		service.WithScanner(f.scanner),
+		service.WithProfileVersions(int(f.cfg.ProfileVersions)),
+		service.WithObjectVersioning(f.objectVersioning),
+		service.WithAuditor(f.CreateAuditService()),
+	)
 }
 
 func (f *HandlerFactory) CreateUserHandler() *handlers.UserHandler {
-	userRepo := db.NewUserRepository(f.db.Client, f.cfg.DynamoDBTable)
-	profileRepo := objectstore.NewUserProfileRepository(f.s3.Client, f.cfg.S3BucketName)
-	userService := service.NewUserService(&userRepo, &profileRepo)
-	return handlers.NewUserHandler(userService, f.cfg)
+	return handlers.NewUserHandler(f.CreateUserService(), f.cfg)
+}
+
+func (f *HandlerFactory) CreateAuditService() *service.AuditService {
+	return service.NewAuditService(f.audit, f.userRepo)
+}
+
+func (f *HandlerFactory) CreateAuditHandler() *handlers.AuditHandler {
+	return handlers.NewAuditHandler(f.CreateAuditService(), f.cfg)
+}
+
+func (f *HandlerFactory) CreateFileService() *service.FileService {
+	if f.fileService != nil {
+		return f.fileService
+	}
+
+	f.fileService = service.NewFileService(f.fileRepo, f.userRepo, f.objects,
+		service.WithMaxFileSize(f.cfg.MaxFileSize),
+		service.WithFileQuota(f.cfg.FileQuota),
+		service.WithMaxUploadSize(f.cfg.MaxUploadSize),
+		service.WithUploadPartSize(f.cfg.UploadPartSize),
+		service.WithPartURLTTL(f.cfg.PresignTTL),
+		service.WithContentAddressing(f.cfg.ContentAddressing),
+		service.WithBlobGracePeriod(f.cfg.BlobGracePeriod),
+		service.WithFileScanner(f.scanner),
+		service.WithFileAuditor(f.CreateAuditService()),
+	)
+	return f.fileService
+}
+
+func (f *HandlerFactory) CreateFileHandler() *handlers.FileHandler {
+	return handlers.NewFileHandler(f.CreateFileService(), f.cfg)
+}
+
+// pinger is implemented by repositories backed by a remote dependency
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// healthChecks lists the readiness checks of the configured dependencies.
+// In-memory repositories need no check.
+func (f *HandlerFactory) healthChecks() []handlers.HealthCheck {
+	checks := []handlers.HealthCheck{{Name: "signing_keys", Check: f.checkSigningKeys}}
+
+	if users, ok := f.userRepo.(pinger); ok {
+		checks = append(checks, handlers.HealthCheck{Name: "dynamodb.users", Check: users.Ping})
+	}
+	if files, ok := f.fileRepo.(pinger); ok {
+		checks = append(checks, handlers.HealthCheck{Name: "dynamodb.files", Check: files.Ping})
+	}
+	if audit, ok := f.audit.(pinger); ok {
+		checks = append(checks, handlers.HealthCheck{Name: "dynamodb.audit", Check: audit.Ping})
+	}
+	if objects, ok := f.objects.(pinger); ok {
+		checks = append(checks, handlers.HealthCheck{Name: f.cfg.ProfileStorage, Check: objects.Ping})
+	}
+
+	return checks
+}
+
+// checkSigningKeys checks that the keys for tokens and download URLs are loaded
+func (f *HandlerFactory) checkSigningKeys(ctx context.Context) error {
+	if f.cfg.ECDSAPrivateKey == nil || f.cfg.ECDSAPublicKey == nil {
+		return fmt.Errorf("token signing keys are not loaded")
+	}
+	if f.hmacSigner != nil && len(f.cfg.URLSigningKey) == 0 {
+		return fmt.Errorf("URL signing key is not loaded")
+	}
+	if f.cfg.URLSigner == "cloudfront" && f.cfg.ProfileStorage == "s3" && f.cfg.CloudFrontPrivateKey == nil {
+		return fmt.Errorf("CloudFront private key is not loaded")
+	}
+	return nil
+}
+
+func (f *HandlerFactory) CreateHealthHandler() *handlers.HealthHandler {
+	return handlers.NewHealthHandler(f.healthChecks(), f.cfg.HealthCheckTimeout, f.cfg.HealthCacheTTL)
 }
 
 func (f *HandlerFactory) CreateMainHandler() *handlers.MainHandler {
 	mainHandler := handlers.NewMainHandler(f.cfg)
+	mainHandler.Auditor = f.CreateAuditService()
+	if f.rateLimits != nil {
+		mainHandler.RateLimits = handlers.NewRateLimits(ratelimit.NewLimiter(f.rateLimits), f.cfg)
+	}
+	if f.idempotency != nil {
+		keeper := idempotency.NewKeeper(f.idempotency, f.cfg.IdempotencyTTL, f.cfg.IdempotencyLockTimeout)
+		mainHandler.Idempotency = handlers.NewIdempotency(keeper, f.cfg)
+	}
 
 	// Auto-register all handlers
 	mainHandler.AddHandler(f.CreateUserHandler())
+	mainHandler.AddHandler(f.CreateFileHandler())
+	mainHandler.AddHandler(f.CreateAuditHandler())
+	mainHandler.AddHandler(f.CreateHealthHandler())
+
+	if f.servedObjects != nil {
+		mainHandler.AddHandler(handlers.NewStorageHandler(f.servedObjects, f.hmacSigner))
+	}
+
+	if f.cookieSigner != nil {
+		mainHandler.AddHandler(handlers.NewSignedCookieHandler(f.cookieSigner, f.cfg))
+	}
 
 	return mainHandler
-}
\ No newline at end of file
+}
@@ -0,0 +1,143 @@
+// Package idempotency lets clients retry a request without its side effects
+// happening twice. The first response to a request is kept under the key the
+// client chose and replayed for repeats of the request.
+package idempotency
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrInProgress is returned while the first request with a key is still
+	// being handled
+	ErrInProgress = errors.New("a request with this idempotency key is in progress")
+	// ErrMismatch is returned when a key is reused for a different request
+	ErrMismatch = errors.New("idempotency key was used for a different request")
+	// ErrLockLost is returned when a request's lock on its key expired and
+	// another request has claimed the key since
+	ErrLockLost = errors.New("idempotency key was claimed by another request")
+)
+
+// Response is a response as it is replayed
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Record is what is kept under a key: the fingerprint of the request that
+// claimed it and, once that request has been handled, its response
+type Record struct {
+	Fingerprint string
+	// Token identifies the reservation, so that a request whose lock has
+	// expired cannot overwrite or release the key once it is reclaimed
+	Token string
+	// Response is nil while the request is in progress
+	Response  *Response
+	ExpiresAt time.Time
+}
+
+// Store keeps records. Claiming a key must be atomic, so that replicas
+// sharing a store never handle the same request twice.
+type Store interface {
+	// Reserve saves record under key unless an unexpired record is there
+	// already, in which case that record is returned and nothing is saved
+	Reserve(ctx context.Context, key string, record Record) (*Record, error)
+	// Save replaces the record under key if it is still held under token,
+	// and returns ErrLockLost otherwise
+	Save(ctx context.Context, key string, token string, record Record) error
+	// Release removes the record under key if it is still held under token.
+	// Releasing a key that is no longer held is not an error.
+	Release(ctx context.Context, key string, token string) error
+}
+
+// Keeper claims keys for requests and keeps their responses
+type Keeper struct {
+	Store Store
+	// TTL is how long responses are kept for replay
+	TTL time.Duration
+	// LockTimeout is how long a request may hold its key before repeats are
+	// handled again, in case the replica handling it has died
+	LockTimeout time.Duration
+	// Now returns the current time; tests replace it
+	Now func() time.Time
+}
+
+func NewKeeper(store Store, ttl time.Duration, lockTimeout time.Duration) *Keeper {
+	return &Keeper{
+		Store:       store,
+		TTL:         ttl,
+		LockTimeout: lockTimeout,
+		Now:         time.Now,
+	}
+}
+
+// Begin claims key for the request with the given fingerprint. It returns
+// the token the key is held under if the request should be handled, the
+// stored response if it has been handled before, ErrInProgress if it is
+// being handled and ErrMismatch if the key belongs to a different request.
+func (k *Keeper) Begin(ctx context.Context, key string, fingerprint string) (string, *Response, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate idempotency lock token: %w", err)
+	}
+
+	existing, err := k.Store.Reserve(ctx, key, Record{
+		Fingerprint: fingerprint,
+		Token:       token,
+		ExpiresAt:   k.Now().Add(k.LockTimeout),
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	switch {
+	case existing == nil:
+		return token, nil, nil
+	case existing.Fingerprint != fingerprint:
+		return "", nil, ErrMismatch
+	case existing.Response == nil:
+		return "", nil, ErrInProgress
+	default:
+		return "", existing.Response, nil
+	}
+}
+
+// Finish keeps the response to the request that claimed key under token. It
+// returns ErrLockLost if the key has been claimed again since.
+func (k *Keeper) Finish(ctx context.Context, key string, token string, fingerprint string, response Response) error {
+	err := k.Store.Save(ctx, key, token, Record{
+		Fingerprint: fingerprint,
+		Token:       token,
+		Response:    &response,
+		ExpiresAt:   k.Now().Add(k.TTL),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save idempotent response: %w", err)
+	}
+	return nil
+}
+
+// Abandon gives up key without keeping a response, so that the request can
+// be retried. A key claimed again since is left to its new holder.
+func (k *Keeper) Abandon(ctx context.Context, key string, token string) error {
+	if err := k.Store.Release(ctx, key, token); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// newToken returns a random lock token
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,188 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// Image types accepted by Process
+const (
+	ContentTypeJPEG = "image/jpeg"
+	ContentTypePNG  = "image/png"
+	ContentTypeWebP = "image/webp"
+)
+
+// Options controls how uploaded images are processed
+type Options struct {
+	// MaxDimension caps the longest side of the stored original
+	MaxDimension int
+	// MaxPixels rejects images whose decoded size would exceed it, which
+	// guards against decompression bombs
+	MaxPixels int
+	// Sizes are the longest sides of the thumbnails to create
+	Sizes []int
+}
+
+// DefaultOptions returns the options used when none are configured
+func DefaultOptions() Options {
+	return Options{
+		MaxDimension: 2048,
+		MaxPixels:    40_000_000,
+		Sizes:        []int{64, 256, 1024},
+	}
+}
+
+// Rendition is one encoded version of a processed image
+type Rendition struct {
+	// Size is the longest side the rendition was fitted into, 0 for the original
+	Size        int
+	ContentType string
+	Ext         string
+	Data        []byte
+}
+
+// DetectContentType identifies JPEG, PNG and WebP images from their magic
+// bytes and returns an empty string for anything else
+func DetectContentType(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}):
+		return ContentTypeJPEG
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return ContentTypePNG
+	case len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WEBP")):
+		return ContentTypeWebP
+	default:
+		return ""
+	}
+}
+
+// Process decodes an image, applies its EXIF orientation, caps its size and
+// re-encodes it together with one thumbnail per configured size. Re-encoding
+// from decoded pixels drops EXIF, GPS and every other kind of metadata.
+// WebP images are stored as PNG because there is no pure Go WebP encoder.
+func Process(data []byte, opts Options) ([]Rendition, error) {
+	contentType := DetectContentType(data)
+	if contentType == "" {
+		return nil, errors.ErrUnsupportedMediaType
+	}
+
+	config, err := decodeConfig(contentType, data)
+	if err != nil {
+		return nil, errors.ErrInvalidUpload
+	}
+
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > opts.MaxPixels {
+		return nil, errors.ErrImageTooLarge
+	}
+
+	img, err := decode(contentType, data)
+	if err != nil {
+		return nil, errors.ErrInvalidUpload
+	}
+
+	if contentType == ContentTypeJPEG {
+		img = applyOrientation(img, jpegOrientation(data))
+	}
+
+	outputType := ContentTypePNG
+	if contentType == ContentTypeJPEG {
+		outputType = ContentTypeJPEG
+	}
+
+	original := fit(img, opts.MaxDimension)
+	renditions := make([]Rendition, 0, len(opts.Sizes)+1)
+
+	encoded, err := encode(outputType, original)
+	if err != nil {
+		return nil, err
+	}
+	renditions = append(renditions, Rendition{Size: 0, ContentType: outputType, Ext: Extension(outputType), Data: encoded})
+
+	for _, size := range opts.Sizes {
+		encoded, err := encode(outputType, fit(original, size))
+		if err != nil {
+			return nil, err
+		}
+		renditions = append(renditions, Rendition{Size: size, ContentType: outputType, Ext: Extension(outputType), Data: encoded})
+	}
+
+	return renditions, nil
+}
+
+// Extension returns the file extension images of contentType are stored with
+func Extension(contentType string) string {
+	switch contentType {
+	case ContentTypeJPEG:
+		return ".jpg"
+	case ContentTypePNG:
+		return ".png"
+	case ContentTypeWebP:
+		return ".webp"
+	default:
+		return ""
+	}
+}
+
+func decodeConfig(contentType string, data []byte) (image.Config, error) {
+	switch contentType {
+	case ContentTypeJPEG:
+		return jpeg.DecodeConfig(bytes.NewReader(data))
+	case ContentTypePNG:
+		return png.DecodeConfig(bytes.NewReader(data))
+	default:
+		return webp.DecodeConfig(bytes.NewReader(data))
+	}
+}
+
+func decode(contentType string, data []byte) (image.Image, error) {
+	switch contentType {
+	case ContentTypeJPEG:
+		return jpeg.Decode(bytes.NewReader(data))
+	case ContentTypePNG:
+		return png.Decode(bytes.NewReader(data))
+	default:
+		return webp.Decode(bytes.NewReader(data))
+	}
+}
+
+func encode(contentType string, img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+
+	if contentType == ContentTypeJPEG {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+
+	return buf.Bytes(), err
+}
+
+// fit scales img down so that its longest side is at most size. Images that
+// already fit are returned unchanged, so nothing is ever scaled up.
+func fit(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if size <= 0 || (width <= size && height <= size) {
+		return img
+	}
+
+	if width >= height {
+		height = max(1, height*size/width)
+		width = size
+	} else {
+		width = max(1, width*size/height)
+		height = size
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
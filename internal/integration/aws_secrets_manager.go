@@ -6,10 +6,12 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmTypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
 )
 
 type SecretsManagerService interface {
 	GetSecretValue(ctx context.Context, secretName string) (string, error)
+	PutSecretValue(ctx context.Context, secretName string, value string, overwrite bool) error
 }
 
 type AWSSSMService struct {
@@ -41,3 +43,19 @@ func (p *AWSSSMService) GetSecretValue(ctx context.Context, name string) (string
 
 	return *result.Parameter.Value, nil
 }
+
+// PutSecretValue stores a value as an encrypted SecureString parameter
+func (p *AWSSSMService) PutSecretValue(ctx context.Context, name string, value string, overwrite bool) error {
+	input := &ssm.PutParameterInput{
+		Name:      &name,
+		Value:     &value,
+		Type:      ssmTypes.ParameterTypeSecureString,
+		Overwrite: aws.Bool(overwrite),
+	}
+
+	if _, err := p.client.PutParameter(ctx, input); err != nil {
+		return fmt.Errorf("failed to put parameter to SSM: %v", err)
+	}
+
+	return nil
+}
@@ -1,28 +1,55 @@
+// Package logging owns the configuration of the standard logrus logger,
+// which every package logs through. Entries logged with a request's context
+// carry its request and trace IDs, and secrets are redacted before entries
+// are written.
 package logging
 
 import (
 	log "github.com/sirupsen/logrus"
 	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	"github.com/zzenonn/go-zenon-api-aws/internal/tracing"
+)
+
+// Formats that LOG_FORMAT accepts
+const (
+	FormatText = "text"
+	FormatJSON = "json"
 )
 
 // InitLogger sets the log level and format based on the provided configuration
 func InitLogger(cfg *config.Config) {
-	// Set log level based on the log level from the config
-	switch logLevel := cfg.LogLevel; logLevel {
+	Configure(log.StandardLogger(), cfg.LogLevel, cfg.LogFormat)
+}
+
+// Configure sets the level, format and hooks of a logger. Hooks installed
+// before are replaced, so configuring a logger twice does not log twice.
+// Unknown levels fall back to error.
+func Configure(logger *log.Logger, level string, format string) {
+	switch level {
 	case "trace":
-		log.SetLevel(log.TraceLevel)
+		logger.SetLevel(log.TraceLevel)
 	case "debug":
-		log.SetLevel(log.DebugLevel)
+		logger.SetLevel(log.DebugLevel)
 	case "info":
-		log.SetLevel(log.InfoLevel)
+		logger.SetLevel(log.InfoLevel)
 	case "warn":
-		log.SetLevel(log.WarnLevel)
+		logger.SetLevel(log.WarnLevel)
 	default:
-		log.SetLevel(log.ErrorLevel)
+		logger.SetLevel(log.ErrorLevel)
+	}
+
+	if format == FormatJSON {
+		logger.SetFormatter(&log.JSONFormatter{})
+	} else {
+		logger.SetFormatter(&log.TextFormatter{
+			FullTimestamp: true,
+		})
 	}
 
-	// Optional: Customize the log format (e.g., JSONFormatter or TextFormatter)
-	log.SetFormatter(&log.TextFormatter{
-		FullTimestamp: true,
-	})
+	// Redaction runs last so that it also covers fields added by other hooks
+	hooks := make(log.LevelHooks)
+	hooks.Add(RequestIDHook{})
+	hooks.Add(tracing.LogHook{})
+	hooks.Add(RedactHook{})
+	logger.ReplaceHooks(hooks)
 }
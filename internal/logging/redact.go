@@ -0,0 +1,148 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Redacted replaces secrets in log entries
+const Redacted = "[REDACTED]"
+
+// Fields and headers whose names contain one of these, ignoring case and
+// punctuation, are always redacted, e.g. "hashed_password" or "Authorization"
+var sensitiveNames = []string{
+	"password",
+	"passwd",
+	"secret",
+	"token",
+	"authorization",
+	"cookie",
+	"hash",
+	"credential",
+	"apikey",
+	"privatekey",
+}
+
+// secretPatterns match secrets embedded in messages and string values
+var secretPatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	// Authorization header values
+	{regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]+`), "$1 " + Redacted},
+	// JWTs
+	{regexp.MustCompile(`\beyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), Redacted},
+	// bcrypt hashes
+	{regexp.MustCompile(`\$2[abxy]?\$\d{2}\$[./A-Za-z0-9]{53}`), Redacted},
+	// Secrets in query strings and key=value pairs, e.g. signed URLs
+	{regexp.MustCompile(`(?i)\b(password|token|secret|signature)=[^&\s"']+`), "$1=" + Redacted},
+}
+
+// RedactHook removes passwords, hashes, tokens and authorization headers
+// from entries before they are written. Fields with a sensitive name are
+// replaced entirely, secrets found in the message and other fields are
+// masked, and structs are logged as their JSON form, which leaves out the
+// fields tagged json:"-" such as password hashes.
+type RedactHook struct{}
+
+func (RedactHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (RedactHook) Fire(entry *log.Entry) error {
+	entry.Message = redactString(entry.Message)
+
+	for key, value := range entry.Data {
+		if isSensitive(key) {
+			entry.Data[key] = Redacted
+			continue
+		}
+		entry.Data[key] = redactValue(value)
+	}
+	return nil
+}
+
+func isSensitive(name string) bool {
+	compact := strings.ToLower(strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, name))
+
+	for _, sensitive := range sensitiveNames {
+		if strings.Contains(compact, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactString(s string) string {
+	for _, secret := range secretPatterns {
+		s = secret.pattern.ReplaceAllString(s, secret.replacement)
+	}
+	return s
+}
+
+func redactValue(value any) any {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return redactString(v)
+	case error:
+		// Keep the error unless its message gives a secret away
+		if message := redactString(v.Error()); message != v.Error() {
+			return message
+		}
+		return v
+	case fmt.Stringer:
+		return v
+	}
+
+	switch kind := reflect.Indirect(reflect.ValueOf(value)).Kind(); kind {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return value
+		}
+		var plain any
+		if err := json.Unmarshal(data, &plain); err != nil {
+			return value
+		}
+		return redactJSON(plain)
+	default:
+		return value
+	}
+}
+
+// redactJSON redacts a value decoded from JSON
+func redactJSON(value any) any {
+	switch v := value.(type) {
+	case string:
+		return redactString(v)
+	case []any:
+		for i, element := range v {
+			v[i] = redactJSON(element)
+		}
+		return v
+	case map[string]any:
+		for key, element := range v {
+			if isSensitive(key) {
+				v[key] = Redacted
+				continue
+			}
+			v[key] = redactJSON(element)
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// Outcomes of AWS API calls
+const (
+	outcomeSuccess = "success"
+	outcomeError   = "error"
+)
+
+// AddAWSMiddleware adds a middleware recording call latency, retries and
+// throttling errors to an AWS client's stack. It is meant for
+// aws.Config.APIOptions, so that every client built from the config is
+// instrumented.
+func AddAWSMiddleware(stack *middleware.Stack) error {
+	return stack.Initialize.Add(awsMetrics{}, middleware.After)
+}
+
+// awsMetrics runs once per API call, around every attempt the retryer makes
+type awsMetrics struct{}
+
+func (awsMetrics) ID() string {
+	return "ZenonMetrics"
+}
+
+func (awsMetrics) HandleInitialize(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	start := time.Now()
+	out, metadata, err := next.HandleInitialize(ctx, in)
+
+	service := awsmiddleware.GetServiceID(ctx)
+	operation := awsmiddleware.GetOperationName(ctx)
+	recordAWSCall(service, operation, time.Since(start), metadata, err)
+
+	return out, metadata, err
+}
+
+// recordAWSCall records a finished call using the attempt results the
+// retryer left in its metadata
+func recordAWSCall(service string, operation string, duration time.Duration, metadata middleware.Metadata, err error) {
+	outcome := outcomeSuccess
+	if err != nil {
+		outcome = outcomeError
+	}
+	AWSCallDuration.WithLabelValues(service, operation, outcome).Observe(duration.Seconds())
+
+	attempts, ok := retry.GetAttemptResults(metadata)
+	if !ok {
+		return
+	}
+	if retries := len(attempts.Results) - 1; retries > 0 {
+		AWSCallRetries.WithLabelValues(service, operation).Add(float64(retries))
+	}
+	for _, attempt := range attempts.Results {
+		if attempt.Err != nil && retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(attempt.Err) == aws.TrueTernary {
+			AWSThrottles.WithLabelValues(service, operation).Inc()
+		}
+	}
+}
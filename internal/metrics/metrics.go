@@ -0,0 +1,123 @@
+// Package metrics holds the Prometheus collectors of the API. They are
+// registered once on Registry, which the /metrics endpoint exposes.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "zenon"
+
+// Registry holds every collector of the API together with the Go runtime
+// and process collectors
+var Registry = newRegistry()
+
+var factory = promauto.With(Registry)
+
+func newRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return registry
+}
+
+// HTTP metrics, labelled by the chi route pattern rather than the request
+// path so that the number of series stays bounded
+var (
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests, by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestsInFlight = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests being handled, by method and route.",
+	}, []string{"method", "route"})
+
+	RateLimited = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests rejected by rate limiting, by policy (a route or default).",
+	}, []string{"policy"})
+
+	IdempotentRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "idempotent_requests_total",
+		Help:      "Repeated requests with an Idempotency-Key, by result (replayed, in_progress or mismatch).",
+	}, []string{"result"})
+)
+
+// AWS SDK metrics, labelled by service (e.g. "DynamoDB") and operation
+// (e.g. "GetItem")
+var (
+	AWSCallDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "aws_call_duration_seconds",
+		Help:      "Time taken by AWS API calls including retries, by service, operation and outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service", "operation", "outcome"})
+
+	AWSCallRetries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "aws_call_retries_total",
+		Help:      "Attempts of AWS API calls beyond the first, by service and operation.",
+	}, []string{"service", "operation"})
+
+	AWSThrottles = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "aws_throttling_errors_total",
+		Help:      "Attempts of AWS API calls rejected by throttling, by service and operation.",
+	}, []string{"service", "operation"})
+)
+
+// Business metrics
+var (
+	Logins = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Login attempts, by result (succeeded or failed).",
+	}, []string{"result"})
+
+	Signups = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "signups_total",
+		Help:      "Users created.",
+	})
+
+	Uploads = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploads_total",
+		Help:      "Completed uploads, by kind (profile or file).",
+	}, []string{"kind"})
+
+	BytesStored = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stored_bytes_total",
+		Help:      "Bytes of content stored by completed uploads, by kind (profile or file).",
+	}, []string{"kind"})
+)
+
+// Label values of Logins
+const (
+	LoginSucceeded = "succeeded"
+	LoginFailed    = "failed"
+)
+
+// Label values of Uploads and BytesStored
+const (
+	UploadProfile = "profile"
+	UploadFile    = "file"
+)
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Store keeps request counters. Increments must be atomic, so that replicas
+// sharing a store never lose a count.
+type Store interface {
+	// Increment adds one to the counter and returns its new value. A new
+	// counter expires at expiresAt.
+	Increment(ctx context.Context, key string, expiresAt time.Time) (int64, error)
+	// Count returns the value of the counter, or 0 if it does not exist or
+	// has expired
+	Count(ctx context.Context, key string) (int64, error)
+}
+
+// Decision is the outcome of counting one request
+type Decision struct {
+	Allowed   bool
+	Limit     int64
+	Remaining int64
+	// Reset is how long until the current window ends
+	Reset time.Duration
+	// RetryAfter is how long a rejected client has to wait before its next
+	// request is allowed
+	RetryAfter time.Duration
+}
+
+// Limiter applies policies with a sliding window. Each client has a counter
+// per fixed window; the count of the previous window is weighted by how much
+// of it still overlaps the sliding window, which smooths out bursts at window
+// boundaries without keeping a log of every request.
+type Limiter struct {
+	Store Store
+	// Now returns the current time; tests replace it
+	Now func() time.Time
+}
+
+func NewLimiter(store Store) *Limiter {
+	return &Limiter{
+		Store: store,
+		Now:   time.Now,
+	}
+}
+
+// Allow counts a request by client against the policy called name. Rejected
+// requests are counted too, so clients that keep retrying stay limited.
+func (l *Limiter) Allow(ctx context.Context, name string, policy Policy, client string) (Decision, error) {
+	now := l.Now()
+	start := now.Truncate(policy.Window)
+	elapsed := now.Sub(start)
+
+	// Counters outlive their window by one more, while they are the previous one
+	current, err := l.Store.Increment(ctx, counterKey(name, client, start), start.Add(2*policy.Window))
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to count request: %w", err)
+	}
+	previous, err := l.Store.Count(ctx, counterKey(name, client, start.Add(-policy.Window)))
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to read previous count: %w", err)
+	}
+
+	overlap := 1 - float64(elapsed)/float64(policy.Window)
+	estimate := float64(previous)*overlap + float64(current)
+
+	decision := Decision{
+		Allowed:   estimate <= float64(policy.Limit),
+		Limit:     policy.Limit,
+		Remaining: max(0, policy.Limit-int64(math.Ceil(estimate))),
+		Reset:     policy.Window - elapsed,
+	}
+	if !decision.Allowed {
+		decision.RetryAfter = retryAfter(policy, previous, current, elapsed)
+	}
+	return decision, nil
+}
+
+// retryAfter works out when the estimate leaves room for one more request:
+// either later in the current window, as the previous window's weight drops,
+// or in the next one, once the current window has become the previous one
+func retryAfter(policy Policy, previous int64, current int64, elapsed time.Duration) time.Duration {
+	window := float64(policy.Window)
+	room := float64(policy.Limit - 1)
+
+	if previous > 0 && float64(current) <= room {
+		at := time.Duration(window * (1 - (room-float64(current))/float64(previous)))
+		if at < policy.Window {
+			return max(0, at-elapsed)
+		}
+	}
+
+	wait := policy.Window - elapsed
+	if float64(current) > room {
+		wait += time.Duration(window * (1 - room/float64(current)))
+	}
+	return wait
+}
+
+func counterKey(name string, client string, start time.Time) string {
+	return fmt.Sprintf("%s#%s#%d", name, client, start.Unix())
+}
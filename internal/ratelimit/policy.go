@@ -0,0 +1,110 @@
+// Package ratelimit limits how many requests each client makes in a sliding
+// window. Counts are kept in a Store, which can be shared by every replica.
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What requests are counted by
+const (
+	// KeyIP counts requests by client IP
+	KeyIP = "ip"
+	// KeySubject counts requests by the subject of a valid bearer token, and
+	// requests without one by client IP
+	KeySubject = "subject"
+	// KeyAPIKey counts requests by their X-API-Key header, and requests
+	// without one by client IP
+	KeyAPIKey = "api_key"
+)
+
+// Policy allows Limit requests per Window for each client, told apart by Key
+type Policy struct {
+	Limit  int64
+	Window time.Duration
+	Key    string
+}
+
+// Enabled reports whether the policy limits anything
+func (p Policy) Enabled() bool {
+	return p.Limit > 0
+}
+
+// String formats the policy the way ParsePolicy reads it
+func (p Policy) String() string {
+	if !p.Enabled() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s:%s", p.Limit, p.Window, p.Key)
+}
+
+// ParsePolicy reads a policy of the form "LIMIT/WINDOW[:KEY]", e.g.
+// "10/1m:ip" or "300/1h:subject". The key defaults to ip, and "off" is a
+// policy that limits nothing.
+func ParsePolicy(value string) (Policy, error) {
+	value = strings.TrimSpace(value)
+	if value == "off" {
+		return Policy{}, nil
+	}
+
+	rate, key, found := strings.Cut(value, ":")
+	if !found {
+		key = KeyIP
+	}
+	switch key {
+	case KeyIP, KeySubject, KeyAPIKey:
+	default:
+		return Policy{}, fmt.Errorf("unknown key %q", key)
+	}
+
+	limit, window, found := strings.Cut(rate, "/")
+	if !found {
+		return Policy{}, fmt.Errorf("%q is not of the form LIMIT/WINDOW", rate)
+	}
+
+	policy := Policy{Key: key}
+	var err error
+	if policy.Limit, err = strconv.ParseInt(limit, 10, 64); err != nil || policy.Limit <= 0 {
+		return Policy{}, fmt.Errorf("invalid limit %q", limit)
+	}
+	if policy.Window, err = time.ParseDuration(window); err != nil || policy.Window < time.Second {
+		return Policy{}, fmt.Errorf("invalid window %q: must be at least 1s", window)
+	}
+
+	return policy, nil
+}
+
+// ParseRoutePolicies reads a semicolon-separated list of route policies of
+// the form "METHOD /route/pattern=POLICY", e.g.
+// "POST /api/v1/users/login=10/1m:ip; PUT /api/v1/users/{username}/profile=30/1h:subject".
+// Routes are paths as listed in the OpenAPI document and policies are read
+// with ParsePolicy.
+func ParseRoutePolicies(value string) (map[string]Policy, error) {
+	policies := make(map[string]Policy)
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		route, policy, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("%q is not of the form ROUTE=POLICY", entry)
+		}
+
+		method, pattern, found := strings.Cut(strings.TrimSpace(route), " ")
+		if !found || !strings.HasPrefix(strings.TrimSpace(pattern), "/") {
+			return nil, fmt.Errorf("route %q is not of the form \"METHOD /pattern\"", route)
+		}
+
+		parsed, err := ParsePolicy(policy)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %w", route, err)
+		}
+		policies[strings.ToUpper(method)+" "+strings.TrimSpace(pattern)] = parsed
+	}
+	return policies, nil
+}
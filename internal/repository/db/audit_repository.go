@@ -0,0 +1,219 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	apperrors "github.com/zzenonn/go-zenon-api-aws/internal/errors"
+)
+
+// Every audit event is kept in a single partition, sorted by sequence number
+const auditPartition = "audit"
+
+// An append that loses its sequence number to another event waits for a
+// random time below its backoff, which doubles from auditRetryBase up to
+// auditRetryMax, and tries again until its context ends. Contexts without a
+// deadline get auditAppendTimeout.
+const (
+	auditRetryBase     = 10 * time.Millisecond
+	auditRetryMax      = time.Second
+	auditAppendTimeout = 30 * time.Second
+)
+
+// AuditRepository manages DynamoDB interactions for the audit log.
+type AuditRepository struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewAuditRepository initializes a new AuditRepository.
+func NewAuditRepository(client *dynamodb.Client, tableName string) AuditRepository {
+	return AuditRepository{
+		client:    client,
+		tableName: tableName,
+	}
+}
+
+// Ping checks that the audit table is available
+func (repo *AuditRepository) Ping(ctx context.Context) error {
+	return pingTable(ctx, repo.client, repo.tableName)
+}
+
+// AppendEvent chains the event to the newest one and writes it on condition
+// that its sequence number is still free. Events are never overwritten, so
+// concurrent writers cannot fork the chain; the loser backs off, reads the
+// new head and tries again until ctx ends.
+func (repo *AuditRepository) AppendEvent(ctx context.Context, event domain.AuditEvent) (domain.AuditEvent, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, auditAppendTimeout)
+		defer cancel()
+	}
+
+	for backoff := auditRetryBase; ; backoff = min(2*backoff, auditRetryMax) {
+		prev, err := repo.newestEvent(ctx)
+		if err != nil {
+			return domain.AuditEvent{}, err
+		}
+		event.Chain(prev)
+
+		item, err := attributevalue.MarshalMap(event)
+		if err != nil {
+			return domain.AuditEvent{}, fmt.Errorf("failed to marshal audit event: %w", err)
+		}
+		item["pk"] = &types.AttributeValueMemberS{Value: auditPartition}
+
+		_, err = repo.client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:           aws.String(repo.tableName),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(seq)"),
+		})
+		if err == nil {
+			return event, nil
+		}
+
+		var conditionFailed *types.ConditionalCheckFailedException
+		if !errors.As(err, &conditionFailed) {
+			return domain.AuditEvent{}, fmt.Errorf("failed to put audit event: %w", err)
+		}
+
+		timer := time.NewTimer(rand.N(backoff))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return domain.AuditEvent{}, fmt.Errorf("failed to append audit event: %w", ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// ListEvents queries one page of the events matching the filter, newest
+// first. The filter is applied after the page is read, so pages may hold
+// fewer events than requested even when more follow.
+func (repo *AuditRepository) ListEvents(ctx context.Context, filter domain.AuditFilter, pageSize int, nextToken string) ([]domain.AuditEvent, string, error) {
+	startKey, err := decodeStartKey(nextToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", apperrors.ErrInvalidPageToken, err)
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(repo.tableName),
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: auditPartition},
+		},
+		ScanIndexForward:  aws.Bool(false),
+		ExclusiveStartKey: startKey,
+	}
+	if pageSize > 0 {
+		input.Limit = aws.Int32(int32(pageSize))
+	}
+
+	// ACTION is a reserved word, so every attribute is referred to by name
+	var conditions []string
+	names := map[string]string{}
+	for attribute, value := range map[string]string{
+		"actor":   filter.Actor,
+		"target":  filter.Target,
+		"action":  filter.Action,
+		"outcome": filter.Outcome,
+	} {
+		if value == "" {
+			continue
+		}
+		conditions = append(conditions, fmt.Sprintf("#%s = :%s", attribute, attribute))
+		names["#"+attribute] = attribute
+		input.ExpressionAttributeValues[":"+attribute] = &types.AttributeValueMemberS{Value: value}
+	}
+	if len(conditions) > 0 {
+		input.FilterExpression = aws.String(strings.Join(conditions, " AND "))
+		input.ExpressionAttributeNames = names
+	}
+
+	result, err := repo.client.Query(ctx, input)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query audit events: %w", err)
+	}
+
+	events := []domain.AuditEvent{}
+	if err := attributevalue.UnmarshalListOfMaps(result.Items, &events); err != nil {
+		return nil, "", fmt.Errorf("failed to unmarshal audit events: %w", err)
+	}
+
+	token, err := encodeStartKey(result.LastEvaluatedKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode pagination token: %w", err)
+	}
+
+	return events, token, nil
+}
+
+// ChainEvents queries up to limit events following the event with the given
+// sequence number, oldest first
+func (repo *AuditRepository) ChainEvents(ctx context.Context, after int64, limit int) ([]domain.AuditEvent, error) {
+	events := []domain.AuditEvent{}
+	var startKey map[string]types.AttributeValue
+	for {
+		result, err := repo.client.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(repo.tableName),
+			KeyConditionExpression: aws.String("pk = :pk AND seq > :after"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk":    &types.AttributeValueMemberS{Value: auditPartition},
+				":after": numberValue(after),
+			},
+			ConsistentRead:    aws.Bool(true),
+			Limit:             aws.Int32(int32(limit - len(events))),
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query audit events: %w", err)
+		}
+
+		page := []domain.AuditEvent{}
+		if err := attributevalue.UnmarshalListOfMaps(result.Items, &page); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal audit events: %w", err)
+		}
+		events = append(events, page...)
+
+		// A query stops early once it has read 1 MB
+		if result.LastEvaluatedKey == nil || len(events) >= limit {
+			return events, nil
+		}
+		startKey = result.LastEvaluatedKey
+	}
+}
+
+// newestEvent returns the event with the highest sequence number, or the
+// zero AuditEvent if the log is empty
+func (repo *AuditRepository) newestEvent(ctx context.Context) (domain.AuditEvent, error) {
+	result, err := repo.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(repo.tableName),
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: auditPartition},
+		},
+		ScanIndexForward: aws.Bool(false),
+		ConsistentRead:   aws.Bool(true),
+		Limit:            aws.Int32(1),
+	})
+	if err != nil {
+		return domain.AuditEvent{}, fmt.Errorf("failed to query newest audit event: %w", err)
+	}
+
+	var event domain.AuditEvent
+	if len(result.Items) > 0 {
+		if err := attributevalue.UnmarshalMap(result.Items[0], &event); err != nil {
+			return domain.AuditEvent{}, fmt.Errorf("failed to unmarshal audit event: %w", err)
+		}
+	}
+	return event, nil
+}
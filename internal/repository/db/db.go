@@ -10,6 +10,7 @@ import (
 type DynamoDb struct {
 	Client        *dynamodb.Client
 	TaggingClient *resourcegroupstaggingapi.Client
+	cfg           *config.Config
 }
 
 func NewDatabase(cfg *config.Config) (*DynamoDb, error) {
@@ -26,5 +27,6 @@ func NewDatabase(cfg *config.Config) (*DynamoDb, error) {
 	return &DynamoDb{
 		Client:        client,
 		TaggingClient: taggingClient,
+		cfg:           cfg,
 	}, nil
 }
@@ -0,0 +1,626 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	apperrors "github.com/zzenonn/go-zenon-api-aws/internal/errors"
+)
+
+// Sort keys of the items in the files table. Every user partition holds one
+// item per file, one per incomplete multipart upload and a single usage counter.
+// Content-addressed blobs are kept in partitions of their own.
+const (
+	fileSortKeyPrefix   = "file#"
+	uploadSortKeyPrefix = "upload#"
+	usageSortKey        = "usage"
+	blobKeyPrefix       = "blob#"
+	blobSortKey         = "blob"
+)
+
+// FileRepository manages DynamoDB interactions for file attachment metadata.
+type FileRepository struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewFileRepository initializes a new FileRepository.
+func NewFileRepository(client *dynamodb.Client, tableName string) FileRepository {
+	return FileRepository{
+		client:    client,
+		tableName: tableName,
+	}
+}
+
+// Ping checks that the files table is available
+func (repo *FileRepository) Ping(ctx context.Context) error {
+	return pingTable(ctx, repo.client, repo.tableName)
+}
+
+// CreateFile writes the file item and adds its size to the usage counter in
+// one transaction. The counter update is conditional on the result staying
+// within quota, so concurrent uploads cannot overshoot it.
+func (repo *FileRepository) CreateFile(ctx context.Context, file domain.File, quota int64) error {
+	if quota > 0 && file.Size > quota {
+		return apperrors.ErrQuotaExceeded
+	}
+
+	item, err := attributevalue.MarshalMap(file)
+	if err != nil {
+		return fmt.Errorf("failed to marshal file: %w", err)
+	}
+	item["sk"] = &types.AttributeValueMemberS{Value: fileSortKeyPrefix + file.ID}
+
+	_, err = repo.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Update: repo.reserve(file.Username, file.Size, quota)},
+			{
+				Put: &types.Put{
+					TableName:           aws.String(repo.tableName),
+					Item:                item,
+					ConditionExpression: aws.String("attribute_not_exists(pk)"),
+				},
+			},
+		},
+	})
+	if err != nil {
+		if conditionFailed(err, 0) {
+			return apperrors.ErrQuotaExceeded
+		}
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+
+	return nil
+}
+
+func (repo *FileRepository) GetFile(ctx context.Context, username string, id string) (domain.File, error) {
+	result, err := repo.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(repo.tableName),
+		Key:       repo.key(username, fileSortKeyPrefix+id),
+	})
+	if err != nil {
+		return domain.File{}, fmt.Errorf("failed to get file: %w", err)
+	}
+
+	if result.Item == nil {
+		return domain.File{}, apperrors.ErrFileNotFound
+	}
+
+	var file domain.File
+	if err := attributevalue.UnmarshalMap(result.Item, &file); err != nil {
+		return domain.File{}, fmt.Errorf("failed to unmarshal file: %w", err)
+	}
+
+	return file, nil
+}
+
+// ListFiles queries one page of a user's files in ID order
+func (repo *FileRepository) ListFiles(ctx context.Context, username string, pageSize int, nextToken string) ([]domain.File, string, error) {
+	startKey, err := decodeStartKey(nextToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", apperrors.ErrInvalidPageToken, err)
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(repo.tableName),
+		KeyConditionExpression: aws.String("pk = :username AND begins_with(sk, :prefix)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":username": &types.AttributeValueMemberS{Value: username},
+			":prefix":   &types.AttributeValueMemberS{Value: fileSortKeyPrefix},
+		},
+		ExclusiveStartKey: startKey,
+	}
+	if pageSize > 0 {
+		input.Limit = aws.Int32(int32(pageSize))
+	}
+
+	result, err := repo.client.Query(ctx, input)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query files: %w", err)
+	}
+
+	files := []domain.File{}
+	if err := attributevalue.UnmarshalListOfMaps(result.Items, &files); err != nil {
+		return nil, "", fmt.Errorf("failed to unmarshal files: %w", err)
+	}
+
+	token, err := encodeStartKey(result.LastEvaluatedKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode pagination token: %w", err)
+	}
+
+	return files, token, nil
+}
+
+// DeleteFile deletes the file item and subtracts its size from the usage
+// counter in one transaction
+func (repo *FileRepository) DeleteFile(ctx context.Context, username string, id string) error {
+	file, err := repo.GetFile(ctx, username, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Delete: &types.Delete{
+					TableName:           aws.String(repo.tableName),
+					Key:                 repo.key(username, fileSortKeyPrefix+id),
+					ConditionExpression: aws.String("attribute_exists(pk)"),
+				},
+			},
+			{
+				Update: &types.Update{
+					TableName:        aws.String(repo.tableName),
+					Key:              repo.key(username, usageSortKey),
+					UpdateExpression: aws.String("ADD used_bytes :size"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":size": numberValue(-file.Size),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		// Another request deleted the file first
+		if conditionFailed(err, 0) {
+			return apperrors.ErrFileNotFound
+		}
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+
+	return nil
+}
+
+func (repo *FileRepository) GetUsage(ctx context.Context, username string) (int64, error) {
+	result, err := repo.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(repo.tableName),
+		Key:       repo.key(username, usageSortKey),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get usage: %w", err)
+	}
+
+	var usage struct {
+		UsedBytes int64 `dynamodbav:"used_bytes"`
+	}
+	if err := attributevalue.UnmarshalMap(result.Item, &usage); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal usage: %w", err)
+	}
+
+	return usage.UsedBytes, nil
+}
+
+// CreateUpload writes the upload item and reserves its size in the usage
+// counter in one transaction, with the same quota check as CreateFile
+func (repo *FileRepository) CreateUpload(ctx context.Context, upload domain.MultipartUpload, quota int64) error {
+	if quota > 0 && upload.Size > quota {
+		return apperrors.ErrQuotaExceeded
+	}
+
+	item, err := attributevalue.MarshalMap(upload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal upload: %w", err)
+	}
+	item["sk"] = &types.AttributeValueMemberS{Value: uploadSortKeyPrefix + upload.ID}
+
+	_, err = repo.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Update: repo.reserve(upload.Username, upload.Size, quota)},
+			{
+				Put: &types.Put{
+					TableName:           aws.String(repo.tableName),
+					Item:                item,
+					ConditionExpression: aws.String("attribute_not_exists(pk)"),
+				},
+			},
+		},
+	})
+	if err != nil {
+		if conditionFailed(err, 0) {
+			return apperrors.ErrQuotaExceeded
+		}
+		return fmt.Errorf("failed to create upload: %w", err)
+	}
+
+	return nil
+}
+
+func (repo *FileRepository) GetUpload(ctx context.Context, username string, id string) (domain.MultipartUpload, error) {
+	result, err := repo.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(repo.tableName),
+		Key:       repo.key(username, uploadSortKeyPrefix+id),
+	})
+	if err != nil {
+		return domain.MultipartUpload{}, fmt.Errorf("failed to get upload: %w", err)
+	}
+
+	if result.Item == nil {
+		return domain.MultipartUpload{}, apperrors.ErrUploadNotFound
+	}
+
+	var upload domain.MultipartUpload
+	if err := attributevalue.UnmarshalMap(result.Item, &upload); err != nil {
+		return domain.MultipartUpload{}, fmt.Errorf("failed to unmarshal upload: %w", err)
+	}
+
+	return upload, nil
+}
+
+// CompleteUpload deletes the upload item and writes the file item in one
+// transaction. The usage counter is only adjusted if the sizes differ.
+func (repo *FileRepository) CompleteUpload(ctx context.Context, file domain.File) error {
+	upload, err := repo.GetUpload(ctx, file.Username, file.ID)
+	if err != nil {
+		return err
+	}
+
+	item, err := attributevalue.MarshalMap(file)
+	if err != nil {
+		return fmt.Errorf("failed to marshal file: %w", err)
+	}
+	item["sk"] = &types.AttributeValueMemberS{Value: fileSortKeyPrefix + file.ID}
+
+	transaction := []types.TransactWriteItem{
+		{
+			Delete: &types.Delete{
+				TableName:           aws.String(repo.tableName),
+				Key:                 repo.key(file.Username, uploadSortKeyPrefix+file.ID),
+				ConditionExpression: aws.String("attribute_exists(pk)"),
+			},
+		},
+		{
+			Put: &types.Put{
+				TableName:           aws.String(repo.tableName),
+				Item:                item,
+				ConditionExpression: aws.String("attribute_not_exists(pk)"),
+			},
+		},
+	}
+	if file.Size != upload.Size {
+		transaction = append(transaction, types.TransactWriteItem{
+			Update: repo.reserve(file.Username, file.Size-upload.Size, 0),
+		})
+	}
+
+	_, err = repo.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: transaction,
+	})
+	if err != nil {
+		// Another request completed or aborted the upload first
+		if conditionFailed(err, 0) {
+			return apperrors.ErrUploadNotFound
+		}
+		return fmt.Errorf("failed to complete upload: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteUpload deletes the upload item and releases its reservation in one
+// transaction
+func (repo *FileRepository) DeleteUpload(ctx context.Context, username string, id string) error {
+	upload, err := repo.GetUpload(ctx, username, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Delete: &types.Delete{
+					TableName:           aws.String(repo.tableName),
+					Key:                 repo.key(username, uploadSortKeyPrefix+id),
+					ConditionExpression: aws.String("attribute_exists(pk)"),
+				},
+			},
+			{Update: repo.reserve(username, -upload.Size, 0)},
+		},
+	})
+	if err != nil {
+		if conditionFailed(err, 0) {
+			return apperrors.ErrUploadNotFound
+		}
+		return fmt.Errorf("failed to delete upload: %w", err)
+	}
+
+	return nil
+}
+
+// ListStaleUploads scans the table for uploads started before cutoff. Uploads
+// are few and short-lived, so a filtered scan is cheaper than an index.
+func (repo *FileRepository) ListStaleUploads(ctx context.Context, cutoff time.Time) ([]domain.MultipartUpload, error) {
+	paginator := dynamodb.NewScanPaginator(repo.client, &dynamodb.ScanInput{
+		TableName:        aws.String(repo.tableName),
+		FilterExpression: aws.String("begins_with(sk, :prefix)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":prefix": &types.AttributeValueMemberS{Value: uploadSortKeyPrefix},
+		},
+	})
+
+	var stale []domain.MultipartUpload
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan uploads: %w", err)
+		}
+
+		var uploads []domain.MultipartUpload
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &uploads); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal uploads: %w", err)
+		}
+
+		// Timestamps are stored as RFC 3339 strings, which do not compare
+		// reliably as strings, so filter here instead of in the scan
+		for _, upload := range uploads {
+			if upload.CreatedAt.Before(cutoff) {
+				stale = append(stale, upload)
+			}
+		}
+	}
+
+	return stale, nil
+}
+
+// MarkUploadAssembled records when an upload's parts were assembled and the
+// checksum the file must have
+func (repo *FileRepository) MarkUploadAssembled(ctx context.Context, username string, id string, checksum string, at time.Time) error {
+	assembledAt, err := attributevalue.Marshal(at)
+	if err != nil {
+		return fmt.Errorf("failed to marshal assembly time: %w", err)
+	}
+
+	update := "SET assembled_at = :at REMOVE checksum"
+	values := map[string]types.AttributeValue{":at": assembledAt}
+	if checksum != "" {
+		update = "SET assembled_at = :at, checksum = :checksum"
+		values[":checksum"] = &types.AttributeValueMemberS{Value: checksum}
+	}
+
+	_, err = repo.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(repo.tableName),
+		Key:                       repo.key(username, uploadSortKeyPrefix+id),
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("attribute_exists(pk)"),
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		var conditionErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			return apperrors.ErrUploadNotFound
+		}
+		return fmt.Errorf("failed to mark upload assembled: %w", err)
+	}
+
+	return nil
+}
+
+// ListAssembledUploads scans the table for uploads assembled before cutoff,
+// filtering on the timestamp here for the same reason as ListStaleUploads
+func (repo *FileRepository) ListAssembledUploads(ctx context.Context, cutoff time.Time) ([]domain.MultipartUpload, error) {
+	paginator := dynamodb.NewScanPaginator(repo.client, &dynamodb.ScanInput{
+		TableName:        aws.String(repo.tableName),
+		FilterExpression: aws.String("begins_with(sk, :prefix) AND attribute_exists(assembled_at)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":prefix": &types.AttributeValueMemberS{Value: uploadSortKeyPrefix},
+		},
+	})
+
+	var assembled []domain.MultipartUpload
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan uploads: %w", err)
+		}
+
+		var uploads []domain.MultipartUpload
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &uploads); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal uploads: %w", err)
+		}
+
+		for _, upload := range uploads {
+			if upload.AssembledAt != nil && upload.AssembledAt.Before(cutoff) {
+				assembled = append(assembled, upload)
+			}
+		}
+	}
+
+	return assembled, nil
+}
+
+// AcquireBlob adds a reference to a blob, creating it if needed, and returns
+// the blob as it was before
+func (repo *FileRepository) AcquireBlob(ctx context.Context, checksum string, key string) (domain.Blob, error) {
+	result, err := repo.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(repo.tableName),
+		Key:                 repo.key(blobKeyPrefix+checksum, blobSortKey),
+		UpdateExpression:    aws.String("SET checksum = :checksum, object_key = if_not_exists(object_key, :key), stored = if_not_exists(stored, :false) ADD refs :one REMOVE released_at"),
+		ConditionExpression: aws.String("attribute_not_exists(deleting)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":checksum": &types.AttributeValueMemberS{Value: checksum},
+			":key":      &types.AttributeValueMemberS{Value: key},
+			":false":    &types.AttributeValueMemberBOOL{Value: false},
+			":one":      numberValue(1),
+		},
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		var conditionErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			return domain.Blob{}, apperrors.ErrBlobDeleted
+		}
+		return domain.Blob{}, fmt.Errorf("failed to acquire blob: %w", err)
+	}
+
+	if len(result.Attributes) == 0 {
+		return domain.Blob{Checksum: checksum, Key: key}, nil
+	}
+
+	var blob domain.Blob
+	if err := attributevalue.UnmarshalMap(result.Attributes, &blob); err != nil {
+		return domain.Blob{}, fmt.Errorf("failed to unmarshal blob: %w", err)
+	}
+
+	return blob, nil
+}
+
+func (repo *FileRepository) MarkBlobStored(ctx context.Context, checksum string) error {
+	return repo.updateBlob(ctx, checksum, "SET stored = :true", map[string]types.AttributeValue{
+		":true": &types.AttributeValueMemberBOOL{Value: true},
+	})
+}
+
+// ReleaseBlob removes a reference to a blob and records when it was released
+func (repo *FileRepository) ReleaseBlob(ctx context.Context, checksum string) error {
+	now, err := attributevalue.Marshal(time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to marshal release time: %w", err)
+	}
+
+	return repo.updateBlob(ctx, checksum, "ADD refs :minus SET released_at = :now", map[string]types.AttributeValue{
+		":minus": numberValue(-1),
+		":now":   now,
+	})
+}
+
+// ListUnreferencedBlobs scans for blobs without references. Like uploads,
+// blobs are compared by release time in Go.
+func (repo *FileRepository) ListUnreferencedBlobs(ctx context.Context, cutoff time.Time) ([]domain.Blob, error) {
+	paginator := dynamodb.NewScanPaginator(repo.client, &dynamodb.ScanInput{
+		TableName:        aws.String(repo.tableName),
+		FilterExpression: aws.String("begins_with(pk, :prefix) AND refs <= :zero"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":prefix": &types.AttributeValueMemberS{Value: blobKeyPrefix},
+			":zero":   numberValue(0),
+		},
+	})
+
+	var unreferenced []domain.Blob
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan blobs: %w", err)
+		}
+
+		var blobs []domain.Blob
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &blobs); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal blobs: %w", err)
+		}
+
+		for _, blob := range blobs {
+			if blob.ReleasedAt != nil && blob.ReleasedAt.Before(cutoff) {
+				unreferenced = append(unreferenced, blob)
+			}
+		}
+	}
+
+	return unreferenced, nil
+}
+
+// ClaimBlob marks an unreferenced blob for deletion. The condition on refs
+// makes this atomic with AcquireBlob.
+func (repo *FileRepository) ClaimBlob(ctx context.Context, checksum string) error {
+	_, err := repo.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(repo.tableName),
+		Key:                 repo.key(blobKeyPrefix+checksum, blobSortKey),
+		UpdateExpression:    aws.String("SET deleting = :true"),
+		ConditionExpression: aws.String("attribute_exists(pk) AND refs <= :zero"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":true": &types.AttributeValueMemberBOOL{Value: true},
+			":zero": numberValue(0),
+		},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	if err != nil {
+		var conditionErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			if len(conditionErr.Item) == 0 {
+				return apperrors.ErrBlobNotFound
+			}
+			return apperrors.ErrBlobInUse
+		}
+		return fmt.Errorf("failed to claim blob: %w", err)
+	}
+
+	return nil
+}
+
+func (repo *FileRepository) DeleteBlob(ctx context.Context, checksum string) error {
+	_, err := repo.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(repo.tableName),
+		Key:       repo.key(blobKeyPrefix+checksum, blobSortKey),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+
+	return nil
+}
+
+// updateBlob applies an update to an existing blob
+func (repo *FileRepository) updateBlob(ctx context.Context, checksum string, expression string, values map[string]types.AttributeValue) error {
+	_, err := repo.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(repo.tableName),
+		Key:                       repo.key(blobKeyPrefix+checksum, blobSortKey),
+		UpdateExpression:          aws.String(expression),
+		ConditionExpression:       aws.String("attribute_exists(pk)"),
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		var conditionErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			return apperrors.ErrBlobNotFound
+		}
+		return fmt.Errorf("failed to update blob: %w", err)
+	}
+
+	return nil
+}
+
+// reserve returns an update adding size to the user's usage counter. With a
+// positive quota the update fails if the new total would exceed it.
+func (repo *FileRepository) reserve(username string, size int64, quota int64) *types.Update {
+	usage := &types.Update{
+		TableName:        aws.String(repo.tableName),
+		Key:              repo.key(username, usageSortKey),
+		UpdateExpression: aws.String("ADD used_bytes :size"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":size": numberValue(size),
+		},
+	}
+	if quota > 0 {
+		// DynamoDB conditions cannot add, so compare against the remaining headroom
+		usage.ConditionExpression = aws.String("attribute_not_exists(used_bytes) OR used_bytes <= :limit")
+		usage.ExpressionAttributeValues[":limit"] = numberValue(quota - size)
+	}
+	return usage
+}
+
+func (repo *FileRepository) key(partition string, sortKey string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"pk": &types.AttributeValueMemberS{Value: partition},
+		"sk": &types.AttributeValueMemberS{Value: sortKey},
+	}
+}
+
+func numberValue(n int64) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(n, 10)}
+}
+
+// conditionFailed reports whether a transaction was cancelled because the
+// condition of the item at index failed
+func conditionFailed(err error, index int) bool {
+	var cancelled *types.TransactionCanceledException
+	if !errors.As(err, &cancelled) || index >= len(cancelled.CancellationReasons) {
+		return false
+	}
+	return aws.ToString(cancelled.CancellationReasons[index].Code) == "ConditionalCheckFailed"
+}
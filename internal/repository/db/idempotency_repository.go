@@ -0,0 +1,160 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/zzenonn/go-zenon-api-aws/internal/idempotency"
+)
+
+// IdempotencyRepository keeps idempotency records in DynamoDB, shared by
+// every replica. Keys are claimed with conditional writes, and DynamoDB's
+// TTL removes records once they have expired.
+type IdempotencyRepository struct {
+	client    *dynamodb.Client
+	tableName string
+	// Now returns the current time; tests replace it
+	Now func() time.Time
+}
+
+// idempotencyItem is how a record is stored. Status is 0 while the request
+// is in progress.
+type idempotencyItem struct {
+	PK          string      `dynamodbav:"pk"`
+	Fingerprint string      `dynamodbav:"fingerprint"`
+	Token       string      `dynamodbav:"lock_token"`
+	Status      int         `dynamodbav:"status,omitempty"`
+	Header      http.Header `dynamodbav:"header,omitempty"`
+	Body        []byte      `dynamodbav:"body,omitempty"`
+	ExpiresAt   int64       `dynamodbav:"expires_at"`
+}
+
+// NewIdempotencyRepository initializes a new IdempotencyRepository.
+func NewIdempotencyRepository(client *dynamodb.Client, tableName string) IdempotencyRepository {
+	return IdempotencyRepository{
+		client:    client,
+		tableName: tableName,
+		Now:       time.Now,
+	}
+}
+
+// Ping checks that the idempotency table is available
+func (repo *IdempotencyRepository) Ping(ctx context.Context) error {
+	return pingTable(ctx, repo.client, repo.tableName)
+}
+
+// Reserve puts the record unless an unexpired one is kept under key. TTL
+// deletes items up to a few days late, so expired records are overwritten
+// explicitly. The record that failed the condition comes back with the
+// error, so no second read can race with its expiry.
+func (repo *IdempotencyRepository) Reserve(ctx context.Context, key string, record idempotency.Record) (*idempotency.Record, error) {
+	item, err := attributevalue.MarshalMap(toIdempotencyItem(key, record))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal idempotency record: %w", err)
+	}
+
+	_, err = repo.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                           aws.String(repo.tableName),
+		Item:                                item,
+		ConditionExpression:                 aws.String("attribute_not_exists(pk) OR expires_at <= :now"),
+		ExpressionAttributeValues:           map[string]types.AttributeValue{":now": numberValue(repo.Now().Unix())},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	if err == nil {
+		return nil, nil
+	}
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	if !errors.As(err, &conditionFailed) {
+		return nil, fmt.Errorf("failed to put idempotency record: %w", err)
+	}
+
+	var existing idempotencyItem
+	if err := attributevalue.UnmarshalMap(conditionFailed.Item, &existing); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal idempotency record: %w", err)
+	}
+	return existing.record(), nil
+}
+
+// Save puts the record under key on condition that it is still held under
+// token, so that a request whose lock expired cannot overwrite the record of
+// the request that reclaimed the key
+func (repo *IdempotencyRepository) Save(ctx context.Context, key string, token string, record idempotency.Record) error {
+	item, err := attributevalue.MarshalMap(toIdempotencyItem(key, record))
+	if err != nil {
+		return fmt.Errorf("failed to marshal idempotency record: %w", err)
+	}
+
+	_, err = repo.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(repo.tableName),
+		Item:                      item,
+		ConditionExpression:       aws.String("lock_token = :token"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":token": &types.AttributeValueMemberS{Value: token}},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return idempotency.ErrLockLost
+	}
+	if err != nil {
+		return fmt.Errorf("failed to put idempotency record: %w", err)
+	}
+	return nil
+}
+
+// Release deletes the record under key on condition that it is still held
+// under token. A record that is gone or held by another request is left
+// alone.
+func (repo *IdempotencyRepository) Release(ctx context.Context, key string, token string) error {
+	_, err := repo.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                 aws.String(repo.tableName),
+		Key:                       map[string]types.AttributeValue{"pk": &types.AttributeValueMemberS{Value: key}},
+		ConditionExpression:       aws.String("lock_token = :token"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":token": &types.AttributeValueMemberS{Value: token}},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete idempotency record: %w", err)
+	}
+	return nil
+}
+
+func toIdempotencyItem(key string, record idempotency.Record) idempotencyItem {
+	item := idempotencyItem{
+		PK:          key,
+		Fingerprint: record.Fingerprint,
+		Token:       record.Token,
+		ExpiresAt:   record.ExpiresAt.Unix(),
+	}
+	if record.Response != nil {
+		item.Status = record.Response.Status
+		item.Header = record.Response.Header
+		item.Body = record.Response.Body
+	}
+	return item
+}
+
+func (item idempotencyItem) record() *idempotency.Record {
+	record := &idempotency.Record{
+		Fingerprint: item.Fingerprint,
+		Token:       item.Token,
+		ExpiresAt:   time.Unix(item.ExpiresAt, 0),
+	}
+	if item.Status != 0 {
+		record.Response = &idempotency.Response{
+			Status: item.Status,
+			Header: item.Header,
+			Body:   item.Body,
+		}
+	}
+	return record
+}
@@ -3,7 +3,6 @@ package db
 import (
 	"context"
 	"fmt"
-	"os"
 	"strings"
 	"time"
 
@@ -15,22 +14,6 @@ import (
 	"github.com/zzenonn/go-zenon-api-aws/internal/repository/migrate"
 )
 
-func init() {
-	// Set log level based on environment variables
-	switch logLevel := strings.ToLower(os.Getenv("LOG_LEVEL")); logLevel {
-	case "trace":
-		log.SetLevel(log.TraceLevel)
-	case "debug":
-		log.SetLevel(log.DebugLevel)
-	case "info":
-		log.SetLevel(log.InfoLevel)
-	case "warn":
-		log.SetLevel(log.WarnLevel)
-	default:
-		log.SetLevel(log.ErrorLevel)
-	}
-}
-
 type Migration interface {
 	Up(ctx context.Context, client *dynamodb.Client) error
 	Down(ctx context.Context, client *dynamodb.Client) error
@@ -38,16 +21,26 @@ type Migration interface {
 	TableName() string
 }
 
+// migrations returns every migration in the order it must be applied, with
+// table names and settings taken from the application configuration
+func (d *DynamoDb) migrations() []Migration {
+	settings := migrate.NewTableSettings(d.cfg)
+
+	return []Migration{
+		migrate.NewCreateUsersTable(d.cfg.TableName(d.cfg.DynamoDBTable), settings),
+		migrate.NewCreateFilesTable(d.cfg.TableName(d.cfg.FilesTable), settings),
+		migrate.NewCreateAuditTable(d.cfg.TableName(d.cfg.AuditTable), settings),
+		migrate.NewCreateRateLimitsTable(d.cfg.TableName(d.cfg.RateLimitTable), settings),
+		migrate.NewCreateIdempotencyTable(d.cfg.TableName(d.cfg.IdempotencyTable), settings), // Add more migrations here
+	}
+}
+
 func (d *DynamoDb) MigrateDb(ctx context.Context) error {
 	log.Info("migrating database")
 
-	// Define migrations in order
-	migrations := []Migration{
-		&migrate.CreateUsersTable{}, // Add more migrations here
-	}
-	for _, migration := range migrations {
+	for _, migration := range d.migrations() {
 		// Check if migration was already applied
-		if applied, err := d.isMigrationApplied(ctx, migration.Version()); err != nil {
+		if applied, err := d.isMigrationApplied(ctx, migration.Version(), migration.TableName()); err != nil {
 			return fmt.Errorf("could not check migration status: %w", err)
 		} else if applied {
 			log.Infof("skipping migration %s: already applied", migration.Version())
@@ -74,17 +67,14 @@ func (d *DynamoDb) MigrateDb(ctx context.Context) error {
 func (d *DynamoDb) MigrateDown(ctx context.Context) error {
 	log.Info("rolling back database migrations")
 
-	// Define migrations in reverse order for rollback
-	migrations := []Migration{
-		&migrate.CreateUsersTable{},
-	}
+	migrations := d.migrations()
 
 	// Reverse the slice for rollback
 	for i := len(migrations) - 1; i >= 0; i-- {
 		migration := migrations[i]
-		
+
 		// Check if migration was applied
-		if applied, err := d.isMigrationApplied(ctx, migration.Version()); err != nil {
+		if applied, err := d.isMigrationApplied(ctx, migration.Version(), migration.TableName()); err != nil {
 			return fmt.Errorf("could not check migration status: %w", err)
 		} else if !applied {
 			log.Infof("skipping rollback %s: not applied", migration.Version())
@@ -103,7 +93,36 @@ func (d *DynamoDb) MigrateDown(ctx context.Context) error {
 	return nil
 }
 
-func (d *DynamoDb) isMigrationApplied(ctx context.Context, version string) (bool, error) {
+// MigrationState describes whether a single migration has been applied
+type MigrationState struct {
+	Version   string
+	TableName string
+	Applied   bool
+}
+
+// MigrationStatus reports the state of every known migration
+func (d *DynamoDb) MigrationStatus(ctx context.Context) ([]MigrationState, error) {
+	var states []MigrationState
+
+	for _, migration := range d.migrations() {
+		applied, err := d.isMigrationApplied(ctx, migration.Version(), migration.TableName())
+		if err != nil {
+			return nil, fmt.Errorf("could not check migration status: %w", err)
+		}
+
+		states = append(states, MigrationState{
+			Version:   migration.Version(),
+			TableName: migration.TableName(),
+			Applied:   applied,
+		})
+	}
+
+	return states, nil
+}
+
+// isMigrationApplied reports whether the given table carries the migration tag.
+// Matching on the table name keeps environments that share an account apart.
+func (d *DynamoDb) isMigrationApplied(ctx context.Context, version string, tableName string) (bool, error) {
 	input := &resourcegroupstaggingapi.GetResourcesInput{
 		TagFilters: []rgTypes.TagFilter{
 			{
@@ -114,12 +133,21 @@ func (d *DynamoDb) isMigrationApplied(ctx context.Context, version string) (bool
 		ResourceTypeFilters: []string{"dynamodb:table"},
 	}
 
-	result, err := d.TaggingClient.GetResources(ctx, input)
-	if err != nil {
-		return false, fmt.Errorf("failed to check migration tags: %w", err)
+	paginator := resourcegroupstaggingapi.NewGetResourcesPaginator(d.TaggingClient, input)
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(ctx)
+		if err != nil {
+			return false, fmt.Errorf("failed to check migration tags: %w", err)
+		}
+
+		for _, mapping := range result.ResourceTagMappingList {
+			if mapping.ResourceARN != nil && strings.HasSuffix(*mapping.ResourceARN, ":table/"+tableName) {
+				return true, nil
+			}
+		}
 	}
 
-	return len(result.ResourceTagMappingList) > 0, nil
+	return false, nil
 }
 
 func (d *DynamoDb) recordMigration(ctx context.Context, version string, tableName string) error {
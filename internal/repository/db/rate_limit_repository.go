@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// RateLimitRepository keeps rate limit counters in DynamoDB, shared by every
+// replica. Counters are atomic ADD updates, and DynamoDB's TTL removes them
+// once they have expired.
+type RateLimitRepository struct {
+	client    *dynamodb.Client
+	tableName string
+	// Now returns the current time; tests replace it
+	Now func() time.Time
+}
+
+// NewRateLimitRepository initializes a new RateLimitRepository.
+func NewRateLimitRepository(client *dynamodb.Client, tableName string) RateLimitRepository {
+	return RateLimitRepository{
+		client:    client,
+		tableName: tableName,
+		Now:       time.Now,
+	}
+}
+
+// Ping checks that the rate limits table is available
+func (repo *RateLimitRepository) Ping(ctx context.Context) error {
+	return pingTable(ctx, repo.client, repo.tableName)
+}
+
+// Increment adds one to the counter in a single update. The expiry is only
+// set when the counter is created. Counter keys include their window, so an
+// expired counter that TTL has not removed yet is never incremented again.
+func (repo *RateLimitRepository) Increment(ctx context.Context, key string, expiresAt time.Time) (int64, error) {
+	result, err := repo.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(repo.tableName),
+		Key:              map[string]types.AttributeValue{"pk": &types.AttributeValueMemberS{Value: key}},
+		UpdateExpression: aws.String("ADD hits :one SET expires_at = if_not_exists(expires_at, :expires_at)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one":        numberValue(1),
+			":expires_at": numberValue(expiresAt.Unix()),
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment rate limit counter: %w", err)
+	}
+
+	return numberAttribute(result.Attributes, "hits")
+}
+
+// Count reads the counter. TTL deletes items up to a few days late, so
+// expired counters are checked for explicitly.
+func (repo *RateLimitRepository) Count(ctx context.Context, key string) (int64, error) {
+	result, err := repo.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(repo.tableName),
+		Key:       map[string]types.AttributeValue{"pk": &types.AttributeValueMemberS{Value: key}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rate limit counter: %w", err)
+	}
+	if result.Item == nil {
+		return 0, nil
+	}
+
+	expiresAt, err := numberAttribute(result.Item, "expires_at")
+	if err != nil {
+		return 0, err
+	}
+	if repo.Now().Unix() >= expiresAt {
+		return 0, nil
+	}
+
+	return numberAttribute(result.Item, "hits")
+}
+
+func numberAttribute(item map[string]types.AttributeValue, name string) (int64, error) {
+	value, ok := item[name].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, fmt.Errorf("rate limit counter has no %s", name)
+	}
+	return strconv.ParseInt(value.Value, 10, 64)
+}
@@ -5,12 +5,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	apperrors "github.com/zzenonn/go-zenon-api-aws/internal/errors"
 )
 
 // UserRepository manages DynamoDB interactions for the User domain.
@@ -27,18 +30,29 @@ func NewUserRepository(client *dynamodb.Client, tableName string) UserRepository
 	}
 }
 
+// Ping checks that the users table is available
+func (repo *UserRepository) Ping(ctx context.Context) error {
+	return pingTable(ctx, repo.client, repo.tableName)
+}
+
 func (repo *UserRepository) CreateUser(ctx context.Context, user domain.User) (domain.User, error) {
 	userMap, err := attributevalue.MarshalMap(user)
 	if err != nil {
 		return domain.User{}, fmt.Errorf("failed to marshal user: %w", err)
 	}
 
+	// Only create the user if the username is not already taken
 	input := &dynamodb.PutItemInput{
-		TableName: aws.String(repo.tableName),
-		Item:      userMap,
+		TableName:           aws.String(repo.tableName),
+		Item:                userMap,
+		ConditionExpression: aws.String("attribute_not_exists(pk)"),
 	}
 
 	if _, err := repo.client.PutItem(ctx, input); err != nil {
+		var conditionErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			return domain.User{}, apperrors.ErrUserAlreadyExists
+		}
 		return domain.User{}, fmt.Errorf("failed to create user: %w", err)
 	}
 
@@ -61,7 +75,7 @@ func (repo *UserRepository) GetUser(ctx context.Context, username string) (domai
 	}
 
 	if len(result.Items) == 0 {
-		return domain.User{}, errors.New("user not found")
+		return domain.User{}, apperrors.ErrUserNotFound
 	}
 
 	var user domain.User
@@ -72,34 +86,85 @@ func (repo *UserRepository) GetUser(ctx context.Context, username string) (domai
 	return user, nil
 }
 
+// UpdateUser sets the non-empty fields of user on an existing item and returns
+// the full updated user. Fields left empty are not changed.
 func (repo *UserRepository) UpdateUser(ctx context.Context, username string, user domain.User) (domain.User, error) {
 	userMap, err := attributevalue.MarshalMap(user)
 	if err != nil {
 		return domain.User{}, fmt.Errorf("failed to marshal user: %w", err)
 	}
 
+	delete(userMap, "pk")
+	if len(userMap) == 0 {
+		return repo.GetUser(ctx, username)
+	}
+
+	// Sort attribute names so the update expression is deterministic
+	keys := make([]string, 0, len(userMap))
+	for key := range userMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	names := make(map[string]string, len(keys))
+	values := make(map[string]types.AttributeValue, len(keys))
+	assignments := make([]string, 0, len(keys))
+	for i, key := range keys {
+		name := fmt.Sprintf("#f%d", i)
+		value := fmt.Sprintf(":v%d", i)
+		names[name] = key
+		values[value] = userMap[key]
+		assignments = append(assignments, name+" = "+value)
+	}
+
 	input := &dynamodb.UpdateItemInput{
 		TableName: aws.String(repo.tableName),
 		Key: map[string]types.AttributeValue{
 			"pk": &types.AttributeValueMemberS{Value: username},
 		},
-		AttributeUpdates: make(map[string]types.AttributeValueUpdate),
+		UpdateExpression:          aws.String("SET " + strings.Join(assignments, ", ")),
+		ConditionExpression:       aws.String("attribute_exists(pk)"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllNew,
 	}
 
-	for key, value := range userMap {
-		if key != "pk" {
-			input.AttributeUpdates[key] = types.AttributeValueUpdate{
-				Value:  value,
-				Action: types.AttributeActionPut,
-			}
+	result, err := repo.client.UpdateItem(ctx, input)
+	if err != nil {
+		var conditionErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			return domain.User{}, apperrors.ErrUserNotFound
 		}
+		return domain.User{}, fmt.Errorf("failed to update user: %w", err)
 	}
 
-	if _, err := repo.client.UpdateItem(ctx, input); err != nil {
-		return domain.User{}, fmt.Errorf("failed to update user: %w", err)
+	var updated domain.User
+	if err := attributevalue.UnmarshalMap(result.Attributes, &updated); err != nil {
+		return domain.User{}, fmt.Errorf("failed to unmarshal user data: %w", err)
 	}
 
-	return user, nil
+	return updated, nil
+}
+
+// ClearProfilePath removes the profile path and checksum from an existing user
+func (repo *UserRepository) ClearProfilePath(ctx context.Context, username string) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(repo.tableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: username},
+		},
+		UpdateExpression:    aws.String("REMOVE profile_path, profile_checksum"),
+		ConditionExpression: aws.String("attribute_exists(pk)"),
+	}
+
+	if _, err := repo.client.UpdateItem(ctx, input); err != nil {
+		var conditionErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			return apperrors.ErrUserNotFound
+		}
+		return fmt.Errorf("failed to clear profile path: %w", err)
+	}
+	return nil
 }
 
 func (repo *UserRepository) DeleteUser(ctx context.Context, username string) error {
@@ -131,8 +196,8 @@ func (repo *UserRepository) GetUserPassword(ctx context.Context, username string
 		return domain.User{}, fmt.Errorf("failed to get user by username: %w", err)
 	}
 
-	if result.Items[0] == nil {
-		return domain.User{}, errors.New("user not found")
+	if len(result.Items) == 0 {
+		return domain.User{}, apperrors.ErrUserNotFound
 	}
 
 	var user domain.User
@@ -153,7 +218,7 @@ func (repo *UserRepository) GetAllUsers(
 
 	startKey, err := decodeStartKey(nextToken)
 	if err != nil {
-		return nil, "", fmt.Errorf("invalid pagination token: %w", err)
+		return nil, "", fmt.Errorf("%w: %v", apperrors.ErrInvalidPageToken, err)
 	}
 
 	input := &dynamodb.ScanInput{
@@ -1,17 +1,30 @@
 package db
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"strings"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
+// encodeStartKey turns a LastEvaluatedKey into an opaque pagination token.
+// AttributeValue is an interface and cannot be decoded from JSON directly, so
+// the key is converted to plain Go values first.
 func encodeStartKey(key map[string]types.AttributeValue) (string, error) {
 	if key == nil {
 		return "", nil
 	}
-	data, err := json.Marshal(key)
+	var plain map[string]any
+	if err := attributevalue.UnmarshalMap(key, &plain); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(plain)
 	if err != nil {
 		return "", err
 	}
@@ -26,9 +39,26 @@ func decodeStartKey(token string) (map[string]types.AttributeValue, error) {
 	if err != nil {
 		return nil, err
 	}
-	var key map[string]types.AttributeValue
-	if err := json.Unmarshal(data, &key); err != nil {
+	var plain map[string]any
+	if err := json.Unmarshal(data, &plain); err != nil {
 		return nil, err
 	}
-	return key, nil
+	return attributevalue.MarshalMap(plain)
+}
+
+// pingTable checks that a table exists and can serve requests
+func pingTable(ctx context.Context, client *dynamodb.Client, tableName string) error {
+	output, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return err
+	}
+
+	switch status := output.Table.TableStatus; status {
+	case types.TableStatusActive, types.TableStatusUpdating:
+		return nil
+	default:
+		return fmt.Errorf("table %s is %s", tableName, strings.ToLower(string(status)))
+	}
 }
@@ -0,0 +1,251 @@
+package filesystem
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	stderrors "errors"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+)
+
+// URLSigner issues URLs through which the API serves stored files
+type URLSigner interface {
+	Sign(ctx context.Context, key string) (string, error)
+}
+
+// ObjectRepository stores user files below a root directory.
+// All access goes through an os.Root, so keys cannot escape the root even
+// through symlinks.
+type ObjectRepository struct {
+	root   *os.Root
+	signer URLSigner
+}
+
+// NewObjectRepository initializes a new ObjectRepository rooted at
+// dir, creating the directory if needed.
+func NewObjectRepository(dir string, signer URLSigner) (ObjectRepository, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return ObjectRepository{}, err
+	}
+
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return ObjectRepository{}, err
+	}
+
+	return ObjectRepository{
+		root:   root,
+		signer: signer,
+	}, nil
+}
+
+// Ping checks that the root directory can still be read
+func (r *ObjectRepository) Ping(ctx context.Context) error {
+	_, err := r.root.Stat(".")
+	return err
+}
+
+// Upload writes a user file, replacing any file with the same key. The
+// content type is not stored; files are served with the type of their extension.
+// The checksum is computed while writing, and a file that does not match it
+// is removed.
+func (r *ObjectRepository) Upload(ctx context.Context, key string, reader io.Reader, contentType string, checksum string) error {
+	name, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := r.mkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+
+	f, err := r.root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, hash), reader); err != nil {
+		f.Close()
+		r.root.Remove(name)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if checksum != "" && hex.EncodeToString(hash.Sum(nil)) != checksum {
+		r.root.Remove(name)
+		return errors.ErrChecksumMismatch
+	}
+
+	return nil
+}
+
+// GetPresignedUrl returns a signed, expiring API URL for the key. Like S3
+// presigning it does not check that the file exists.
+func (r *ObjectRepository) GetPresignedUrl(ctx context.Context, key string) (string, error) {
+	name, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return r.signer.Sign(ctx, name)
+}
+
+// Delete removes a user file. Deleting a missing file succeeds.
+func (r *ObjectRepository) Delete(ctx context.Context, key string) error {
+	name, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := r.root.Remove(name); err != nil && !stderrors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// Open opens a stored file for reading
+func (r *ObjectRepository) Open(ctx context.Context, key string) (io.ReadSeekCloser, time.Time, error) {
+	name, err := cleanKey(key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	f, err := r.root.Open(name)
+	if stderrors.Is(err, fs.ErrNotExist) {
+		return nil, time.Time{}, errors.ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, time.Time{}, err
+	}
+
+	if info.IsDir() {
+		f.Close()
+		return nil, time.Time{}, errors.ErrObjectNotFound
+	}
+
+	return f, info.ModTime(), nil
+}
+
+// Download returns the content of a stored file. The caller closes it.
+func (r *ObjectRepository) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	f, _, err := r.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Copy copies a stored file to another key
+func (r *ObjectRepository) Copy(ctx context.Context, source string, destination string) error {
+	f, _, err := r.Open(ctx, source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return r.Upload(ctx, destination, f, "", "")
+}
+
+// List returns the files whose keys start with prefix, ordered by key. As
+// with Upload, content types are derived from extensions.
+func (r *ObjectRepository) List(ctx context.Context, prefix string) ([]domain.ObjectInfo, error) {
+	// Only the directory holding the prefix needs to be walked
+	dir := "."
+	if i := strings.LastIndex(prefix, "/"); i > 0 {
+		cleaned, err := cleanKey(prefix[:i])
+		if err != nil {
+			return nil, err
+		}
+		dir = cleaned
+	}
+
+	var objects []domain.ObjectInfo
+	err := fs.WalkDir(r.root.FS(), dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if stderrors.Is(err, fs.ErrNotExist) {
+				return fs.SkipDir
+			}
+			return err
+		}
+
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		objects = append(objects, domain.ObjectInfo{
+			Key:          name,
+			Size:         info.Size(),
+			ContentType:  mime.TypeByExtension(path.Ext(name)),
+			LastModified: info.ModTime().UTC(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+// mkdirAll creates dir and its parents inside the root
+func (r *ObjectRepository) mkdirAll(dir string) error {
+	if dir == "." {
+		return nil
+	}
+
+	current := ""
+	for _, segment := range strings.Split(dir, "/") {
+		current = path.Join(current, segment)
+		if err := r.root.Mkdir(current, 0750); err != nil && !stderrors.Is(err, fs.ErrExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cleanKey rejects keys that are absolute, empty or contain ".." segments,
+// backslashes or NUL bytes, and returns the cleaned slash-separated key
+func cleanKey(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.ContainsAny(key, "\\\x00") {
+		return "", errors.ErrInvalidObjectKey
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." {
+			return "", errors.ErrInvalidObjectKey
+		}
+	}
+
+	cleaned := path.Clean(key)
+	if cleaned == "." {
+		return "", errors.ErrInvalidObjectKey
+	}
+
+	return cleaned, nil
+}
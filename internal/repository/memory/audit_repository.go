@@ -0,0 +1,95 @@
+package memory
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+)
+
+// AuditRepository keeps the audit log in memory, oldest event first.
+type AuditRepository struct {
+	mu     *sync.RWMutex
+	events []domain.AuditEvent
+}
+
+// NewAuditRepository initializes a new, empty AuditRepository.
+func NewAuditRepository() AuditRepository {
+	return AuditRepository{
+		mu: &sync.RWMutex{},
+	}
+}
+
+// AppendEvent chains the event to the newest one and appends it
+func (repo *AuditRepository) AppendEvent(ctx context.Context, event domain.AuditEvent) (domain.AuditEvent, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var prev domain.AuditEvent
+	if len(repo.events) > 0 {
+		prev = repo.events[len(repo.events)-1]
+	}
+
+	event.Chain(prev)
+	repo.events = append(repo.events, event)
+	return event, nil
+}
+
+// ListEvents returns one page of the events matching the filter, newest
+// first. The token is the sequence number below which the next page starts.
+func (repo *AuditRepository) ListEvents(ctx context.Context, filter domain.AuditFilter, pageSize int, nextToken string) ([]domain.AuditEvent, string, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	before := int64(len(repo.events)) + 1
+	if nextToken != "" {
+		sequence, err := strconv.ParseInt(nextToken, 10, 64)
+		if err != nil || sequence < 1 {
+			return nil, "", errors.ErrInvalidPageToken
+		}
+		before = sequence
+	}
+
+	events := []domain.AuditEvent{}
+	for i := min(before-1, int64(len(repo.events))); i >= 1; i-- {
+		event := repo.events[i-1]
+		if !filter.Matches(event) {
+			continue
+		}
+
+		events = append(events, event)
+		if pageSize > 0 && len(events) == pageSize {
+			if i == 1 {
+				break
+			}
+			return events, strconv.FormatInt(i, 10), nil
+		}
+	}
+
+	return events, "", nil
+}
+
+// ChainEvents returns up to limit events following the event with the given
+// sequence number, oldest first
+func (repo *AuditRepository) ChainEvents(ctx context.Context, after int64, limit int) ([]domain.AuditEvent, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	if after < 0 || after >= int64(len(repo.events)) {
+		return []domain.AuditEvent{}, nil
+	}
+
+	end := min(after+int64(limit), int64(len(repo.events)))
+	return append([]domain.AuditEvent{}, repo.events[after:end]...), nil
+}
+
+// Reset removes every event. The audit log is append-only; this only
+// exists so that tests can start from an empty log.
+func (repo *AuditRepository) Reset() {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.events = nil
+}
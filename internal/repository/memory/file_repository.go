@@ -0,0 +1,344 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+)
+
+// FileRepository keeps file attachment metadata in memory.
+type FileRepository struct {
+	mu      *sync.RWMutex
+	files   map[string]map[string]domain.File
+	uploads map[string]map[string]domain.MultipartUpload
+	blobs   map[string]domain.Blob
+	usage   map[string]int64
+}
+
+// NewFileRepository initializes a new, empty FileRepository.
+func NewFileRepository() FileRepository {
+	return FileRepository{
+		mu:      &sync.RWMutex{},
+		files:   make(map[string]map[string]domain.File),
+		uploads: make(map[string]map[string]domain.MultipartUpload),
+		blobs:   make(map[string]domain.Blob),
+		usage:   make(map[string]int64),
+	}
+}
+
+// CreateFile records a file and adds its size to the user's usage
+func (repo *FileRepository) CreateFile(ctx context.Context, file domain.File, quota int64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if quota > 0 && repo.usage[file.Username]+file.Size > quota {
+		return errors.ErrQuotaExceeded
+	}
+
+	files, exists := repo.files[file.Username]
+	if !exists {
+		files = make(map[string]domain.File)
+		repo.files[file.Username] = files
+	}
+
+	files[file.ID] = file
+	repo.usage[file.Username] += file.Size
+	return nil
+}
+
+func (repo *FileRepository) GetFile(ctx context.Context, username string, id string) (domain.File, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	file, exists := repo.files[username][id]
+	if !exists {
+		return domain.File{}, errors.ErrFileNotFound
+	}
+
+	return file, nil
+}
+
+// ListFiles returns files ordered by ID. The pagination token is the encoded
+// ID of the last file on the previous page.
+func (repo *FileRepository) ListFiles(ctx context.Context, username string, pageSize int, nextToken string) ([]domain.File, string, error) {
+	startAfter, err := decodeToken(nextToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errors.ErrInvalidPageToken, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	ids := make([]string, 0, len(repo.files[username]))
+	for id := range repo.files[username] {
+		if id > startAfter {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	if pageSize <= 0 || pageSize > len(ids) {
+		pageSize = len(ids)
+	}
+
+	files := make([]domain.File, 0, pageSize)
+	for _, id := range ids[:pageSize] {
+		files = append(files, repo.files[username][id])
+	}
+
+	token := ""
+	if pageSize < len(ids) {
+		token = encodeToken(ids[pageSize-1])
+	}
+
+	return files, token, nil
+}
+
+// DeleteFile removes a file and subtracts its size from the user's usage
+func (repo *FileRepository) DeleteFile(ctx context.Context, username string, id string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	file, exists := repo.files[username][id]
+	if !exists {
+		return errors.ErrFileNotFound
+	}
+
+	delete(repo.files[username], id)
+	repo.usage[username] -= file.Size
+	return nil
+}
+
+func (repo *FileRepository) GetUsage(ctx context.Context, username string) (int64, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	return repo.usage[username], nil
+}
+
+// CreateUpload records a multipart upload and reserves its size
+func (repo *FileRepository) CreateUpload(ctx context.Context, upload domain.MultipartUpload, quota int64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if quota > 0 && repo.usage[upload.Username]+upload.Size > quota {
+		return errors.ErrQuotaExceeded
+	}
+
+	uploads, exists := repo.uploads[upload.Username]
+	if !exists {
+		uploads = make(map[string]domain.MultipartUpload)
+		repo.uploads[upload.Username] = uploads
+	}
+
+	upload.Parts = nil
+	uploads[upload.ID] = upload
+	repo.usage[upload.Username] += upload.Size
+	return nil
+}
+
+func (repo *FileRepository) GetUpload(ctx context.Context, username string, id string) (domain.MultipartUpload, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	upload, exists := repo.uploads[username][id]
+	if !exists {
+		return domain.MultipartUpload{}, errors.ErrUploadNotFound
+	}
+
+	return upload, nil
+}
+
+// CompleteUpload replaces an upload by the finished file, keeping its reservation
+func (repo *FileRepository) CompleteUpload(ctx context.Context, file domain.File) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	upload, exists := repo.uploads[file.Username][file.ID]
+	if !exists {
+		return errors.ErrUploadNotFound
+	}
+
+	files, exists := repo.files[file.Username]
+	if !exists {
+		files = make(map[string]domain.File)
+		repo.files[file.Username] = files
+	}
+
+	delete(repo.uploads[file.Username], file.ID)
+	files[file.ID] = file
+	repo.usage[file.Username] += file.Size - upload.Size
+	return nil
+}
+
+// DeleteUpload removes an upload and releases its reservation
+func (repo *FileRepository) DeleteUpload(ctx context.Context, username string, id string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	upload, exists := repo.uploads[username][id]
+	if !exists {
+		return errors.ErrUploadNotFound
+	}
+
+	delete(repo.uploads[username], id)
+	repo.usage[username] -= upload.Size
+	return nil
+}
+
+func (repo *FileRepository) ListStaleUploads(ctx context.Context, cutoff time.Time) ([]domain.MultipartUpload, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	var stale []domain.MultipartUpload
+	for _, uploads := range repo.uploads {
+		for _, upload := range uploads {
+			if upload.CreatedAt.Before(cutoff) {
+				stale = append(stale, upload)
+			}
+		}
+	}
+
+	return stale, nil
+}
+
+// MarkUploadAssembled records when an upload's parts were assembled
+func (repo *FileRepository) MarkUploadAssembled(ctx context.Context, username string, id string, checksum string, at time.Time) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	upload, exists := repo.uploads[username][id]
+	if !exists {
+		return errors.ErrUploadNotFound
+	}
+
+	upload.Checksum = checksum
+	upload.AssembledAt = &at
+	repo.uploads[username][id] = upload
+	return nil
+}
+
+func (repo *FileRepository) ListAssembledUploads(ctx context.Context, cutoff time.Time) ([]domain.MultipartUpload, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	var assembled []domain.MultipartUpload
+	for _, uploads := range repo.uploads {
+		for _, upload := range uploads {
+			if upload.AssembledAt != nil && upload.AssembledAt.Before(cutoff) {
+				assembled = append(assembled, upload)
+			}
+		}
+	}
+
+	return assembled, nil
+}
+
+// AcquireBlob adds a reference to a blob, creating it if needed, and returns
+// the blob as it was before
+func (repo *FileRepository) AcquireBlob(ctx context.Context, checksum string, key string) (domain.Blob, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	blob, exists := repo.blobs[checksum]
+	if !exists {
+		blob = domain.Blob{Checksum: checksum, Key: key}
+	}
+	if blob.Deleting {
+		return domain.Blob{}, errors.ErrBlobDeleted
+	}
+
+	acquired := blob
+	acquired.Refs++
+	acquired.ReleasedAt = nil
+	repo.blobs[checksum] = acquired
+
+	return blob, nil
+}
+
+func (repo *FileRepository) MarkBlobStored(ctx context.Context, checksum string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	blob, exists := repo.blobs[checksum]
+	if !exists {
+		return errors.ErrBlobNotFound
+	}
+
+	blob.Stored = true
+	repo.blobs[checksum] = blob
+	return nil
+}
+
+// ReleaseBlob removes a reference to a blob and records when it was released
+func (repo *FileRepository) ReleaseBlob(ctx context.Context, checksum string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	blob, exists := repo.blobs[checksum]
+	if !exists {
+		return errors.ErrBlobNotFound
+	}
+
+	now := time.Now().UTC()
+	blob.Refs--
+	blob.ReleasedAt = &now
+	repo.blobs[checksum] = blob
+	return nil
+}
+
+func (repo *FileRepository) ListUnreferencedBlobs(ctx context.Context, cutoff time.Time) ([]domain.Blob, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	var unreferenced []domain.Blob
+	for _, blob := range repo.blobs {
+		if blob.Refs <= 0 && blob.ReleasedAt != nil && blob.ReleasedAt.Before(cutoff) {
+			unreferenced = append(unreferenced, blob)
+		}
+	}
+
+	return unreferenced, nil
+}
+
+// ClaimBlob marks an unreferenced blob for deletion
+func (repo *FileRepository) ClaimBlob(ctx context.Context, checksum string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	blob, exists := repo.blobs[checksum]
+	if !exists {
+		return errors.ErrBlobNotFound
+	}
+	if blob.Refs > 0 {
+		return errors.ErrBlobInUse
+	}
+
+	blob.Deleting = true
+	repo.blobs[checksum] = blob
+	return nil
+}
+
+func (repo *FileRepository) DeleteBlob(ctx context.Context, checksum string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	delete(repo.blobs, checksum)
+	return nil
+}
+
+// Reset removes every file, upload and blob record
+func (repo *FileRepository) Reset() {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.files = make(map[string]map[string]domain.File)
+	repo.uploads = make(map[string]map[string]domain.MultipartUpload)
+	repo.blobs = make(map[string]domain.Blob)
+	repo.usage = make(map[string]int64)
+}
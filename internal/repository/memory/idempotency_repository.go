@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/zzenonn/go-zenon-api-aws/internal/idempotency"
+)
+
+// idempotencySweepSize is how many records may pile up before expired ones
+// are removed
+const idempotencySweepSize = 10000
+
+// IdempotencyRepository keeps idempotency records in memory, for a single
+// instance.
+type IdempotencyRepository struct {
+	mu      *sync.Mutex
+	records map[string]idempotency.Record
+	// Now returns the current time; tests replace it
+	Now func() time.Time
+}
+
+// NewIdempotencyRepository initializes a new, empty IdempotencyRepository.
+func NewIdempotencyRepository() IdempotencyRepository {
+	return IdempotencyRepository{
+		mu:      &sync.Mutex{},
+		records: make(map[string]idempotency.Record),
+		Now:     time.Now,
+	}
+}
+
+// Reserve saves the record unless an unexpired one is kept under key
+func (repo *IdempotencyRepository) Reserve(ctx context.Context, key string, record idempotency.Record) (*idempotency.Record, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	now := repo.Now()
+	if len(repo.records) >= idempotencySweepSize {
+		repo.sweep(now)
+	}
+
+	if existing, ok := repo.records[key]; ok && now.Before(existing.ExpiresAt) {
+		return &existing, nil
+	}
+	repo.records[key] = record
+	return nil, nil
+}
+
+// Save replaces the record under key if it is still held under token
+func (repo *IdempotencyRepository) Save(ctx context.Context, key string, token string, record idempotency.Record) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if existing, ok := repo.records[key]; !ok || existing.Token != token {
+		return idempotency.ErrLockLost
+	}
+	repo.records[key] = record
+	return nil
+}
+
+// Release removes the record under key if it is still held under token
+func (repo *IdempotencyRepository) Release(ctx context.Context, key string, token string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if existing, ok := repo.records[key]; ok && existing.Token == token {
+		delete(repo.records, key)
+	}
+	return nil
+}
+
+// sweep removes expired records
+func (repo *IdempotencyRepository) sweep(now time.Time) {
+	for key, record := range repo.records {
+		if !now.Before(record.ExpiresAt) {
+			delete(repo.records, key)
+		}
+	}
+}
+
+// Reset removes every record.
+func (repo *IdempotencyRepository) Reset() {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.records = make(map[string]idempotency.Record)
+}
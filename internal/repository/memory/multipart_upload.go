@@ -0,0 +1,170 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+)
+
+type multipartUpload struct {
+	key         string
+	contentType string
+	initiated   time.Time
+	parts       map[int32][]byte
+}
+
+// CreateMultipartUpload starts a multipart upload and returns its ID
+func (r *ObjectRepository) CreateMultipartUpload(ctx context.Context, key string, contentType string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(b)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.uploads[uploadID] = &multipartUpload{
+		key:         key,
+		contentType: contentType,
+		initiated:   time.Now().UTC(),
+		parts:       make(map[int32][]byte),
+	}
+	return uploadID, nil
+}
+
+// UploadPart stores one part of a multipart upload, replacing any earlier
+// part with the same number
+func (r *ObjectRepository) UploadPart(ctx context.Context, key string, uploadID string, number int32, reader io.Reader, size int64) (domain.UploadPart, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return domain.UploadPart{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	upload, err := r.upload(key, uploadID)
+	if err != nil {
+		return domain.UploadPart{}, err
+	}
+
+	upload.parts[number] = data
+	return domain.UploadPart{Number: number, ETag: etag(data), Size: int64(len(data))}, nil
+}
+
+// PresignUploadPart returns a memory:// URL for the part
+func (r *ObjectRepository) PresignUploadPart(ctx context.Context, key string, uploadID string, number int32, size int64, expires time.Duration) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, err := r.upload(key, uploadID); err != nil {
+		return "", err
+	}
+
+	query := url.Values{"uploadId": {uploadID}, "partNumber": {strconv.Itoa(int(number))}}
+	u := url.URL{Scheme: "memory", Host: "objects", Path: "/" + key, RawQuery: query.Encode()}
+	return u.String(), nil
+}
+
+// ListParts returns every part stored for a multipart upload in part order
+func (r *ObjectRepository) ListParts(ctx context.Context, key string, uploadID string) ([]domain.UploadPart, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	upload, err := r.upload(key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]domain.UploadPart, 0, len(upload.parts))
+	for number, data := range upload.parts {
+		parts = append(parts, domain.UploadPart{Number: number, ETag: etag(data), Size: int64(len(data))})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+
+	return parts, nil
+}
+
+// CompleteMultipartUpload joins the given parts into the final object
+func (r *ObjectRepository) CompleteMultipartUpload(ctx context.Context, key string, uploadID string, parts []domain.UploadPart) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	upload, err := r.upload(key, uploadID)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for _, part := range parts {
+		data, exists := upload.parts[part.Number]
+		if !exists || etag(data) != part.ETag {
+			return errors.ErrInvalidPart
+		}
+		buf.Write(data)
+	}
+
+	r.objects[key] = object{data: buf.Bytes(), contentType: upload.contentType, modified: time.Now().UTC()}
+	delete(r.uploads, uploadID)
+	return nil
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts
+func (r *ObjectRepository) AbortMultipartUpload(ctx context.Context, key string, uploadID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.upload(key, uploadID); err != nil {
+		return err
+	}
+
+	delete(r.uploads, uploadID)
+	return nil
+}
+
+// ListMultipartUploads returns the incomplete multipart uploads under prefix
+func (r *ObjectRepository) ListMultipartUploads(ctx context.Context, prefix string) ([]domain.ObjectUpload, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var uploads []domain.ObjectUpload
+	for uploadID, upload := range r.uploads {
+		if strings.HasPrefix(upload.key, prefix) {
+			uploads = append(uploads, domain.ObjectUpload{
+				Key:       upload.key,
+				UploadID:  uploadID,
+				Initiated: upload.initiated,
+			})
+		}
+	}
+
+	return uploads, nil
+}
+
+// upload returns a multipart upload, checking that it belongs to key. The
+// caller holds the lock.
+func (r *ObjectRepository) upload(key string, uploadID string) (*multipartUpload, error) {
+	upload, exists := r.uploads[uploadID]
+	if !exists || upload.key != key {
+		return nil, errors.ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+// etag returns a quoted MD5 of the data, like S3 does for single parts
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
@@ -0,0 +1,160 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+)
+
+// ObjectRepository keeps user files in memory.
+type ObjectRepository struct {
+	mu      *sync.RWMutex
+	objects map[string]object
+	uploads map[string]*multipartUpload
+}
+
+type object struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+// NewObjectRepository initializes a new, empty ObjectRepository.
+func NewObjectRepository() ObjectRepository {
+	return ObjectRepository{
+		mu:      &sync.RWMutex{},
+		objects: make(map[string]object),
+		uploads: make(map[string]*multipartUpload),
+	}
+}
+
+// Upload stores a user file, replacing any file with the same key
+func (r *ObjectRepository) Upload(ctx context.Context, key string, reader io.Reader, contentType string, checksum string) error {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	if sum := sha256.Sum256(data); checksum != "" && hex.EncodeToString(sum[:]) != checksum {
+		return errors.ErrChecksumMismatch
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.objects[key] = object{data: data, contentType: contentType, modified: time.Now().UTC()}
+	return nil
+}
+
+// GetPresignedUrl returns a memory:// URL for the key. Like S3 presigning it
+// does not check that the object exists.
+func (r *ObjectRepository) GetPresignedUrl(ctx context.Context, key string) (string, error) {
+	u := url.URL{Scheme: "memory", Host: "objects", Path: "/" + key}
+	return u.String(), nil
+}
+
+// Delete removes a user file. Deleting a missing file succeeds.
+func (r *ObjectRepository) Delete(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.objects, key)
+	return nil
+}
+
+// Download returns the content of a stored file
+func (r *ObjectRepository) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	obj, exists := r.objects[key]
+	if !exists {
+		return nil, errors.ErrObjectNotFound
+	}
+	return io.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+// Copy copies a stored file to another key, keeping its content type
+func (r *ObjectRepository) Copy(ctx context.Context, source string, destination string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	obj, exists := r.objects[source]
+	if !exists {
+		return errors.ErrObjectNotFound
+	}
+
+	obj.modified = time.Now().UTC()
+	r.objects[destination] = obj
+	return nil
+}
+
+// List returns the objects whose keys start with prefix, ordered by key
+func (r *ObjectRepository) List(ctx context.Context, prefix string) ([]domain.ObjectInfo, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var objects []domain.ObjectInfo
+	for key, obj := range r.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, domain.ObjectInfo{
+				Key:          key,
+				Size:         int64(len(obj.data)),
+				ContentType:  obj.contentType,
+				LastModified: obj.modified,
+			})
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// Get returns the stored contents of a key and whether it exists
+func (r *ObjectRepository) Get(key string) ([]byte, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	obj, exists := r.objects[key]
+	return obj.data, exists
+}
+
+// Keys returns the keys of every stored object in order
+func (r *ObjectRepository) Keys() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := make([]string, 0, len(r.objects))
+	for key := range r.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ContentType returns the content type a key was uploaded with
+func (r *ObjectRepository) ContentType(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.objects[key].contentType
+}
+
+// Reset removes every stored file and multipart upload
+func (r *ObjectRepository) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.objects = make(map[string]object)
+	r.uploads = make(map[string]*multipartUpload)
+}
@@ -0,0 +1,83 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimitSweepSize is how many counters may pile up before expired ones are
+// removed
+const rateLimitSweepSize = 10000
+
+type rateLimitCounter struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// RateLimitRepository keeps rate limit counters in memory, for a single
+// instance.
+type RateLimitRepository struct {
+	mu       *sync.Mutex
+	counters map[string]rateLimitCounter
+	// Now returns the current time; tests replace it
+	Now func() time.Time
+}
+
+// NewRateLimitRepository initializes a new, empty RateLimitRepository.
+func NewRateLimitRepository() RateLimitRepository {
+	return RateLimitRepository{
+		mu:       &sync.Mutex{},
+		counters: make(map[string]rateLimitCounter),
+		Now:      time.Now,
+	}
+}
+
+// Increment adds one to the counter, starting a new one if it has expired
+func (repo *RateLimitRepository) Increment(ctx context.Context, key string, expiresAt time.Time) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	now := repo.Now()
+	if len(repo.counters) >= rateLimitSweepSize {
+		repo.sweep(now)
+	}
+
+	counter, ok := repo.counters[key]
+	if !ok || !now.Before(counter.expiresAt) {
+		counter = rateLimitCounter{expiresAt: expiresAt}
+	}
+	counter.count++
+	repo.counters[key] = counter
+
+	return counter.count, nil
+}
+
+// Count returns the value of the counter, or 0 if it has expired
+func (repo *RateLimitRepository) Count(ctx context.Context, key string) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	counter, ok := repo.counters[key]
+	if !ok || !repo.Now().Before(counter.expiresAt) {
+		return 0, nil
+	}
+	return counter.count, nil
+}
+
+// sweep removes expired counters
+func (repo *RateLimitRepository) sweep(now time.Time) {
+	for key, counter := range repo.counters {
+		if !now.Before(counter.expiresAt) {
+			delete(repo.counters, key)
+		}
+	}
+}
+
+// Reset removes every counter.
+func (repo *RateLimitRepository) Reset() {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.counters = make(map[string]rateLimitCounter)
+}
@@ -0,0 +1,209 @@
+package memory
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+)
+
+// UserRepository keeps users in memory. It mirrors the DynamoDB
+// implementation: creates are conditional, updates only touch the fields
+// that are set and deletes of missing users succeed.
+type UserRepository struct {
+	mu    *sync.RWMutex
+	users map[string]domain.User
+}
+
+// NewUserRepository initializes a new, empty UserRepository.
+func NewUserRepository() UserRepository {
+	return UserRepository{
+		mu:    &sync.RWMutex{},
+		users: make(map[string]domain.User),
+	}
+}
+
+func (repo *UserRepository) CreateUser(ctx context.Context, user domain.User) (domain.User, error) {
+	if user.Username == nil {
+		return domain.User{}, errors.ErrMissingRequiredFields
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, exists := repo.users[*user.Username]; exists {
+		return domain.User{}, errors.ErrUserAlreadyExists
+	}
+
+	stored := cloneUser(user)
+	stored.Password = ""
+	repo.users[*user.Username] = stored
+
+	return user, nil
+}
+
+func (repo *UserRepository) GetUser(ctx context.Context, username string) (domain.User, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	user, exists := repo.users[username]
+	if !exists {
+		return domain.User{}, errors.ErrUserNotFound
+	}
+
+	return cloneUser(user), nil
+}
+
+// UpdateUser sets the non-empty fields of user on an existing user and returns
+// the full updated user. Fields left empty are not changed.
+func (repo *UserRepository) UpdateUser(ctx context.Context, username string, user domain.User) (domain.User, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	existing, exists := repo.users[username]
+	if !exists {
+		return domain.User{}, errors.ErrUserNotFound
+	}
+
+	if len(user.HashedPassword) > 0 {
+		existing.HashedPassword = append([]byte(nil), user.HashedPassword...)
+	}
+	if user.ProfilePath != nil {
+		existing.ProfilePath = stringPtr(*user.ProfilePath)
+	}
+	if user.ProfileChecksum != nil {
+		existing.ProfileChecksum = stringPtr(*user.ProfileChecksum)
+	}
+	if user.Role != nil {
+		existing.Role = stringPtr(*user.Role)
+	}
+	if user.Disabled != nil {
+		disabled := *user.Disabled
+		existing.Disabled = &disabled
+	}
+	if user.ProfileVisibility != nil {
+		existing.ProfileVisibility = stringPtr(*user.ProfileVisibility)
+	}
+
+	repo.users[username] = existing
+
+	return cloneUser(existing), nil
+}
+
+// ClearProfilePath removes the profile path and checksum from an existing user
+func (repo *UserRepository) ClearProfilePath(ctx context.Context, username string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	existing, exists := repo.users[username]
+	if !exists {
+		return errors.ErrUserNotFound
+	}
+
+	existing.ProfilePath = nil
+	existing.ProfileChecksum = nil
+	repo.users[username] = existing
+	return nil
+}
+
+func (repo *UserRepository) DeleteUser(ctx context.Context, username string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	delete(repo.users, username)
+	return nil
+}
+
+// GetAllUsers returns users ordered by username. The pagination token is the
+// encoded username of the last user on the previous page.
+func (repo *UserRepository) GetAllUsers(ctx context.Context, pageSize int, nextToken string) ([]domain.User, string, error) {
+	startAfter, err := decodeToken(nextToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errors.ErrInvalidPageToken, err)
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	usernames := make([]string, 0, len(repo.users))
+	for username := range repo.users {
+		if username > startAfter {
+			usernames = append(usernames, username)
+		}
+	}
+	sort.Strings(usernames)
+
+	if pageSize <= 0 || pageSize > len(usernames) {
+		pageSize = len(usernames)
+	}
+
+	users := make([]domain.User, 0, pageSize)
+	for _, username := range usernames[:pageSize] {
+		users = append(users, cloneUser(repo.users[username]))
+	}
+
+	tokenOut := ""
+	if pageSize < len(usernames) {
+		tokenOut = encodeToken(usernames[pageSize-1])
+	}
+
+	return users, tokenOut, nil
+}
+
+// Reset removes every user
+func (repo *UserRepository) Reset() {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.users = make(map[string]domain.User)
+}
+
+func cloneUser(user domain.User) domain.User {
+	clone := user
+	if user.Username != nil {
+		clone.Username = stringPtr(*user.Username)
+	}
+	if user.HashedPassword != nil {
+		clone.HashedPassword = append([]byte(nil), user.HashedPassword...)
+	}
+	if user.ProfilePath != nil {
+		clone.ProfilePath = stringPtr(*user.ProfilePath)
+	}
+	if user.ProfileChecksum != nil {
+		clone.ProfileChecksum = stringPtr(*user.ProfileChecksum)
+	}
+	if user.Role != nil {
+		clone.Role = stringPtr(*user.Role)
+	}
+	if user.Disabled != nil {
+		disabled := *user.Disabled
+		clone.Disabled = &disabled
+	}
+	if user.ProfileVisibility != nil {
+		clone.ProfileVisibility = stringPtr(*user.ProfileVisibility)
+	}
+	return clone
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func encodeToken(username string) string {
+	return base64.StdEncoding.EncodeToString([]byte(username))
+}
+
+func decodeToken(token string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+	data, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
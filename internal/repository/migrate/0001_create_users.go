@@ -12,23 +12,34 @@ import (
 )
 
 const (
-	TableName = "users"
-	Version   = "20250405000000_users_table"
+	Version = "20250405000000_users_table"
 )
 
-type CreateUsersTable struct{}
+// CreateUsersTable creates the users table and seeds the default admin user
+type CreateUsersTable struct {
+	tableName string
+	settings  TableSettings
+}
+
+// NewCreateUsersTable initializes the migration for the given table
+func NewCreateUsersTable(tableName string, settings TableSettings) *CreateUsersTable {
+	return &CreateUsersTable{
+		tableName: tableName,
+		settings:  settings,
+	}
+}
 
 func (m *CreateUsersTable) Version() string {
 	return Version
 }
 
 func (m *CreateUsersTable) TableName() string {
-	return TableName
+	return m.tableName
 }
 
 func (m *CreateUsersTable) Up(ctx context.Context, client *dynamodb.Client) error {
-	log.Infof("Creating DynamoDB table: %s", TableName)
-	
+	log.Infof("Creating DynamoDB table: %s", m.tableName)
+
 	input := &dynamodb.CreateTableInput{
 		AttributeDefinitions: []types.AttributeDefinition{
 			{
@@ -42,31 +53,33 @@ func (m *CreateUsersTable) Up(ctx context.Context, client *dynamodb.Client) erro
 				KeyType:       types.KeyTypeHash,
 			},
 		},
-		TableName: aws.String(TableName),
-		ProvisionedThroughput: &types.ProvisionedThroughput{
-			ReadCapacityUnits:  aws.Int64(5),
-			WriteCapacityUnits: aws.Int64(5),
-		},
+		TableName: aws.String(m.tableName),
 	}
+	m.settings.Apply(input)
 
 	_, err := client.CreateTable(ctx, input)
 	if err != nil {
-		log.Errorf("Failed to create table %s: %v", TableName, err)
+		log.Errorf("Failed to create table %s: %v", m.tableName, err)
 		return err
 	}
 
-	log.Infof("Waiting for table %s to become active...", TableName)
+	log.Infof("Waiting for table %s to become active...", m.tableName)
 	waiter := dynamodb.NewTableExistsWaiter(client)
 	err = waiter.Wait(ctx, &dynamodb.DescribeTableInput{
-		TableName: aws.String(TableName),
+		TableName: aws.String(m.tableName),
 	}, 5*time.Minute)
 
 	if err != nil {
-		log.Errorf("Table %s failed to become active: %v", TableName, err)
+		log.Errorf("Table %s failed to become active: %v", m.tableName, err)
 		return err
 	}
 
-	log.Infof("Table %s created successfully", TableName)
+	log.Infof("Table %s created successfully", m.tableName)
+
+	if err := m.settings.enablePointInTimeRecovery(ctx, client, m.tableName); err != nil {
+		log.Errorf("Failed to enable point-in-time recovery on table %s: %v", m.tableName, err)
+		return err
+	}
 
 	// Create default admin user
 	log.Info("Creating default admin user")
@@ -81,30 +94,30 @@ func (m *CreateUsersTable) Up(ctx context.Context, client *dynamodb.Client) erro
 }
 
 func (m *CreateUsersTable) Down(ctx context.Context, client *dynamodb.Client) error {
-	log.Infof("Deleting DynamoDB table: %s", TableName)
-	
+	log.Infof("Deleting DynamoDB table: %s", m.tableName)
+
 	input := &dynamodb.DeleteTableInput{
-		TableName: aws.String(TableName),
+		TableName: aws.String(m.tableName),
 	}
 
 	_, err := client.DeleteTable(ctx, input)
 	if err != nil {
-		log.Errorf("Failed to delete table %s: %v", TableName, err)
+		log.Errorf("Failed to delete table %s: %v", m.tableName, err)
 		return err
 	}
 
-	log.Infof("Waiting for table %s to be completely deleted...", TableName)
+	log.Infof("Waiting for table %s to be completely deleted...", m.tableName)
 	waiter := dynamodb.NewTableNotExistsWaiter(client)
 	err = waiter.Wait(ctx, &dynamodb.DescribeTableInput{
-		TableName: aws.String(TableName),
+		TableName: aws.String(m.tableName),
 	}, 5*time.Minute)
 
 	if err != nil {
-		log.Errorf("Table %s failed to be completely deleted: %v", TableName, err)
+		log.Errorf("Table %s failed to be completely deleted: %v", m.tableName, err)
 		return err
 	}
 
-	log.Infof("Table %s deleted successfully", TableName)
+	log.Infof("Table %s deleted successfully", m.tableName)
 	return nil
 }
 
@@ -119,11 +132,12 @@ func (m *CreateUsersTable) createDefaultUser(ctx context.Context, client *dynamo
 	item := map[string]types.AttributeValue{
 		"pk":              &types.AttributeValueMemberS{Value: "admin"},
 		"hashed_password": &types.AttributeValueMemberB{Value: hashedPassword},
+		"role":            &types.AttributeValueMemberS{Value: "admin"},
 	}
 
 	// Put the admin user into the table
 	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(TableName),
+		TableName: aws.String(m.tableName),
 		Item:      item,
 	})
 
@@ -0,0 +1,119 @@
+package migrate
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	FilesTableVersion = "20261018000000_files_table"
+)
+
+// CreateFilesTable creates the table holding file attachment metadata. Items
+// are partitioned by username (pk) and sorted by sk, which holds either
+// "file#<id>" or the per-user "usage" counter.
+type CreateFilesTable struct {
+	tableName string
+	settings  TableSettings
+}
+
+// NewCreateFilesTable initializes the migration for the given table
+func NewCreateFilesTable(tableName string, settings TableSettings) *CreateFilesTable {
+	return &CreateFilesTable{
+		tableName: tableName,
+		settings:  settings,
+	}
+}
+
+func (m *CreateFilesTable) Version() string {
+	return FilesTableVersion
+}
+
+func (m *CreateFilesTable) TableName() string {
+	return m.tableName
+}
+
+func (m *CreateFilesTable) Up(ctx context.Context, client *dynamodb.Client) error {
+	log.Infof("Creating DynamoDB table: %s", m.tableName)
+
+	input := &dynamodb.CreateTableInput{
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("pk"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("sk"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("pk"),
+				KeyType:       types.KeyTypeHash,
+			},
+			{
+				AttributeName: aws.String("sk"),
+				KeyType:       types.KeyTypeRange,
+			},
+		},
+		TableName: aws.String(m.tableName),
+	}
+	m.settings.Apply(input)
+
+	if _, err := client.CreateTable(ctx, input); err != nil {
+		log.Errorf("Failed to create table %s: %v", m.tableName, err)
+		return err
+	}
+
+	log.Infof("Waiting for table %s to become active...", m.tableName)
+	waiter := dynamodb.NewTableExistsWaiter(client)
+	err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(m.tableName),
+	}, 5*time.Minute)
+
+	if err != nil {
+		log.Errorf("Table %s failed to become active: %v", m.tableName, err)
+		return err
+	}
+
+	log.Infof("Table %s created successfully", m.tableName)
+
+	if err := m.settings.enablePointInTimeRecovery(ctx, client, m.tableName); err != nil {
+		log.Errorf("Failed to enable point-in-time recovery on table %s: %v", m.tableName, err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *CreateFilesTable) Down(ctx context.Context, client *dynamodb.Client) error {
+	log.Infof("Deleting DynamoDB table: %s", m.tableName)
+
+	_, err := client.DeleteTable(ctx, &dynamodb.DeleteTableInput{
+		TableName: aws.String(m.tableName),
+	})
+	if err != nil {
+		log.Errorf("Failed to delete table %s: %v", m.tableName, err)
+		return err
+	}
+
+	log.Infof("Waiting for table %s to be completely deleted...", m.tableName)
+	waiter := dynamodb.NewTableNotExistsWaiter(client)
+	err = waiter.Wait(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(m.tableName),
+	}, 5*time.Minute)
+
+	if err != nil {
+		log.Errorf("Table %s failed to be completely deleted: %v", m.tableName, err)
+		return err
+	}
+
+	log.Infof("Table %s deleted successfully", m.tableName)
+	return nil
+}
@@ -0,0 +1,123 @@
+package migrate
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	RateLimitsTableVersion = "20261018000002_rate_limits_table"
+)
+
+// CreateRateLimitsTable creates the table holding rate limit counters, one
+// item per client and window keyed by pk. Counters only matter for two
+// windows, so the table has TTL on expires_at to remove them afterwards.
+type CreateRateLimitsTable struct {
+	tableName string
+	settings  TableSettings
+}
+
+// NewCreateRateLimitsTable initializes the migration for the given table
+func NewCreateRateLimitsTable(tableName string, settings TableSettings) *CreateRateLimitsTable {
+	return &CreateRateLimitsTable{
+		tableName: tableName,
+		settings:  settings,
+	}
+}
+
+func (m *CreateRateLimitsTable) Version() string {
+	return RateLimitsTableVersion
+}
+
+func (m *CreateRateLimitsTable) TableName() string {
+	return m.tableName
+}
+
+func (m *CreateRateLimitsTable) Up(ctx context.Context, client *dynamodb.Client) error {
+	log.Infof("Creating DynamoDB table: %s", m.tableName)
+
+	input := &dynamodb.CreateTableInput{
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("pk"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("pk"),
+				KeyType:       types.KeyTypeHash,
+			},
+		},
+		TableName: aws.String(m.tableName),
+	}
+	m.settings.Apply(input)
+
+	if _, err := client.CreateTable(ctx, input); err != nil {
+		log.Errorf("Failed to create table %s: %v", m.tableName, err)
+		return err
+	}
+
+	log.Infof("Waiting for table %s to become active...", m.tableName)
+	waiter := dynamodb.NewTableExistsWaiter(client)
+	err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(m.tableName),
+	}, 5*time.Minute)
+
+	if err != nil {
+		log.Errorf("Table %s failed to become active: %v", m.tableName, err)
+		return err
+	}
+
+	log.Infof("Table %s created successfully", m.tableName)
+
+	_, err = client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(m.tableName),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String("expires_at"),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		log.Errorf("Failed to enable TTL on table %s: %v", m.tableName, err)
+		return err
+	}
+
+	if err := m.settings.enablePointInTimeRecovery(ctx, client, m.tableName); err != nil {
+		log.Errorf("Failed to enable point-in-time recovery on table %s: %v", m.tableName, err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *CreateRateLimitsTable) Down(ctx context.Context, client *dynamodb.Client) error {
+	log.Infof("Deleting DynamoDB table: %s", m.tableName)
+
+	_, err := client.DeleteTable(ctx, &dynamodb.DeleteTableInput{
+		TableName: aws.String(m.tableName),
+	})
+	if err != nil {
+		log.Errorf("Failed to delete table %s: %v", m.tableName, err)
+		return err
+	}
+
+	log.Infof("Waiting for table %s to be completely deleted...", m.tableName)
+	waiter := dynamodb.NewTableNotExistsWaiter(client)
+	err = waiter.Wait(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(m.tableName),
+	}, 5*time.Minute)
+
+	if err != nil {
+		log.Errorf("Table %s failed to be completely deleted: %v", m.tableName, err)
+		return err
+	}
+
+	log.Infof("Table %s deleted successfully", m.tableName)
+	return nil
+}
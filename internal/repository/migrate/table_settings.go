@@ -0,0 +1,99 @@
+package migrate
+
+import (
+	"context"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+)
+
+// TableSettings holds the deployment-specific options used when creating a table
+type TableSettings struct {
+	BillingMode         types.BillingMode
+	ReadCapacity        int64
+	WriteCapacity       int64
+	TableClass          types.TableClass
+	PointInTimeRecovery bool
+	SSEEnabled          bool
+	SSEKMSKeyID         string
+	Tags                map[string]string
+}
+
+// NewTableSettings builds TableSettings from the application configuration
+func NewTableSettings(cfg *config.Config) TableSettings {
+	settings := TableSettings{
+		BillingMode:         types.BillingModeProvisioned,
+		ReadCapacity:        cfg.DynamoDBReadCapacity,
+		WriteCapacity:       cfg.DynamoDBWriteCapacity,
+		TableClass:          types.TableClassStandard,
+		PointInTimeRecovery: cfg.DynamoDBPointInTimeRecovery,
+		SSEEnabled:          cfg.DynamoDBSSEEnabled,
+		SSEKMSKeyID:         cfg.DynamoDBSSEKMSKeyID,
+		Tags:                cfg.DynamoDBTags,
+	}
+
+	if cfg.DynamoDBBillingMode == "pay_per_request" {
+		settings.BillingMode = types.BillingModePayPerRequest
+	}
+
+	if cfg.DynamoDBTableClass == "standard_infrequent_access" {
+		settings.TableClass = types.TableClassStandardInfrequentAccess
+	}
+
+	return settings
+}
+
+// Apply copies the settings onto a CreateTableInput
+func (s TableSettings) Apply(input *dynamodb.CreateTableInput) {
+	input.BillingMode = s.BillingMode
+	if s.BillingMode == types.BillingModeProvisioned {
+		input.ProvisionedThroughput = &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(s.ReadCapacity),
+			WriteCapacityUnits: aws.Int64(s.WriteCapacity),
+		}
+	}
+
+	input.TableClass = s.TableClass
+
+	if s.SSEEnabled {
+		input.SSESpecification = &types.SSESpecification{
+			Enabled: aws.Bool(true),
+			SSEType: types.SSETypeKms,
+		}
+		if s.SSEKMSKeyID != "" {
+			input.SSESpecification.KMSMasterKeyId = aws.String(s.SSEKMSKeyID)
+		}
+	}
+
+	// Sort keys so the request is deterministic
+	keys := make([]string, 0, len(s.Tags))
+	for key := range s.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		input.Tags = append(input.Tags, types.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(s.Tags[key]),
+		})
+	}
+}
+
+// enablePointInTimeRecovery turns on continuous backups once the table is active
+func (s TableSettings) enablePointInTimeRecovery(ctx context.Context, client *dynamodb.Client, tableName string) error {
+	if !s.PointInTimeRecovery {
+		return nil
+	}
+
+	_, err := client.UpdateContinuousBackups(ctx, &dynamodb.UpdateContinuousBackupsInput{
+		TableName: aws.String(tableName),
+		PointInTimeRecoverySpecification: &types.PointInTimeRecoverySpecification{
+			PointInTimeRecoveryEnabled: aws.Bool(true),
+		},
+	})
+	return err
+}
@@ -0,0 +1,162 @@
+package objectstore
+
+import (
+	"context"
+	stderrors "errors"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+)
+
+// CreateMultipartUpload starts a multipart upload and returns its S3 upload ID
+func (r *ObjectRepository) CreateMultipartUpload(ctx context.Context, key string, contentType string) (string, error) {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(r.bucketName),
+		Key:    aws.String(key),
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+
+	output, err := r.client.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(output.UploadId), nil
+}
+
+// UploadPart stores one part of a multipart upload. The reader must hold
+// exactly size bytes.
+func (r *ObjectRepository) UploadPart(ctx context.Context, key string, uploadID string, number int32, reader io.Reader, size int64) (domain.UploadPart, error) {
+	output, err := r.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(r.bucketName),
+		Key:           aws.String(key),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int32(number),
+		Body:          reader,
+		ContentLength: aws.Int64(size),
+	})
+	if err != nil {
+		return domain.UploadPart{}, uploadError(err)
+	}
+
+	return domain.UploadPart{
+		Number: number,
+		ETag:   aws.ToString(output.ETag),
+		Size:   size,
+	}, nil
+}
+
+// PresignUploadPart returns a URL a client can PUT one part of a multipart
+// upload to. The URL is signed for the exact part size.
+func (r *ObjectRepository) PresignUploadPart(ctx context.Context, key string, uploadID string, number int32, size int64, expires time.Duration) (string, error) {
+	presignClient := s3.NewPresignClient(r.client)
+
+	request, err := presignClient.PresignUploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(r.bucketName),
+		Key:           aws.String(key),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int32(number),
+		ContentLength: aws.Int64(size),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", err
+	}
+	return request.URL, nil
+}
+
+// ListParts returns every part stored for a multipart upload in part order
+func (r *ObjectRepository) ListParts(ctx context.Context, key string, uploadID string) ([]domain.UploadPart, error) {
+	paginator := s3.NewListPartsPaginator(r.client, &s3.ListPartsInput{
+		Bucket:   aws.String(r.bucketName),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+
+	parts := []domain.UploadPart{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, uploadError(err)
+		}
+
+		for _, part := range page.Parts {
+			parts = append(parts, domain.UploadPart{
+				Number: aws.ToInt32(part.PartNumber),
+				ETag:   aws.ToString(part.ETag),
+				Size:   aws.ToInt64(part.Size),
+			})
+		}
+	}
+
+	return parts, nil
+}
+
+// CompleteMultipartUpload assembles the given parts into the final object
+func (r *ObjectRepository) CompleteMultipartUpload(ctx context.Context, key string, uploadID string, parts []domain.UploadPart) error {
+	completed := make([]types.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, types.CompletedPart{
+			PartNumber: aws.Int32(part.Number),
+			ETag:       aws.String(part.ETag),
+		})
+	}
+
+	_, err := r.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(r.bucketName),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	return uploadError(err)
+}
+
+// AbortMultipartUpload discards a multipart upload and its stored parts
+func (r *ObjectRepository) AbortMultipartUpload(ctx context.Context, key string, uploadID string) error {
+	_, err := r.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(r.bucketName),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	return uploadError(err)
+}
+
+// ListMultipartUploads returns the incomplete multipart uploads under prefix
+func (r *ObjectRepository) ListMultipartUploads(ctx context.Context, prefix string) ([]domain.ObjectUpload, error) {
+	paginator := s3.NewListMultipartUploadsPaginator(r.client, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(r.bucketName),
+		Prefix: aws.String(prefix),
+	})
+
+	var uploads []domain.ObjectUpload
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, upload := range page.Uploads {
+			uploads = append(uploads, domain.ObjectUpload{
+				Key:       aws.ToString(upload.Key),
+				UploadID:  aws.ToString(upload.UploadId),
+				Initiated: aws.ToTime(upload.Initiated),
+			})
+		}
+	}
+
+	return uploads, nil
+}
+
+// uploadError maps a missing multipart upload to errors.ErrUploadNotFound
+func uploadError(err error) error {
+	var noSuchUpload *types.NoSuchUpload
+	if stderrors.As(err, &noSuchUpload) {
+		return errors.ErrUploadNotFound
+	}
+	return err
+}
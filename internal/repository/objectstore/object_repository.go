@@ -0,0 +1,329 @@
+package objectstore
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+)
+
+// URLSigner issues the URLs through which clients download stored files,
+// e.g. S3 presigned URLs or CloudFront signed URLs
+type URLSigner interface {
+	Sign(ctx context.Context, key string) (string, error)
+}
+
+// CookieURLSigner is a URLSigner whose Sign may return unsigned URLs covered by
+// signed cookies, and that can sign URLs on their own as well
+type CookieURLSigner interface {
+	SignURL(ctx context.Context, key string) (string, error)
+}
+
+// ObjectRepository manages S3 interactions for user files such as profile
+// images and attachments.
+type ObjectRepository struct {
+	client     *s3.Client
+	bucketName string
+	signer     URLSigner
+}
+
+// NewObjectRepository initializes a new ObjectRepository.
+func NewObjectRepository(client *s3.Client, bucketName string, signer URLSigner) ObjectRepository {
+	return ObjectRepository{
+		client:     client,
+		bucketName: bucketName,
+		signer:     signer,
+	}
+}
+
+// Ping checks that the bucket exists and can be accessed
+func (r *ObjectRepository) Ping(ctx context.Context) error {
+	_, err := r.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(r.bucketName),
+	})
+	return err
+}
+
+// Upload uploads a user file to S3. S3 verifies the SHA-256 checksum when one
+// is given; otherwise the SDK computes it while streaming and S3 stores it
+// with the object.
+func (r *ObjectRepository) Upload(ctx context.Context, key string, reader io.Reader, contentType string, checksum string) error {
+	input := &s3.PutObjectInput{
+		Bucket:            aws.String(r.bucketName),
+		Key:               aws.String(key),
+		Body:              reader,
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	}
+
+	// Without a content type S3 serves the file as binary/octet-stream
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+
+	if checksum != "" {
+		sum, err := hex.DecodeString(checksum)
+		if err != nil {
+			return errors.ErrInvalidChecksum
+		}
+		input.ChecksumSHA256 = aws.String(base64.StdEncoding.EncodeToString(sum))
+	}
+
+	_, err := r.client.PutObject(ctx, input)
+	var apiErr smithy.APIError
+	if stderrors.As(err, &apiErr) && apiErr.ErrorCode() == "BadDigest" {
+		return errors.ErrChecksumMismatch
+	}
+	return err
+}
+
+// GetPresignedUrl returns a signed, expiring URL for accessing a user file
+// through the repository's URL signer
+func (r *ObjectRepository) GetPresignedUrl(ctx context.Context, key string) (string, error) {
+	return r.signer.Sign(ctx, key)
+}
+
+// GetSignedUrl returns a URL for a user file that carries its own signature,
+// even if the URL signer relies on signed cookies otherwise
+func (r *ObjectRepository) GetSignedUrl(ctx context.Context, key string) (string, error) {
+	if signer, ok := r.signer.(CookieURLSigner); ok {
+		return signer.SignURL(ctx, key)
+	}
+	return r.signer.Sign(ctx, key)
+}
+
+// Delete removes a user file from S3
+func (r *ObjectRepository) Delete(ctx context.Context, key string) error {
+	_, err := r.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(r.bucketName),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// PresignUpload returns a presigned request that lets a client upload a file
+// straight to S3. PUT requests are signed for the exact content type and
+// size, POST policies allow any size up to maxSize.
+func (r *ObjectRepository) PresignUpload(
+	ctx context.Context,
+	key string,
+	method string,
+	contentType string,
+	size int64,
+	maxSize int64,
+	expires time.Duration,
+) (domain.PresignedUpload, error) {
+	presignClient := s3.NewPresignClient(r.client)
+	expiresAt := time.Now().Add(expires)
+
+	if method == domain.UploadMethodPost {
+		request, err := presignClient.PresignPostObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(r.bucketName),
+			Key:    aws.String(key),
+		}, func(o *s3.PresignPostOptions) {
+			o.Expires = expires
+			o.Conditions = []interface{}{
+				[]interface{}{"content-length-range", 1, maxSize},
+				map[string]string{"Content-Type": contentType},
+			}
+		})
+		if err != nil {
+			return domain.PresignedUpload{}, err
+		}
+
+		// The policy requires the Content-Type form field
+		fields := request.Values
+		fields["Content-Type"] = contentType
+
+		return domain.PresignedUpload{
+			Method:    domain.UploadMethodPost,
+			URL:       request.URL,
+			Key:       key,
+			Fields:    fields,
+			ExpiresAt: expiresAt,
+		}, nil
+	}
+
+	request, err := presignClient.PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(r.bucketName),
+		Key:           aws.String(key),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(size),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return domain.PresignedUpload{}, err
+	}
+
+	headers := make(map[string]string)
+	for name, values := range request.SignedHeader {
+		if len(values) > 0 && name != "Host" {
+			headers[name] = values[0]
+		}
+	}
+
+	return domain.PresignedUpload{
+		Method:    domain.UploadMethodPut,
+		URL:       request.URL,
+		Key:       key,
+		Headers:   headers,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// Stat returns the size and content type of a stored object
+func (r *ObjectRepository) Stat(ctx context.Context, key string) (domain.ObjectInfo, error) {
+	output, err := r.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(r.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if stderrors.As(err, &notFound) {
+			return domain.ObjectInfo{}, errors.ErrObjectNotFound
+		}
+		return domain.ObjectInfo{}, err
+	}
+
+	return domain.ObjectInfo{
+		Key:         key,
+		Size:        aws.ToInt64(output.ContentLength),
+		ContentType: aws.ToString(output.ContentType),
+	}, nil
+}
+
+// Download returns the content of a stored object. The caller closes it.
+func (r *ObjectRepository) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	output, err := r.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if stderrors.As(err, &noSuchKey) {
+			return nil, errors.ErrObjectNotFound
+		}
+		return nil, err
+	}
+
+	return output.Body, nil
+}
+
+// S3 copies objects of up to 5 GiB in one request; larger ones are copied
+// in parts
+const (
+	maxCopySize  = 5 << 30
+	copyPartSize = 512 << 20
+)
+
+// Copy copies a stored object to another key within the bucket, keeping its
+// content type
+func (r *ObjectRepository) Copy(ctx context.Context, source string, destination string) error {
+	info, err := r.Stat(ctx, source)
+	if err != nil {
+		return err
+	}
+
+	copySource := url.PathEscape(r.bucketName + "/" + source)
+	if info.Size <= maxCopySize {
+		_, err := r.client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:            aws.String(r.bucketName),
+			Key:               aws.String(destination),
+			CopySource:        aws.String(copySource),
+			ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+		})
+		return err
+	}
+
+	return r.copyParts(ctx, copySource, destination, info)
+}
+
+// copyParts copies an object too large for CopyObject with a multipart
+// upload whose parts are copied from ranges of the source
+func (r *ObjectRepository) copyParts(ctx context.Context, copySource string, destination string, info domain.ObjectInfo) error {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:            aws.String(r.bucketName),
+		Key:               aws.String(destination),
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	}
+	if info.ContentType != "" {
+		input.ContentType = aws.String(info.ContentType)
+	}
+
+	output, err := r.client.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return err
+	}
+	uploadID := output.UploadId
+
+	partSize := max(int64(copyPartSize), (info.Size+9999)/10000)
+	var parts []types.CompletedPart
+	for number, start := int32(1), int64(0); start < info.Size; number, start = number+1, start+partSize {
+		end := min(start+partSize, info.Size) - 1
+
+		part, err := r.client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(r.bucketName),
+			Key:             aws.String(destination),
+			UploadId:        uploadID,
+			PartNumber:      aws.Int32(number),
+			CopySource:      aws.String(copySource),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		})
+		if err != nil {
+			r.AbortMultipartUpload(ctx, destination, aws.ToString(uploadID))
+			return err
+		}
+
+		parts = append(parts, types.CompletedPart{
+			PartNumber:     aws.Int32(number),
+			ETag:           part.CopyPartResult.ETag,
+			ChecksumSHA256: part.CopyPartResult.ChecksumSHA256,
+		})
+	}
+
+	_, err = r.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(r.bucketName),
+		Key:             aws.String(destination),
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		r.AbortMultipartUpload(ctx, destination, aws.ToString(uploadID))
+	}
+	return err
+}
+
+// List returns the objects whose keys start with prefix, ordered by key
+func (r *ObjectRepository) List(ctx context.Context, prefix string) ([]domain.ObjectInfo, error) {
+	paginator := s3.NewListObjectsV2Paginator(r.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(r.bucketName),
+		Prefix: aws.String(prefix),
+	})
+
+	var objects []domain.ObjectInfo
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range page.Contents {
+			objects = append(objects, domain.ObjectInfo{
+				Key:          aws.ToString(object.Key),
+				Size:         aws.ToInt64(object.Size),
+				LastModified: aws.ToTime(object.LastModified),
+			})
+		}
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,105 @@
+package objectstore
+
+import (
+	"context"
+	stderrors "errors"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+)
+
+// VersioningEnabled reports whether the bucket keeps every version of its objects
+func (r *ObjectRepository) VersioningEnabled(ctx context.Context) (bool, error) {
+	output, err := r.client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{
+		Bucket: aws.String(r.bucketName),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return output.Status == types.BucketVersioningStatusEnabled, nil
+}
+
+// ListVersions returns every version and delete marker of the objects whose
+// keys start with prefix
+func (r *ObjectRepository) ListVersions(ctx context.Context, prefix string) ([]domain.ObjectVersion, error) {
+	input := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(r.bucketName),
+		Prefix: aws.String(prefix),
+	}
+
+	// The SDK has no paginator for ListObjectVersions
+	var versions []domain.ObjectVersion
+	for {
+		page, err := r.client.ListObjectVersions(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, version := range page.Versions {
+			versions = append(versions, domain.ObjectVersion{
+				Key:          aws.ToString(version.Key),
+				VersionID:    aws.ToString(version.VersionId),
+				Size:         aws.ToInt64(version.Size),
+				LastModified: aws.ToTime(version.LastModified),
+				IsLatest:     aws.ToBool(version.IsLatest),
+			})
+		}
+
+		for _, marker := range page.DeleteMarkers {
+			versions = append(versions, domain.ObjectVersion{
+				Key:          aws.ToString(marker.Key),
+				VersionID:    aws.ToString(marker.VersionId),
+				LastModified: aws.ToTime(marker.LastModified),
+				IsLatest:     aws.ToBool(marker.IsLatest),
+				DeleteMarker: true,
+			})
+		}
+
+		if !aws.ToBool(page.IsTruncated) {
+			return versions, nil
+		}
+		input.KeyMarker = page.NextKeyMarker
+		input.VersionIdMarker = page.NextVersionIdMarker
+	}
+}
+
+// DownloadVersion returns the content of one version of an object. The
+// caller closes it.
+func (r *ObjectRepository) DownloadVersion(ctx context.Context, key string, versionID string) (io.ReadCloser, error) {
+	output, err := r.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:    aws.String(r.bucketName),
+		Key:       aws.String(key),
+		VersionId: aws.String(versionID),
+	})
+	if err != nil {
+		return nil, versionError(err)
+	}
+
+	return output.Body, nil
+}
+
+// DeleteVersion permanently deletes one version of an object
+func (r *ObjectRepository) DeleteVersion(ctx context.Context, key string, versionID string) error {
+	_, err := r.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:    aws.String(r.bucketName),
+		Key:       aws.String(key),
+		VersionId: aws.String(versionID),
+	})
+	return err
+}
+
+// versionError maps a missing object or version to errors.ErrObjectNotFound
+func versionError(err error) error {
+	var noSuchKey *types.NoSuchKey
+	var apiErr smithy.APIError
+	if stderrors.As(err, &noSuchKey) || (stderrors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchVersion") {
+		return errors.ErrObjectNotFound
+	}
+	return err
+}
@@ -0,0 +1,137 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+)
+
+// chunkSize is the largest chunk sent to clamd in one INSTREAM frame
+const chunkSize = 64 << 10
+
+// ClamdScanner scans files with a ClamAV daemon using the INSTREAM command
+// of the clamd protocol
+type ClamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamdScanner initializes a new ClamdScanner. address is either
+// "tcp://host:port" or "unix:///path/to/clamd.sock"; a bare path is treated
+// as a Unix socket and a bare host:port as TCP. timeout bounds each scan.
+func NewClamdScanner(address string, timeout time.Duration) (*ClamdScanner, error) {
+	network, addr, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClamdScanner{
+		network: network,
+		address: addr,
+		timeout: timeout,
+	}, nil
+}
+
+// Scan streams r to clamd and returns its verdict
+func (s *ClamdScanner) Scan(ctx context.Context, r io.Reader) (domain.ScanResult, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return domain.ScanResult{}, fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// clamd stops reading and replies early when a stream is too large, so
+	// its reply is read even if sending fails
+	sendErr := sendStream(conn, r)
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil {
+		if sendErr != nil {
+			return domain.ScanResult{}, fmt.Errorf("failed to send file to clamd: %w", sendErr)
+		}
+		return domain.ScanResult{}, fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+
+	return parseReply(strings.TrimSuffix(reply, "\x00"))
+}
+
+// sendStream sends the INSTREAM command followed by r in length-prefixed
+// chunks and the zero-length chunk that ends the stream
+func sendStream(w io.Writer, r io.Reader) error {
+	if _, err := io.WriteString(w, "zINSTREAM\x00"); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4+chunkSize)
+	for {
+		n, err := r.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, writeErr := w.Write(buf[:4+n]); writeErr != nil {
+				return writeErr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+// parseReply interprets a reply such as "stream: OK" or
+// "stream: Eicar-Signature FOUND"
+func parseReply(reply string) (domain.ScanResult, error) {
+	result := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+
+	switch {
+	case result == "OK":
+		return domain.ScanResult{}, nil
+	case strings.HasSuffix(result, " FOUND"):
+		return domain.ScanResult{
+			Infected:  true,
+			Signature: strings.TrimSuffix(result, " FOUND"),
+		}, nil
+	default:
+		return domain.ScanResult{}, fmt.Errorf("clamd returned an error: %s", reply)
+	}
+}
+
+// parseAddress splits a clamd address into a network and an address for net.Dial
+func parseAddress(address string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(address, "tcp://"):
+		return "tcp", strings.TrimPrefix(address, "tcp://"), nil
+	case strings.HasPrefix(address, "unix://"):
+		return "unix", strings.TrimPrefix(address, "unix://"), nil
+	case strings.HasPrefix(address, "/"):
+		return "unix", address, nil
+	case strings.Contains(address, ":"):
+		return "tcp", address, nil
+	default:
+		return "", "", fmt.Errorf("invalid clamd address: %s", address)
+	}
+}
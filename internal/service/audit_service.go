@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zzenonn/go-zenon-api-aws/internal/audit"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+	"github.com/zzenonn/go-zenon-api-aws/internal/logging"
+	"github.com/zzenonn/go-zenon-api-aws/internal/tracing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// auditVerifyPageSize is how many events are read at a time while verifying
+const auditVerifyPageSize = 1000
+
+// auditRecordTimeout bounds how long recording an event may take once it
+// no longer depends on the request that caused it
+const auditRecordTimeout = 30 * time.Second
+
+// AuditRepository - interface for the append-only storage of the audit log
+type AuditRepository interface {
+	// AppendEvent chains the event to the newest event with
+	// domain.AuditEvent.Chain and stores it. Events are never changed or
+	// deleted once stored.
+	AppendEvent(ctx context.Context, event domain.AuditEvent) (domain.AuditEvent, error)
+	// ListEvents returns one page of the events matching the filter, newest
+	// first, and the token for the next page
+	ListEvents(ctx context.Context, filter domain.AuditFilter, pageSize int, nextToken string) ([]domain.AuditEvent, string, error)
+	// ChainEvents returns up to limit events following the event with the
+	// given sequence number, oldest first
+	ChainEvents(ctx context.Context, after int64, limit int) ([]domain.AuditEvent, error)
+}
+
+// Auditor - records security-relevant events in the audit log
+type Auditor interface {
+	Record(ctx context.Context, event domain.AuditEvent)
+}
+
+// WithAuditor - records logins and changes to users and profiles
+func WithAuditor(auditor Auditor) Option {
+	return func(s *UserService) {
+		s.auditor = auditor
+	}
+}
+
+// AuditService - service for recording and reading the audit log. Only
+// admins can read it.
+type AuditService struct {
+	Repo  AuditRepository
+	Users UserRepository
+}
+
+// NewAuditService - returns a new instance of AuditService
+func NewAuditService(repo AuditRepository, users UserRepository) *AuditService {
+	return &AuditService{
+		Repo:  repo,
+		Users: users,
+	}
+}
+
+// Record appends an event to the audit log. Its time, client and request ID
+// are taken from ctx, as is its actor unless one is set. The event is stored
+// even if ctx is cancelled, e.g. because the client went away, within
+// auditRecordTimeout. An event that cannot be stored is logged instead, so
+// that auditing never fails the operation being audited.
+func (s *AuditService) Record(ctx context.Context, event domain.AuditEvent) {
+	ctx, span := tracing.Start(ctx, "AuditService.Record")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), auditRecordTimeout)
+	defer cancel()
+
+	client := audit.ClientFrom(ctx)
+	event.Time = time.Now().UTC()
+	event.ClientIP = client.IP
+	event.UserAgent = client.UserAgent
+	event.RequestID = logging.RequestID(ctx)
+	if event.Actor == "" {
+		event.Actor = audit.Actor(ctx)
+	}
+	if event.Actor == "" {
+		event.Actor = domain.AuditAnonymous
+	}
+
+	if _, err := s.Repo.AppendEvent(ctx, event); err != nil {
+		log.WithContext(ctx).WithFields(log.Fields{
+			"audit":   true,
+			"event":   event.Action,
+			"actor":   event.Actor,
+			"target":  event.Target,
+			"outcome": event.Outcome,
+		}).Error("Failed to record audit event: ", err)
+	}
+}
+
+// ListEvents returns one page of the events matching the filter, newest
+// first. Attempts by users who are not admins are recorded.
+func (s *AuditService) ListEvents(ctx context.Context, viewer string, filter domain.AuditFilter, pageSize int, nextToken string) ([]domain.AuditEvent, string, error) {
+	ctx, span := tracing.Start(ctx, "AuditService.ListEvents")
+	defer span.End()
+
+	if err := s.requireAdmin(ctx, viewer); err != nil {
+		return nil, "", err
+	}
+
+	return s.Repo.ListEvents(ctx, filter, pageSize, nextToken)
+}
+
+// Verify walks the whole audit log and checks that every event follows the
+// one before it and has not been changed
+func (s *AuditService) Verify(ctx context.Context, viewer string) (domain.AuditVerification, error) {
+	ctx, span := tracing.Start(ctx, "AuditService.Verify")
+	defer span.End()
+
+	if err := s.requireAdmin(ctx, viewer); err != nil {
+		return domain.AuditVerification{}, err
+	}
+
+	result := domain.AuditVerification{Valid: true, HeadHash: domain.AuditGenesisHash}
+	for {
+		events, err := s.Repo.ChainEvents(ctx, result.Events, auditVerifyPageSize)
+		if err != nil {
+			return domain.AuditVerification{}, err
+		}
+
+		for _, event := range events {
+			var reason string
+			switch {
+			case event.Sequence != result.Events+1:
+				reason = fmt.Sprintf("expected event %d", result.Events+1)
+			case event.PrevHash != result.HeadHash:
+				reason = "previous hash does not match"
+			case event.Hash != event.ComputeHash():
+				reason = "hash does not match contents"
+			}
+			if reason != "" {
+				result.Valid = false
+				result.BrokenAt = event.Sequence
+				result.Reason = reason
+				return result, nil
+			}
+
+			result.Events = event.Sequence
+			result.HeadHash = event.Hash
+		}
+
+		if len(events) < auditVerifyPageSize {
+			return result, nil
+		}
+	}
+}
+
+// requireAdmin returns errors.ErrForbidden and records the attempt unless
+// the viewer is an admin
+func (s *AuditService) requireAdmin(ctx context.Context, viewer string) error {
+	user, err := s.Users.GetUser(ctx, viewer)
+	if err == nil && user.IsAdmin() {
+		return nil
+	}
+
+	s.Record(ctx, domain.AuditEvent{
+		Actor:   viewer,
+		Action:  domain.AuditAccessDenied,
+		Target:  "audit",
+		Outcome: domain.AuditDenied,
+		Reason:  "not an admin",
+	})
+	return errors.ErrForbidden
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	stderrors "errors"
+	"time"
+
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WithContentAddressing - stores new files once per distinct content, keyed
+// by checksum, instead of once per file
+func WithContentAddressing(enabled bool) FileOption {
+	return func(s *FileService) {
+		s.contentAddressing = enabled
+	}
+}
+
+// WithBlobGracePeriod - sets how long an unreferenced blob is kept before it
+// is collected, so download URLs already handed out keep working
+func WithBlobGracePeriod(period time.Duration) FileOption {
+	return func(s *FileService) {
+		s.blobGracePeriod = period
+	}
+}
+
+// shareBlob points the file at the blob for its checksum and reports whether
+// the blob's object is already stored. A blob that is being collected cannot
+// be shared, so the file then keeps its own object.
+func (s *FileService) shareBlob(ctx context.Context, file domain.File) (domain.File, bool, error) {
+	blob, err := s.Repo.AcquireBlob(ctx, file.Checksum, blobKey(file.Checksum))
+	if stderrors.Is(err, errors.ErrBlobDeleted) {
+		return file, false, nil
+	}
+	if err != nil {
+		return domain.File{}, false, err
+	}
+
+	file.Key = blob.Key
+	file.ContentAddressed = true
+	return file, blob.Stored, nil
+}
+
+// shareUploadedBlob turns a verified multipart upload into a content-addressed
+// file. If the content is already stored, the file points at its blob;
+// otherwise the upload becomes the blob, which the caller marks as stored
+// once the upload is copied to it. Failures leave the file with its own object.
+func (s *FileService) shareUploadedBlob(ctx context.Context, file domain.File) domain.File {
+	blob, err := s.Repo.AcquireBlob(ctx, file.Checksum, file.Key)
+	if err != nil {
+		if !stderrors.Is(err, errors.ErrBlobDeleted) {
+			log.WithContext(ctx).Warn("Failed to share uploaded file: ", err)
+		}
+		return file
+	}
+
+	if blob.Key != file.Key && !blob.Stored {
+		// Another copy of the content is still being stored
+		if err := s.Repo.ReleaseBlob(ctx, file.Checksum); err != nil {
+			log.WithContext(ctx).Warn("Failed to release blob: ", err)
+		}
+		return file
+	}
+
+	file.Key = blob.Key
+	file.ContentAddressed = true
+	return file
+}
+
+// releaseBlob drops the reference a content-addressed file took on its blob,
+// logging failures
+func (s *FileService) releaseBlob(ctx context.Context, file domain.File) {
+	if !file.ContentAddressed {
+		return
+	}
+
+	if err := s.Repo.ReleaseBlob(ctx, file.Checksum); err != nil {
+		log.WithContext(ctx).Error("Failed to release blob: ", err)
+	}
+}
+
+// CollectBlobs deletes blobs that have had no references for longer than
+// olderThan and returns how many were deleted. Each blob is claimed first, so
+// it cannot be shared again while its object is deleted.
+func (s *FileService) CollectBlobs(ctx context.Context, olderThan time.Duration) (int, error) {
+	blobs, err := s.Repo.ListUnreferencedBlobs(ctx, time.Now().Add(-olderThan))
+	if err != nil {
+		return 0, err
+	}
+
+	collected := 0
+	for _, blob := range blobs {
+		err := s.Repo.ClaimBlob(ctx, blob.Checksum)
+		if stderrors.Is(err, errors.ErrBlobInUse) || stderrors.Is(err, errors.ErrBlobNotFound) {
+			continue
+		}
+		if err != nil {
+			return collected, err
+		}
+
+		if err := s.Objects.Delete(ctx, blob.Key); err != nil {
+			return collected, err
+		}
+
+		if err := s.Repo.DeleteBlob(ctx, blob.Checksum); err != nil {
+			return collected, err
+		}
+		collected++
+	}
+
+	return collected, nil
+}
+
+// blobKey returns the object key of the blob with checksum
+func blobKey(checksum string) string {
+	return "blobs/sha256/" + checksum
+}
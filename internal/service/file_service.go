@@ -0,0 +1,331 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+	"github.com/zzenonn/go-zenon-api-aws/internal/metrics"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FileRepository - interface for file attachment metadata. Implementations
+// keep a running total of each user's stored bytes so quotas can be checked
+// without listing every file.
+type FileRepository interface {
+	// CreateFile records a file and adds its size to the user's usage. It
+	// returns errors.ErrQuotaExceeded if the new total would exceed quota;
+	// a quota of 0 means unlimited.
+	CreateFile(ctx context.Context, file domain.File, quota int64) error
+	GetFile(ctx context.Context, username string, id string) (domain.File, error)
+	ListFiles(ctx context.Context, username string, pageSize int, nextToken string) ([]domain.File, string, error)
+	// DeleteFile removes a file and subtracts its size from the user's usage
+	DeleteFile(ctx context.Context, username string, id string) error
+	GetUsage(ctx context.Context, username string) (int64, error)
+
+	// CreateUpload records a multipart upload and reserves its size against
+	// quota like CreateFile
+	CreateUpload(ctx context.Context, upload domain.MultipartUpload, quota int64) error
+	GetUpload(ctx context.Context, username string, id string) (domain.MultipartUpload, error)
+	// CompleteUpload replaces the upload with the same ID by the finished
+	// file. The reservation becomes the file's usage.
+	CompleteUpload(ctx context.Context, file domain.File) error
+	// DeleteUpload removes an upload and releases its reservation
+	DeleteUpload(ctx context.Context, username string, id string) error
+	// ListStaleUploads returns the uploads of every user started before cutoff
+	ListStaleUploads(ctx context.Context, cutoff time.Time) ([]domain.MultipartUpload, error)
+	// MarkUploadAssembled records that the parts of an upload were assembled
+	// at the given time, together with the checksum the file must have
+	MarkUploadAssembled(ctx context.Context, username string, id string, checksum string, at time.Time) error
+	// ListAssembledUploads returns the uploads of every user that were
+	// assembled before cutoff but not yet completed
+	ListAssembledUploads(ctx context.Context, cutoff time.Time) ([]domain.MultipartUpload, error)
+
+	// AcquireBlob adds a reference to the blob with checksum, creating it with
+	// key if it does not exist, and returns the blob as it was before. It
+	// returns errors.ErrBlobDeleted if the blob is being collected.
+	AcquireBlob(ctx context.Context, checksum string, key string) (domain.Blob, error)
+	// MarkBlobStored records that the blob's object has been uploaded
+	MarkBlobStored(ctx context.Context, checksum string) error
+	// ReleaseBlob removes a reference to a blob
+	ReleaseBlob(ctx context.Context, checksum string) error
+	// ListUnreferencedBlobs returns blobs without references since before cutoff
+	ListUnreferencedBlobs(ctx context.Context, cutoff time.Time) ([]domain.Blob, error)
+	// ClaimBlob marks an unreferenced blob for deletion so it can no longer
+	// be acquired. It returns errors.ErrBlobInUse if the blob has references.
+	ClaimBlob(ctx context.Context, checksum string) error
+	DeleteBlob(ctx context.Context, checksum string) error
+}
+
+// FileService - service for managing user file attachments
+type FileService struct {
+	Repo     FileRepository
+	UserRepo UserRepository
+	Objects  ObjectRepository
+
+	maxFileSize   int64
+	quota         int64
+	maxUploadSize int64
+	partSize      int64
+	uploadURLTTL  time.Duration
+
+	contentAddressing bool
+	blobGracePeriod   time.Duration
+
+	scanner Scanner
+	auditor Auditor
+
+	// verifying holds the verifications of assembled uploads in progress
+	verifyMu  sync.Mutex
+	verifying map[string]*verification
+}
+
+// FileOption - configures optional FileService settings
+type FileOption func(*FileService)
+
+// WithMaxFileSize - sets the largest file accepted, in bytes
+func WithMaxFileSize(size int64) FileOption {
+	return func(s *FileService) {
+		s.maxFileSize = size
+	}
+}
+
+// WithFileQuota - sets how many bytes each user may store, 0 for unlimited
+func WithFileQuota(quota int64) FileOption {
+	return func(s *FileService) {
+		s.quota = quota
+	}
+}
+
+// WithMaxUploadSize - sets the largest file accepted as a multipart upload, in bytes
+func WithMaxUploadSize(size int64) FileOption {
+	return func(s *FileService) {
+		s.maxUploadSize = size
+	}
+}
+
+// WithUploadPartSize - sets the preferred size of multipart upload parts
+func WithUploadPartSize(size int64) FileOption {
+	return func(s *FileService) {
+		s.partSize = size
+	}
+}
+
+// WithPartURLTTL - sets how long presigned part upload URLs stay valid
+func WithPartURLTTL(ttl time.Duration) FileOption {
+	return func(s *FileService) {
+		s.uploadURLTTL = ttl
+	}
+}
+
+// NewFileService - returns a new instance of FileService
+func NewFileService(repo FileRepository, userRepo UserRepository, objects ObjectRepository, opts ...FileOption) *FileService {
+	s := &FileService{
+		Repo:          repo,
+		UserRepo:      userRepo,
+		Objects:       objects,
+		maxFileSize:   25 << 20,
+		quota:         100 << 20,
+		maxUploadSize: 5 << 30,
+		partSize:      8 << 20,
+		uploadURLTTL:  15 * time.Minute,
+
+		blobGracePeriod: time.Hour,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// UploadFile stores a file for the user. With a scanner configured, the file
+// is stored and scanned in quarantine first, then copied to its real key.
+// The quota is reserved by recording the file before its content is
+// uploaded, and released again if the upload fails.
+func (s *FileService) UploadFile(ctx context.Context, username string, name string, contentType string, r io.Reader) (domain.File, error) {
+	name = cleanFileName(name)
+	if name == "" {
+		return domain.File{}, errors.ErrMissingRequiredFields
+	}
+
+	if _, err := s.UserRepo.GetUser(ctx, username); err != nil {
+		return domain.File{}, err
+	}
+
+	// Read one byte past the limit to tell a full-size file from a larger one
+	data, err := io.ReadAll(io.LimitReader(r, s.maxFileSize+1))
+	if err != nil {
+		return domain.File{}, err
+	}
+
+	if int64(len(data)) > s.maxFileSize {
+		return domain.File{}, errors.ErrFileTooLarge
+	}
+
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(data)
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return domain.File{}, err
+	}
+
+	file := domain.File{
+		Username:    username,
+		ID:          id,
+		Name:        name,
+		Size:        int64(len(data)),
+		ContentType: contentType,
+		Checksum:    sha256Hex(data),
+		Key:         fileKey(username, id, name),
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	quarantined, err := quarantine(ctx, s.Objects, s.scanner, s.auditor, username, file.Key, data, contentType)
+	if err != nil {
+		return domain.File{}, err
+	}
+	defer releaseQuarantined(ctx, s.Objects, quarantined)
+
+	// Content that is already stored as a blob is not uploaded again
+	stored := false
+	if s.contentAddressing {
+		if file, stored, err = s.shareBlob(ctx, file); err != nil {
+			return domain.File{}, err
+		}
+	}
+
+	if err := s.Repo.CreateFile(ctx, file, s.quota); err != nil {
+		s.releaseBlob(ctx, file)
+		return domain.File{}, err
+	}
+
+	if stored {
+		recordFileUpload(file)
+		return file, nil
+	}
+
+	if err := promote(ctx, s.Objects, quarantined, file.Key, data, contentType, file.Checksum); err != nil {
+		if deleteErr := s.Repo.DeleteFile(ctx, username, id); deleteErr != nil {
+			log.WithContext(ctx).Error("Failed to release quota for failed upload: ", deleteErr)
+		}
+		s.releaseBlob(ctx, file)
+		return domain.File{}, err
+	}
+
+	if file.ContentAddressed {
+		if err := s.Repo.MarkBlobStored(ctx, file.Checksum); err != nil {
+			log.WithContext(ctx).Warn("Failed to mark blob as stored: ", err)
+		}
+	}
+
+	recordFileUpload(file)
+	return file, nil
+}
+
+// recordFileUpload counts a completed file upload and its size
+func recordFileUpload(file domain.File) {
+	metrics.Uploads.WithLabelValues(metrics.UploadFile).Inc()
+	metrics.BytesStored.WithLabelValues(metrics.UploadFile).Add(float64(file.Size))
+}
+
+// GetFile returns a file's metadata together with a URL to download it.
+// Signed cookies only cover the user's own prefix, so URLs for files stored
+// elsewhere, such as shared content-addressed blobs, are signed on their own.
+func (s *FileService) GetFile(ctx context.Context, username string, id string) (domain.File, string, error) {
+	file, err := s.Repo.GetFile(ctx, username, id)
+	if err != nil {
+		return domain.File{}, "", err
+	}
+
+	var url string
+	if signer, ok := s.Objects.(SignedURLRepository); ok && !strings.HasPrefix(file.Key, username+"/") {
+		url, err = signer.GetSignedUrl(ctx, file.Key)
+	} else {
+		url, err = s.Objects.GetPresignedUrl(ctx, file.Key)
+	}
+	if err != nil {
+		return domain.File{}, "", err
+	}
+
+	return file, url, nil
+}
+
+// ListFiles returns one page of the user's files and the token for the next page
+func (s *FileService) ListFiles(ctx context.Context, username string, pageSize int, nextToken string) ([]domain.File, string, error) {
+	if _, err := s.UserRepo.GetUser(ctx, username); err != nil {
+		return nil, "", err
+	}
+
+	return s.Repo.ListFiles(ctx, username, pageSize, nextToken)
+}
+
+// GetUsage returns how many bytes the user stores and their quota
+func (s *FileService) GetUsage(ctx context.Context, username string) (int64, int64, error) {
+	used, err := s.Repo.GetUsage(ctx, username)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return used, s.quota, nil
+}
+
+// DeleteFile deletes a file's metadata and then its content. The content of
+// a content-addressed file is only released; it is collected once no file
+// refers to it.
+func (s *FileService) DeleteFile(ctx context.Context, username string, id string) error {
+	file, err := s.Repo.GetFile(ctx, username, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Repo.DeleteFile(ctx, username, id); err != nil {
+		return err
+	}
+
+	if file.ContentAddressed {
+		return s.Repo.ReleaseBlob(ctx, file.Checksum)
+	}
+	return s.Objects.Delete(ctx, file.Key)
+}
+
+// fileKey returns the object key of an attachment. The extension of the
+// original name is kept so stores that infer types from keys serve it correctly.
+func fileKey(username string, id string, name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	for _, c := range strings.TrimPrefix(ext, ".") {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			ext = ""
+			break
+		}
+	}
+	return username + "/files/" + id + ext
+}
+
+// sha256Hex returns the hex SHA-256 of data, the checksum format used for
+// every stored object
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// cleanFileName strips any directories from a client-supplied file name
+func cleanFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(strings.TrimSpace(name), "\\", "/"))
+	if name == "." || name == "/" {
+		return ""
+	}
+	return name
+}
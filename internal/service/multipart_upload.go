@@ -0,0 +1,638 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	stderrors "errors"
+	"io"
+	"time"
+
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// S3 accepts at most 10,000 parts per upload
+const maxUploadParts = 10000
+
+// uploadVerifyTimeout bounds how long an assembled upload is read back for
+// verification. Uploads assembled longer ago are no longer being verified,
+// so the sweeper takes them up again.
+const uploadVerifyTimeout = time.Hour
+
+// MultipartRepository - optional interface for object stores that can receive
+// a file in separately uploaded parts
+type MultipartRepository interface {
+	CreateMultipartUpload(ctx context.Context, key string, contentType string) (string, error)
+	UploadPart(ctx context.Context, key string, uploadID string, number int32, r io.Reader, size int64) (domain.UploadPart, error)
+	PresignUploadPart(ctx context.Context, key string, uploadID string, number int32, size int64, expires time.Duration) (string, error)
+	ListParts(ctx context.Context, key string, uploadID string) ([]domain.UploadPart, error)
+	CompleteMultipartUpload(ctx context.Context, key string, uploadID string, parts []domain.UploadPart) error
+	AbortMultipartUpload(ctx context.Context, key string, uploadID string) error
+	ListMultipartUploads(ctx context.Context, prefix string) ([]domain.ObjectUpload, error)
+	Download(ctx context.Context, key string) (io.ReadCloser, error)
+	Copy(ctx context.Context, source string, destination string) error
+}
+
+// CreateUpload starts a multipart upload of a file of the given size. The
+// size is reserved against the user's quota until the upload is completed or
+// aborted. An optional SHA-256 checksum is verified on completion. Parts are
+// assembled in quarantine and only copied to the file's key once verified.
+func (s *FileService) CreateUpload(ctx context.Context, username string, name string, contentType string, size int64, checksum string) (domain.MultipartUpload, error) {
+	uploader, ok := s.Objects.(MultipartRepository)
+	if !ok {
+		return domain.MultipartUpload{}, errors.ErrNotImplemented
+	}
+
+	name = cleanFileName(name)
+	if name == "" || size <= 0 {
+		return domain.MultipartUpload{}, errors.ErrMissingRequiredFields
+	}
+
+	if size > s.maxUploadSize {
+		return domain.MultipartUpload{}, errors.ErrFileTooLarge
+	}
+
+	if checksum != "" && !validChecksum(checksum) {
+		return domain.MultipartUpload{}, errors.ErrInvalidChecksum
+	}
+
+	if _, err := s.UserRepo.GetUser(ctx, username); err != nil {
+		return domain.MultipartUpload{}, err
+	}
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return domain.MultipartUpload{}, err
+	}
+
+	// Grow the parts for files that would otherwise need too many
+	partSize := s.partSize
+	if minimum := (size + maxUploadParts - 1) / maxUploadParts; partSize < minimum {
+		partSize = minimum
+	}
+
+	upload := domain.MultipartUpload{
+		Username:    username,
+		ID:          id,
+		Name:        name,
+		Size:        size,
+		ContentType: contentType,
+		Checksum:    checksum,
+		PartSize:    partSize,
+		PartCount:   int32((size + partSize - 1) / partSize),
+		Key:         fileKey(username, id, name),
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	if upload.UploadID, err = uploader.CreateMultipartUpload(ctx, quarantineKey(upload.Key), contentType); err != nil {
+		return domain.MultipartUpload{}, err
+	}
+
+	if err := s.Repo.CreateUpload(ctx, upload, s.quota); err != nil {
+		if abortErr := uploader.AbortMultipartUpload(ctx, quarantineKey(upload.Key), upload.UploadID); abortErr != nil {
+			log.WithContext(ctx).Error("Failed to abort multipart upload: ", abortErr)
+		}
+		return domain.MultipartUpload{}, err
+	}
+
+	upload.Parts = []domain.UploadPart{}
+	return upload, nil
+}
+
+// GetUpload returns an upload together with the parts stored so far, so an
+// interrupted client can resume with the missing ones
+func (s *FileService) GetUpload(ctx context.Context, username string, id string) (domain.MultipartUpload, error) {
+	uploader, ok := s.Objects.(MultipartRepository)
+	if !ok {
+		return domain.MultipartUpload{}, errors.ErrNotImplemented
+	}
+
+	upload, err := s.Repo.GetUpload(ctx, username, id)
+	if err != nil {
+		return domain.MultipartUpload{}, err
+	}
+
+	// Assembled uploads have no parts left, only a file being verified
+	if upload.AssembledAt != nil {
+		upload.Parts = []domain.UploadPart{}
+		return upload, nil
+	}
+
+	if upload.Parts, err = uploader.ListParts(ctx, quarantineKey(upload.Key), upload.UploadID); err != nil {
+		return domain.MultipartUpload{}, err
+	}
+
+	return upload, nil
+}
+
+// UploadPart stores one part of an upload. Parts can be sent in any order
+// and re-sent to replace an earlier attempt.
+func (s *FileService) UploadPart(ctx context.Context, username string, id string, number int32, r io.Reader) (domain.UploadPart, error) {
+	uploader, ok := s.Objects.(MultipartRepository)
+	if !ok {
+		return domain.UploadPart{}, errors.ErrNotImplemented
+	}
+
+	upload, err := s.Repo.GetUpload(ctx, username, id)
+	if err != nil {
+		return domain.UploadPart{}, err
+	}
+
+	size, err := partSize(upload, number)
+	if err != nil {
+		return domain.UploadPart{}, err
+	}
+
+	// Read one byte past the expected size to reject parts that are too long
+	data, err := io.ReadAll(io.LimitReader(r, size+1))
+	if err != nil {
+		return domain.UploadPart{}, err
+	}
+
+	if int64(len(data)) != size {
+		return domain.UploadPart{}, errors.ErrInvalidPart
+	}
+
+	return uploader.UploadPart(ctx, quarantineKey(upload.Key), upload.UploadID, number, bytes.NewReader(data), size)
+}
+
+// PresignUploadPart returns a URL the client can PUT one part to directly
+func (s *FileService) PresignUploadPart(ctx context.Context, username string, id string, number int32) (domain.PresignedUpload, error) {
+	uploader, ok := s.Objects.(MultipartRepository)
+	if !ok {
+		return domain.PresignedUpload{}, errors.ErrNotImplemented
+	}
+
+	upload, err := s.Repo.GetUpload(ctx, username, id)
+	if err != nil {
+		return domain.PresignedUpload{}, err
+	}
+
+	size, err := partSize(upload, number)
+	if err != nil {
+		return domain.PresignedUpload{}, err
+	}
+
+	key := quarantineKey(upload.Key)
+	url, err := uploader.PresignUploadPart(ctx, key, upload.UploadID, number, size, s.uploadURLTTL)
+	if err != nil {
+		return domain.PresignedUpload{}, err
+	}
+
+	return domain.PresignedUpload{
+		Method:    domain.UploadMethodPut,
+		URL:       url,
+		Key:       key,
+		ExpiresAt: time.Now().Add(s.uploadURLTTL),
+	}, nil
+}
+
+// CompleteUpload assembles the parts in quarantine and verifies the result.
+// The assembled object is read back, hashed and, with a scanner configured,
+// scanned, and copied to the file's key if it is clean. If it does not match
+// the checksum given here or when the upload was created, or is infected, it
+// is deleted and the upload is discarded. If the scanner fails, the upload
+// stays assembled so completing it can be retried.
+//
+// If ctx ends before verification is done, it carries on in the background
+// and errors.ErrUploadVerifying is returned. Calling CompleteUpload again
+// waits for it, or returns the file once it is done.
+func (s *FileService) CompleteUpload(ctx context.Context, username string, id string, checksum string) (domain.File, error) {
+	uploader, ok := s.Objects.(MultipartRepository)
+	if !ok {
+		return domain.File{}, errors.ErrNotImplemented
+	}
+
+	if checksum != "" && !validChecksum(checksum) {
+		return domain.File{}, errors.ErrInvalidChecksum
+	}
+
+	upload, err := s.Repo.GetUpload(ctx, username, id)
+	if stderrors.Is(err, errors.ErrUploadNotFound) {
+		// An earlier attempt may have completed the upload
+		if file, getErr := s.Repo.GetFile(ctx, username, id); getErr == nil {
+			if checksum != "" && checksum != file.Checksum {
+				return domain.File{}, errors.ErrChecksumMismatch
+			}
+			return file, nil
+		}
+	}
+	if err != nil {
+		return domain.File{}, err
+	}
+
+	if upload.AssembledAt != nil {
+		// The file is verified against the checksum it was assembled with
+		if checksum != "" && checksum != upload.Checksum {
+			return domain.File{}, errors.ErrChecksumMismatch
+		}
+		return s.awaitVerification(ctx, uploader, upload)
+	}
+
+	if checksum == "" {
+		checksum = upload.Checksum
+	}
+
+	parts, err := uploader.ListParts(ctx, quarantineKey(upload.Key), upload.UploadID)
+	if err != nil {
+		return domain.File{}, err
+	}
+
+	if !partsComplete(upload, parts) {
+		return domain.File{}, errors.ErrIncompleteUpload
+	}
+
+	if err := uploader.CompleteMultipartUpload(ctx, quarantineKey(upload.Key), upload.UploadID, parts); err != nil {
+		return domain.File{}, err
+	}
+
+	// The parts are gone once assembled, so record that the upload only
+	// needs verifying before starting on it
+	assembledAt := time.Now().UTC()
+	if err := s.Repo.MarkUploadAssembled(ctx, username, id, checksum, assembledAt); err != nil {
+		log.WithContext(ctx).Error("Failed to record assembled upload: ", err)
+	}
+	upload.Checksum = checksum
+	upload.AssembledAt = &assembledAt
+
+	return s.awaitVerification(ctx, uploader, upload)
+}
+
+// AbortUpload discards an upload and its parts and releases its reservation
+func (s *FileService) AbortUpload(ctx context.Context, username string, id string) error {
+	uploader, ok := s.Objects.(MultipartRepository)
+	if !ok {
+		return errors.ErrNotImplemented
+	}
+
+	upload, err := s.Repo.GetUpload(ctx, username, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Repo.DeleteUpload(ctx, username, id); err != nil {
+		return err
+	}
+
+	if upload.AssembledAt != nil {
+		return s.Objects.Delete(ctx, quarantineKey(upload.Key))
+	}
+
+	err = uploader.AbortMultipartUpload(ctx, quarantineKey(upload.Key), upload.UploadID)
+	if stderrors.Is(err, errors.ErrUploadNotFound) {
+		return nil
+	}
+	return err
+}
+
+// SweepUploads aborts uploads started more than olderThan ago, including
+// multipart uploads in object storage that have no record, and returns how
+// many were aborted. Uploads whose parts were assembled are left to
+// VerifyUploads.
+func (s *FileService) SweepUploads(ctx context.Context, olderThan time.Duration) (int, error) {
+	uploader, ok := s.Objects.(MultipartRepository)
+	if !ok {
+		return 0, nil
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+
+	stale, err := s.Repo.ListStaleUploads(ctx, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	aborted := make(map[string]bool)
+	for _, upload := range stale {
+		if upload.AssembledAt != nil {
+			continue
+		}
+
+		if err := s.Repo.DeleteUpload(ctx, upload.Username, upload.ID); err != nil && !stderrors.Is(err, errors.ErrUploadNotFound) {
+			return len(aborted), err
+		}
+
+		err := uploader.AbortMultipartUpload(ctx, quarantineKey(upload.Key), upload.UploadID)
+		if err != nil && !stderrors.Is(err, errors.ErrUploadNotFound) {
+			return len(aborted), err
+		}
+		aborted[upload.UploadID] = true
+	}
+
+	// Uploads whose record was never written or already removed. Every
+	// upload is assembled in quarantine, so nothing else is touched.
+	orphans, err := uploader.ListMultipartUploads(ctx, quarantineKey(""))
+	if err != nil {
+		return len(aborted), err
+	}
+
+	for _, orphan := range orphans {
+		if aborted[orphan.UploadID] || !orphan.Initiated.Before(cutoff) {
+			continue
+		}
+
+		err := uploader.AbortMultipartUpload(ctx, orphan.Key, orphan.UploadID)
+		if err != nil && !stderrors.Is(err, errors.ErrUploadNotFound) {
+			return len(aborted), err
+		}
+		aborted[orphan.UploadID] = true
+	}
+
+	return len(aborted), nil
+}
+
+// VerifyUploads verifies uploads whose parts were assembled more than
+// olderThan ago but that were never completed, e.g. because the server
+// stopped while verifying them, and returns how many were completed.
+// Failures are logged and the uploads left for the next call.
+func (s *FileService) VerifyUploads(ctx context.Context, olderThan time.Duration) (int, error) {
+	uploader, ok := s.Objects.(MultipartRepository)
+	if !ok {
+		return 0, nil
+	}
+
+	assembled, err := s.Repo.ListAssembledUploads(ctx, time.Now().Add(-olderThan))
+	if err != nil {
+		return 0, err
+	}
+
+	completed := 0
+	for _, upload := range assembled {
+		if _, err := s.awaitVerification(ctx, uploader, upload); err != nil {
+			if ctx.Err() != nil {
+				return completed, ctx.Err()
+			}
+			log.WithContext(ctx).WithField("upload", upload.ID).Warn("Failed to verify assembled upload: ", err)
+			continue
+		}
+		completed++
+	}
+
+	return completed, nil
+}
+
+// RunSweeper sweeps uploads older than olderThan every interval until ctx is
+// cancelled, and completes assembled uploads that are no longer being
+// verified. With content addressing it also collects unreferenced blobs.
+// Failures are logged and retried on the next tick.
+func (s *FileService) RunSweeper(ctx context.Context, interval time.Duration, olderThan time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			swept, err := s.SweepUploads(ctx, olderThan)
+			if err != nil {
+				log.WithContext(ctx).Error("Failed to sweep stale uploads: ", err)
+			}
+			if swept > 0 {
+				log.WithContext(ctx).Infof("Aborted %d stale uploads", swept)
+			}
+
+			verified, err := s.VerifyUploads(ctx, uploadVerifyTimeout)
+			if err != nil {
+				log.WithContext(ctx).Error("Failed to verify assembled uploads: ", err)
+			}
+			if verified > 0 {
+				log.WithContext(ctx).Infof("Completed %d assembled uploads", verified)
+			}
+
+			if !s.contentAddressing {
+				continue
+			}
+
+			collected, err := s.CollectBlobs(ctx, s.blobGracePeriod)
+			if err != nil {
+				log.WithContext(ctx).Error("Failed to collect unreferenced blobs: ", err)
+			}
+			if collected > 0 {
+				log.WithContext(ctx).Infof("Deleted %d unreferenced blobs", collected)
+			}
+		}
+	}
+}
+
+// verification is the verification of an assembled upload, which is done
+// once file and err are set
+type verification struct {
+	done chan struct{}
+	file domain.File
+	err  error
+}
+
+// awaitVerification verifies an assembled upload, or joins the verification
+// of it already running, and waits for the result until ctx ends
+func (s *FileService) awaitVerification(ctx context.Context, uploader MultipartRepository, upload domain.MultipartUpload) (domain.File, error) {
+	v := s.startVerification(ctx, uploader, upload)
+
+	select {
+	case <-v.done:
+		return v.file, v.err
+	case <-ctx.Done():
+		return domain.File{}, errors.ErrUploadVerifying
+	}
+}
+
+// startVerification verifies an upload in the background unless it is
+// already being verified. Verification outlives the request that started
+// it, up to uploadVerifyTimeout.
+func (s *FileService) startVerification(ctx context.Context, uploader MultipartRepository, upload domain.MultipartUpload) *verification {
+	s.verifyMu.Lock()
+	defer s.verifyMu.Unlock()
+
+	key := upload.Username + "/" + upload.ID
+	if v, running := s.verifying[key]; running {
+		return v
+	}
+
+	v := &verification{done: make(chan struct{})}
+	if s.verifying == nil {
+		s.verifying = make(map[string]*verification)
+	}
+	s.verifying[key] = v
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), uploadVerifyTimeout)
+		defer cancel()
+
+		v.file, v.err = s.verifyUpload(ctx, uploader, upload)
+
+		s.verifyMu.Lock()
+		delete(s.verifying, key)
+		s.verifyMu.Unlock()
+		close(v.done)
+	}()
+
+	return v
+}
+
+// verifyUpload reads back an assembled upload from quarantine and, if it
+// matches its checksum and is clean, copies it to its real key and records
+// it as a file. An upload that cannot be read or scanned is left as it is,
+// so it can be verified again.
+func (s *FileService) verifyUpload(ctx context.Context, uploader MultipartRepository, upload domain.MultipartUpload) (domain.File, error) {
+	quarantined := quarantineKey(upload.Key)
+
+	sum, scanErr, err := s.inspectObject(ctx, uploader, upload.Username, quarantined)
+	if stderrors.Is(err, errors.ErrObjectNotFound) {
+		// Another attempt completed the upload first, or the object is lost
+		if file, getErr := s.Repo.GetFile(ctx, upload.Username, upload.ID); getErr == nil {
+			return file, nil
+		}
+		s.discardUpload(ctx, upload)
+		return domain.File{}, err
+	}
+	if err != nil {
+		return domain.File{}, err
+	}
+
+	if upload.Checksum != "" && sum != upload.Checksum {
+		s.discardUpload(ctx, upload)
+		return domain.File{}, errors.ErrChecksumMismatch
+	}
+
+	if scanErr != nil {
+		if !stderrors.Is(scanErr, errors.ErrScanFailed) {
+			s.discardUpload(ctx, upload)
+		}
+		return domain.File{}, scanErr
+	}
+
+	file := domain.File{
+		Username:    upload.Username,
+		ID:          upload.ID,
+		Name:        upload.Name,
+		Size:        upload.Size,
+		ContentType: upload.ContentType,
+		Checksum:    sum,
+		Key:         upload.Key,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	if s.contentAddressing {
+		file = s.shareUploadedBlob(ctx, file)
+	}
+
+	// Content already stored as a blob needs no copy of its own
+	if file.Key == upload.Key {
+		if err := uploader.Copy(ctx, quarantined, upload.Key); err != nil {
+			s.releaseBlob(ctx, file)
+			return domain.File{}, err
+		}
+
+		if file.ContentAddressed {
+			if err := s.Repo.MarkBlobStored(ctx, file.Checksum); err != nil {
+				log.WithContext(ctx).Warn("Failed to mark blob as stored: ", err)
+			}
+		}
+	}
+
+	if err := s.Repo.CompleteUpload(ctx, file); err != nil {
+		s.releaseBlob(ctx, file)
+
+		// Another attempt completed the upload first
+		if completed, getErr := s.Repo.GetFile(ctx, upload.Username, upload.ID); getErr == nil {
+			return completed, nil
+		}
+
+		// A shared object is left to blob collection
+		if !file.ContentAddressed {
+			if deleteErr := s.Objects.Delete(ctx, upload.Key); deleteErr != nil {
+				log.WithContext(ctx).Error("Failed to delete object of abandoned upload: ", deleteErr)
+			}
+		}
+
+		// Unless the upload was aborted while it was being completed, it
+		// stays in quarantine to be completed again
+		if _, getErr := s.Repo.GetUpload(ctx, upload.Username, upload.ID); stderrors.Is(getErr, errors.ErrUploadNotFound) {
+			releaseQuarantined(ctx, s.Objects, quarantined)
+		}
+		return domain.File{}, err
+	}
+
+	releaseQuarantined(ctx, s.Objects, quarantined)
+	recordFileUpload(file)
+	return file, nil
+}
+
+// discardUpload removes an assembled object that failed verification along
+// with its upload record, logging failures
+func (s *FileService) discardUpload(ctx context.Context, upload domain.MultipartUpload) {
+	if err := s.Objects.Delete(ctx, quarantineKey(upload.Key)); err != nil {
+		log.WithContext(ctx).Error("Failed to delete object that failed verification: ", err)
+	}
+
+	if err := s.Repo.DeleteUpload(ctx, upload.Username, upload.ID); err != nil {
+		log.WithContext(ctx).Error("Failed to delete upload that failed verification: ", err)
+	}
+}
+
+// partSize returns the expected size of a part, which is the upload's part
+// size for every part but the last
+func partSize(upload domain.MultipartUpload, number int32) (int64, error) {
+	if number < 1 || number > upload.PartCount {
+		return 0, errors.ErrInvalidPart
+	}
+
+	if number == upload.PartCount {
+		return upload.Size - int64(upload.PartCount-1)*upload.PartSize, nil
+	}
+	return upload.PartSize, nil
+}
+
+// partsComplete reports whether parts holds every part of the upload at its
+// expected size. Parts are listed in part order.
+func partsComplete(upload domain.MultipartUpload, parts []domain.UploadPart) bool {
+	if len(parts) != int(upload.PartCount) {
+		return false
+	}
+
+	for i, part := range parts {
+		size, err := partSize(upload, int32(i+1))
+		if err != nil || part.Number != int32(i+1) || part.Size != size {
+			return false
+		}
+	}
+	return true
+}
+
+// inspectObject reads an assembled upload once to compute its hex SHA-256
+// and, with a scanner configured, scan it. The result of the scan is returned
+// separately from errors reading the object.
+func (s *FileService) inspectObject(ctx context.Context, uploader MultipartRepository, username string, key string) (string, error, error) {
+	body, err := uploader.Download(ctx, key)
+	if err != nil {
+		return "", nil, err
+	}
+	defer body.Close()
+
+	hash := sha256.New()
+
+	var scanErr error
+	if s.scanner != nil {
+		scanErr = scan(ctx, s.scanner, s.auditor, username, key, io.TeeReader(body, hash))
+	}
+
+	// Scanners may stop reading early, e.g. when a file is too large for them
+	if _, err := io.Copy(hash, body); err != nil {
+		return "", nil, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), scanErr, nil
+}
+
+// validChecksum reports whether checksum is a lowercase hex SHA-256 digest
+func validChecksum(checksum string) bool {
+	decoded, err := hex.DecodeString(checksum)
+	return err == nil && len(decoded) == sha256.Size && hex.EncodeToString(decoded) == checksum
+}
@@ -0,0 +1,362 @@
+package service
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+	"github.com/zzenonn/go-zenon-api-aws/internal/imaging"
+	"github.com/zzenonn/go-zenon-api-aws/internal/tracing"
+)
+
+// ObjectLister - optional interface for object stores that can list and read
+// back stored objects. Profile versions are kept under their own keys in
+// stores without object versioning.
+type ObjectLister interface {
+	List(ctx context.Context, prefix string) ([]domain.ObjectInfo, error)
+	Download(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// VersionedObjectRepository - optional interface for object stores that keep
+// every version of an object, such as S3 buckets with versioning enabled
+type VersionedObjectRepository interface {
+	VersioningEnabled(ctx context.Context) (bool, error)
+	ListVersions(ctx context.Context, prefix string) ([]domain.ObjectVersion, error)
+	DownloadVersion(ctx context.Context, key string, versionID string) (io.ReadCloser, error)
+	DeleteVersion(ctx context.Context, key string, versionID string) error
+}
+
+// WithProfileVersions - sets how many profile image versions are kept per
+// user, 0 to keep every version
+func WithProfileVersions(count int) Option {
+	return func(s *UserService) {
+		s.profileVersions = count
+	}
+}
+
+// WithObjectVersioning - keeps profile versions with the object store's own
+// versioning instead of under separate keys
+func WithObjectVersioning(enabled bool) Option {
+	return func(s *UserService) {
+		s.objectVersioning = enabled
+	}
+}
+
+// ListProfileVersions returns the user's profile image versions, newest first
+func (s *UserService) ListProfileVersions(ctx context.Context, username string) ([]domain.ProfileVersion, error) {
+	ctx, span := tracing.Start(ctx, "UserService.ListProfileVersions")
+	defer span.End()
+
+	user, err := s.Repo.GetUser(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.profileVersionsOf(ctx, user)
+}
+
+// RestoreProfileVersion makes an earlier profile image the current one. With
+// object versioning the image is copied over the current one as a new
+// version; otherwise the user is pointed back at the version's keys.
+func (s *UserService) RestoreProfileVersion(ctx context.Context, username string, id string) (_ domain.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.RestoreProfileVersion")
+	defer span.End()
+	defer func() {
+		s.audit(ctx, domain.AuditEvent{Action: domain.AuditProfileRestore, Target: username, Detail: "version=" + id}, err)
+	}()
+
+	user, err := s.Repo.GetUser(ctx, username)
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	versions, err := s.profileVersionsOf(ctx, user)
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	index := -1
+	for i, version := range versions {
+		if version.ID == id {
+			index = i
+		}
+	}
+	if index < 0 {
+		return domain.User{}, errors.ErrVersionNotFound
+	}
+
+	version := versions[index]
+	if version.Current {
+		return user, nil
+	}
+
+	if versioned, ok := s.versionedStore(); ok {
+		return s.restoreObjectVersion(ctx, user, versioned, version)
+	}
+
+	lister, _ := s.versionLister()
+	data, err := readAll(lister.Download(ctx, version.Key))
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	profileChecksum := sha256Hex(data)
+	updated, err := s.Repo.UpdateUser(ctx, username, domain.User{ProfilePath: &version.Key, ProfileChecksum: &profileChecksum})
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	s.deleteReplacedProfile(ctx, user, version.Key)
+	return updated, nil
+}
+
+// restoreObjectVersion copies a stored version over the current profile.
+// The original is kept byte for byte; only the thumbnails are created again.
+func (s *UserService) restoreObjectVersion(ctx context.Context, user domain.User, versioned VersionedObjectRepository, version domain.ProfileVersion) (domain.User, error) {
+	data, err := readAll(versioned.DownloadVersion(ctx, version.Key, version.ID))
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	renditions, err := imaging.Process(data, s.imageOptions)
+	if err != nil {
+		return domain.User{}, err
+	}
+	renditions[0].Data = data
+
+	return s.saveProfile(ctx, user, version.Key, renditions)
+}
+
+// profileVersionsOf lists the versions of the user's profile image, newest first
+func (s *UserService) profileVersionsOf(ctx context.Context, user domain.User) ([]domain.ProfileVersion, error) {
+	username := *user.Username
+	current := ""
+	if user.ProfilePath != nil {
+		current = *user.ProfilePath
+	}
+
+	var versions []domain.ProfileVersion
+	if versioned, ok := s.versionedStore(); ok {
+		objects, err := versioned.ListVersions(ctx, profileKeyPrefix(username))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range objects {
+			if object.DeleteMarker || !isProfileOriginal(username, object.Key) {
+				continue
+			}
+			versions = append(versions, domain.ProfileVersion{
+				ID:        object.VersionID,
+				Size:      object.Size,
+				CreatedAt: object.LastModified,
+				Current:   object.IsLatest && object.Key == current,
+				Key:       object.Key,
+			})
+		}
+	} else if lister, ok := s.versionLister(); ok {
+		objects, err := lister.List(ctx, profileVersionsPrefix(username))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range objects {
+			id, ok := profileVersionID(username, object.Key)
+			if !ok {
+				continue
+			}
+			versions = append(versions, domain.ProfileVersion{
+				ID:        id,
+				Size:      object.Size,
+				CreatedAt: object.LastModified,
+				Current:   object.Key == current,
+				Key:       object.Key,
+			})
+		}
+	} else {
+		return nil, errors.ErrNotImplemented
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		if !versions[i].CreatedAt.Equal(versions[j].CreatedAt) {
+			return versions[i].CreatedAt.After(versions[j].CreatedAt)
+		}
+		return versions[i].ID > versions[j].ID
+	})
+	return versions, nil
+}
+
+// pruneProfileVersions deletes the oldest versions beyond the retention
+// count. The current version is always kept. With object versioning, older
+// versions of thumbnails are deleted as well since they are never restored.
+func (s *UserService) pruneProfileVersions(ctx context.Context, user domain.User) error {
+	if s.profileVersions <= 0 {
+		return nil
+	}
+
+	versions, err := s.profileVersionsOf(ctx, user)
+	if stderrors.Is(err, errors.ErrNotImplemented) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	versioned, isVersioned := s.versionedStore()
+
+	kept := 0
+	for _, version := range versions {
+		if version.Current || kept < s.profileVersions-1 {
+			if !version.Current {
+				kept++
+			}
+			continue
+		}
+
+		if isVersioned {
+			err = versioned.DeleteVersion(ctx, version.Key, version.ID)
+		} else {
+			err = s.deleteProfileFiles(ctx, version.Key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if !isVersioned {
+		return nil
+	}
+
+	objects, err := versioned.ListVersions(ctx, profileKeyPrefix(*user.Username))
+	if err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		if object.IsLatest || isProfileOriginal(*user.Username, object.Key) {
+			continue
+		}
+		if err := versioned.DeleteVersion(ctx, object.Key, object.VersionID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteProfileVersions permanently deletes every stored version of the
+// user's profile image and thumbnails
+func (s *UserService) deleteProfileVersions(ctx context.Context, username string) error {
+	if versioned, ok := s.versionedStore(); ok {
+		objects, err := versioned.ListVersions(ctx, profileKeyPrefix(username))
+		if err != nil {
+			return err
+		}
+
+		for _, object := range objects {
+			if err := versioned.DeleteVersion(ctx, object.Key, object.VersionID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	lister, ok := s.versionLister()
+	if !ok {
+		return nil
+	}
+
+	objects, err := lister.List(ctx, profileVersionsPrefix(username))
+	if err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		if err := s.ProfileRepo.Delete(ctx, object.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// versionedStore returns the profile store if it keeps object versions itself
+func (s *UserService) versionedStore() (VersionedObjectRepository, bool) {
+	if !s.objectVersioning {
+		return nil, false
+	}
+	versioned, ok := s.ProfileRepo.(VersionedObjectRepository)
+	return versioned, ok
+}
+
+// versionLister returns the profile store if versions are kept under their
+// own keys, which is the case for any listable store without object versioning
+func (s *UserService) versionLister() (ObjectLister, bool) {
+	if _, ok := s.versionedStore(); ok {
+		return nil, false
+	}
+	lister, ok := s.ProfileRepo.(ObjectLister)
+	return lister, ok
+}
+
+// profileKeyPrefix is the prefix shared by every profile key of a user
+func profileKeyPrefix(username string) string {
+	return username + "/profile/"
+}
+
+// profileVersionsPrefix is where profile versions are kept without object versioning
+func profileVersionsPrefix(username string) string {
+	return profileKeyPrefix(username) + "versions/"
+}
+
+// profileVersionKey returns the key of the original of a stored version.
+// Its thumbnails sit next to it.
+func profileVersionKey(username string, id string, ext string) string {
+	return profileVersionsPrefix(username) + id + "/profile" + ext
+}
+
+// profileVersionID extracts the version ID from the key of a version's
+// original, rejecting thumbnails and unrelated keys
+func profileVersionID(username string, key string) (string, bool) {
+	rest, found := strings.CutPrefix(key, profileVersionsPrefix(username))
+	if !found {
+		return "", false
+	}
+
+	id, name, found := strings.Cut(rest, "/")
+	if !found || id == "" || name != "profile"+path.Ext(name) {
+		return "", false
+	}
+	return id, true
+}
+
+// isProfileOriginal reports whether key is the stable key of a user's
+// original profile image rather than a thumbnail
+func isProfileOriginal(username string, key string) bool {
+	return key == profileKey(username, 0, path.Ext(key))
+}
+
+// newVersionID returns an identifier that sorts in the order versions were created
+func newVersionID() (string, error) {
+	id, err := randomID()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%016x%s", time.Now().UnixNano(), id[:8]), nil
+}
+
+// readAll reads and closes the body returned by a download
+func readAll(body io.ReadCloser, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return io.ReadAll(body)
+}
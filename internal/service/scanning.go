@@ -0,0 +1,147 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Scanner - interface for malware scanners that uploads pass through before
+// they become visible
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (domain.ScanResult, error)
+}
+
+// WithScanner - scans profile images before they are stored
+func WithScanner(scanner Scanner) Option {
+	return func(s *UserService) {
+		s.scanner = scanner
+	}
+}
+
+// WithFileScanner - scans file attachments before they are stored
+func WithFileScanner(scanner Scanner) FileOption {
+	return func(s *FileService) {
+		s.scanner = scanner
+	}
+}
+
+// WithFileAuditor - records file attachments rejected as infected
+func WithFileAuditor(auditor Auditor) FileOption {
+	return func(s *FileService) {
+		s.auditor = auditor
+	}
+}
+
+// QuarantineRepository - optional interface for object stores in which
+// uploads can be scanned where they are stored and then copied to their real key
+type QuarantineRepository interface {
+	Download(ctx context.Context, key string) (io.ReadCloser, error)
+	Copy(ctx context.Context, source string, destination string) error
+}
+
+// quarantine stores data under the quarantine prefix, scans the stored copy
+// and returns its key. Callers promote clean data to its real key and then
+// release the quarantined copy. Without a scanner nothing is stored and ""
+// is returned; stores that cannot copy objects get data scanned in memory.
+func quarantine(ctx context.Context, objects ObjectRepository, scanner Scanner, auditor Auditor, username string, key string, data []byte, contentType string) (string, error) {
+	if scanner == nil {
+		return "", nil
+	}
+
+	store, ok := objects.(QuarantineRepository)
+	if !ok {
+		return "", scan(ctx, scanner, auditor, username, key, bytes.NewReader(data))
+	}
+
+	quarantined := quarantineKey(key)
+	if err := objects.Upload(ctx, quarantined, bytes.NewReader(data), contentType, sha256Hex(data)); err != nil {
+		return "", err
+	}
+
+	if err := scanStored(ctx, store, scanner, auditor, username, key, quarantined); err != nil {
+		releaseQuarantined(ctx, objects, quarantined)
+		return "", err
+	}
+	return quarantined, nil
+}
+
+// scanStored scans the object stored under quarantined, the quarantined copy
+// of key
+func scanStored(ctx context.Context, store QuarantineRepository, scanner Scanner, auditor Auditor, username string, key string, quarantined string) error {
+	body, err := store.Download(ctx, quarantined)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return scan(ctx, scanner, auditor, username, key, body)
+}
+
+// promote stores clean data under key, by copying its quarantined copy if
+// there is one and by uploading data otherwise
+func promote(ctx context.Context, objects ObjectRepository, quarantined string, key string, data []byte, contentType string, checksum string) error {
+	if quarantined == "" {
+		return objects.Upload(ctx, key, bytes.NewReader(data), contentType, checksum)
+	}
+	return objects.(QuarantineRepository).Copy(ctx, quarantined, key)
+}
+
+// releaseQuarantined deletes a quarantined copy that is no longer needed,
+// logging failures
+func releaseQuarantined(ctx context.Context, objects ObjectRepository, quarantined string) {
+	if quarantined == "" {
+		return
+	}
+
+	if err := objects.Delete(ctx, quarantined); err != nil {
+		log.WithContext(ctx).Warn("Failed to delete quarantined upload: ", err)
+	}
+}
+
+// scan checks r for malware. Infected uploads are logged, recorded in the
+// audit log if auditor is set, and rejected with errors.ErrMalwareDetected.
+func scan(ctx context.Context, scanner Scanner, auditor Auditor, username string, key string, r io.Reader) error {
+	result, err := scanner.Scan(ctx, r)
+	if err != nil {
+		log.WithContext(ctx).Error("Failed to scan upload: ", err)
+		return fmt.Errorf("%w: %v", errors.ErrScanFailed, err)
+	}
+
+	if result.Infected {
+		log.WithContext(ctx).WithFields(log.Fields{
+			"audit":     true,
+			"event":     "malware_detected",
+			"username":  username,
+			"key":       key,
+			"signature": result.Signature,
+		}).Warn("Rejected infected upload")
+
+		if auditor != nil {
+			auditor.Record(ctx, domain.AuditEvent{
+				Actor:   username,
+				Action:  domain.AuditMalware,
+				Target:  key,
+				Outcome: domain.AuditDenied,
+				Detail:  result.Signature,
+				Reason:  errors.ErrMalwareDetected.Error(),
+			})
+		}
+		return errors.ErrMalwareDetected
+	}
+
+	return nil
+}
+
+// quarantineKey returns where an upload is kept until it has been scanned. It
+// sits at the top of the bucket so access to unscanned files can be denied
+// with one prefix.
+func quarantineKey(key string) string {
+	return "quarantine/" + key
+}
@@ -1,11 +1,25 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
 	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+	"github.com/zzenonn/go-zenon-api-aws/internal/imaging"
+	"github.com/zzenonn/go-zenon-api-aws/internal/metrics"
+	"github.com/zzenonn/go-zenon-api-aws/internal/tracing"
+
+	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -16,38 +30,110 @@ type UserRepository interface {
 	UpdateUser(ctx context.Context, username string, user domain.User) (domain.User, error)
 	DeleteUser(ctx context.Context, username string) error
 	GetAllUsers(ctx context.Context, pageSize int, nextToken string) ([]domain.User, string, error)
+	ClearProfilePath(ctx context.Context, username string) error
 }
 
-// UserProfileRepository - interface for profile storage operations
-type UserProfileRepository interface {
-	Upload(ctx context.Context, key string, r io.Reader) error
+// ObjectRepository - interface for the object storage that holds profile
+// images and file attachments
+type ObjectRepository interface {
+	// Upload stores an object. A non-empty checksum is the hex SHA-256 the
+	// content must have; stores verify it while writing and return
+	// errors.ErrChecksumMismatch without keeping the object if it differs.
+	Upload(ctx context.Context, key string, r io.Reader, contentType string, checksum string) error
 	GetPresignedUrl(ctx context.Context, key string) (string, error)
 	Delete(ctx context.Context, key string) error
 }
 
+// UserProfileRepository - interface for profile storage operations. Profile
+// images are ordinary objects kept under {username}/profile/.
+type UserProfileRepository interface {
+	ObjectRepository
+}
+
+// DirectUploadRepository - optional interface for profile stores that let
+// clients upload files directly instead of through the API
+type DirectUploadRepository interface {
+	PresignUpload(ctx context.Context, key string, method string, contentType string, size int64, maxSize int64, expires time.Duration) (domain.PresignedUpload, error)
+	Stat(ctx context.Context, key string) (domain.ObjectInfo, error)
+	Download(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// SignedURLRepository - optional interface for profile stores whose URLs may
+// rely on signed cookies, which only cover the viewer's own objects. URLs for
+// anyone else are signed on their own.
+type SignedURLRepository interface {
+	GetSignedUrl(ctx context.Context, key string) (string, error)
+}
+
 // UserService - service for managing users and profiles
 type UserService struct {
 	Repo        UserRepository
 	ProfileRepo UserProfileRepository
+
+	maxProfileSize int64
+	uploadURLTTL   time.Duration
+	imageOptions   imaging.Options
+	scanner        Scanner
+
+	profileVersions  int
+	objectVersioning bool
+
+	auditor Auditor
 }
 
-// NewUserService - returns a new instance of UserService
-func NewUserService(repo UserRepository, profileRepo UserProfileRepository) *UserService {
-	return &UserService{
-		Repo:        repo,
-		ProfileRepo: profileRepo,
+// Option - configures optional UserService settings
+type Option func(*UserService)
+
+// WithMaxProfileSize - sets the largest profile image accepted, in bytes
+func WithMaxProfileSize(size int64) Option {
+	return func(s *UserService) {
+		s.maxProfileSize = size
 	}
 }
 
-func (s *UserService) CreateUser(ctx context.Context, user domain.User) (domain.User, error) {
-	// Check if the username already exists
-	existingUser, err := s.Repo.GetUser(ctx, *user.Username)
-	if err == nil && existingUser.Username != nil {
-		// If a user with the username already exists, return an error
-		return domain.User{}, errors.ErrInvalidUser
+// WithUploadURLTTL - sets how long direct upload URLs stay valid
+func WithUploadURLTTL(ttl time.Duration) Option {
+	return func(s *UserService) {
+		s.uploadURLTTL = ttl
 	}
+}
+
+// WithImageOptions - sets how uploaded profile images are processed
+func WithImageOptions(opts imaging.Options) Option {
+	return func(s *UserService) {
+		s.imageOptions = opts
+	}
+}
 
-	if err := user.HashPassword(); err != nil {
+// NewUserService - returns a new instance of UserService
+func NewUserService(repo UserRepository, profileRepo UserProfileRepository, opts ...Option) *UserService {
+	s := &UserService{
+		Repo:           repo,
+		ProfileRepo:    profileRepo,
+		maxProfileSize: 10 << 20,
+		uploadURLTTL:   15 * time.Minute,
+		imageOptions:   imaging.DefaultOptions(),
+
+		profileVersions: 5,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+func (s *UserService) CreateUser(ctx context.Context, user domain.User) (_ domain.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.CreateUser")
+	defer span.End()
+	defer func() {
+		s.audit(ctx, domain.AuditEvent{Action: domain.AuditUserCreate, Target: usernameOf(user)}, err)
+	}()
+
+	// The repository only creates the user if the username is not already
+	// taken and returns errors.ErrUserAlreadyExists otherwise
+	if err := hashPassword(ctx, &user); err != nil {
 		return domain.User{}, err
 	}
 
@@ -56,10 +142,13 @@ func (s *UserService) CreateUser(ctx context.Context, user domain.User) (domain.
 		return domain.User{}, err
 	}
 
+	metrics.Signups.Inc()
 	return insertedUser, nil
 }
 
 func (s *UserService) GetUser(ctx context.Context, username string) (domain.User, error) {
+	ctx, span := tracing.Start(ctx, "UserService.GetUser")
+	defer span.End()
 
 	user, err := s.Repo.GetUser(ctx, username)
 
@@ -70,8 +159,22 @@ func (s *UserService) GetUser(ctx context.Context, username string) (domain.User
 	return user, nil
 }
 
-func (s *UserService) UpdateUser(ctx context.Context, user domain.User) (domain.User, error) {
+func (s *UserService) UpdateUser(ctx context.Context, user domain.User) (_ domain.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.UpdateUser")
+	defer span.End()
+	defer func() {
+		event := domain.AuditEvent{Action: domain.AuditUserUpdate, Target: usernameOf(user)}
+		if user.Password != "" {
+			event.Detail = "password changed"
+		}
+		s.audit(ctx, event, err)
+	}()
+
+	return s.updateUser(ctx, user)
+}
 
+// updateUser replaces the user's fields that are set, hashing a new password
+func (s *UserService) updateUser(ctx context.Context, user domain.User) (domain.User, error) {
 	userToUpdate, err := s.Repo.GetUser(ctx, *user.Username)
 
 	if err != nil {
@@ -80,7 +183,7 @@ func (s *UserService) UpdateUser(ctx context.Context, user domain.User) (domain.
 
 	// if the password is not empty, hash it
 	if user.Password != "" {
-		if err := user.HashPassword(); err != nil {
+		if err := hashPassword(ctx, &user); err != nil {
 			return domain.User{}, err
 		}
 	}
@@ -94,7 +197,12 @@ func (s *UserService) UpdateUser(ctx context.Context, user domain.User) (domain.
 	return user, nil
 }
 
-func (s *UserService) DeleteUser(ctx context.Context, username string) error {
+func (s *UserService) DeleteUser(ctx context.Context, username string) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.DeleteUser")
+	defer span.End()
+	defer func() {
+		s.audit(ctx, domain.AuditEvent{Action: domain.AuditUserDelete, Target: username}, err)
+	}()
 
 	userToDelete, err := s.Repo.GetUser(ctx, username)
 
@@ -108,9 +216,14 @@ func (s *UserService) DeleteUser(ctx context.Context, username string) error {
 }
 
 // TODO send email vefification
-func (s *UserService) Signup(ctx context.Context, user domain.User) (domain.User, error) {
-
-	if err := user.HashPassword(); err != nil {
+func (s *UserService) Signup(ctx context.Context, user domain.User) (_ domain.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.Signup")
+	defer span.End()
+	defer func() {
+		s.audit(ctx, domain.AuditEvent{Action: domain.AuditUserCreate, Actor: usernameOf(user), Target: usernameOf(user), Detail: "signup"}, err)
+	}()
+
+	if err := hashPassword(ctx, &user); err != nil {
 		return domain.User{}, err
 	}
 
@@ -119,61 +232,519 @@ func (s *UserService) Signup(ctx context.Context, user domain.User) (domain.User
 		return domain.User{}, err
 	}
 
+	metrics.Signups.Inc()
 	return insertedUser, nil
 }
 
 func (s *UserService) Login(ctx context.Context, username string, password string) error {
+	ctx, span := tracing.Start(ctx, "UserService.Login")
+	defer span.End()
+
+	err := s.login(ctx, username, password)
+	s.audit(ctx, domain.AuditEvent{Action: domain.AuditLogin, Actor: username, Target: username}, err)
+	if err != nil {
+		metrics.Logins.WithLabelValues(metrics.LoginFailed).Inc()
+		return err
+	}
+
+	metrics.Logins.WithLabelValues(metrics.LoginSucceeded).Inc()
+	return nil
+}
+
+// audit records the outcome of an action in the audit log, if one is configured
+func (s *UserService) audit(ctx context.Context, event domain.AuditEvent, err error) {
+	if s.auditor == nil {
+		return
+	}
+
+	event.Outcome = domain.AuditSuccess
+	if err != nil {
+		event.Outcome = domain.AuditFailure
+		event.Reason = err.Error()
+	}
+	s.auditor.Record(ctx, event)
+}
+
+// usernameOf returns the user's username, or "" if it is not set
+func usernameOf(user domain.User) string {
+	if user.Username == nil {
+		return ""
+	}
+	return *user.Username
+}
+
+// hashPassword hashes the user's password in a span of its own, as bcrypt
+// is slow by design
+func hashPassword(ctx context.Context, user *domain.User) error {
+	_, span := tracing.Start(ctx, "bcrypt.GenerateFromPassword")
+	defer span.End()
+
+	return user.HashPassword()
+}
+
+// login checks that the user exists, is enabled and has the given password
+func (s *UserService) login(ctx context.Context, username string, password string) error {
 	user, err := s.Repo.GetUser(ctx, username)
 	if err != nil {
 		return err
 	}
 
-	if bcrypt.CompareHashAndPassword(user.HashedPassword, []byte(password)) != nil {
+	if user.IsDisabled() {
+		return errors.ErrInvalidUser
+	}
+
+	_, compareSpan := tracing.Start(ctx, "bcrypt.CompareHashAndPassword")
+	err = bcrypt.CompareHashAndPassword(user.HashedPassword, []byte(password))
+	compareSpan.End()
+	if err != nil {
 		return errors.ErrInvalidUser
 	}
 
 	return nil
 }
 
-// UploadProfile uploads a user profile image
-func (s *UserService) UploadProfile(ctx context.Context, username string, key string, r io.Reader) error {
-	profilePath := username + "/profile/" + key
-	err := s.ProfileRepo.Upload(ctx, profilePath, r)
-	if err != nil {
+// ListUsers returns one page of users and the token for the next page
+func (s *UserService) ListUsers(ctx context.Context, pageSize int, nextToken string) ([]domain.User, string, error) {
+	ctx, span := tracing.Start(ctx, "UserService.ListUsers")
+	defer span.End()
+
+	return s.Repo.GetAllUsers(ctx, pageSize, nextToken)
+}
+
+// ResetPassword replaces a user's password
+func (s *UserService) ResetPassword(ctx context.Context, username string, password string) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.ResetPassword")
+	defer span.End()
+	defer func() {
+		s.audit(ctx, domain.AuditEvent{Action: domain.AuditPasswordReset, Target: username}, err)
+	}()
+
+	if password == "" {
+		return errors.ErrMissingRequiredFields
+	}
+
+	_, err = s.updateUser(ctx, domain.User{
+		Username: &username,
+		Password: password,
+	})
+	return err
+}
+
+// SetDisabled enables or disables a user's ability to log in
+func (s *UserService) SetDisabled(ctx context.Context, username string, disabled bool) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.SetDisabled")
+	defer span.End()
+	defer func() {
+		action := domain.AuditUserEnable
+		if disabled {
+			action = domain.AuditUserDisable
+		}
+		s.audit(ctx, domain.AuditEvent{Action: action, Target: username}, err)
+	}()
+
+	if _, err := s.Repo.GetUser(ctx, username); err != nil {
 		return err
 	}
 
-	// Update user's profile path in database
-	user, err := s.Repo.GetUser(ctx, username)
-	if err != nil {
+	_, err = s.Repo.UpdateUser(ctx, username, domain.User{Disabled: &disabled})
+	return err
+}
+
+// SetRole changes the role of a user
+func (s *UserService) SetRole(ctx context.Context, username string, role string) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.SetRole")
+	defer span.End()
+	defer func() {
+		s.audit(ctx, domain.AuditEvent{Action: domain.AuditRoleChange, Target: username, Detail: "role=" + role}, err)
+	}()
+
+	if !domain.IsValidRole(role) {
+		return errors.ErrInvalidRole
+	}
+
+	if _, err := s.Repo.GetUser(ctx, username); err != nil {
 		return err
 	}
 
-	user.ProfilePath = &profilePath
-	_, err = s.Repo.UpdateUser(ctx, username, user)
+	_, err = s.Repo.UpdateUser(ctx, username, domain.User{Role: &role})
 	return err
 }
 
-// GeneratePresignedURL generates a pre-signed URL for accessing a profile image
-func (s *UserService) GeneratePresignedURL(ctx context.Context, username string, key string) (string, error) {
-	return s.ProfileRepo.GetPresignedUrl(ctx, username+"/profile/"+key)
+// SetProfileVisibility changes who can see the user's profile image
+func (s *UserService) SetProfileVisibility(ctx context.Context, username string, visibility string) (domain.User, error) {
+	ctx, span := tracing.Start(ctx, "UserService.SetProfileVisibility")
+	defer span.End()
+
+	if !domain.IsValidVisibility(visibility) {
+		return domain.User{}, errors.ErrInvalidVisibility
+	}
+
+	if _, err := s.Repo.GetUser(ctx, username); err != nil {
+		return domain.User{}, err
+	}
+
+	return s.Repo.UpdateUser(ctx, username, domain.User{ProfileVisibility: &visibility})
 }
 
-// DeleteProfile deletes a user profile image
-func (s *UserService) DeleteProfile(ctx context.Context, username string, key string) error {
-	profilePath := username + "/profile/" + key
-	err := s.ProfileRepo.Delete(ctx, profilePath)
+// UploadProfile validates a profile image, strips its metadata and stores it
+// together with its thumbnails. With a scanner configured, the image is
+// scanned in quarantine before it is processed.
+func (s *UserService) UploadProfile(ctx context.Context, username string, r io.Reader) (_ domain.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.UploadProfile")
+	defer span.End()
+	defer func() {
+		s.audit(ctx, domain.AuditEvent{Action: domain.AuditProfileUpload, Target: username}, err)
+	}()
+
+	user, err := s.Repo.GetUser(ctx, username)
 	if err != nil {
-		return err
+		return domain.User{}, err
+	}
+
+	// Read one byte past the limit to tell a full-size file from a larger one
+	data, err := io.ReadAll(io.LimitReader(r, s.maxProfileSize+1))
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	if int64(len(data)) > s.maxProfileSize {
+		return domain.User{}, errors.ErrFileTooLarge
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	// The stored profile is processed from data, which is what was scanned
+	quarantined, err := quarantine(ctx, s.ProfileRepo, s.scanner, s.auditor, username, username+"/profile/"+id, data, http.DetectContentType(data))
+	if err != nil {
+		return domain.User{}, err
+	}
+	defer releaseQuarantined(ctx, s.ProfileRepo, quarantined)
+
+	return s.storeProfile(ctx, user, data)
+}
+
+// storeProfile processes a profile image and stores it as a new version
+func (s *UserService) storeProfile(ctx context.Context, user domain.User, data []byte) (domain.User, error) {
+	username := *user.Username
+
+	renditions, err := imaging.Process(data, s.imageOptions)
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	// Without object versioning, every upload is kept under its own key
+	profilePath := profileKey(username, 0, renditions[0].Ext)
+	if _, ok := s.versionLister(); ok {
+		id, err := newVersionID()
+		if err != nil {
+			return domain.User{}, err
+		}
+		profilePath = profileVersionKey(username, id, renditions[0].Ext)
+	}
+
+	updated, err := s.saveProfile(ctx, user, profilePath, renditions)
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	var stored int
+	for _, rendition := range renditions {
+		stored += len(rendition.Data)
+	}
+	metrics.Uploads.WithLabelValues(metrics.UploadProfile).Inc()
+	metrics.BytesStored.WithLabelValues(metrics.UploadProfile).Add(float64(stored))
+
+	return updated, nil
+}
+
+// saveProfile uploads every rendition next to profilePath, records the path
+// of the original on the user and prunes versions beyond the retention count
+func (s *UserService) saveProfile(ctx context.Context, user domain.User, profilePath string, renditions []imaging.Rendition) (domain.User, error) {
+	username := *user.Username
+
+	for _, rendition := range renditions {
+		key := renditionKey(profilePath, rendition.Size)
+		if err := s.ProfileRepo.Upload(ctx, key, bytes.NewReader(rendition.Data), rendition.ContentType, sha256Hex(rendition.Data)); err != nil {
+			return domain.User{}, err
+		}
+	}
+
+	profileChecksum := sha256Hex(renditions[0].Data)
+	updated, err := s.Repo.UpdateUser(ctx, username, domain.User{ProfilePath: &profilePath, ProfileChecksum: &profileChecksum})
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	s.deleteReplacedProfile(ctx, user, profilePath)
+
+	if err := s.pruneProfileVersions(ctx, updated); err != nil {
+		log.WithContext(ctx).Warn("Failed to prune profile versions: ", err)
+	}
+
+	return updated, nil
+}
+
+// deleteReplacedProfile deletes the user's previous profile once profilePath
+// has replaced it, unless it is a version that is kept. The new profile is
+// already recorded, so a failed clean-up only leaves unreferenced files behind.
+func (s *UserService) deleteReplacedProfile(ctx context.Context, user domain.User, profilePath string) {
+	previous := user.ProfilePath
+	if previous == nil || *previous == profilePath || strings.HasPrefix(*previous, profileVersionsPrefix(*user.Username)) {
+		return
+	}
+
+	if err := s.deleteProfileFiles(ctx, *previous); err != nil {
+		log.WithContext(ctx).Warn("Failed to delete previous profile image: ", err)
+	}
+}
+
+// GetProfileURL returns a URL for the user's profile image as seen by
+// viewer, the signed-in user or "" for anonymous requests. A size of 0
+// selects the original, any other size must be one of the thumbnail sizes.
+// Images the viewer may not see are reported as missing. URLs for anyone but
+// the owner never depend on the owner's signed cookies.
+func (s *UserService) GetProfileURL(ctx context.Context, viewer string, username string, size int) (string, error) {
+	ctx, span := tracing.Start(ctx, "UserService.GetProfileURL")
+	defer span.End()
+
+	if size != 0 && !slices.Contains(s.imageOptions.Sizes, size) {
+		return "", errors.ErrInvalidRenditionSize
 	}
 
-	// Clear user's profile path in database
+	user, err := s.Repo.GetUser(ctx, username)
+	if err != nil {
+		return "", err
+	}
+
+	if user.ProfilePath == nil || !s.canViewProfile(ctx, viewer, user) {
+		return "", errors.ErrProfileNotFound
+	}
+
+	key := renditionKey(*user.ProfilePath, size)
+	if signer, ok := s.ProfileRepo.(SignedURLRepository); ok && viewer != username {
+		return signer.GetSignedUrl(ctx, key)
+	}
+	return s.ProfileRepo.GetPresignedUrl(ctx, key)
+}
+
+// canViewProfile reports whether viewer may see the user's profile image
+func (s *UserService) canViewProfile(ctx context.Context, viewer string, user domain.User) bool {
+	switch user.Visibility() {
+	case domain.VisibilityPublic:
+		return true
+	case domain.VisibilityAuthenticated:
+		return viewer != ""
+	}
+
+	if viewer == "" {
+		return false
+	}
+	if viewer == *user.Username {
+		return true
+	}
+
+	viewingUser, err := s.Repo.GetUser(ctx, viewer)
+	return err == nil && viewingUser.IsAdmin()
+}
+
+// DeleteProfile deletes a user's profile image, its thumbnails and every
+// earlier version
+func (s *UserService) DeleteProfile(ctx context.Context, username string) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.DeleteProfile")
+	defer span.End()
+	defer func() {
+		s.audit(ctx, domain.AuditEvent{Action: domain.AuditProfileDelete, Target: username}, err)
+	}()
+
 	user, err := s.Repo.GetUser(ctx, username)
 	if err != nil {
 		return err
 	}
 
-	user.ProfilePath = nil
-	_, err = s.Repo.UpdateUser(ctx, username, user)
-	return err
+	if user.ProfilePath == nil {
+		return errors.ErrProfileNotFound
+	}
+
+	// Clear the path first so the user never points at a deleted file
+	if err := s.Repo.ClearProfilePath(ctx, username); err != nil {
+		return err
+	}
+
+	if err := s.deleteProfileFiles(ctx, *user.ProfilePath); err != nil {
+		return err
+	}
+
+	return s.deleteProfileVersions(ctx, username)
+}
+
+// deleteProfileFiles deletes the original at profilePath and every thumbnail
+// derived from it
+func (s *UserService) deleteProfileFiles(ctx context.Context, profilePath string) error {
+	if err := s.ProfileRepo.Delete(ctx, profilePath); err != nil {
+		return err
+	}
+
+	for _, size := range s.imageOptions.Sizes {
+		if err := s.ProfileRepo.Delete(ctx, renditionKey(profilePath, size)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateProfileUpload returns a presigned request through which the client
+// uploads a profile image straight to object storage. The image only becomes
+// the user's profile once ConfirmProfileUpload has checked it.
+func (s *UserService) CreateProfileUpload(ctx context.Context, username string, method string, contentType string, size int64) (domain.PresignedUpload, error) {
+	ctx, span := tracing.Start(ctx, "UserService.CreateProfileUpload")
+	defer span.End()
+
+	uploader, ok := s.ProfileRepo.(DirectUploadRepository)
+	if !ok {
+		return domain.PresignedUpload{}, errors.ErrNotImplemented
+	}
+
+	ext := imaging.Extension(contentType)
+	if ext == "" {
+		return domain.PresignedUpload{}, errors.ErrUnsupportedMediaType
+	}
+
+	if method == "" {
+		method = domain.UploadMethodPut
+	}
+
+	if method == domain.UploadMethodPut && size <= 0 {
+		return domain.PresignedUpload{}, errors.ErrMissingRequiredFields
+	}
+
+	if size > s.maxProfileSize {
+		return domain.PresignedUpload{}, errors.ErrFileTooLarge
+	}
+
+	if _, err := s.Repo.GetUser(ctx, username); err != nil {
+		return domain.PresignedUpload{}, err
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return domain.PresignedUpload{}, err
+	}
+
+	key := pendingProfilePrefix(username) + id + ext
+	return uploader.PresignUpload(ctx, key, method, contentType, size, s.maxProfileSize, s.uploadURLTTL)
+}
+
+// ConfirmProfileUpload checks a directly uploaded profile image and, if it is
+// valid, processes it like an upload through the API. Pending uploads are
+// not visible to other users, so they are scanned where they are. The pending
+// upload is deleted whether or not it was valid.
+func (s *UserService) ConfirmProfileUpload(ctx context.Context, username string, key string) (_ domain.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.ConfirmProfileUpload")
+	defer span.End()
+	defer func() {
+		s.audit(ctx, domain.AuditEvent{Action: domain.AuditProfileUpload, Target: username, Detail: "direct upload"}, err)
+	}()
+
+	uploader, ok := s.ProfileRepo.(DirectUploadRepository)
+	if !ok {
+		return domain.User{}, errors.ErrNotImplemented
+	}
+
+	// Only keys handed out by CreateProfileUpload for this user are accepted
+	name, found := strings.CutPrefix(key, pendingProfilePrefix(username))
+	if !found || name == "" || strings.Contains(name, "/") {
+		return domain.User{}, errors.ErrInvalidObjectKey
+	}
+
+	user, err := s.Repo.GetUser(ctx, username)
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	info, err := uploader.Stat(ctx, key)
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	data, err := s.readUpload(ctx, uploader, info)
+	if err == nil && s.scanner != nil {
+		err = scan(ctx, s.scanner, s.auditor, username, key, bytes.NewReader(data))
+	}
+	if err == nil {
+		user, err = s.storeProfile(ctx, user, data)
+		if err == nil {
+			return user, s.ProfileRepo.Delete(ctx, key)
+		}
+	}
+
+	if deleteErr := s.ProfileRepo.Delete(ctx, key); deleteErr != nil {
+		return domain.User{}, deleteErr
+	}
+	return domain.User{}, err
+}
+
+// readUpload verifies the size of an uploaded object, downloads it and checks
+// that its content, declared content type and extension agree
+func (s *UserService) readUpload(ctx context.Context, uploader DirectUploadRepository, info domain.ObjectInfo) ([]byte, error) {
+	if info.Size > s.maxProfileSize {
+		return nil, errors.ErrFileTooLarge
+	}
+
+	if info.Size == 0 {
+		return nil, errors.ErrInvalidUpload
+	}
+
+	body, err := uploader.Download(ctx, info.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, s.maxProfileSize))
+	if err != nil {
+		return nil, err
+	}
+
+	detected := imaging.DetectContentType(data)
+	if detected == "" || detected != info.ContentType || path.Ext(info.Key) != imaging.Extension(detected) {
+		return nil, errors.ErrInvalidUpload
+	}
+
+	return data, nil
+}
+
+// profileKey returns the object key of a profile rendition. Size 0 is the original.
+func profileKey(username string, size int, ext string) string {
+	if size == 0 {
+		return username + "/profile/profile" + ext
+	}
+	return fmt.Sprintf("%s/profile/profile-%d%s", username, size, ext)
+}
+
+// renditionKey derives the key of a thumbnail from the key of the original
+func renditionKey(profilePath string, size int) string {
+	if size == 0 {
+		return profilePath
+	}
+	ext := path.Ext(profilePath)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(profilePath, ext), size, ext)
+}
+
+// pendingProfilePrefix is where unconfirmed direct uploads are kept. It sits at
+// the top of the bucket so one lifecycle rule can expire abandoned uploads.
+func pendingProfilePrefix(username string) string {
+	return "pending/" + username + "/profile/"
+}
+
+// randomID returns a random hex identifier
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
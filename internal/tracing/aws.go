@@ -0,0 +1,59 @@
+package tracing
+
+import (
+	"context"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AddAWSMiddleware adds a middleware tracing each call to an AWS client's
+// stack. It is meant for aws.Config.APIOptions, so that every client built
+// from the config is traced.
+func AddAWSMiddleware(stack *middleware.Stack) error {
+	return stack.Initialize.Add(awsTracing{}, middleware.After)
+}
+
+// awsTracing wraps an API call, including every attempt the retryer makes,
+// in a client span named after the service and operation, e.g. "DynamoDB.GetItem"
+type awsTracing struct{}
+
+func (awsTracing) ID() string {
+	return "ZenonTracing"
+}
+
+func (awsTracing) HandleInitialize(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	service := awsmiddleware.GetServiceID(ctx)
+	operation := awsmiddleware.GetOperationName(ctx)
+
+	ctx, span := Start(ctx, service+"."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String("aws-api"),
+			semconv.RPCService(service),
+			semconv.RPCMethod(operation),
+			semconv.CloudRegion(awsmiddleware.GetRegion(ctx)),
+		),
+	)
+	defer span.End()
+
+	out, metadata, err := next.HandleInitialize(ctx, in)
+
+	if requestID, ok := awsmiddleware.GetRequestIDMetadata(metadata); ok {
+		span.SetAttributes(semconv.AWSRequestID(requestID))
+	}
+	if attempts, ok := retry.GetAttemptResults(metadata); ok {
+		span.SetAttributes(attribute.Int("aws.attempts", len(attempts.Results)))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return out, metadata, err
+}
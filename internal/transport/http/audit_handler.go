@@ -0,0 +1,121 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+)
+
+// Auditor records security-relevant events, such as rejected tokens, in the
+// audit log
+type Auditor interface {
+	Record(ctx context.Context, event domain.AuditEvent)
+}
+
+type AuditService interface {
+	ListEvents(ctx context.Context, viewer string, filter domain.AuditFilter, pageSize int, nextToken string) ([]domain.AuditEvent, string, error)
+	Verify(ctx context.Context, viewer string) (domain.AuditVerification, error)
+}
+
+// AuditHandler lets admins read and verify the audit log
+type AuditHandler struct {
+	Service AuditService
+	Config  *config.Config
+}
+
+type ListAuditEventsResponse struct {
+	Events    []domain.AuditEvent `json:"events"`
+	NextToken string              `json:"next_token,omitempty"`
+}
+
+func NewAuditHandler(s AuditService, cfg *config.Config) *AuditHandler {
+	return &AuditHandler{
+		Service: s,
+		Config:  cfg,
+	}
+}
+
+// ListEvents handles GET requests for the audit log, newest event first
+func (h *AuditHandler) ListEvents(w http.ResponseWriter, r *http.Request) {
+	log.WithContext(r.Context()).Debug("Received GET /api/v1/audit request")
+
+	query := r.URL.Query()
+	pageSize := defaultPageSize
+	if value := query.Get("page_size"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > 1000 {
+			http.Error(w, "Invalid page size", http.StatusBadRequest)
+			return
+		}
+		pageSize = parsed
+	}
+
+	filter := domain.AuditFilter{
+		Actor:   query.Get("actor"),
+		Target:  query.Get("target"),
+		Action:  query.Get("action"),
+		Outcome: query.Get("outcome"),
+	}
+
+	events, nextToken, err := h.Service.ListEvents(r.Context(), subject(r), filter, pageSize, query.Get("next_token"))
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error listing audit events: ", err)
+		http.Error(w, "Failed to list audit events", errorStatus(err))
+		return
+	}
+
+	response := ListAuditEventsResponse{
+		Events:    events,
+		NextToken: nextToken,
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.WithContext(r.Context()).Error("Error encoding response: ", err)
+	}
+}
+
+// VerifyEvents handles GET requests that check the hash chain of the audit log
+func (h *AuditHandler) VerifyEvents(w http.ResponseWriter, r *http.Request) {
+	log.WithContext(r.Context()).Debug("Received GET /api/v1/audit/verify request")
+
+	result, err := h.Service.Verify(r.Context(), subject(r))
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error verifying audit log: ", err)
+		http.Error(w, "Failed to verify audit log", errorStatus(err))
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.WithContext(r.Context()).Error("Error encoding response: ", err)
+	}
+}
+
+func (h *AuditHandler) mapRoutes(router chi.Router) {
+	router.Route("/api/v1/audit", func(r chi.Router) {
+		r.Get("/", JwtAuth(h.ListEvents, h.Config.ECDSAPublicKey))
+		r.Get("/verify", JwtAuth(h.VerifyEvents, h.Config.ECDSAPublicKey))
+	})
+}
+
+func (h *AuditHandler) operations() []Operation {
+	return []Operation{
+		{Method: http.MethodGet, Path: "/api/v1/audit", Summary: "List audit events, newest first (admins only)", Tag: "audit",
+			Query: []Param{
+				{Name: "actor", Description: "Only events by this user"},
+				{Name: "target", Description: "Only events affecting this user or resource"},
+				{Name: "action", Description: "Only events of this action, e.g. login or user.role_change"},
+				{Name: "outcome", Description: "Only events with this outcome: success, failure or denied"},
+				{Name: "page_size", Type: "integer", Minimum: ptr(1), Maximum: ptr(1000), Description: "Events per page"},
+				{Name: "next_token", Description: "Token of the next page from the previous response"},
+			},
+			Response: ListAuditEventsResponse{}, Errors: []int{http.StatusBadRequest, http.StatusForbidden}},
+		{Method: http.MethodGet, Path: "/api/v1/audit/verify", Summary: "Check the hash chain of the audit log (admins only)", Tag: "audit",
+			Response: domain.AuditVerification{}, Errors: []int{http.StatusForbidden}},
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/zzenonn/go-zenon-api-aws/internal/audit"
 )
 
 // Define a custom type for the context key to avoid collisions
@@ -17,41 +18,78 @@ type contextKey string
 
 const (
 	subjectContextKey contextKey = "sub"
+	denialContextKey  contextKey = "denial"
 )
 
-func JwtAuth(original func(w http.ResponseWriter, r *http.Request), publicKey *ecdsa.PublicKey) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header["Authorization"]
-		if authHeader == nil {
-			http.Error(w, "not authorized", http.StatusUnauthorized)
-			return
-		}
+// accessDenial is filled in when a request is refused for lack of
+// authorization, so that AuditMiddleware can record it once it is handled
+type accessDenial struct {
+	actor  string
+	reason string
+}
+
+// denyAccess marks the request as refused for the given reason
+func denyAccess(r *http.Request, reason string) {
+	if denial, ok := r.Context().Value(denialContextKey).(*accessDenial); ok {
+		denial.actor = subject(r)
+		denial.reason = reason
+	}
+}
 
-		authHeaderParts := strings.Split(authHeader[0], " ")
+// Reasons a bearer token is rejected
+var (
+	errMissingToken    = errors.New("missing token")
+	errMalformedHeader = errors.New("malformed authorization header")
+	errInvalidToken    = errors.New("invalid token")
+)
 
-		if len(authHeaderParts) != 2 || strings.ToLower(authHeaderParts[0]) != "bearer" {
-			http.Error(w, "not authorized", http.StatusUnauthorized)
-			return
+// bearerSubject verifies the request's bearer token and returns its subject,
+// which is "" for a valid token without one
+func bearerSubject(r *http.Request, publicKey *ecdsa.PublicKey) (string, error) {
+	authHeader := r.Header["Authorization"]
+	if authHeader == nil {
+		return "", errMissingToken
+	}
+
+	authHeaderParts := strings.Split(authHeader[0], " ")
+
+	if len(authHeaderParts) != 2 || strings.ToLower(authHeaderParts[0]) != "bearer" {
+		return "", errMalformedHeader
+	}
+
+	token, err := jwt.Parse(authHeaderParts[1], func(token *jwt.Token) (any, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodECDSA); !ok {
+			return nil, errors.New("unexpected signing method")
 		}
+		return publicKey, nil
+	})
 
-		token, err := jwt.Parse(authHeaderParts[1], func(token *jwt.Token) (any, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodECDSA); !ok {
-				return nil, errors.New("unexpected signing method")
-			}
-			return publicKey, nil
-		})
+	if err != nil || !token.Valid {
+		return "", errInvalidToken
+	}
 
-		if err != nil || !token.Valid {
+	if claims, ok := token.Claims.(jwt.MapClaims); ok {
+		if sub, ok := claims["sub"].(string); ok {
+			return sub, nil
+		}
+	}
+	return "", nil
+}
+
+func JwtAuth(original func(w http.ResponseWriter, r *http.Request), publicKey *ecdsa.PublicKey) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sub, err := bearerSubject(r, publicKey)
+		if err != nil {
+			denyAccess(r, err.Error())
 			http.Error(w, "not authorized", http.StatusUnauthorized)
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			if sub, ok := claims["sub"].(string); ok {
-				ctx := r.Context()
-				ctx = context.WithValue(ctx, subjectContextKey, sub)
-				r = r.WithContext(ctx)
-			}
+		if sub != "" {
+			ctx := r.Context()
+			ctx = context.WithValue(ctx, subjectContextKey, sub)
+			ctx = audit.WithActor(ctx, sub)
+			r = r.WithContext(ctx)
 		}
 
 		original(w, r)
@@ -75,10 +113,15 @@ func JwtAuth(original func(w http.ResponseWriter, r *http.Request), publicKey *e
 // }
 
 func generateJwtToken(username string, privateKey *ecdsa.PrivateKey) (string, error) {
+	return MintJwtToken(username, time.Hour*24, privateKey)
+}
+
+// MintJwtToken signs an ES384 token for the given subject that expires after ttl
+func MintJwtToken(subject string, ttl time.Duration, privateKey *ecdsa.PrivateKey) (string, error) {
 	// Create a new token object, specifying signing method and the claims
 	token := jwt.NewWithClaims(jwt.SigningMethodES384, jwt.MapClaims{
-		"sub": username,
-		"exp": time.Now().Add(time.Hour * 24).Unix(),
+		"sub": subject,
+		"exp": time.Now().Add(ttl).Unix(),
 		"iat": time.Now().Unix(),
 	})
 
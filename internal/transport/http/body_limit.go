@@ -0,0 +1,49 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+)
+
+// BodyLimits caps the size of request bodies. A limit of 0 accepts bodies
+// of any size.
+type BodyLimits struct {
+	Default int64
+	// Routes holds the limits of single routes, keyed by method and OpenAPI
+	// path, e.g. "PUT /api/v1/users/{username}/profile"
+	Routes map[string]int64
+}
+
+// BodyLimitMiddleware caps the body of every request at the limit of its
+// route, or the default limit. Requests that declare a larger body are
+// rejected with 413 Request Entity Too Large straight away; reads past the
+// limit of other bodies fail, which handlers answer with the same status.
+func (h *MainHandler) BodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := h.BodyLimits.Default
+		if rctx, ok := h.matchRoute(r); ok {
+			if routeLimit, ok := h.BodyLimits.Routes[r.Method+" "+OpenAPIPath(rctx.RoutePattern())]; ok {
+				limit = routeLimit
+			}
+		}
+		if limit <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.ContentLength > limit {
+			http.Error(w, "Request body is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bodyTooLarge reports whether err comes from reading past the limit of a
+// request body
+func bodyTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
@@ -1,23 +1,76 @@
 package http
 
 import (
+	"errors"
 	"net/http"
 
 	log "github.com/sirupsen/logrus"
+	apperrors "github.com/zzenonn/go-zenon-api-aws/internal/errors"
 )
 
 type Response struct {
 	Message string `json:"message"`
 }
 
+// subject returns the username of the authenticated caller, or "" if the
+// request carries no token
+func subject(r *http.Request) string {
+	sub, _ := r.Context().Value(subjectContextKey).(string)
+	return sub
+}
+
 // ValidateUserAccess checks if the JWT token's sub claim matches the username
 func ValidateUserAccess(w http.ResponseWriter, r *http.Request, username string) bool {
 	subVal := r.Context().Value(subjectContextKey)
 	sub, ok := subVal.(string)
 	if !ok || sub != username {
-		log.Error("Token sub does not match username or sub is missing. Sub value: ", sub)
+		log.WithContext(r.Context()).Error("Token sub does not match username or sub is missing. Sub value: ", sub)
+		denyAccess(r, "token subject does not match user "+username)
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return false
 	}
 	return true
 }
+
+// errorStatus maps service errors to HTTP status codes, defaulting to 500
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, apperrors.ErrMissingRequiredFields),
+		errors.Is(err, apperrors.ErrInvalidObjectKey),
+		errors.Is(err, apperrors.ErrInvalidPageToken),
+		errors.Is(err, apperrors.ErrInvalidRenditionSize),
+		errors.Is(err, apperrors.ErrInvalidPart),
+		errors.Is(err, apperrors.ErrInvalidChecksum),
+		errors.Is(err, apperrors.ErrInvalidVisibility):
+		return http.StatusBadRequest
+	case errors.Is(err, apperrors.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, apperrors.ErrUserNotFound),
+		errors.Is(err, apperrors.ErrObjectNotFound),
+		errors.Is(err, apperrors.ErrProfileNotFound),
+		errors.Is(err, apperrors.ErrFileNotFound),
+		errors.Is(err, apperrors.ErrUploadNotFound),
+		errors.Is(err, apperrors.ErrVersionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, apperrors.ErrUserAlreadyExists),
+		errors.Is(err, apperrors.ErrIncompleteUpload):
+		return http.StatusConflict
+	case errors.Is(err, apperrors.ErrFileTooLarge),
+		errors.Is(err, apperrors.ErrQuotaExceeded),
+		bodyTooLarge(err):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, apperrors.ErrUnsupportedMediaType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, apperrors.ErrInvalidUpload),
+		errors.Is(err, apperrors.ErrChecksumMismatch),
+		errors.Is(err, apperrors.ErrImageTooLarge),
+		errors.Is(err, apperrors.ErrMalwareDetected):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, apperrors.ErrNotImplemented):
+		return http.StatusNotImplemented
+	case errors.Is(err, apperrors.ErrScanFailed):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
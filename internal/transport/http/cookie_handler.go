@@ -0,0 +1,62 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+)
+
+// CookieSigner issues signed cookies granting access to every object under a key prefix
+type CookieSigner interface {
+	SignCookies(prefix string) ([]*http.Cookie, error)
+}
+
+// SignedCookieHandler hands out CloudFront signed cookies, which let a client
+// load all of its own objects from the CDN through plain, cacheable URLs
+type SignedCookieHandler struct {
+	Signer CookieSigner
+	Config *config.Config
+}
+
+func NewSignedCookieHandler(signer CookieSigner, cfg *config.Config) *SignedCookieHandler {
+	return &SignedCookieHandler{
+		Signer: signer,
+		Config: cfg,
+	}
+}
+
+// PostStorageCookies handles POST requests for signed cookies covering the user's objects
+func (h *SignedCookieHandler) PostStorageCookies(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	if !ValidateUserAccess(w, r, username) {
+		return
+	}
+
+	cookies, err := h.Signer.SignCookies(username + "/")
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error signing cookies: ", err)
+		http.Error(w, "Failed to sign cookies", http.StatusInternalServerError)
+		return
+	}
+
+	for _, cookie := range cookies {
+		cookie.Domain = h.Config.CloudFrontCookieDomain
+		http.SetCookie(w, cookie)
+	}
+
+	json.NewEncoder(w).Encode(Response{Message: "Signed cookies set"})
+}
+
+func (h *SignedCookieHandler) mapRoutes(router chi.Router) {
+	router.Post("/api/v1/users/{username}/storage-cookies", JwtAuth(h.PostStorageCookies, h.Config.ECDSAPublicKey))
+}
+
+func (h *SignedCookieHandler) operations() []Operation {
+	return []Operation{
+		{Method: http.MethodPost, Path: "/api/v1/users/{username}/storage-cookies", Summary: "Get CloudFront signed cookies for the user's objects", Tag: "storage",
+			Response: Response{}},
+	}
+}
@@ -0,0 +1,233 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+)
+
+// Page size used when a list request does not ask for one
+const defaultPageSize = 50
+
+type FileService interface {
+	UploadFile(ctx context.Context, username string, name string, contentType string, r io.Reader) (domain.File, error)
+	GetFile(ctx context.Context, username string, id string) (domain.File, string, error)
+	ListFiles(ctx context.Context, username string, pageSize int, nextToken string) ([]domain.File, string, error)
+	GetUsage(ctx context.Context, username string) (int64, int64, error)
+	DeleteFile(ctx context.Context, username string, id string) error
+
+	CreateUpload(ctx context.Context, username string, name string, contentType string, size int64, checksum string) (domain.MultipartUpload, error)
+	GetUpload(ctx context.Context, username string, id string) (domain.MultipartUpload, error)
+	UploadPart(ctx context.Context, username string, id string, number int32, r io.Reader) (domain.UploadPart, error)
+	PresignUploadPart(ctx context.Context, username string, id string, number int32) (domain.PresignedUpload, error)
+	CompleteUpload(ctx context.Context, username string, id string, checksum string) (domain.File, error)
+	AbortUpload(ctx context.Context, username string, id string) error
+}
+
+type FileHandler struct {
+	Service FileService
+	Config  *config.Config
+}
+
+type FileResponse struct {
+	domain.File
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+type ListFilesResponse struct {
+	Files      []domain.File `json:"files"`
+	NextToken  string        `json:"next_token,omitempty"`
+	UsedBytes  int64         `json:"used_bytes"`
+	QuotaBytes int64         `json:"quota_bytes"`
+}
+
+func NewFileHandler(s FileService, cfg *config.Config) *FileHandler {
+	return &FileHandler{
+		Service: s,
+		Config:  cfg,
+	}
+}
+
+// PostFile handles multipart POST requests that upload a new file
+func (h *FileHandler) PostFile(w http.ResponseWriter, r *http.Request) {
+	log.WithContext(r.Context()).Debug("Received POST /api/v1/users/{username}/files request")
+
+	username := chi.URLParam(r, "username")
+	if !ValidateUserAccess(w, r, username) {
+		return
+	}
+
+	// Cap the request body so oversized files are rejected while reading
+	r.Body = http.MaxBytesReader(w, r.Body, h.Config.MaxFileSize+(1<<20))
+
+	file, header, err := r.FormFile("file")
+	if bodyTooLarge(err) {
+		http.Error(w, "File is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		log.WithContext(r.Context()).Error("Failed to read file: ", err)
+		http.Error(w, "File upload is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	created, err := h.Service.UploadFile(r.Context(), username, header.Filename, header.Header.Get("Content-Type"), file)
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error uploading file: ", err)
+		http.Error(w, "Failed to upload file", errorStatus(err))
+		return
+	}
+
+	log.WithContext(r.Context()).Debug(fmt.Sprintf("File %s uploaded for user: %s", created.ID, username))
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(created); err != nil {
+		log.WithContext(r.Context()).Error("Error encoding response: ", err)
+	}
+}
+
+// ListFiles handles GET requests for a page of the user's files
+func (h *FileHandler) ListFiles(w http.ResponseWriter, r *http.Request) {
+	log.WithContext(r.Context()).Debug("Received GET /api/v1/users/{username}/files request")
+
+	username := chi.URLParam(r, "username")
+	if !ValidateUserAccess(w, r, username) {
+		return
+	}
+
+	pageSize := defaultPageSize
+	if value := r.URL.Query().Get("page_size"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > 1000 {
+			http.Error(w, "Invalid page size", http.StatusBadRequest)
+			return
+		}
+		pageSize = parsed
+	}
+
+	files, nextToken, err := h.Service.ListFiles(r.Context(), username, pageSize, r.URL.Query().Get("next_token"))
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error listing files: ", err)
+		http.Error(w, "Failed to list files", errorStatus(err))
+		return
+	}
+
+	used, quota, err := h.Service.GetUsage(r.Context(), username)
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error getting storage usage: ", err)
+		http.Error(w, "Failed to list files", errorStatus(err))
+		return
+	}
+
+	response := ListFilesResponse{
+		Files:      files,
+		NextToken:  nextToken,
+		UsedBytes:  used,
+		QuotaBytes: quota,
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.WithContext(r.Context()).Error("Error encoding response: ", err)
+	}
+}
+
+// GetFile handles GET requests for a file's metadata and download URL
+func (h *FileHandler) GetFile(w http.ResponseWriter, r *http.Request) {
+	log.WithContext(r.Context()).Debug("Received GET /api/v1/users/{username}/files/{id} request")
+
+	username := chi.URLParam(r, "username")
+	if !ValidateUserAccess(w, r, username) {
+		return
+	}
+
+	file, url, err := h.Service.GetFile(r.Context(), username, chi.URLParam(r, "id"))
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error getting file: ", err)
+		http.Error(w, "Failed to get file", errorStatus(err))
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(FileResponse{File: file, DownloadURL: url}); err != nil {
+		log.WithContext(r.Context()).Error("Error encoding response: ", err)
+	}
+}
+
+// DeleteFile handles DELETE requests that remove a file
+func (h *FileHandler) DeleteFile(w http.ResponseWriter, r *http.Request) {
+	log.WithContext(r.Context()).Debug("Received DELETE /api/v1/users/{username}/files/{id} request")
+
+	username := chi.URLParam(r, "username")
+	if !ValidateUserAccess(w, r, username) {
+		return
+	}
+
+	if err := h.Service.DeleteFile(r.Context(), username, chi.URLParam(r, "id")); err != nil {
+		log.WithContext(r.Context()).Error("Error deleting file: ", err)
+		http.Error(w, "Failed to delete file", errorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(Response{Message: "File deleted successfully"})
+}
+
+func (h *FileHandler) mapRoutes(router chi.Router) {
+	router.Route("/api/v1/users/{username}/files", func(r chi.Router) {
+		r.Post("/", JwtAuth(h.PostFile, h.Config.ECDSAPublicKey))
+		r.Get("/", JwtAuth(h.ListFiles, h.Config.ECDSAPublicKey))
+		r.Get("/{id}", JwtAuth(h.GetFile, h.Config.ECDSAPublicKey))
+		r.Delete("/{id}", JwtAuth(h.DeleteFile, h.Config.ECDSAPublicKey))
+
+		// Multipart upload routes
+		r.Route("/uploads", func(r chi.Router) {
+			r.Post("/", JwtAuth(h.PostUpload, h.Config.ECDSAPublicKey))
+			r.Get("/{id}", JwtAuth(h.GetUpload, h.Config.ECDSAPublicKey))
+			r.Delete("/{id}", JwtAuth(h.AbortUpload, h.Config.ECDSAPublicKey))
+			r.Put("/{id}/parts/{number}", JwtAuth(h.PutUploadPart, h.Config.ECDSAPublicKey))
+			r.Get("/{id}/parts/{number}/url", JwtAuth(h.GetUploadPartURL, h.Config.ECDSAPublicKey))
+			r.Post("/{id}/complete", JwtAuth(h.CompleteUpload, h.Config.ECDSAPublicKey))
+		})
+	})
+}
+
+func (h *FileHandler) operations() []Operation {
+	partNumberParam := Param{Name: "number", Type: "integer", Minimum: ptr(1), Maximum: ptr(10000)}
+
+	return []Operation{
+		{Method: http.MethodPost, Path: "/api/v1/users/{username}/files", Summary: "Upload a file", Tag: "files",
+			Upload: true, Status: http.StatusCreated, Response: domain.File{},
+			Errors: []int{http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity}},
+		{Method: http.MethodGet, Path: "/api/v1/users/{username}/files", Summary: "List files", Tag: "files",
+			Query: []Param{
+				{Name: "page_size", Type: "integer", Minimum: ptr(1), Maximum: ptr(1000), Description: "Files per page"},
+				{Name: "next_token", Description: "Token of the next page from the previous response"},
+			},
+			Response: ListFilesResponse{}, Errors: []int{http.StatusBadRequest}},
+		{Method: http.MethodGet, Path: "/api/v1/users/{username}/files/{id}", Summary: "Get a file and its download URL", Tag: "files",
+			Response: FileResponse{}, Errors: []int{http.StatusNotFound}},
+		{Method: http.MethodDelete, Path: "/api/v1/users/{username}/files/{id}", Summary: "Delete a file", Tag: "files",
+			Response: Response{}, Errors: []int{http.StatusNotFound}},
+
+		{Method: http.MethodPost, Path: "/api/v1/users/{username}/files/uploads", Summary: "Start a multipart upload", Tag: "uploads",
+			Body: CreateUploadRequest{}, Status: http.StatusCreated, Response: domain.MultipartUpload{},
+			Errors: []int{http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusNotImplemented}},
+		{Method: http.MethodGet, Path: "/api/v1/users/{username}/files/uploads/{id}", Summary: "Get an upload and its stored parts", Tag: "uploads",
+			Response: domain.MultipartUpload{}, Errors: []int{http.StatusNotFound}},
+		{Method: http.MethodDelete, Path: "/api/v1/users/{username}/files/uploads/{id}", Summary: "Abort an upload", Tag: "uploads",
+			Response: Response{}, Errors: []int{http.StatusNotFound}},
+		{Method: http.MethodPut, Path: "/api/v1/users/{username}/files/uploads/{id}/parts/{number}", Summary: "Upload a part", Tag: "uploads",
+			PathParams: []Param{partNumberParam}, RawBody: true, Response: domain.UploadPart{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+		{Method: http.MethodGet, Path: "/api/v1/users/{username}/files/uploads/{id}/parts/{number}/url", Summary: "Get a URL to upload a part directly", Tag: "uploads",
+			PathParams: []Param{partNumberParam}, Response: domain.PresignedUpload{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotImplemented}},
+		{Method: http.MethodPost, Path: "/api/v1/users/{username}/files/uploads/{id}/complete", Summary: "Complete an upload", Tag: "uploads",
+			Body: CompleteUploadRequest{}, BodyOptional: true, Status: http.StatusCreated, Response: domain.File{},
+			Errors: []int{http.StatusAccepted, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity}},
+	}
+}
@@ -2,33 +2,80 @@ package http
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
-	logger "github.com/chi-middleware/logrus-logger"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	"github.com/zzenonn/go-zenon-api-aws/internal/metrics"
 )
 
+// Handler maps routes onto the router and documents each of them for the
+// OpenAPI document
 type Handler interface {
 	mapRoutes(router chi.Router)
+	operations() []Operation
+}
+
+// drainer is implemented by handlers that react to the server shutting down
+type drainer interface {
+	Drain()
 }
 
 type MainHandler struct {
 	Router   chi.Router
 	Handlers []Handler
 	Server   *http.Server
+	// Auditor, if set, records requests refused for lack of authorization
+	Auditor Auditor
+	// RateLimits, if set, limits how many requests each client makes
+	RateLimits *RateLimits
+	// Idempotency, if set, replays the responses to repeated requests that
+	// carry an Idempotency-Key
+	Idempotency *Idempotency
+	// RequestTimeout is the deadline of each request's context; 0 is none
+	RequestTimeout time.Duration
+	// BodyLimits caps the size of request bodies
+	BodyLimits BodyLimits
+	// PublicKey verifies bearer tokens, so that requests to protected
+	// routes are only validated once their caller is authenticated
+	PublicKey *ecdsa.PublicKey
+	// TrustedProxies is how many proxies in front of the API append to
+	// X-Forwarded-For
+	TrustedProxies int
+	// ShutdownDrain is how long readiness fails before the server stops
+	// accepting connections, and ShutdownTimeout how long requests in
+	// flight are then given to finish
+	ShutdownDrain   time.Duration
+	ShutdownTimeout time.Duration
+
+	validator     *requestValidator
+	validatorOnce sync.Once
 }
 
 func NewMainHandler(cfg *config.Config) *MainHandler {
 	h := &MainHandler{
-		Handlers: []Handler{},
+		Handlers:       []Handler{},
+		RequestTimeout: cfg.RequestTimeout,
+		BodyLimits: BodyLimits{
+			Default: cfg.MaxRequestBody,
+			Routes:  cfg.RouteBodyLimits,
+		},
+		ShutdownDrain:   cfg.ShutdownDrain,
+		ShutdownTimeout: cfg.ShutdownTimeout,
+		PublicKey:       cfg.ECDSAPublicKey,
+		TrustedProxies:  int(cfg.TrustedProxies),
 	}
 
 	h.Router = chi.NewRouter()
@@ -44,18 +91,31 @@ func NewMainHandler(cfg *config.Config) *MainHandler {
 			http.MethodDelete,
 		},
 		AllowedHeaders:   []string{"*"},
+		ExposedHeaders:   []string{RequestIDHeader},
 		AllowCredentials: false,
 	}))
 
-	h.Router.Use(logger.Logger("router", log.New()))
+	h.Router.Use(RequestIDMiddleware)
+	h.Router.Use(h.TracingMiddleware)
+	h.Router.Use(h.MetricsMiddleware)
+	h.Router.Use(LoggingMiddleware)
+	h.Router.Use(h.AuditMiddleware)
+	h.Router.Use(h.RateLimitMiddleware)
+	h.Router.Use(h.BodyLimitMiddleware)
+	h.Router.Use(h.IdempotencyMiddleware)
 	h.Router.Use(JSONMiddleware)
-	h.Router.Use(TimeoutMiddleware)
+	h.Router.Use(h.TimeoutMiddleware)
+	h.Router.Use(h.ValidationMiddleware)
 
 	// h.mapRoutes()
 
 	h.Server = &http.Server{
-		Addr:    fmt.Sprintf("0.0.0.0:%d", cfg.Port),
-		Handler: h.Router,
+		Addr:              fmt.Sprintf("0.0.0.0:%d", cfg.Port),
+		Handler:           h.Router,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
 	}
 
 	return h
@@ -71,6 +131,9 @@ func (h *MainHandler) MapRoutes() {
 			"message": "Hello world",
 		})
 	})
+	h.Router.Get("/openapi.json", h.GetOpenAPI)
+	h.Router.Get("/docs", h.GetDocs)
+	h.Router.Method(http.MethodGet, "/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
 
 	for _, handler := range h.Handlers {
 		handler.mapRoutes(h.Router)
@@ -81,26 +144,80 @@ func (h *MainHandler) MapRoutes() {
 	}
 }
 
-func (h *MainHandler) Serve() error {
+// matchRoute finds the route a request will be sent to. Middleware runs
+// before the router has routed the request, so its routing context is not
+// set yet.
+func (h *MainHandler) matchRoute(r *http.Request) (*chi.Context, bool) {
+	path := r.URL.RawPath
+	if path == "" {
+		path = r.URL.Path
+	}
 
-	go func() {
-		if err := h.Server.ListenAndServe(); err != nil {
-			log.Error(err)
-		}
-	}()
+	rctx := chi.NewRouteContext()
+	if !h.Router.Match(rctx, r.Method, path) {
+		return nil, false
+	}
+	return rctx, true
+}
 
-	c := make(chan os.Signal, 1)
+// Serve listens on the configured address and serves requests until the
+// process receives SIGINT or SIGTERM, then shuts down gracefully
+func (h *MainHandler) Serve() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	signal.Notify(c, os.Interrupt)
-	<-c
+	listener, err := net.Listen("tcp", h.Server.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", h.Server.Addr, err)
+	}
+	log.Infof("listening on %s", listener.Addr())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	return h.ServeListener(ctx, listener)
+}
 
-	defer cancel()
+// ServeListener serves requests on listener until ctx is done. Readiness
+// then fails for ShutdownDrain, so that load balancers stop sending
+// requests, before the server stops accepting connections and waits up to
+// ShutdownTimeout for requests in flight. It returns an error if the server
+// stops for any other reason, e.g. because the listener failed.
+func (h *MainHandler) ServeListener(ctx context.Context, listener net.Listener) error {
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- h.Server.Serve(listener)
+	}()
 
-	h.Server.Shutdown(ctx)
+	select {
+	case err := <-stopped:
+		return fmt.Errorf("server stopped: %w", err)
+	case <-ctx.Done():
+	}
 
 	log.Warn("shutting down gracefully")
+	for _, handler := range h.Handlers {
+		if d, ok := handler.(drainer); ok {
+			d.Drain()
+		}
+	}
+
+	if h.ShutdownDrain > 0 {
+		log.Infof("draining for %s", h.ShutdownDrain)
+		select {
+		case err := <-stopped:
+			return fmt.Errorf("server stopped while draining: %w", err)
+		case <-time.After(h.ShutdownDrain):
+		}
+	}
+
+	shutdownCtx := context.Background()
+	if h.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, h.ShutdownTimeout)
+		defer cancel()
+	}
+	if err := h.Server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down gracefully: %w", err)
+	}
 
+	log.Warn("server stopped")
 	return nil
 }
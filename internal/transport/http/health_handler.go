@@ -0,0 +1,173 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// Statuses reported by the health endpoints
+const (
+	HealthOK       = "ok"
+	HealthFailing  = "failing"
+	HealthDraining = "draining"
+)
+
+// HealthCheck checks one dependency the API needs to serve requests
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// CheckResult is the outcome of one readiness check. Why a check failed is
+// only logged, since the probe is public and errors name internal resources.
+type CheckResult struct {
+	Status     string `json:"status"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// HealthResponse is the body of the liveness and readiness endpoints
+type HealthResponse struct {
+	Status    string                 `json:"status"`
+	Checks    map[string]CheckResult `json:"checks,omitempty"`
+	CheckedAt *time.Time             `json:"checked_at,omitempty"`
+}
+
+// HealthHandler serves the liveness and readiness probes. Liveness only shows
+// that the process serves requests; readiness runs every check, each with its
+// own timeout, and reuses the results for a short while. Once the server
+// starts shutting down, readiness fails so that no new traffic is routed to it.
+type HealthHandler struct {
+	Checks   []HealthCheck
+	Timeout  time.Duration
+	CacheTTL time.Duration
+
+	draining atomic.Bool
+
+	// mu is held while checks run, so concurrent probes share one run
+	mu      sync.Mutex
+	results map[string]CheckResult
+	checked time.Time
+}
+
+func NewHealthHandler(checks []HealthCheck, timeout time.Duration, cacheTTL time.Duration) *HealthHandler {
+	return &HealthHandler{
+		Checks:   checks,
+		Timeout:  timeout,
+		CacheTTL: cacheTTL,
+	}
+}
+
+// Drain makes readiness fail from now on
+func (h *HealthHandler) Drain() {
+	h.draining.Store(true)
+}
+
+// GetHealthz handles GET requests for the liveness probe
+func (h *HealthHandler) GetHealthz(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(HealthResponse{Status: HealthOK})
+}
+
+// GetReadyz handles GET requests for the readiness probe
+func (h *HealthHandler) GetReadyz(w http.ResponseWriter, r *http.Request) {
+	if h.draining.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(HealthResponse{Status: HealthDraining})
+		return
+	}
+
+	results, checked := h.check(r.Context())
+
+	response := HealthResponse{Status: HealthOK, Checks: results, CheckedAt: &checked}
+	for _, result := range results {
+		if result.Status != HealthOK {
+			response.Status = HealthFailing
+		}
+	}
+
+	if response.Status != HealthOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// check returns the cached results, running every check again once they are
+// older than CacheTTL
+func (h *HealthHandler) check(ctx context.Context) (map[string]CheckResult, time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.results != nil && time.Since(h.checked) < h.CacheTTL {
+		return h.results, h.checked
+	}
+
+	// A probe that gives up must not fail the checks it shares with others
+	ctx = context.WithoutCancel(ctx)
+
+	results := make(map[string]CheckResult, len(h.Checks))
+	var resultsMu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range h.Checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := h.run(ctx, check)
+
+			resultsMu.Lock()
+			results[check.Name] = result
+			resultsMu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	h.results = results
+	h.checked = time.Now().UTC()
+	return h.results, h.checked
+}
+
+// run runs one check with its own timeout
+func (h *HealthHandler) run(ctx context.Context, check HealthCheck) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, h.Timeout)
+	defer cancel()
+
+	// Checks that ignore the context are abandoned when it expires
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := CheckResult{Status: HealthOK, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		log.WithContext(ctx).Warnf("Readiness check %s failed: %v", check.Name, err)
+		result.Status = HealthFailing
+	}
+	return result
+}
+
+func (h *HealthHandler) mapRoutes(router chi.Router) {
+	router.Get("/healthz", h.GetHealthz)
+	router.Get("/readyz", h.GetReadyz)
+}
+
+func (h *HealthHandler) operations() []Operation {
+	return []Operation{
+		{Method: http.MethodGet, Path: "/healthz", Summary: "Check that the process is alive", Tag: "meta", Public: true,
+			Response: HealthResponse{}},
+		{Method: http.MethodGet, Path: "/readyz", Summary: "Check that the API and its dependencies are ready to serve requests", Tag: "meta", Public: true,
+			Response: HealthResponse{}, Errors: []int{http.StatusServiceUnavailable}},
+	}
+}
@@ -0,0 +1,372 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	"github.com/zzenonn/go-zenon-api-aws/internal/idempotency"
+	"github.com/zzenonn/go-zenon-api-aws/internal/metrics"
+)
+
+const (
+	// IdempotencyKeyHeader carries the key a client chose for a request it
+	// may retry
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks responses replayed for a repeated request
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+const (
+	maxIdempotencyKeyLength = 255
+	// maxReplayedBody is the largest response body kept for replay; DynamoDB
+	// items are limited to 400 KB
+	maxReplayedBody = 256 << 10
+	// idempotencyBodyMemory is how much of a request body is held in memory
+	// while it is fingerprinted; larger bodies go to a temporary file
+	idempotencyBodyMemory = 1 << 20
+)
+
+// Idempotency decides which client a key belongs to and keeps the responses
+// to requests that carry one
+type Idempotency struct {
+	Keeper *idempotency.Keeper
+	// PublicKey verifies the tokens of clients, whose keys are kept apart
+	PublicKey *ecdsa.PublicKey
+	// TrustedProxies is how many proxies in front of the API append to
+	// X-Forwarded-For, which tells anonymous clients apart
+	TrustedProxies int
+}
+
+func NewIdempotency(keeper *idempotency.Keeper, cfg *config.Config) *Idempotency {
+	return &Idempotency{
+		Keeper:         keeper,
+		PublicKey:      cfg.ECDSAPublicKey,
+		TrustedProxies: int(cfg.TrustedProxies),
+	}
+}
+
+// IdempotencyMiddleware handles POST, PUT, PATCH and DELETE requests with an
+// Idempotency-Key header once per client and key. Repeats of a request are
+// answered with the status, headers and body of the first response, marked
+// with Idempotent-Replayed. A key reused for a different request is rejected
+// with 422 Unprocessable Entity, and a repeat that arrives while the first
+// request is still being handled with 409 Conflict. Server errors and
+// requests that were refused before being handled are not kept, so they can
+// be retried with the same key. Requests are handled normally if the store
+// cannot be reached.
+func (h *MainHandler) IdempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if h.Idempotency == nil || key == "" || !mutating(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := h.matchRoute(r); !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !validIdempotencyKey(key) {
+			http.Error(w, fmt.Sprintf("Invalid %s header: must be 1 to %d printable characters", IdempotencyKeyHeader, maxIdempotencyKeyLength), http.StatusBadRequest)
+			return
+		}
+
+		body, err := spoolBody(r.Body)
+		if bodyTooLarge(err) {
+			http.Error(w, "Request body is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			log.WithContext(r.Context()).Error("Failed to read request body: ", err)
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		defer body.Close()
+		r.Body = body
+
+		fingerprint, err := requestFingerprint(r, body)
+		if err != nil {
+			log.WithContext(r.Context()).Error("Failed to fingerprint request: ", err)
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+
+		keeper := h.Idempotency.Keeper
+		storageKey := h.Idempotency.scope(r) + "#" + key
+		token, replay, err := keeper.Begin(r.Context(), storageKey, fingerprint)
+		switch {
+		case errors.Is(err, idempotency.ErrMismatch):
+			metrics.IdempotentRequests.WithLabelValues("mismatch").Inc()
+			http.Error(w, fmt.Sprintf("%s was already used for a different request", IdempotencyKeyHeader), http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, idempotency.ErrInProgress):
+			metrics.IdempotentRequests.WithLabelValues("in_progress").Inc()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, fmt.Sprintf("A request with this %s is in progress", IdempotencyKeyHeader), http.StatusConflict)
+			return
+		case err != nil:
+			log.WithContext(r.Context()).Warn("Idempotency skipped: ", err)
+			next.ServeHTTP(w, r)
+			return
+		case replay != nil:
+			metrics.IdempotentRequests.WithLabelValues("replayed").Inc()
+			for name, values := range replay.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set(IdempotentReplayedHeader, "true")
+			w.WriteHeader(replay.Status)
+			w.Write(replay.Body)
+			return
+		}
+
+		// The key is settled even if the client has gone or the request
+		// timed out, so that retries are not refused until the lock expires
+		ctx := context.WithoutCancel(r.Context())
+		recorder := &idempotencyRecorder{ResponseWriter: w, before: w.Header().Clone()}
+		kept := false
+		defer func() {
+			if kept {
+				return
+			}
+			if err := keeper.Abandon(ctx, storageKey, token); err != nil {
+				log.WithContext(ctx).Warn(err)
+			}
+		}()
+
+		next.ServeHTTP(recorder, r)
+
+		response := recorder.response()
+		if !keepResponse(response.Status) || recorder.overflow {
+			return
+		}
+		if err := keeper.Finish(ctx, storageKey, token, fingerprint, response); err != nil {
+			log.WithContext(ctx).Warn(err)
+			return
+		}
+		kept = true
+	})
+}
+
+// scope returns the client a key belongs to: the subject of a valid bearer
+// token, or the client IP for anonymous requests, as the rate limiter counts
+// them, so that anonymous clients cannot replay each other's responses
+func (i *Idempotency) scope(r *http.Request) string {
+	if sub, err := bearerSubject(r, i.PublicKey); err == nil && sub != "" {
+		return "sub:" + sub
+	}
+	return "ip:" + clientIP(r, i.TrustedProxies)
+}
+
+func mutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for _, c := range key {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// keepResponse reports whether a response is replayed for repeats. Server
+// errors may be transient, malformed, unauthenticated and forbidden requests
+// have had no effect, and 202 Accepted asks the client to repeat the request
+// later, so retries of those are handled again.
+func keepResponse(status int) bool {
+	switch status {
+	case http.StatusAccepted, http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return false
+	}
+	return status < http.StatusInternalServerError
+}
+
+// requestFingerprint hashes what makes two requests the same: the method,
+// path, query, media type and body. Multipart bodies are hashed part by
+// part, since clients pick a new boundary each time they send one.
+func requestFingerprint(r *http.Request, body io.ReadSeeker) (string, error) {
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var digest []byte
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+		digest, _ = multipartDigest(body, params["boundary"])
+	}
+	if digest == nil {
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		hash := sha256.New()
+		if _, err := io.Copy(hash, body); err != nil {
+			return "", err
+		}
+		digest = hash.Sum(nil)
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n%s\n%x", r.Method, r.URL.RequestURI(), mediaType, digest)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// multipartDigest hashes the headers and content of each part
+func multipartDigest(body io.ReadSeeker, boundary string) ([]byte, error) {
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	reader := multipart.NewReader(body, boundary)
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			return hash.Sum(nil), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		names := make([]string, 0, len(part.Header))
+		for name := range part.Header {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(hash, "%s: %s\n", name, strings.Join(part.Header[name], ", "))
+		}
+
+		partHash := sha256.New()
+		if _, err := io.Copy(partHash, part); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(hash, "%x\n", partHash.Sum(nil))
+	}
+}
+
+// spooledBody is a request body that can be read again. Small bodies are
+// held in memory and larger ones in a temporary file.
+type spooledBody struct {
+	io.ReadSeeker
+	file *os.File
+}
+
+func spoolBody(body io.Reader) (*spooledBody, error) {
+	data, err := io.ReadAll(io.LimitReader(body, idempotencyBodyMemory+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= idempotencyBodyMemory {
+		return &spooledBody{ReadSeeker: bytes.NewReader(data)}, nil
+	}
+
+	file, err := os.CreateTemp("", "idempotent-body-*")
+	if err != nil {
+		return nil, err
+	}
+	spooled := &spooledBody{ReadSeeker: file, file: file}
+	if _, err := io.Copy(file, io.MultiReader(bytes.NewReader(data), body)); err != nil {
+		spooled.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		spooled.Close()
+		return nil, err
+	}
+	return spooled, nil
+}
+
+// Close removes the temporary file, if there is one
+func (b *spooledBody) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	b.file.Close()
+	return os.Remove(b.file.Name())
+}
+
+// idempotencyRecorder keeps a copy of the response as it is written. Only
+// headers set after the middleware, rather than by the ones before it such
+// as the request ID, are kept.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	before   http.Header
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.header = addedHeaders(rec.before, rec.ResponseWriter.Header())
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.overflow {
+		if rec.body.Len()+len(b) > maxReplayedBody {
+			rec.overflow = true
+			rec.body.Reset()
+		} else {
+			rec.body.Write(b)
+		}
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rec *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func (rec *idempotencyRecorder) response() idempotency.Response {
+	if rec.status == 0 {
+		// Nothing was written, which the server answers with 200 OK
+		rec.status = http.StatusOK
+		rec.header = addedHeaders(rec.before, rec.ResponseWriter.Header())
+	}
+	return idempotency.Response{
+		Status: rec.status,
+		Header: rec.header,
+		Body:   bytes.Clone(rec.body.Bytes()),
+	}
+}
+
+// addedHeaders returns the headers in after that are not in before
+func addedHeaders(before http.Header, after http.Header) http.Header {
+	added := make(http.Header)
+	for name, values := range after {
+		if !slices.Equal(before[name], values) {
+			added[name] = slices.Clone(values)
+		}
+	}
+	return added
+}
@@ -2,10 +2,28 @@ package http
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5/middleware"
 	log "github.com/sirupsen/logrus"
+	"github.com/zzenonn/go-zenon-api-aws/internal/audit"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/logging"
+	"github.com/zzenonn/go-zenon-api-aws/internal/metrics"
+	"github.com/zzenonn/go-zenon-api-aws/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func JSONMiddleware(next http.Handler) http.Handler {
@@ -23,23 +41,181 @@ func CorsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// RequestIDHeader carries the ID of a request, which is taken from the
+// client or proxy when valid and generated otherwise
+const RequestIDHeader = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:/-]{1,128}$`)
+
+// RequestIDMiddleware gives every request an ID, which is echoed in the
+// response and added to every line logged with the request's context
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// LoggingMiddleware logs every request once it has been handled. The query
+// string is left out since it may carry URL signatures.
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.WithFields(
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		log.WithContext(r.Context()).WithFields(
 			log.Fields{
-				"method": r.Method,
-				"path":   r.URL.Path,
+				"method":      r.Method,
+				"path":        r.URL.Path,
+				"status":      ww.Status(),
+				"bytes":       ww.BytesWritten(),
+				"duration_ms": time.Since(start).Milliseconds(),
+				"remote_ip":   remoteIP(r),
+				"user_agent":  r.UserAgent(),
 			}).Info("handled request")
-		next.ServeHTTP(w, r)
 	})
+}
+
+// AuditMiddleware adds the client of every request to its context, so that
+// the events it causes name it, and records requests refused by JwtAuth or
+// ValidateUserAccess as access.denied events. Behind TrustedProxies proxies
+// the client is taken from X-Forwarded-For.
+func (h *MainHandler) AuditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := audit.WithClient(r.Context(), audit.Client{
+			IP:        clientIP(r, h.TrustedProxies),
+			UserAgent: r.UserAgent(),
+		})
+		denial := &accessDenial{}
+		ctx = context.WithValue(ctx, denialContextKey, denial)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+
+		if denial.reason == "" || h.Auditor == nil {
+			return
+		}
+		h.Auditor.Record(ctx, domain.AuditEvent{
+			Actor:   denial.actor,
+			Action:  domain.AuditAccessDenied,
+			Target:  r.URL.Path,
+			Outcome: domain.AuditDenied,
+			Detail:  r.Method,
+			Reason:  denial.reason,
+		})
+	})
+}
 
+// remoteIP returns the address of the client without its port
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
 }
 
-func TimeoutMiddleware(next http.Handler) http.Handler {
+// clientIP returns the address of the client behind trustedProxies proxies.
+// Every proxy appends the address it got the request from to
+// X-Forwarded-For, so the client is the entry added by the outermost trusted
+// proxy; entries before it can be forged.
+func clientIP(r *http.Request, trustedProxies int) string {
+	if trustedProxies > 0 {
+		var hops []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(header, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+		if len(hops) >= trustedProxies {
+			return hops[len(hops)-trustedProxies]
+		}
+	}
+	return remoteIP(r)
+}
+
+// TimeoutMiddleware gives the context of every request a deadline of
+// RequestTimeout, after which the AWS calls made for it are cancelled
+func (h *MainHandler) TimeoutMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+		if h.RequestTimeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), h.RequestTimeout)
 		defer cancel()
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 
 }
+
+// MetricsMiddleware records the count, latency and number in flight of
+// requests, labelled by route pattern. Requests that match no route share
+// one label so that unknown paths cannot create new series.
+func (h *MainHandler) MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unmatched"
+		if rctx, ok := h.matchRoute(r); ok {
+			route = rctx.RoutePattern()
+		}
+
+		inFlight := metrics.HTTPRequestsInFlight.WithLabelValues(r.Method, route)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		labels := []string{r.Method, route, strconv.Itoa(status)}
+		metrics.HTTPRequests.WithLabelValues(labels...).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	})
+}
+
+// TracingMiddleware starts a server span for each request, continuing the
+// trace of a W3C traceparent header if the client sent one. Spans are named
+// after the method and route pattern, and marked as failed on 5xx responses.
+func (h *MainHandler) TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		name := r.Method
+		attributes := []attribute.KeyValue{
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+			semconv.UserAgentOriginal(r.UserAgent()),
+		}
+		if rctx, ok := h.matchRoute(r); ok {
+			name += " " + rctx.RoutePattern()
+			attributes = append(attributes, semconv.HTTPRoute(rctx.RoutePattern()))
+		}
+
+		ctx, span := tracing.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attributes...))
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
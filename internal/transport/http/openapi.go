@@ -0,0 +1,493 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+
+	_ "embed"
+)
+
+// OpenAPIVersion is the version of the OpenAPI specification the document follows
+const OpenAPIVersion = "3.1.0"
+
+// Content types of request and response bodies that are not JSON
+const (
+	contentTypeJSON      = "application/json"
+	contentTypeMultipart = "multipart/form-data"
+	contentTypeBinary    = "application/octet-stream"
+	contentTypeHTML      = "text/html"
+	contentTypeText      = "text/plain"
+)
+
+//go:embed docs.html
+var docsPage []byte
+
+// Operation documents one route. Every route a handler maps must have an
+// operation with the same method and path; the OpenAPI document is built
+// from the registered routes and these operations.
+type Operation struct {
+	Method string
+	// Path is the route in OpenAPI form, e.g. "/api/v1/users/{username}".
+	// Path parameters are taken from it.
+	Path    string
+	Summary string
+	Tag     string
+	// Public operations do not take a bearer token
+	Public bool
+	Query  []Param
+	// PathParams types path parameters; any not listed are strings
+	PathParams []Param
+
+	// Body is the JSON request body, Upload a multipart form with a "file"
+	// field and RawBody a body of raw bytes
+	Body         any
+	BodyOptional bool
+	Upload       bool
+	RawBody      bool
+
+	// Status is the success status, 200 if not set. Response is its JSON
+	// body; ContentType describes any other body.
+	Status      int
+	Response    any
+	ContentType string
+	Errors      []int
+}
+
+// Param documents a query or path parameter
+type Param struct {
+	Name        string
+	Description string
+	// Type is the JSON schema type, "string" if not set
+	Type     string
+	Required bool
+	Minimum  *float64
+	Maximum  *float64
+}
+
+// OpenAPIDocument is the root of an OpenAPI document
+type OpenAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+	Security   []map[string][]string                   `json:"security"`
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type OpenAPIComponents struct {
+	Schemas         map[string]*Schema               `json:"schemas"`
+	SecuritySchemes map[string]OpenAPISecurityScheme `json:"securitySchemes"`
+}
+
+type OpenAPISecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+type OpenAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Parameters  []OpenAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+	// Security is empty for public operations, overriding the document's bearer auth
+	Security *[]map[string][]string `json:"security,omitempty"`
+}
+
+type OpenAPIParameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Headers     map[string]OpenAPIHeader    `json:"headers,omitempty"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPIHeader struct {
+	Schema *Schema `json:"schema"`
+}
+
+type OpenAPIMediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of JSON Schema used to describe requests and responses
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+}
+
+// OpenAPIPath converts a chi route pattern to an OpenAPI path. Trailing
+// slashes of subrouter roots are dropped and a catch-all becomes {path}.
+func OpenAPIPath(route string) string {
+	route = strings.Replace(route, "/*/", "/", -1)
+	if strings.HasSuffix(route, "/*") {
+		route = strings.TrimSuffix(route, "*") + "{path}"
+	}
+	if len(route) > 1 {
+		route = strings.TrimSuffix(route, "/")
+	}
+	return route
+}
+
+// Operations returns the operations documented by the main handler and
+// every registered handler
+func (h *MainHandler) Operations() []Operation {
+	operations := h.operations()
+	for _, handler := range h.Handlers {
+		operations = append(operations, handler.operations()...)
+	}
+	return operations
+}
+
+// OpenAPI builds the OpenAPI document of the registered routes. Routes
+// without a documented operation are logged and left out.
+func (h *MainHandler) OpenAPI() *OpenAPIDocument {
+	documented := make(map[string]Operation)
+	for _, operation := range h.Operations() {
+		documented[operation.Method+" "+operation.Path] = operation
+	}
+
+	doc := &OpenAPIDocument{
+		OpenAPI: OpenAPIVersion,
+		Info:    OpenAPIInfo{Title: "Zenon API", Version: "1.0.0"},
+		Paths:   make(map[string]map[string]*OpenAPIOperation),
+		Components: OpenAPIComponents{
+			Schemas: make(map[string]*Schema),
+			SecuritySchemes: map[string]OpenAPISecurityScheme{
+				"bearerAuth": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+			},
+		},
+		Security: []map[string][]string{{"bearerAuth": {}}},
+	}
+	schemas := schemaRegistry(doc.Components.Schemas)
+
+	chi.Walk(h.Router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		path := OpenAPIPath(route)
+		operation, ok := documented[method+" "+path]
+		if !ok {
+			log.Warnf("Route %s %s is not documented", method, path)
+			return nil
+		}
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*OpenAPIOperation)
+		}
+		doc.Paths[path][strings.ToLower(method)] = schemas.operation(operation)
+		return nil
+	})
+
+	return doc
+}
+
+// GetOpenAPI handles GET requests for the OpenAPI document
+func (h *MainHandler) GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if err := json.NewEncoder(w).Encode(h.OpenAPI()); err != nil {
+		log.WithContext(r.Context()).Error("Error encoding response: ", err)
+	}
+}
+
+// GetDocs handles GET requests for the API reference page, which renders
+// the OpenAPI document
+func (h *MainHandler) GetDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentTypeHTML+"; charset=utf-8")
+	w.Write(docsPage)
+}
+
+func (h *MainHandler) operations() []Operation {
+	return []Operation{
+		{Method: http.MethodGet, Path: "/", Summary: "Check that the API is up", Tag: "meta", Public: true, Response: map[string]string{}},
+		{Method: http.MethodGet, Path: "/openapi.json", Summary: "Get the OpenAPI document", Tag: "meta", Public: true, Response: map[string]any{}},
+		{Method: http.MethodGet, Path: "/docs", Summary: "Browse the API reference", Tag: "meta", Public: true, ContentType: contentTypeHTML},
+		{Method: http.MethodGet, Path: "/metrics", Summary: "Get metrics in Prometheus exposition format", Tag: "meta", Public: true, ContentType: contentTypeText},
+	}
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+// schemaRegistry holds the named schemas of a document's components
+type schemaRegistry map[string]*Schema
+
+func (s schemaRegistry) operation(operation Operation) *OpenAPIOperation {
+	result := &OpenAPIOperation{
+		OperationID: operationID(operation),
+		Summary:     operation.Summary,
+		Responses:   make(map[string]*OpenAPIResponse),
+	}
+	if operation.Tag != "" {
+		result.Tags = []string{operation.Tag}
+	}
+	if operation.Public {
+		result.Security = &[]map[string][]string{}
+	}
+
+	for _, match := range pathParamPattern.FindAllStringSubmatch(operation.Path, -1) {
+		param := Param{Name: match[1]}
+		for _, typed := range operation.PathParams {
+			if typed.Name == param.Name {
+				param = typed
+			}
+		}
+		param.Required = true
+		result.Parameters = append(result.Parameters, parameter(param, "path"))
+	}
+	for _, param := range operation.Query {
+		result.Parameters = append(result.Parameters, parameter(param, "query"))
+	}
+	if mutating(operation.Method) {
+		result.Parameters = append(result.Parameters, OpenAPIParameter{
+			Name:        IdempotencyKeyHeader,
+			In:          "header",
+			Description: "Key under which the response is kept, so that retries of the request are answered with it",
+			Schema:      &Schema{Type: "string", MinLength: intPtr(1)},
+		})
+	}
+
+	switch {
+	case operation.Body != nil:
+		result.RequestBody = &OpenAPIRequestBody{
+			Required: !operation.BodyOptional,
+			Content:  map[string]OpenAPIMediaType{contentTypeJSON: {Schema: s.schemaOf(reflect.TypeOf(operation.Body))}},
+		}
+	case operation.Upload:
+		result.RequestBody = &OpenAPIRequestBody{
+			Required: true,
+			Content: map[string]OpenAPIMediaType{contentTypeMultipart: {Schema: &Schema{
+				Type:       "object",
+				Properties: map[string]*Schema{"file": {Type: "string", Format: "binary"}},
+				Required:   []string{"file"},
+			}}},
+		}
+	case operation.RawBody:
+		result.RequestBody = &OpenAPIRequestBody{
+			Required: true,
+			Content:  map[string]OpenAPIMediaType{contentTypeBinary: {Schema: &Schema{Type: "string", Format: "binary"}}},
+		}
+	}
+
+	status := operation.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := &OpenAPIResponse{Description: http.StatusText(status)}
+	switch {
+	case operation.Response != nil:
+		success.Content = map[string]OpenAPIMediaType{contentTypeJSON: {Schema: s.schemaOf(reflect.TypeOf(operation.Response))}}
+	case operation.ContentType != "":
+		success.Content = map[string]OpenAPIMediaType{operation.ContentType: {Schema: &Schema{Type: "string"}}}
+	}
+	if status >= 300 && status < 400 {
+		success.Headers = map[string]OpenAPIHeader{"Location": {Schema: &Schema{Type: "string", Format: "uri"}}}
+	}
+	result.Responses[strconv.Itoa(status)] = success
+
+	errors := slices.Clone(operation.Errors)
+	if !operation.Public {
+		errors = append(errors, http.StatusUnauthorized)
+	}
+	// Any request may be rate limited
+	errors = append(errors, http.StatusTooManyRequests)
+	if mutating(operation.Method) {
+		errors = append(errors, http.StatusConflict, http.StatusUnprocessableEntity)
+	}
+	if len(result.Parameters) > 0 || result.RequestBody != nil {
+		errors = append(errors, http.StatusBadRequest)
+	}
+	if result.RequestBody != nil {
+		errors = append(errors, http.StatusRequestEntityTooLarge)
+	}
+	for _, code := range errors {
+		response := &OpenAPIResponse{
+			Description: http.StatusText(code),
+			Content:     map[string]OpenAPIMediaType{contentTypeText: {Schema: &Schema{Type: "string"}}},
+		}
+		// Requests rejected by the validation middleware get field details
+		if code == http.StatusBadRequest {
+			response.Content[contentTypeJSON] = OpenAPIMediaType{Schema: s.schemaOf(reflect.TypeOf(ValidationErrorResponse{}))}
+		}
+		result.Responses[strconv.Itoa(code)] = response
+	}
+
+	return result
+}
+
+// parameter describes a query or path parameter
+func parameter(param Param, in string) OpenAPIParameter {
+	schema := &Schema{Type: param.Type, Minimum: param.Minimum, Maximum: param.Maximum}
+	if schema.Type == "" {
+		schema.Type = "string"
+	}
+
+	return OpenAPIParameter{
+		Name:        param.Name,
+		In:          in,
+		Description: param.Description,
+		Required:    param.Required,
+		Schema:      schema,
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf describes a Go type. Named structs are added to the registry and
+// referenced; fields follow their json tags and required, oneof, gt and gte
+// validation rules.
+func (s schemaRegistry) schemaOf(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Minimum: ptr(0)}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: s.schemaOf(t.Elem())}
+	case reflect.Map:
+		if t.Elem().Kind() == reflect.Interface {
+			return &Schema{Type: "object"}
+		}
+		return &Schema{Type: "object", AdditionalProperties: s.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+		if _, exists := s[t.Name()]; !exists {
+			// Register first so recursive types end in a reference
+			s[t.Name()] = &Schema{}
+			*s[t.Name()] = *s.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	default:
+		return &Schema{}
+	}
+}
+
+func (s schemaRegistry) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{
+		Type:                 "object",
+		Properties:           make(map[string]*Schema),
+		AdditionalProperties: false,
+	}
+	s.addFields(schema, t)
+	sort.Strings(schema.Required)
+	return schema
+}
+
+// addFields adds the JSON fields of t to schema, flattening embedded structs
+// the way encoding/json does
+func (s schemaRegistry) addFields(schema *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			s.addFields(schema, field.Type)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := s.schemaOf(field.Type)
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			rule, value, _ := strings.Cut(rule, "=")
+			switch rule {
+			case "required":
+				schema.Required = append(schema.Required, name)
+				if property.Type == "string" {
+					property.MinLength = intPtr(1)
+				}
+			case "oneof":
+				property.Enum = strings.Fields(value)
+			case "gt":
+				if limit, err := strconv.ParseFloat(value, 64); err == nil {
+					property.ExclusiveMinimum = &limit
+				}
+			case "gte":
+				if limit, err := strconv.ParseFloat(value, 64); err == nil {
+					property.Minimum = &limit
+				}
+			}
+		}
+		schema.Properties[name] = property
+	}
+}
+
+// operationID derives a stable identifier such as "getApiV1UsersUsernameProfile"
+func operationID(operation Operation) string {
+	var id strings.Builder
+	id.WriteString(strings.ToLower(operation.Method))
+	for _, segment := range strings.FieldsFunc(operation.Path, func(r rune) bool {
+		return r == '/' || r == '{' || r == '}' || r == '-' || r == '.' || r == '_'
+	}) {
+		id.WriteString(strings.ToUpper(segment[:1]) + segment[1:])
+	}
+	return id.String()
+}
+
+func ptr(value float64) *float64 {
+	return &value
+}
+
+func intPtr(value int) *int {
+	return &value
+}
@@ -0,0 +1,134 @@
+package http
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	"github.com/zzenonn/go-zenon-api-aws/internal/metrics"
+	"github.com/zzenonn/go-zenon-api-aws/internal/ratelimit"
+)
+
+// APIKeyHeader identifies clients limited by ratelimit.KeyAPIKey
+const APIKeyHeader = "X-API-Key"
+
+// defaultRateLimitPolicy names the policy shared by routes without their own
+const defaultRateLimitPolicy = "default"
+
+// unlimitedPaths are never rate limited, so that probes and metric scrapes
+// keep working while clients are being limited
+var unlimitedPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+// RateLimits decides which policy a request falls under and which client it
+// is counted against
+type RateLimits struct {
+	Limiter *ratelimit.Limiter
+	Default ratelimit.Policy
+	// Routes holds the policies of single routes, keyed by method and
+	// OpenAPI path, e.g. "POST /api/v1/users/login"
+	Routes map[string]ratelimit.Policy
+
+	// PublicKey verifies the tokens of clients limited by subject
+	PublicKey *ecdsa.PublicKey
+	// APIKeys holds the keys of clients limited by API key. Any other key
+	// is ignored, so that clients cannot pick a new bucket per request.
+	APIKeys map[string]bool
+	// TrustedProxies is how many proxies in front of the API append to
+	// X-Forwarded-For
+	TrustedProxies int
+}
+
+func NewRateLimits(limiter *ratelimit.Limiter, cfg *config.Config) *RateLimits {
+	return &RateLimits{
+		Limiter:        limiter,
+		Default:        cfg.DefaultRateLimit,
+		Routes:         cfg.RouteRateLimits,
+		PublicKey:      cfg.ECDSAPublicKey,
+		APIKeys:        cfg.RateLimitAPIKeys,
+		TrustedProxies: int(cfg.TrustedProxies),
+	}
+}
+
+// RateLimitMiddleware counts every request against the policy of its route,
+// or the default policy, and rejects it with 429 Too Many Requests once the
+// client is over the limit. Responses carry the RateLimit-Policy,
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers of the
+// IETF draft, and rejections a Retry-After. Requests are let through if the
+// counters cannot be reached, so that the store is not a single point of
+// failure. Health checks and metrics are not limited.
+func (h *MainHandler) RateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limits := h.RateLimits
+		if limits == nil || unlimitedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		name, policy := defaultRateLimitPolicy, limits.Default
+		if rctx, ok := h.matchRoute(r); ok {
+			route := r.Method + " " + OpenAPIPath(rctx.RoutePattern())
+			if routePolicy, ok := limits.Routes[route]; ok {
+				name, policy = route, routePolicy
+			}
+		}
+		if !policy.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		decision, err := limits.Limiter.Allow(r.Context(), name, policy, limits.client(r, policy.Key))
+		if err != nil {
+			log.WithContext(r.Context()).Warn("Rate limiting skipped: ", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, int64(policy.Window.Seconds())))
+		w.Header().Set("RateLimit-Limit", strconv.FormatInt(decision.Limit, 10))
+		w.Header().Set("RateLimit-Remaining", strconv.FormatInt(decision.Remaining, 10))
+		w.Header().Set("RateLimit-Reset", seconds(decision.Reset))
+
+		if !decision.Allowed {
+			metrics.RateLimited.WithLabelValues(name).Inc()
+			w.Header().Set("Retry-After", seconds(decision.RetryAfter))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// client returns the identity a request is counted against. Requests
+// without a valid token or known API key are counted by IP. API keys are
+// hashed so that they are never stored.
+func (l *RateLimits) client(r *http.Request, key string) string {
+	switch key {
+	case ratelimit.KeySubject:
+		if sub, err := bearerSubject(r, l.PublicKey); err == nil && sub != "" {
+			return "sub:" + sub
+		}
+	case ratelimit.KeyAPIKey:
+		if apiKey := r.Header.Get(APIKeyHeader); l.APIKeys[apiKey] {
+			sum := sha256.Sum256([]byte(apiKey))
+			return "key:" + hex.EncodeToString(sum[:16])
+		}
+	}
+	return "ip:" + clientIP(r, l.TrustedProxies)
+}
+
+// seconds formats a duration as whole seconds, rounded up
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -0,0 +1,133 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+	apperrors "github.com/zzenonn/go-zenon-api-aws/internal/errors"
+	"github.com/zzenonn/go-zenon-api-aws/internal/urlsign"
+)
+
+// ObjectDownloader streams stored objects served by the API
+type ObjectDownloader interface {
+	Download(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// ObjectReader opens stored objects for random access, which lets the API
+// answer range and conditional requests. Stores such as S3 that only stream
+// objects are served in full.
+type ObjectReader interface {
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, time.Time, error)
+}
+
+// URLVerifier checks the expiry and signature of a signed storage URL
+type URLVerifier interface {
+	Verify(key string, expires string, signature string) error
+}
+
+// StorageHandler serves objects through HMAC-signed, expiring URLs. It stands
+// in for S3 presigned URLs when objects are stored on the local filesystem,
+// and keeps the bucket hidden when the API serves S3 objects itself.
+type StorageHandler struct {
+	Store    ObjectDownloader
+	Verifier URLVerifier
+}
+
+func NewStorageHandler(store ObjectDownloader, verifier URLVerifier) *StorageHandler {
+	return &StorageHandler{
+		Store:    store,
+		Verifier: verifier,
+	}
+}
+
+// GetObject handles GET requests for a signed object URL
+func (h *StorageHandler) GetObject(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "*")
+	if r.URL.RawPath != "" {
+		// chi matched against the escaped path, so the key is still escaped
+		unescaped, err := url.PathUnescape(key)
+		if err != nil {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		key = unescaped
+	}
+	query := r.URL.Query()
+
+	if err := h.Verifier.Verify(key, query.Get("expires"), query.Get("signature")); err != nil {
+		log.WithContext(r.Context()).Debug("Rejected storage URL: ", err)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	reader, ok := h.Store.(ObjectReader)
+	if !ok {
+		h.streamObject(w, r, key)
+		return
+	}
+
+	object, modTime, err := reader.Open(r.Context(), key)
+	if errors.Is(err, apperrors.ErrObjectNotFound) || errors.Is(err, apperrors.ErrInvalidObjectKey) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error opening object: ", err)
+		http.Error(w, "Failed to read object", http.StatusInternalServerError)
+		return
+	}
+	defer object.Close()
+
+	// Let ServeContent pick the type from the extension or content instead of
+	// the JSON type set by JSONMiddleware
+	w.Header().Del("Content-Type")
+	http.ServeContent(w, r, key, modTime, object)
+}
+
+// streamObject copies an object from a store that cannot seek to the response
+func (h *StorageHandler) streamObject(w http.ResponseWriter, r *http.Request, key string) {
+	object, err := h.Store.Download(r.Context(), key)
+	if errors.Is(err, apperrors.ErrObjectNotFound) || errors.Is(err, apperrors.ErrInvalidObjectKey) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error opening object: ", err)
+		http.Error(w, "Failed to read object", http.StatusInternalServerError)
+		return
+	}
+	defer object.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+
+	if _, err := io.Copy(w, object); err != nil {
+		log.WithContext(r.Context()).Error("Error streaming object: ", err)
+	}
+}
+
+func (h *StorageHandler) mapRoutes(router chi.Router) {
+	router.Get(urlsign.StoragePath+"*", h.GetObject)
+}
+
+func (h *StorageHandler) operations() []Operation {
+	return []Operation{
+		{Method: http.MethodGet, Path: urlsign.StoragePath + "{path}", Summary: "Download an object through a signed URL", Tag: "storage", Public: true,
+			Query: []Param{
+				{Name: "expires", Type: "integer", Required: true, Description: "Unix time at which the URL expires"},
+				{Name: "signature", Required: true, Description: "HMAC of the key and expiry"},
+			},
+			ContentType: contentTypeBinary, Errors: []int{http.StatusForbidden, http.StatusNotFound}},
+	}
+}
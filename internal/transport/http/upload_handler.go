@@ -0,0 +1,202 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+	apperrors "github.com/zzenonn/go-zenon-api-aws/internal/errors"
+)
+
+// uploadVerifyRetryAfter is how many seconds a client waits before asking
+// again for an upload that is still being verified
+const uploadVerifyRetryAfter = "5"
+
+type CreateUploadRequest struct {
+	Name        string `json:"name" validate:"required"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size" validate:"gt=0"`
+	Checksum    string `json:"checksum"`
+}
+
+type CompleteUploadRequest struct {
+	Checksum string `json:"checksum"`
+}
+
+// PostUpload handles POST requests that start a multipart upload
+func (h *FileHandler) PostUpload(w http.ResponseWriter, r *http.Request) {
+	log.WithContext(r.Context()).Debug("Received POST /api/v1/users/{username}/files/uploads request")
+
+	username := chi.URLParam(r, "username")
+	if !ValidateUserAccess(w, r, username) {
+		return
+	}
+
+	req, err := requestBody[CreateUploadRequest](r)
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error decoding request body: ", err)
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	upload, err := h.Service.CreateUpload(r.Context(), username, req.Name, req.ContentType, req.Size, req.Checksum)
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error creating upload: ", err)
+		http.Error(w, "Failed to create upload", errorStatus(err))
+		return
+	}
+
+	log.WithContext(r.Context()).Debug(fmt.Sprintf("Upload %s started for user: %s", upload.ID, username))
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(upload); err != nil {
+		log.WithContext(r.Context()).Error("Error encoding response: ", err)
+	}
+}
+
+// GetUpload handles GET requests for an upload and the parts stored so far
+func (h *FileHandler) GetUpload(w http.ResponseWriter, r *http.Request) {
+	log.WithContext(r.Context()).Debug("Received GET /api/v1/users/{username}/files/uploads/{id} request")
+
+	username := chi.URLParam(r, "username")
+	if !ValidateUserAccess(w, r, username) {
+		return
+	}
+
+	upload, err := h.Service.GetUpload(r.Context(), username, chi.URLParam(r, "id"))
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error getting upload: ", err)
+		http.Error(w, "Failed to get upload", errorStatus(err))
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(upload); err != nil {
+		log.WithContext(r.Context()).Error("Error encoding response: ", err)
+	}
+}
+
+// PutUploadPart handles PUT requests whose raw body is one part of an upload
+func (h *FileHandler) PutUploadPart(w http.ResponseWriter, r *http.Request) {
+	log.WithContext(r.Context()).Debug("Received PUT /api/v1/users/{username}/files/uploads/{id}/parts/{number} request")
+
+	username := chi.URLParam(r, "username")
+	if !ValidateUserAccess(w, r, username) {
+		return
+	}
+
+	number, ok := partNumber(w, r)
+	if !ok {
+		return
+	}
+
+	part, err := h.Service.UploadPart(r.Context(), username, chi.URLParam(r, "id"), number, r.Body)
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error uploading part: ", err)
+		http.Error(w, "Failed to upload part", errorStatus(err))
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(part); err != nil {
+		log.WithContext(r.Context()).Error("Error encoding response: ", err)
+	}
+}
+
+// GetUploadPartURL handles GET requests for a presigned URL to upload one
+// part directly to object storage
+func (h *FileHandler) GetUploadPartURL(w http.ResponseWriter, r *http.Request) {
+	log.WithContext(r.Context()).Debug("Received GET /api/v1/users/{username}/files/uploads/{id}/parts/{number}/url request")
+
+	username := chi.URLParam(r, "username")
+	if !ValidateUserAccess(w, r, username) {
+		return
+	}
+
+	number, ok := partNumber(w, r)
+	if !ok {
+		return
+	}
+
+	upload, err := h.Service.PresignUploadPart(r.Context(), username, chi.URLParam(r, "id"), number)
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error creating part upload URL: ", err)
+		http.Error(w, "Failed to create upload URL", errorStatus(err))
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(upload); err != nil {
+		log.WithContext(r.Context()).Error("Error encoding response: ", err)
+	}
+}
+
+// CompleteUpload handles POST requests that assemble an upload's parts into a
+// file. Files still being verified when the request ends are answered with
+// 202 Accepted, and the client repeats the request until it gets the file.
+func (h *FileHandler) CompleteUpload(w http.ResponseWriter, r *http.Request) {
+	log.WithContext(r.Context()).Debug("Received POST /api/v1/users/{username}/files/uploads/{id}/complete request")
+
+	username := chi.URLParam(r, "username")
+	if !ValidateUserAccess(w, r, username) {
+		return
+	}
+
+	// The checksum may already have been given when the upload was created
+	var req CompleteUploadRequest
+	if r.ContentLength != 0 {
+		var err error
+		if req, err = requestBody[CompleteUploadRequest](r); err != nil {
+			log.WithContext(r.Context()).Error("Error decoding request body: ", err)
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	file, err := h.Service.CompleteUpload(r.Context(), username, chi.URLParam(r, "id"), req.Checksum)
+	if errors.Is(err, apperrors.ErrUploadVerifying) {
+		// Large files take longer to read back than a request may last
+		w.Header().Set("Retry-After", uploadVerifyRetryAfter)
+		http.Error(w, "Upload is being verified; complete it again to get the file", http.StatusAccepted)
+		return
+	}
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error completing upload: ", err)
+		http.Error(w, "Failed to complete upload", errorStatus(err))
+		return
+	}
+
+	log.WithContext(r.Context()).Debug(fmt.Sprintf("Upload %s completed for user: %s", file.ID, username))
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(file); err != nil {
+		log.WithContext(r.Context()).Error("Error encoding response: ", err)
+	}
+}
+
+// AbortUpload handles DELETE requests that discard an upload
+func (h *FileHandler) AbortUpload(w http.ResponseWriter, r *http.Request) {
+	log.WithContext(r.Context()).Debug("Received DELETE /api/v1/users/{username}/files/uploads/{id} request")
+
+	username := chi.URLParam(r, "username")
+	if !ValidateUserAccess(w, r, username) {
+		return
+	}
+
+	if err := h.Service.AbortUpload(r.Context(), username, chi.URLParam(r, "id")); err != nil {
+		log.WithContext(r.Context()).Error("Error aborting upload: ", err)
+		http.Error(w, "Failed to abort upload", errorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(Response{Message: "Upload aborted successfully"})
+}
+
+// partNumber parses the {number} URL parameter, writing a 400 if it is invalid
+func partNumber(w http.ResponseWriter, r *http.Request) (int32, bool) {
+	number, err := strconv.ParseInt(chi.URLParam(r, "number"), 10, 32)
+	if err != nil || number < 1 {
+		http.Error(w, "Invalid part number", http.StatusBadRequest)
+		return 0, false
+	}
+	return int32(number), true
+}
@@ -3,16 +3,18 @@ package http
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-playground/validator/v10"
 	log "github.com/sirupsen/logrus"
 	"github.com/zzenonn/go-zenon-api-aws/internal/config"
 	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	apperrors "github.com/zzenonn/go-zenon-api-aws/internal/errors"
 )
 
 type UserService interface {
@@ -21,9 +23,14 @@ type UserService interface {
 	DeleteUser(ctx context.Context, id string) error
 	CreateUser(ctx context.Context, u domain.User) (domain.User, error)
 	Login(ctx context.Context, username string, password string) error
-	UploadProfile(ctx context.Context, username string, key string, r io.Reader) error
-	GeneratePresignedURL(ctx context.Context, username string, key string) (string, error)
-	DeleteProfile(ctx context.Context, username string, key string) error
+	UploadProfile(ctx context.Context, username string, r io.Reader) (domain.User, error)
+	GetProfileURL(ctx context.Context, viewer string, username string, size int) (string, error)
+	SetProfileVisibility(ctx context.Context, username string, visibility string) (domain.User, error)
+	DeleteProfile(ctx context.Context, username string) error
+	CreateProfileUpload(ctx context.Context, username string, method string, contentType string, size int64) (domain.PresignedUpload, error)
+	ConfirmProfileUpload(ctx context.Context, username string, key string) (domain.User, error)
+	ListProfileVersions(ctx context.Context, username string) ([]domain.ProfileVersion, error)
+	RestoreProfileVersion(ctx context.Context, username string, id string) (domain.User, error)
 }
 
 type Token struct {
@@ -41,6 +48,33 @@ type PostUserRequest struct {
 	Password string `json:"password" validate:"required"`
 }
 
+type LoginRequest struct {
+	Username string `json:"username" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+type ProfileURLResponse struct {
+	ProfileURL string `json:"profile_url"`
+}
+
+type ProfileUploadURLRequest struct {
+	ContentType   string `json:"content_type" validate:"required"`
+	ContentLength int64  `json:"content_length" validate:"gte=0"`
+	Method        string `json:"method" validate:"omitempty,oneof=PUT POST"`
+}
+
+type ConfirmProfileUploadRequest struct {
+	Key string `json:"key" validate:"required"`
+}
+
+type ProfileVisibilityRequest struct {
+	Visibility string `json:"visibility" validate:"required,oneof=public authenticated private"`
+}
+
+type ProfileVersionsResponse struct {
+	Versions []domain.ProfileVersion `json:"versions"`
+}
+
 func convertPostUserRequestToUser(u PostUserRequest) domain.User {
 	return domain.User{
 		Username: &u.Username,
@@ -60,71 +94,67 @@ func NewUserHandler(s UserService, cfg *config.Config) *UserHandler {
 
 
 func (h *UserHandler) PostUser(w http.ResponseWriter, r *http.Request) {
-	log.Debug("Received POST /api/v1/users request")
-
-	var u PostUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
-		log.Error("Error decoding request body: ", err)
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		return
-	}
+	log.WithContext(r.Context()).Debug("Received POST /api/v1/users request")
 
-	validate := validator.New()
-	err := validate.Struct(u)
+	u, err := requestBody[PostUserRequest](r)
 	if err != nil {
-		log.Debug("Validation failed for user: ", u)
-		http.Error(w, "Not a valid user", http.StatusBadRequest)
+		log.WithContext(r.Context()).Error("Error decoding request body: ", err)
+		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
 	convertedUser := convertPostUserRequestToUser(u)
-	log.Debug(fmt.Sprintf("Converted user data: %#v", convertedUser))
 
 	createdUser, err := h.Service.CreateUser(r.Context(), convertedUser)
+	if errors.Is(err, apperrors.ErrUserAlreadyExists) {
+		log.WithContext(r.Context()).Debug("User already exists: ", u.Username)
+		http.Error(w, "User already exists", http.StatusConflict)
+		return
+	}
 	if err != nil {
-		log.Error("Error creating user: ", err)
+		log.WithContext(r.Context()).Error("Error creating user: ", err)
 		http.Error(w, "Failed to create user", http.StatusInternalServerError)
 		return
 	}
 
-	log.Debug(fmt.Sprintf("User created successfully: %#v", createdUser))
+	log.WithContext(r.Context()).WithField("username", u.Username).Debug("User created successfully")
 
 	if err := json.NewEncoder(w).Encode(createdUser); err != nil {
-		log.Fatal("Error encoding response: ", err)
+		log.WithContext(r.Context()).Fatal("Error encoding response: ", err)
 	}
 }
 
 func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
-	log.Debug("Received GET /api/v1/users/{username} request")
+	log.WithContext(r.Context()).Debug("Received GET /api/v1/users/{username} request")
 
 	username := chi.URLParam(r, "username")
 	if username == "" {
-		log.Error("No username provided in request")
+		log.WithContext(r.Context()).Error("No username provided in request")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	log.Debug(fmt.Sprintf("Fetching user with ID: %s", username))
+	log.WithContext(r.Context()).Debug(fmt.Sprintf("Fetching user with ID: %s", username))
 	u, err := h.Service.GetUser(r.Context(), username)
 	if err != nil {
-		log.Error("Error fetching user: ", err)
+		log.WithContext(r.Context()).Error("Error fetching user: ", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	log.Debug(fmt.Sprintf("User fetched successfully: %#v", u))
+	log.WithContext(r.Context()).WithField("username", username).Debug("User fetched successfully")
 
 	if err := json.NewEncoder(w).Encode(u); err != nil {
-		log.Fatal("Error encoding response: ", err)
+		log.WithContext(r.Context()).Fatal("Error encoding response: ", err)
 	}
 }
 
 func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
-	log.Debug("Received PUT /api/v1/users/{username} request")
+	log.WithContext(r.Context()).Debug("Received PUT /api/v1/users/{username} request")
 
 	username := chi.URLParam(r, "username")
 	if username == "" {
-		log.Debug("No username provided in request")
+		log.WithContext(r.Context()).Debug("No username provided in request")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -133,110 +163,111 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req PostUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Error("Error decoding request body: ", err)
+	req, err := requestBody[PostUserRequest](r)
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error decoding request body: ", err)
+		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
 	// Validate that username in request body matches path parameter
 	if req.Username != username {
-		log.Error("Username in request body does not match path parameter")
+		log.WithContext(r.Context()).Error("Username in request body does not match path parameter")
 		http.Error(w, "Username cannot be changed", http.StatusBadRequest)
 		return
 	}
 
 	u := convertPostUserRequestToUser(req)
 
-	log.Debug(fmt.Sprintf("Updating user with ID: %s", username))
+	log.WithContext(r.Context()).Debug(fmt.Sprintf("Updating user with ID: %s", username))
 
-	u, err := h.Service.UpdateUser(r.Context(), u)
+	u, err = h.Service.UpdateUser(r.Context(), u)
 	if err != nil {
-		log.Error("Error updating user: ", err)
+		log.WithContext(r.Context()).Error("Error updating user: ", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	log.Debug(fmt.Sprintf("User updated successfully: %#v", u))
+	log.WithContext(r.Context()).WithField("username", username).Debug("User updated successfully")
 
 	if err := json.NewEncoder(w).Encode(u); err != nil {
-		log.Fatal("Error encoding response: ", err)
+		log.WithContext(r.Context()).Fatal("Error encoding response: ", err)
 	}
 }
 
 func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
-	log.Debug("Received DELETE /api/v1/users/{username} request")
+	log.WithContext(r.Context()).Debug("Received DELETE /api/v1/users/{username} request")
 
 	username := chi.URLParam(r, "username")
 	if username == "" {
-		log.Debug("No username provided in request")
+		log.WithContext(r.Context()).Debug("No username provided in request")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	log.Debug(fmt.Sprintf("Deleting user with ID: %s", username))
+	log.WithContext(r.Context()).Debug(fmt.Sprintf("Deleting user with ID: %s", username))
 
 	err := h.Service.DeleteUser(r.Context(), username)
 	if err != nil {
-		log.Error("Error deleting user: ", err)
+		log.WithContext(r.Context()).Error("Error deleting user: ", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	log.Debug("User deleted successfully")
+	log.WithContext(r.Context()).Debug("User deleted successfully")
 
 	if err := json.NewEncoder(w).Encode(Response{Message: "Successfully deleted"}); err != nil {
-		log.Fatal("Error encoding response: ", err)
+		log.WithContext(r.Context()).Fatal("Error encoding response: ", err)
 	}
 }
 
 func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
-	log.Debug("Received POST /api/v1/users/login request")
+	log.WithContext(r.Context()).Debug("Received POST /api/v1/users/login request")
 
-	var m map[string]string
-	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
-		log.Error("Error decoding request body: ", err)
+	req, err := requestBody[LoginRequest](r)
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error decoding request body: ", err)
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
-	username := m["username"]
-	password := m["password"]
+	username := req.Username
+	password := req.Password
 
-	log.Debug(fmt.Sprintf("Attempting login for user: %s", username))
+	log.WithContext(r.Context()).Debug(fmt.Sprintf("Attempting login for user: %s", username))
 
-	err := h.Service.Login(r.Context(), username, password)
+	err = h.Service.Login(r.Context(), username, password)
 	if err != nil {
-		log.Error("Login failed: ", err)
+		log.WithContext(r.Context()).Error("Login failed: ", err)
 		http.Error(w, "Not authorized", http.StatusUnauthorized)
 		return
 	}
 
 	tokenString, err := generateJwtToken(username, h.Config.ECDSAPrivateKey)
 	if err != nil {
-		log.Error("Error generating JWT token: ", err)
+		log.WithContext(r.Context()).Error("Error generating JWT token: ", err)
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
-	log.Debug("JWT token generated successfully")
+	log.WithContext(r.Context()).Debug("JWT token generated successfully")
 
 	token := Token{
 		Token: tokenString,
 	}
 
 	if err := json.NewEncoder(w).Encode(token); err != nil {
-		log.Error("Error encoding response: ", err)
+		log.WithContext(r.Context()).Error("Error encoding response: ", err)
 	}
 }
 
 // PutProfile handles PUT requests to upload a user's profile image
 func (h *UserHandler) PutProfile(w http.ResponseWriter, r *http.Request) {
-	log.Debug("Received PUT /api/v1/users/{username}/profile request")
+	log.WithContext(r.Context()).Debug("Received PUT /api/v1/users/{username}/profile request")
 
 	username := chi.URLParam(r, "username")
 	if username == "" {
-		log.Error("No username provided in request")
+		log.WithContext(r.Context()).Error("No username provided in request")
 		http.Error(w, "Username is required", http.StatusBadRequest)
 		return
 	}
@@ -247,81 +278,150 @@ func (h *UserHandler) PutProfile(w http.ResponseWriter, r *http.Request) {
 
 	// Parse multipart form with 10MB size limit
 	err := r.ParseMultipartForm(10 << 20)
+	if bodyTooLarge(err) {
+		http.Error(w, "Profile image is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil {
-		log.Error("Failed to parse multipart form: ", err)
+		log.WithContext(r.Context()).Error("Failed to parse multipart form: ", err)
 		http.Error(w, "Invalid multipart form", http.StatusBadRequest)
 		return
 	}
 
 	// Retrieve and validate file from form
-	file, header, err := r.FormFile("file")
+	file, _, err := r.FormFile("file")
 	if err != nil {
-		log.Error("Failed to read file: ", err)
+		log.WithContext(r.Context()).Error("Failed to read file: ", err)
 		http.Error(w, "File upload is required", http.StatusBadRequest)
 		return
 	}
 	defer file.Close()
 
-	// Get file extension
-	ext := filepath.Ext(header.Filename)
-	if ext == "" {
-		ext = ".jpg" // default extension
-	}
-	key := "profile" + ext
-
-	// Upload profile image using the service
-	err = h.Service.UploadProfile(r.Context(), username, key, file)
+	// The image type is detected from its content, not the file name
+	_, err = h.Service.UploadProfile(r.Context(), username, file)
 	if err != nil {
-		log.Error("Error uploading profile: ", err)
-		http.Error(w, "Failed to upload profile", http.StatusInternalServerError)
+		log.WithContext(r.Context()).Error("Error uploading profile: ", err)
+		http.Error(w, "Failed to upload profile", errorStatus(err))
 		return
 	}
 
-	log.Debug(fmt.Sprintf("Profile uploaded successfully for user: %s", username))
+	log.WithContext(r.Context()).Debug(fmt.Sprintf("Profile uploaded successfully for user: %s", username))
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(Response{Message: "Profile uploaded successfully"})
 }
 
 // GetProfile handles GET requests to retrieve a user's profile image URL
 func (h *UserHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
-	log.Debug("Received GET /api/v1/users/{username}/profile request")
+	log.WithContext(r.Context()).Debug("Received GET /api/v1/users/{username}/profile request")
 
 	username := chi.URLParam(r, "username")
 	if username == "" {
-		log.Error("No username provided in request")
+		log.WithContext(r.Context()).Error("No username provided in request")
 		http.Error(w, "Username is required", http.StatusBadRequest)
 		return
 	}
 
+	size, err := profileSize(r)
+	if err != nil {
+		http.Error(w, "Invalid size", http.StatusBadRequest)
+		return
+	}
+
 	// Generate pre-signed URL for profile image
-	profileURL, err := h.Service.GeneratePresignedURL(r.Context(), username, "profile.jpg")
+	profileURL, err := h.Service.GetProfileURL(r.Context(), subject(r), username, size)
 	if err != nil {
-		log.Error("Error generating profile URL: ", err)
-		http.Error(w, "Failed to get profile", http.StatusInternalServerError)
+		log.WithContext(r.Context()).Error("Error generating profile URL: ", err)
+		http.Error(w, "Failed to get profile", errorStatus(err))
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	response := struct {
-		ProfileURL string `json:"profile_url"`
-	}{
+	response := ProfileURLResponse{
 		ProfileURL: profileURL,
 	}
 
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Error("Error encoding response: ", err)
+		log.WithContext(r.Context()).Error("Error encoding response: ", err)
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 		return
 	}
 }
 
+// GetAvatar handles unauthenticated GET requests for a public profile image.
+// It redirects to a short-lived signed URL so the endpoint itself stays stable.
+func (h *UserHandler) GetAvatar(w http.ResponseWriter, r *http.Request) {
+	log.WithContext(r.Context()).Debug("Received GET /avatars/{username} request")
+
+	username := chi.URLParam(r, "username")
+
+	size, err := profileSize(r)
+	if err != nil {
+		http.Error(w, "Invalid size", http.StatusBadRequest)
+		return
+	}
+
+	profileURL, err := h.Service.GetProfileURL(r.Context(), "", username, size)
+	if err != nil {
+		log.WithContext(r.Context()).Debug("Error generating avatar URL: ", err)
+		http.Error(w, "Not found", errorStatus(err))
+		return
+	}
+
+	// Caches must not keep the redirect after the signed URL expires
+	maxAge := min(h.Config.PresignTTL/2, time.Minute)
+	if maxAge >= time.Second {
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(maxAge.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
+	w.Header().Del("Content-Type")
+	http.Redirect(w, r, profileURL, http.StatusFound)
+}
+
+// PutProfileVisibility handles PUT requests that change who can see the
+// user's profile image
+func (h *UserHandler) PutProfileVisibility(w http.ResponseWriter, r *http.Request) {
+	log.WithContext(r.Context()).Debug("Received PUT /api/v1/users/{username}/profile/visibility request")
+
+	username := chi.URLParam(r, "username")
+	if username == "" {
+		log.WithContext(r.Context()).Error("No username provided in request")
+		http.Error(w, "Username is required", http.StatusBadRequest)
+		return
+	}
+
+	if !ValidateUserAccess(w, r, username) {
+		return
+	}
+
+	req, err := requestBody[ProfileVisibilityRequest](r)
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error decoding request body: ", err)
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	u, err := h.Service.SetProfileVisibility(r.Context(), username, req.Visibility)
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error setting profile visibility: ", err)
+		http.Error(w, "Failed to set profile visibility", errorStatus(err))
+		return
+	}
+
+	log.WithContext(r.Context()).Debug(fmt.Sprintf("Profile visibility set to %s for user: %s", req.Visibility, username))
+	if err := json.NewEncoder(w).Encode(u); err != nil {
+		log.WithContext(r.Context()).Error("Error encoding response: ", err)
+	}
+}
+
 // DeleteProfile handles DELETE requests to remove a user's profile image
 func (h *UserHandler) DeleteProfile(w http.ResponseWriter, r *http.Request) {
-	log.Debug("Received DELETE /api/v1/users/{username}/profile request")
+	log.WithContext(r.Context()).Debug("Received DELETE /api/v1/users/{username}/profile request")
 
 	username := chi.URLParam(r, "username")
 	if username == "" {
-		log.Error("No username provided in request")
+		log.WithContext(r.Context()).Error("No username provided in request")
 		http.Error(w, "Username is required", http.StatusBadRequest)
 		return
 	}
@@ -331,18 +431,165 @@ func (h *UserHandler) DeleteProfile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Delete profile image using the service
-	err := h.Service.DeleteProfile(r.Context(), username, "profile.jpg")
+	err := h.Service.DeleteProfile(r.Context(), username)
 	if err != nil {
-		log.Error("Error deleting profile: ", err)
-		http.Error(w, "Failed to delete profile", http.StatusInternalServerError)
+		log.WithContext(r.Context()).Error("Error deleting profile: ", err)
+		http.Error(w, "Failed to delete profile", errorStatus(err))
 		return
 	}
 
-	log.Debug(fmt.Sprintf("Profile deleted successfully for user: %s", username))
+	log.WithContext(r.Context()).Debug(fmt.Sprintf("Profile deleted successfully for user: %s", username))
 	json.NewEncoder(w).Encode(Response{Message: "Profile deleted successfully"})
 }
 
+// PostProfileUploadURL handles POST requests for a presigned URL through which
+// the client uploads a profile image directly to object storage
+func (h *UserHandler) PostProfileUploadURL(w http.ResponseWriter, r *http.Request) {
+	log.WithContext(r.Context()).Debug("Received POST /api/v1/users/{username}/profile/upload-url request")
+
+	username := chi.URLParam(r, "username")
+	if username == "" {
+		log.WithContext(r.Context()).Error("No username provided in request")
+		http.Error(w, "Username is required", http.StatusBadRequest)
+		return
+	}
+
+	if !ValidateUserAccess(w, r, username) {
+		return
+	}
+
+	req, err := requestBody[ProfileUploadURLRequest](r)
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error decoding request body: ", err)
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	upload, err := h.Service.CreateProfileUpload(r.Context(), username, req.Method, req.ContentType, req.ContentLength)
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error creating profile upload URL: ", err)
+		http.Error(w, "Failed to create upload URL", errorStatus(err))
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(upload); err != nil {
+		log.WithContext(r.Context()).Error("Error encoding response: ", err)
+	}
+}
+
+// ConfirmProfileUpload handles POST requests that confirm a direct upload
+// and make the uploaded image the user's profile
+func (h *UserHandler) ConfirmProfileUpload(w http.ResponseWriter, r *http.Request) {
+	log.WithContext(r.Context()).Debug("Received POST /api/v1/users/{username}/profile/confirm request")
+
+	username := chi.URLParam(r, "username")
+	if username == "" {
+		log.WithContext(r.Context()).Error("No username provided in request")
+		http.Error(w, "Username is required", http.StatusBadRequest)
+		return
+	}
+
+	if !ValidateUserAccess(w, r, username) {
+		return
+	}
+
+	req, err := requestBody[ConfirmProfileUploadRequest](r)
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error decoding request body: ", err)
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	u, err := h.Service.ConfirmProfileUpload(r.Context(), username, req.Key)
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error confirming profile upload: ", err)
+		http.Error(w, "Failed to confirm upload", errorStatus(err))
+		return
+	}
+
+	log.WithContext(r.Context()).Debug(fmt.Sprintf("Profile upload confirmed for user: %s", username))
+	if err := json.NewEncoder(w).Encode(u); err != nil {
+		log.WithContext(r.Context()).Error("Error encoding response: ", err)
+	}
+}
+
+func (h *UserHandler) GetProfileVersions(w http.ResponseWriter, r *http.Request) {
+	log.WithContext(r.Context()).Debug("Received GET /api/v1/users/{username}/profile/versions request")
+
+	username := chi.URLParam(r, "username")
+	if username == "" {
+		log.WithContext(r.Context()).Error("No username provided in request")
+		http.Error(w, "Username is required", http.StatusBadRequest)
+		return
+	}
+
+	if !ValidateUserAccess(w, r, username) {
+		return
+	}
+
+	versions, err := h.Service.ListProfileVersions(r.Context(), username)
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error listing profile versions: ", err)
+		http.Error(w, "Failed to list profile versions", errorStatus(err))
+		return
+	}
+
+	if versions == nil {
+		versions = []domain.ProfileVersion{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ProfileVersionsResponse{Versions: versions}); err != nil {
+		log.WithContext(r.Context()).Error("Error encoding response: ", err)
+	}
+}
+
+func (h *UserHandler) RestoreProfileVersion(w http.ResponseWriter, r *http.Request) {
+	log.WithContext(r.Context()).Debug("Received POST /api/v1/users/{username}/profile/versions/{versionID}/restore request")
+
+	username := chi.URLParam(r, "username")
+	if username == "" {
+		log.WithContext(r.Context()).Error("No username provided in request")
+		http.Error(w, "Username is required", http.StatusBadRequest)
+		return
+	}
+
+	if !ValidateUserAccess(w, r, username) {
+		return
+	}
+
+	u, err := h.Service.RestoreProfileVersion(r.Context(), username, chi.URLParam(r, "versionID"))
+	if err != nil {
+		log.WithContext(r.Context()).Error("Error restoring profile version: ", err)
+		http.Error(w, "Failed to restore profile version", errorStatus(err))
+		return
+	}
+
+	log.WithContext(r.Context()).Debug(fmt.Sprintf("Profile version restored for user: %s", username))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(u); err != nil {
+		log.WithContext(r.Context()).Error("Error encoding response: ", err)
+	}
+}
+
+// profileSize reads the optional size query parameter, which selects one of
+// the thumbnails instead of the original
+func profileSize(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("size")
+	if value == "" {
+		return 0, nil
+	}
+
+	size, err := strconv.Atoi(value)
+	if err != nil || size <= 0 {
+		return 0, apperrors.ErrInvalidRenditionSize
+	}
+	return size, nil
+}
+
 func (h *UserHandler) mapRoutes(router chi.Router) {
+	router.Get("/avatars/{username}", h.GetAvatar)
+
 	router.Route("/api/v1/users", func(r chi.Router) {
 		r.Post("/", JwtAuth(h.PostUser, h.Config.ECDSAPublicKey))
 		r.Post("/login", h.Login)
@@ -357,7 +604,52 @@ func (h *UserHandler) mapRoutes(router chi.Router) {
 				r.Put("/", JwtAuth(h.PutProfile, h.Config.ECDSAPublicKey))
 				r.Get("/", JwtAuth(h.GetProfile, h.Config.ECDSAPublicKey))
 				r.Delete("/", JwtAuth(h.DeleteProfile, h.Config.ECDSAPublicKey))
+				r.Put("/visibility", JwtAuth(h.PutProfileVisibility, h.Config.ECDSAPublicKey))
+				r.Post("/upload-url", JwtAuth(h.PostProfileUploadURL, h.Config.ECDSAPublicKey))
+				r.Post("/confirm", JwtAuth(h.ConfirmProfileUpload, h.Config.ECDSAPublicKey))
+				r.Get("/versions", JwtAuth(h.GetProfileVersions, h.Config.ECDSAPublicKey))
+				r.Post("/versions/{versionID}/restore", JwtAuth(h.RestoreProfileVersion, h.Config.ECDSAPublicKey))
 			})
 		})
 	})
 }
+
+func (h *UserHandler) operations() []Operation {
+	sizeParam := Param{Name: "size", Type: "integer", Minimum: ptr(1), Description: "Thumbnail size; the original if omitted"}
+
+	return []Operation{
+		{Method: http.MethodGet, Path: "/avatars/{username}", Summary: "Redirect to a public profile image", Tag: "profile", Public: true,
+			Query: []Param{sizeParam}, Status: http.StatusFound, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+
+		{Method: http.MethodPost, Path: "/api/v1/users", Summary: "Create a user", Tag: "users",
+			Body: PostUserRequest{}, Response: domain.User{}, Errors: []int{http.StatusBadRequest, http.StatusConflict}},
+		{Method: http.MethodPost, Path: "/api/v1/users/login", Summary: "Log in and get a token", Tag: "users", Public: true,
+			Body: LoginRequest{}, Response: Token{}, Errors: []int{http.StatusBadRequest, http.StatusUnauthorized}},
+		{Method: http.MethodGet, Path: "/api/v1/users/{username}", Summary: "Get a user", Tag: "users",
+			Response: domain.User{}},
+		{Method: http.MethodPut, Path: "/api/v1/users/{username}", Summary: "Update a user", Tag: "users",
+			Body: PostUserRequest{}, Response: domain.User{}, Errors: []int{http.StatusBadRequest}},
+		{Method: http.MethodDelete, Path: "/api/v1/users/{username}", Summary: "Delete a user", Tag: "users",
+			Response: Response{}},
+
+		{Method: http.MethodPut, Path: "/api/v1/users/{username}/profile", Summary: "Upload a profile image", Tag: "profile",
+			Upload: true, Status: http.StatusCreated, Response: Response{},
+			Errors: []int{http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity}},
+		{Method: http.MethodGet, Path: "/api/v1/users/{username}/profile", Summary: "Get a signed URL for a profile image", Tag: "profile",
+			Query: []Param{sizeParam}, Response: ProfileURLResponse{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+		{Method: http.MethodDelete, Path: "/api/v1/users/{username}/profile", Summary: "Delete the profile image and its versions", Tag: "profile",
+			Response: Response{}, Errors: []int{http.StatusNotFound}},
+		{Method: http.MethodPut, Path: "/api/v1/users/{username}/profile/visibility", Summary: "Set who can see the profile image", Tag: "profile",
+			Body: ProfileVisibilityRequest{}, Response: domain.User{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+		{Method: http.MethodPost, Path: "/api/v1/users/{username}/profile/upload-url", Summary: "Get a URL to upload a profile image directly", Tag: "profile",
+			Body: ProfileUploadURLRequest{}, Response: domain.PresignedUpload{},
+			Errors: []int{http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusNotImplemented}},
+		{Method: http.MethodPost, Path: "/api/v1/users/{username}/profile/confirm", Summary: "Confirm a direct profile image upload", Tag: "profile",
+			Body: ConfirmProfileUploadRequest{}, Response: domain.User{},
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity}},
+		{Method: http.MethodGet, Path: "/api/v1/users/{username}/profile/versions", Summary: "List profile image versions", Tag: "profile",
+			Response: ProfileVersionsResponse{}, Errors: []int{http.StatusNotFound, http.StatusNotImplemented}},
+		{Method: http.MethodPost, Path: "/api/v1/users/{username}/profile/versions/{versionID}/restore", Summary: "Restore a profile image version", Tag: "profile",
+			Response: domain.User{}, Errors: []int{http.StatusNotFound}},
+	}
+}
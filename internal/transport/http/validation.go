@@ -0,0 +1,341 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const bodyContextKey contextKey = "body"
+
+// FieldError describes why one parameter or body field of a request is invalid
+type FieldError struct {
+	// Field is the parameter name or the body field, e.g. "username". It is
+	// empty when the body as a whole is invalid.
+	Field   string `json:"field,omitempty"`
+	In      string `json:"in"`
+	Message string `json:"message"`
+}
+
+// ValidationErrorResponse is returned for requests that do not match the
+// OpenAPI document
+type ValidationErrorResponse struct {
+	Message string       `json:"message"`
+	Errors  []FieldError `json:"errors"`
+}
+
+// requestValidator checks requests against the operations of the OpenAPI
+// document, keyed by method and OpenAPI path
+type requestValidator struct {
+	operations map[string]Operation
+	schemas    schemaRegistry
+}
+
+func newRequestValidator(h *MainHandler) *requestValidator {
+	doc := h.OpenAPI()
+	v := &requestValidator{
+		operations: make(map[string]Operation),
+		schemas:    schemaRegistry(doc.Components.Schemas),
+	}
+	for _, operation := range h.Operations() {
+		v.operations[operation.Method+" "+operation.Path] = operation
+	}
+	return v
+}
+
+// ValidationMiddleware validates the path parameters, query parameters and
+// JSON body of a request against its documented operation before the
+// handler runs. Invalid requests are rejected with a ValidationErrorResponse
+// listing every invalid field. A valid body is decoded once and handed to the
+// handler through requestBody. Requests to protected operations without a
+// valid bearer token are passed on unchecked, so that JwtAuth refuses them
+// before anything about the request's shape is revealed.
+func (h *MainHandler) ValidationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Routes are mapped after the middleware is added, so the operations
+		// are indexed on the first request
+		h.validatorOnce.Do(func() {
+			h.validator = newRequestValidator(h)
+		})
+
+		rctx, ok := h.matchRoute(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		operation, ok := h.validator.operations[r.Method+" "+OpenAPIPath(rctx.RoutePattern())]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !operation.Public {
+			if _, err := bearerSubject(r, h.PublicKey); err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		var errs []FieldError
+		for _, param := range operation.PathParams {
+			param.Required = true
+			errs = append(errs, validateParam(param, rctx.URLParam(param.Name), "path")...)
+		}
+		query := r.URL.Query()
+		for _, param := range operation.Query {
+			errs = append(errs, validateParam(param, query.Get(param.Name), "query")...)
+		}
+
+		if operation.Body != nil {
+			data, err := io.ReadAll(r.Body)
+			if bodyTooLarge(err) {
+				http.Error(w, "Request body is too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				log.WithContext(r.Context()).Error("Error reading request body: ", err)
+				http.Error(w, "Invalid request", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(data))
+
+			switch {
+			case len(bytes.TrimSpace(data)) == 0:
+				if !operation.BodyOptional {
+					errs = append(errs, FieldError{In: "body", Message: "is required"})
+				}
+			default:
+				bodyErrs := h.validator.validateBody(operation, data)
+				if len(bodyErrs) == 0 && len(errs) == 0 {
+					body := reflect.New(reflect.TypeOf(operation.Body))
+					if err := json.Unmarshal(data, body.Interface()); err != nil {
+						bodyErrs = append(bodyErrs, FieldError{In: "body", Message: err.Error()})
+					} else {
+						r = r.WithContext(context.WithValue(r.Context(), bodyContextKey, body.Elem().Interface()))
+					}
+				}
+				errs = append(errs, bodyErrs...)
+			}
+		}
+
+		if len(errs) > 0 {
+			log.WithContext(r.Context()).Debugf("Request %s %s failed validation: %v", r.Method, r.URL.Path, errs)
+			w.WriteHeader(http.StatusBadRequest)
+			if err := json.NewEncoder(w).Encode(ValidationErrorResponse{Message: "Invalid request", Errors: errs}); err != nil {
+				log.WithContext(r.Context()).Error("Error encoding response: ", err)
+			}
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestBody returns the body the validation middleware decoded for the
+// request, decoding it here if the middleware did not run
+func requestBody[T any](r *http.Request) (T, error) {
+	if body, ok := r.Context().Value(bodyContextKey).(T); ok {
+		return body, nil
+	}
+
+	var body T
+	err := json.NewDecoder(r.Body).Decode(&body)
+	return body, err
+}
+
+// validateParam checks a path or query parameter, which arrives as a string
+func validateParam(param Param, value string, in string) []FieldError {
+	invalid := func(message string) []FieldError {
+		return []FieldError{{Field: param.Name, In: in, Message: message}}
+	}
+
+	if value == "" {
+		if param.Required {
+			return invalid("is required")
+		}
+		return nil
+	}
+
+	var number float64
+	switch param.Type {
+	case "integer":
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return invalid("must be an integer")
+		}
+		number = float64(parsed)
+	case "number":
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return invalid("must be a number")
+		}
+		number = parsed
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return invalid("must be a boolean")
+		}
+		return nil
+	default:
+		return nil
+	}
+
+	if message := checkBounds(&Schema{Minimum: param.Minimum, Maximum: param.Maximum}, number); message != "" {
+		return invalid(message)
+	}
+	return nil
+}
+
+// validateBody checks a JSON body against the schema of the operation's body
+func (v *requestValidator) validateBody(operation Operation, data []byte) []FieldError {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var body any
+	if err := decoder.Decode(&body); err != nil {
+		return []FieldError{{In: "body", Message: "must be valid JSON"}}
+	}
+	if decoder.More() {
+		return []FieldError{{In: "body", Message: "must be a single JSON value"}}
+	}
+
+	var errs []FieldError
+	v.validateValue(v.schemas.schemaOf(reflect.TypeOf(operation.Body)), body, "", true, &errs)
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Field < errs[j].Field
+	})
+	return errs
+}
+
+// validateValue checks a decoded JSON value against schema, appending an
+// error for every invalid field
+func (v *requestValidator) validateValue(schema *Schema, value any, field string, required bool, errs *[]FieldError) {
+	invalid := func(message string) {
+		*errs = append(*errs, FieldError{Field: field, In: "body", Message: message})
+	}
+
+	if schema.Ref != "" {
+		schema = v.schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	if value == nil {
+		if required {
+			invalid("is required")
+		}
+		return
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			invalid("must be an object")
+			return
+		}
+		for _, name := range schema.Required {
+			if _, exists := object[name]; !exists {
+				*errs = append(*errs, FieldError{Field: joinField(field, name), In: "body", Message: "is required"})
+			}
+		}
+		for name, property := range object {
+			propertySchema, known := schema.Properties[name]
+			if !known {
+				switch additional := schema.AdditionalProperties.(type) {
+				case bool:
+					if !additional {
+						*errs = append(*errs, FieldError{Field: joinField(field, name), In: "body", Message: "is not a known field"})
+					}
+				case *Schema:
+					v.validateValue(additional, property, joinField(field, name), false, errs)
+				}
+				continue
+			}
+			v.validateValue(propertySchema, property, joinField(field, name), slices.Contains(schema.Required, name), errs)
+		}
+
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			invalid("must be an array")
+			return
+		}
+		if schema.Items != nil {
+			for i, item := range items {
+				v.validateValue(schema.Items, item, fmt.Sprintf("%s[%d]", field, i), true, errs)
+			}
+		}
+
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			invalid("must be a string")
+			return
+		}
+		if schema.MinLength != nil && utf8.RuneCountInString(text) < *schema.MinLength {
+			if text == "" {
+				invalid("must not be empty")
+			} else {
+				invalid(fmt.Sprintf("must be at least %d characters", *schema.MinLength))
+			}
+			return
+		}
+		// Optional fields may be left empty instead of taking an enum value
+		if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, text) && (text != "" || required) {
+			invalid("must be one of " + strings.Join(schema.Enum, ", "))
+		}
+
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if schema.Type == "integer" {
+			if _, err := number.Int64(); !ok || err != nil {
+				invalid("must be an integer")
+				return
+			}
+		}
+		parsed, err := number.Float64()
+		if !ok || err != nil {
+			invalid("must be a number")
+			return
+		}
+		if message := checkBounds(schema, parsed); message != "" {
+			invalid(message)
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			invalid("must be a boolean")
+		}
+	}
+}
+
+// checkBounds returns why number is outside the schema's bounds, or "" if it is not
+func checkBounds(schema *Schema, number float64) string {
+	format := func(limit float64) string {
+		return strconv.FormatFloat(limit, 'f', -1, 64)
+	}
+
+	switch {
+	case schema.Minimum != nil && number < *schema.Minimum:
+		return "must be at least " + format(*schema.Minimum)
+	case schema.ExclusiveMinimum != nil && number <= *schema.ExclusiveMinimum:
+		return "must be greater than " + format(*schema.ExclusiveMinimum)
+	case schema.Maximum != nil && number > *schema.Maximum:
+		return "must be at most " + format(*schema.Maximum)
+	}
+	return ""
+}
+
+func joinField(parent string, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
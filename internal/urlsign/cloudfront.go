@@ -0,0 +1,133 @@
+package urlsign
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Names of the cookies CloudFront reads a custom policy from
+const (
+	CloudFrontPolicyCookie    = "CloudFront-Policy"
+	CloudFrontSignatureCookie = "CloudFront-Signature"
+	CloudFrontKeyPairIDCookie = "CloudFront-Key-Pair-Id"
+)
+
+// CloudFrontSigner issues CloudFront signed URLs or signed cookies for
+// objects served from the bucket through a distribution. Signing happens
+// locally with the private key of a key pair in the distribution's trusted
+// key group.
+type CloudFrontSigner struct {
+	baseURL   string
+	keyPairID string
+	key       *rsa.PrivateKey
+	ttl       time.Duration
+	cookies   bool
+	now       func() time.Time
+}
+
+// NewCloudFrontSigner initializes a new CloudFrontSigner. baseURL is the
+// address of the distribution, e.g. "https://cdn.example.com". With cookies
+// set, Sign returns plain URLs and access is granted by SignCookies instead.
+// SignURL signs URLs either way.
+func NewCloudFrontSigner(baseURL string, keyPairID string, key *rsa.PrivateKey, ttl time.Duration, cookies bool) *CloudFrontSigner {
+	return &CloudFrontSigner{
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		keyPairID: keyPairID,
+		key:       key,
+		ttl:       ttl,
+		cookies:   cookies,
+		now:       time.Now,
+	}
+}
+
+// Sign returns a URL for key that stays valid for the signer's TTL. The URL
+// uses a canned policy, so CloudFront can cache the object across signatures.
+// With signed cookies, the URL is not signed; see SignURL.
+func (s *CloudFrontSigner) Sign(ctx context.Context, key string) (string, error) {
+	if s.cookies {
+		return s.baseURL + "/" + escapeKey(key), nil
+	}
+	return s.SignURL(ctx, key)
+}
+
+// SignURL returns a signed URL for key even with signed cookies, for clients
+// that hold no cookies covering it, e.g. anyone viewing another user's
+// public profile image
+func (s *CloudFrontSigner) SignURL(ctx context.Context, key string) (string, error) {
+	resource := s.baseURL + "/" + escapeKey(key)
+	expires := s.now().Add(s.ttl).Unix()
+	signature, err := s.sign(policy(resource, expires))
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("Expires", strconv.FormatInt(expires, 10))
+	query.Set("Signature", signature)
+	query.Set("Key-Pair-Id", s.keyPairID)
+
+	return resource + "?" + query.Encode(), nil
+}
+
+// SignCookies returns signed cookies granting access to every object whose
+// key starts with prefix for the signer's TTL
+func (s *CloudFrontSigner) SignCookies(prefix string) ([]*http.Cookie, error) {
+	expiresAt := s.now().Add(s.ttl)
+	document := policy(s.baseURL+"/"+escapeKey(prefix)+"*", expiresAt.Unix())
+
+	signature, err := s.sign(document)
+	if err != nil {
+		return nil, err
+	}
+
+	values := []struct{ name, value string }{
+		{CloudFrontPolicyCookie, cloudFrontEncode([]byte(document))},
+		{CloudFrontSignatureCookie, signature},
+		{CloudFrontKeyPairIDCookie, s.keyPairID},
+	}
+
+	cookies := make([]*http.Cookie, 0, len(values))
+	for _, value := range values {
+		cookies = append(cookies, &http.Cookie{
+			Name:     value.name,
+			Value:    value.value,
+			Path:     "/" + escapeKey(prefix),
+			Expires:  expiresAt,
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return cookies, nil
+}
+
+// sign returns the encoded RSA-SHA1 signature CloudFront expects for a policy
+func (s *CloudFrontSigner) sign(document string) (string, error) {
+	hash := sha1.Sum([]byte(document))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA1, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return cloudFrontEncode(signature), nil
+}
+
+// policy returns a policy document for resource. CloudFront compares canned
+// policies byte for byte, so it must not contain any whitespace.
+func policy(resource string, expires int64) string {
+	return `{"Statement":[{"Resource":"` + resource + `","Condition":{"DateLessThan":{"AWS:EpochTime":` + strconv.FormatInt(expires, 10) + `}}}]}`
+}
+
+// cloudFrontEncode base64-encodes data and replaces the characters that are
+// invalid in query strings and cookies the way CloudFront expects
+func cloudFrontEncode(data []byte) string {
+	return strings.NewReplacer("+", "-", "=", "_", "/", "~").Replace(base64.StdEncoding.EncodeToString(data))
+}
@@ -0,0 +1,39 @@
+package urlsign
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3Signer issues S3 presigned GET URLs. They point straight at the bucket
+// and cannot be cached by a CDN.
+type S3Signer struct {
+	client     *s3.PresignClient
+	bucketName string
+	ttl        time.Duration
+}
+
+// NewS3Signer initializes a new S3Signer. S3 rejects presigned URLs that
+// are valid for longer than seven days.
+func NewS3Signer(client *s3.Client, bucketName string, ttl time.Duration) *S3Signer {
+	return &S3Signer{
+		client:     s3.NewPresignClient(client),
+		bucketName: bucketName,
+		ttl:        ttl,
+	}
+}
+
+// Sign returns a presigned URL for key that stays valid for the signer's TTL
+func (s *S3Signer) Sign(ctx context.Context, key string) (string, error) {
+	request, err := s.client.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(s.ttl))
+	if err != nil {
+		return "", err
+	}
+	return request.URL, nil
+}
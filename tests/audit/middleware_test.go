@@ -0,0 +1,41 @@
+package audit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/memory"
+	"github.com/zzenonn/go-zenon-api-aws/internal/service"
+	handlers "github.com/zzenonn/go-zenon-api-aws/internal/transport/http"
+)
+
+func TestAuditRecordsClientBehindProxies(t *testing.T) {
+	repo := memory.NewAuditRepository()
+	mainHandler := handlers.NewMainHandler(&config.Config{TrustedProxies: 1})
+	mainHandler.Auditor = service.NewAuditService(&repo, nil)
+	mainHandler.MapRoutes()
+	mainHandler.Router.Get("/secret", handlers.JwtAuth(func(w http.ResponseWriter, r *http.Request) {}, nil))
+
+	server := httptest.NewServer(mainHandler.Router)
+	defer server.Close()
+
+	// The entry before the one added by the trusted proxy is forged
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/secret", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.7")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	events, _, err := repo.ListEvents(context.Background(), domain.AuditFilter{Action: domain.AuditAccessDenied}, 0, "")
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "198.51.100.7", events[0].ClientIP)
+}
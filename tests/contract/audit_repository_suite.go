@@ -0,0 +1,146 @@
+package contract
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+	"github.com/zzenonn/go-zenon-api-aws/internal/service"
+)
+
+// AuditRepositoryFactory returns an empty repository for a single test
+type AuditRepositoryFactory func(t *testing.T) service.AuditRepository
+
+// RunAuditRepositoryTests checks that a service.AuditRepository
+// implementation behaves like every other backend
+func RunAuditRepositoryTests(t *testing.T, newRepo AuditRepositoryFactory) {
+	t.Run("AppendChains", func(t *testing.T) { testAuditAppendChains(t, newRepo(t)) })
+	t.Run("ListFilterAndPages", func(t *testing.T) { testAuditListFilterAndPages(t, newRepo(t)) })
+	t.Run("InvalidPageToken", func(t *testing.T) { testAuditInvalidPageToken(t, newRepo(t)) })
+	t.Run("ConcurrentAppends", func(t *testing.T) { testAuditConcurrentAppends(t, newRepo(t)) })
+}
+
+func newAuditEvent(actor string, action string) domain.AuditEvent {
+	return domain.AuditEvent{
+		Time:    time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC),
+		Actor:   actor,
+		Action:  action,
+		Target:  "alice",
+		Outcome: domain.AuditSuccess,
+	}
+}
+
+func testAuditAppendChains(t *testing.T, repo service.AuditRepository) {
+	ctx := context.Background()
+
+	first, err := repo.AppendEvent(ctx, newAuditEvent("root", domain.AuditUserCreate))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), first.Sequence)
+	assert.Equal(t, domain.AuditGenesisHash, first.PrevHash)
+
+	second, err := repo.AppendEvent(ctx, newAuditEvent("root", domain.AuditUserDelete))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), second.Sequence)
+	assert.Equal(t, first.Hash, second.PrevHash)
+
+	// Events read back hash to what was stored
+	events, err := repo.ChainEvents(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, first, events[0])
+	assert.Equal(t, events[0].Hash, events[0].ComputeHash())
+	assert.Equal(t, events[1].Hash, events[1].ComputeHash())
+
+	events, err = repo.ChainEvents(ctx, 1, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, second, events[0])
+
+	events, err = repo.ChainEvents(ctx, 0, 1)
+	require.NoError(t, err)
+	assert.Len(t, events, 1)
+
+	events, err = repo.ChainEvents(ctx, 2, 10)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+}
+
+func testAuditListFilterAndPages(t *testing.T, repo service.AuditRepository) {
+	ctx := context.Background()
+
+	for i := range 7 {
+		actor := "root"
+		if i%2 == 1 {
+			actor = "bob"
+		}
+		_, err := repo.AppendEvent(ctx, newAuditEvent(actor, domain.AuditLogin))
+		require.NoError(t, err)
+	}
+
+	// Filtered pages may be short, so read until there is no next page
+	var sequences []int64
+	token := ""
+	for {
+		events, next, err := repo.ListEvents(ctx, domain.AuditFilter{Actor: "root", Action: domain.AuditLogin}, 2, token)
+		require.NoError(t, err)
+		for _, event := range events {
+			assert.Equal(t, "root", event.Actor)
+			sequences = append(sequences, event.Sequence)
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	assert.Equal(t, []int64{7, 5, 3, 1}, sequences)
+
+	events, next, err := repo.ListEvents(ctx, domain.AuditFilter{Outcome: domain.AuditFailure}, 0, "")
+	require.NoError(t, err)
+	assert.Empty(t, events)
+	assert.Empty(t, next)
+}
+
+func testAuditInvalidPageToken(t *testing.T, repo service.AuditRepository) {
+	_, _, err := repo.ListEvents(context.Background(), domain.AuditFilter{}, 10, "not a token")
+	assert.ErrorIs(t, err, errors.ErrInvalidPageToken)
+}
+
+func testAuditConcurrentAppends(t *testing.T, repo service.AuditRepository) {
+	ctx := context.Background()
+
+	// Concurrent writers must not fork the chain, and every event is
+	// appended however many writers contend for the head
+	const writers = 20
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.AppendEvent(ctx, newAuditEvent(fmt.Sprintf("writer-%d", i), domain.AuditLogin))
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	events, err := repo.ChainEvents(ctx, 0, 2*writers)
+	require.NoError(t, err)
+	require.Len(t, events, writers)
+
+	actors := make(map[string]bool)
+	prevHash := domain.AuditGenesisHash
+	for i, event := range events {
+		assert.Equal(t, int64(i+1), event.Sequence)
+		assert.Equal(t, prevHash, event.PrevHash)
+		prevHash = event.Hash
+		actors[event.Actor] = true
+	}
+	for i := range writers {
+		assert.True(t, actors[fmt.Sprintf("writer-%d", i)], "event of writer %d is missing", i)
+	}
+}
@@ -0,0 +1,315 @@
+package contract
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/idempotency"
+	"github.com/zzenonn/go-zenon-api-aws/internal/ratelimit"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/db"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/filesystem"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/memory"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/migrate"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/objectstore"
+	"github.com/zzenonn/go-zenon-api-aws/internal/service"
+	"github.com/zzenonn/go-zenon-api-aws/internal/urlsign"
+)
+
+// Backends that need a running service are only tested when their endpoint is
+// set, e.g. DYNAMODB_ENDPOINT=http://localhost:8000 for DynamoDB Local and
+// S3_ENDPOINT=http://localhost:9000 for MinIO (see docker-compose.yaml).
+const (
+	dynamoDBEndpointEnv = "DYNAMODB_ENDPOINT"
+	s3EndpointEnv       = "S3_ENDPOINT"
+)
+
+func TestMemoryUserRepository(t *testing.T) {
+	RunUserRepositoryTests(t, func(t *testing.T) service.UserRepository {
+		repo := memory.NewUserRepository()
+		return &repo
+	})
+}
+
+func TestMemoryFileRepository(t *testing.T) {
+	RunFileRepositoryTests(t, func(t *testing.T) service.FileRepository {
+		repo := memory.NewFileRepository()
+		return &repo
+	})
+}
+
+func TestMemoryAuditRepository(t *testing.T) {
+	RunAuditRepositoryTests(t, func(t *testing.T) service.AuditRepository {
+		repo := memory.NewAuditRepository()
+		return &repo
+	})
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	RunRateLimitStoreTests(t, func(t *testing.T) ratelimit.Store {
+		repo := memory.NewRateLimitRepository()
+		return &repo
+	})
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	RunIdempotencyStoreTests(t, func(t *testing.T) idempotency.Store {
+		repo := memory.NewIdempotencyRepository()
+		return &repo
+	})
+}
+
+func TestMemoryObjectRepository(t *testing.T) {
+	RunObjectRepositoryTests(t, func(t *testing.T) service.ObjectRepository {
+		repo := memory.NewObjectRepository()
+		return &repo
+	})
+}
+
+func TestFilesystemObjectRepository(t *testing.T) {
+	RunObjectRepositoryTests(t, func(t *testing.T) service.ObjectRepository {
+		signer := urlsign.NewHMACSigner([]byte("contract-secret"), "http://localhost:8080", time.Minute)
+		repo, err := filesystem.NewObjectRepository(t.TempDir(), signer)
+		require.NoError(t, err)
+		return &repo
+	})
+}
+
+func TestDynamoDBUserRepository(t *testing.T) {
+	endpoint := os.Getenv(dynamoDBEndpointEnv)
+	if endpoint == "" {
+		t.Skipf("%s is not set", dynamoDBEndpointEnv)
+	}
+
+	client := dynamodb.NewFromConfig(localAwsConfig(t), func(o *dynamodb.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+	})
+
+	RunUserRepositoryTests(t, func(t *testing.T) service.UserRepository {
+		ctx := context.Background()
+		tableName := fmt.Sprintf("contract-users-%d", time.Now().UnixNano())
+
+		// Create the table with the real migration so its schema is tested too
+		migration := migrate.NewCreateUsersTable(tableName, migrate.TableSettings{
+			BillingMode: types.BillingModePayPerRequest,
+			TableClass:  types.TableClassStandard,
+		})
+		require.NoError(t, migration.Up(ctx, client))
+		t.Cleanup(func() {
+			if err := migration.Down(context.Background(), client); err != nil {
+				t.Logf("failed to delete table %s: %v", tableName, err)
+			}
+		})
+
+		// The migration seeds the admin user; remove it so every backend starts empty
+		repo := db.NewUserRepository(client, tableName)
+		require.NoError(t, repo.DeleteUser(ctx, "admin"))
+
+		return &repo
+	})
+}
+
+func TestDynamoDBFileRepository(t *testing.T) {
+	endpoint := os.Getenv(dynamoDBEndpointEnv)
+	if endpoint == "" {
+		t.Skipf("%s is not set", dynamoDBEndpointEnv)
+	}
+
+	client := dynamodb.NewFromConfig(localAwsConfig(t), func(o *dynamodb.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+	})
+
+	RunFileRepositoryTests(t, func(t *testing.T) service.FileRepository {
+		tableName := fmt.Sprintf("contract-files-%d", time.Now().UnixNano())
+
+		migration := migrate.NewCreateFilesTable(tableName, migrate.TableSettings{
+			BillingMode: types.BillingModePayPerRequest,
+			TableClass:  types.TableClassStandard,
+		})
+		require.NoError(t, migration.Up(context.Background(), client))
+		t.Cleanup(func() {
+			if err := migration.Down(context.Background(), client); err != nil {
+				t.Logf("failed to delete table %s: %v", tableName, err)
+			}
+		})
+
+		repo := db.NewFileRepository(client, tableName)
+		return &repo
+	})
+}
+
+func TestDynamoDBAuditRepository(t *testing.T) {
+	endpoint := os.Getenv(dynamoDBEndpointEnv)
+	if endpoint == "" {
+		t.Skipf("%s is not set", dynamoDBEndpointEnv)
+	}
+
+	client := dynamodb.NewFromConfig(localAwsConfig(t), func(o *dynamodb.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+	})
+
+	RunAuditRepositoryTests(t, func(t *testing.T) service.AuditRepository {
+		tableName := fmt.Sprintf("contract-audit-%d", time.Now().UnixNano())
+
+		migration := migrate.NewCreateAuditTable(tableName, migrate.TableSettings{
+			BillingMode: types.BillingModePayPerRequest,
+			TableClass:  types.TableClassStandard,
+		})
+		require.NoError(t, migration.Up(context.Background(), client))
+		t.Cleanup(func() {
+			if err := migration.Down(context.Background(), client); err != nil {
+				t.Logf("failed to delete table %s: %v", tableName, err)
+			}
+		})
+
+		repo := db.NewAuditRepository(client, tableName)
+		return &repo
+	})
+}
+
+func TestDynamoDBRateLimitStore(t *testing.T) {
+	endpoint := os.Getenv(dynamoDBEndpointEnv)
+	if endpoint == "" {
+		t.Skipf("%s is not set", dynamoDBEndpointEnv)
+	}
+
+	client := dynamodb.NewFromConfig(localAwsConfig(t), func(o *dynamodb.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+	})
+
+	RunRateLimitStoreTests(t, func(t *testing.T) ratelimit.Store {
+		tableName := fmt.Sprintf("contract-rate-limits-%d", time.Now().UnixNano())
+
+		migration := migrate.NewCreateRateLimitsTable(tableName, migrate.TableSettings{
+			BillingMode: types.BillingModePayPerRequest,
+			TableClass:  types.TableClassStandard,
+		})
+		require.NoError(t, migration.Up(context.Background(), client))
+		t.Cleanup(func() {
+			if err := migration.Down(context.Background(), client); err != nil {
+				t.Logf("failed to delete table %s: %v", tableName, err)
+			}
+		})
+
+		repo := db.NewRateLimitRepository(client, tableName)
+		return &repo
+	})
+}
+
+func TestS3ObjectRepository(t *testing.T) {
+	endpoint := os.Getenv(s3EndpointEnv)
+	if endpoint == "" {
+		t.Skipf("%s is not set", s3EndpointEnv)
+	}
+
+	client := s3.NewFromConfig(localAwsConfig(t), func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+		o.UsePathStyle = true
+	})
+
+	RunObjectRepositoryTests(t, func(t *testing.T) service.ObjectRepository {
+		ctx := context.Background()
+		bucketName := fmt.Sprintf("contract-profiles-%d", time.Now().UnixNano())
+
+		_, err := client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(bucketName)})
+		require.NoError(t, err)
+		t.Cleanup(func() { deleteBucket(t, client, bucketName) })
+
+		repo := objectstore.NewObjectRepository(client, bucketName, urlsign.NewS3Signer(client, bucketName, time.Minute))
+		return &repo
+	})
+}
+
+func TestS3DirectUpload(t *testing.T) {
+	endpoint := os.Getenv(s3EndpointEnv)
+	if endpoint == "" {
+		t.Skipf("%s is not set", s3EndpointEnv)
+	}
+
+	client := s3.NewFromConfig(localAwsConfig(t), func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+		o.UsePathStyle = true
+	})
+
+	RunDirectUploadTests(t, func(t *testing.T) DirectUploadStore {
+		ctx := context.Background()
+		bucketName := fmt.Sprintf("contract-uploads-%d", time.Now().UnixNano())
+
+		_, err := client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(bucketName)})
+		require.NoError(t, err)
+		t.Cleanup(func() { deleteBucket(t, client, bucketName) })
+
+		repo := objectstore.NewObjectRepository(client, bucketName, urlsign.NewS3Signer(client, bucketName, time.Minute))
+		return &repo
+	})
+}
+
+// localAwsConfig returns an AWS config with static credentials matching the
+// MinIO root user in docker-compose.yaml; DynamoDB Local accepts any credentials
+func localAwsConfig(t *testing.T) aws.Config {
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(),
+		awsconfig.WithRegion("us-east-1"),
+		awsconfig.WithCredentialsProvider(aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "local", SecretAccessKey: "localsecret", Source: "contract-tests"}, nil
+		})),
+	)
+	require.NoError(t, err)
+	return cfg
+}
+
+func deleteBucket(t *testing.T, client *s3.Client, bucketName string) {
+	ctx := context.Background()
+
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{Bucket: aws.String(bucketName)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			t.Logf("failed to list bucket %s: %v", bucketName, err)
+			return
+		}
+		for _, object := range page.Contents {
+			client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucketName), Key: object.Key})
+		}
+	}
+
+	if _, err := client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(bucketName)}); err != nil {
+		t.Logf("failed to delete bucket %s: %v", bucketName, err)
+	}
+}
+
+func TestDynamoDBIdempotencyStore(t *testing.T) {
+	endpoint := os.Getenv(dynamoDBEndpointEnv)
+	if endpoint == "" {
+		t.Skipf("%s is not set", dynamoDBEndpointEnv)
+	}
+
+	client := dynamodb.NewFromConfig(localAwsConfig(t), func(o *dynamodb.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+	})
+
+	RunIdempotencyStoreTests(t, func(t *testing.T) idempotency.Store {
+		tableName := fmt.Sprintf("contract-idempotency-%d", time.Now().UnixNano())
+
+		migration := migrate.NewCreateIdempotencyTable(tableName, migrate.TableSettings{
+			BillingMode: types.BillingModePayPerRequest,
+			TableClass:  types.TableClassStandard,
+		})
+		require.NoError(t, migration.Up(context.Background(), client))
+		t.Cleanup(func() {
+			if err := migration.Down(context.Background(), client); err != nil {
+				t.Logf("failed to delete table %s: %v", tableName, err)
+			}
+		})
+
+		repo := db.NewIdempotencyRepository(client, tableName)
+		return &repo
+	})
+}
@@ -0,0 +1,168 @@
+package contract
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+	"github.com/zzenonn/go-zenon-api-aws/internal/service"
+)
+
+// DirectUploadStore is an object store that clients can upload to directly
+type DirectUploadStore interface {
+	service.ObjectRepository
+	service.DirectUploadRepository
+}
+
+// DirectUploadStoreFactory returns an empty store for a single test
+type DirectUploadStoreFactory func(t *testing.T) DirectUploadStore
+
+// RunDirectUploadTests checks that the requests presigned by a store are
+// accepted by the store itself, and only within what was signed
+func RunDirectUploadTests(t *testing.T, newStore DirectUploadStoreFactory) {
+	t.Run("PresignedPut", func(t *testing.T) { testPresignedPut(t, newStore(t)) })
+	t.Run("PresignedPutRequiresSignedHeaders", func(t *testing.T) { testPresignedPutRequiresSignedHeaders(t, newStore(t)) })
+	t.Run("PresignedPost", func(t *testing.T) { testPresignedPost(t, newStore(t)) })
+	t.Run("PresignedPostEnforcesPolicy", func(t *testing.T) { testPresignedPostEnforcesPolicy(t, newStore(t)) })
+}
+
+// putPresigned sends data to a presigned PUT request with the given content type
+func putPresigned(t *testing.T, upload domain.PresignedUpload, contentType string, data []byte) int {
+	req, err := http.NewRequest(upload.Method, upload.URL, bytes.NewReader(data))
+	require.NoError(t, err)
+	for name, value := range upload.Headers {
+		if name != "Content-Length" {
+			req.Header.Set(name, value)
+		}
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode
+}
+
+// postPresigned sends data as the file of a presigned POST form. The fields
+// are overridden by those in overrides.
+func postPresigned(t *testing.T, upload domain.PresignedUpload, overrides map[string]string, data []byte) int {
+	fields := make(map[string]string)
+	for name, value := range upload.Fields {
+		fields[name] = value
+	}
+	for name, value := range overrides {
+		fields[name] = value
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, name := range names {
+		require.NoError(t, writer.WriteField(name, fields[name]))
+	}
+	// The file has to be the last field of the form
+	file, err := writer.CreateFormFile("file", "upload")
+	require.NoError(t, err)
+	file.Write(data)
+	require.NoError(t, writer.Close())
+
+	resp, err := http.Post(upload.URL, writer.FormDataContentType(), &body)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode
+}
+
+// assertStored checks that key holds data with the given content type
+func assertStored(t *testing.T, store DirectUploadStore, key string, contentType string, data []byte) {
+	ctx := context.Background()
+
+	info, err := store.Stat(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), info.Size)
+	assert.Equal(t, contentType, info.ContentType)
+
+	body, err := store.Download(ctx, key)
+	require.NoError(t, err)
+	defer body.Close()
+	stored, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, data, stored)
+}
+
+func testPresignedPut(t *testing.T, store DirectUploadStore) {
+	ctx := context.Background()
+	key := "pending/contract-user/profile/put.png"
+	data := []byte("image data")
+
+	upload, err := store.PresignUpload(ctx, key, domain.UploadMethodPut, "image/png", int64(len(data)), 1<<20, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, domain.UploadMethodPut, upload.Method)
+	assert.Equal(t, key, upload.Key)
+
+	status := putPresigned(t, upload, "image/png", data)
+	require.Equal(t, http.StatusOK, status)
+	assertStored(t, store, key, "image/png", data)
+}
+
+func testPresignedPutRequiresSignedHeaders(t *testing.T, store DirectUploadStore) {
+	ctx := context.Background()
+	key := "pending/contract-user/profile/put-rejected.png"
+	data := []byte("image data")
+
+	upload, err := store.PresignUpload(ctx, key, domain.UploadMethodPut, "image/png", int64(len(data)), 1<<20, time.Minute)
+	require.NoError(t, err)
+
+	// Neither another content type nor another size was signed
+	assert.GreaterOrEqual(t, putPresigned(t, upload, "text/html", data), http.StatusBadRequest)
+	assert.GreaterOrEqual(t, putPresigned(t, upload, "image/png", append(data, " and more"...)), http.StatusBadRequest)
+
+	_, err = store.Stat(ctx, key)
+	assert.ErrorIs(t, err, errors.ErrObjectNotFound)
+}
+
+func testPresignedPost(t *testing.T, store DirectUploadStore) {
+	ctx := context.Background()
+	key := "pending/contract-user/profile/post.jpg"
+	data := []byte("image data")
+
+	upload, err := store.PresignUpload(ctx, key, domain.UploadMethodPost, "image/jpeg", 0, 1<<20, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, domain.UploadMethodPost, upload.Method)
+	assert.Equal(t, key, upload.Key)
+	assert.Equal(t, "image/jpeg", upload.Fields["Content-Type"])
+
+	status := postPresigned(t, upload, nil, data)
+	require.Less(t, status, http.StatusMultipleChoices)
+	assertStored(t, store, key, "image/jpeg", data)
+}
+
+func testPresignedPostEnforcesPolicy(t *testing.T, store DirectUploadStore) {
+	ctx := context.Background()
+	key := "pending/contract-user/profile/post-rejected.jpg"
+
+	upload, err := store.PresignUpload(ctx, key, domain.UploadMethodPost, "image/jpeg", 0, 16, time.Minute)
+	require.NoError(t, err)
+
+	// Files over the size limit, empty files and other content types are refused
+	assert.GreaterOrEqual(t, postPresigned(t, upload, nil, bytes.Repeat([]byte("x"), 17)), http.StatusBadRequest)
+	assert.GreaterOrEqual(t, postPresigned(t, upload, nil, nil), http.StatusBadRequest)
+	assert.GreaterOrEqual(t, postPresigned(t, upload, map[string]string{"Content-Type": "text/html"}, []byte("image data")), http.StatusBadRequest)
+
+	_, err = store.Stat(ctx, key)
+	assert.ErrorIs(t, err, errors.ErrObjectNotFound)
+}
@@ -0,0 +1,311 @@
+package contract
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+	"github.com/zzenonn/go-zenon-api-aws/internal/service"
+)
+
+// FileRepositoryFactory returns an empty repository for a single test
+type FileRepositoryFactory func(t *testing.T) service.FileRepository
+
+// RunFileRepositoryTests checks that a service.FileRepository implementation
+// behaves like every other backend
+func RunFileRepositoryTests(t *testing.T, newRepo FileRepositoryFactory) {
+	t.Run("CreateThenGet", func(t *testing.T) { testFileCreateThenGet(t, newRepo(t)) })
+	t.Run("GetMissing", func(t *testing.T) { testFileGetMissing(t, newRepo(t)) })
+	t.Run("Quota", func(t *testing.T) { testFileQuota(t, newRepo(t)) })
+	t.Run("DeleteReleasesQuota", func(t *testing.T) { testFileDeleteReleasesQuota(t, newRepo(t)) })
+	t.Run("Pagination", func(t *testing.T) { testFilePagination(t, newRepo(t)) })
+	t.Run("UploadLifecycle", func(t *testing.T) { testUploadLifecycle(t, newRepo(t)) })
+	t.Run("UploadQuota", func(t *testing.T) { testUploadQuota(t, newRepo(t)) })
+	t.Run("StaleUploads", func(t *testing.T) { testStaleUploads(t, newRepo(t)) })
+	t.Run("AssembledUploads", func(t *testing.T) { testAssembledUploads(t, newRepo(t)) })
+	t.Run("BlobReferences", func(t *testing.T) { testBlobReferences(t, newRepo(t)) })
+	t.Run("BlobClaim", func(t *testing.T) { testBlobClaim(t, newRepo(t)) })
+}
+
+func newFile(username string, id string, size int64) domain.File {
+	return domain.File{
+		Username:    username,
+		ID:          id,
+		Name:        id + ".txt",
+		Size:        size,
+		ContentType: "text/plain",
+		Checksum:    "checksum-of-" + id,
+		Key:         username + "/files/" + id + ".txt",
+		CreatedAt:   time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func newUpload(username string, id string, size int64, createdAt time.Time) domain.MultipartUpload {
+	return domain.MultipartUpload{
+		Username:    username,
+		ID:          id,
+		UploadID:    "s3-" + id,
+		Name:        id + ".bin",
+		Size:        size,
+		ContentType: "application/octet-stream",
+		PartSize:    size,
+		PartCount:   1,
+		Key:         username + "/files/" + id + ".bin",
+		CreatedAt:   createdAt,
+	}
+}
+
+func testFileCreateThenGet(t *testing.T, repo service.FileRepository) {
+	ctx := context.Background()
+
+	file := newFile("contract-files", "a1", 10)
+	require.NoError(t, repo.CreateFile(ctx, file, 0))
+
+	got, err := repo.GetFile(ctx, "contract-files", "a1")
+	require.NoError(t, err)
+	assert.Equal(t, file, got)
+
+	// Files are scoped to their owner
+	_, err = repo.GetFile(ctx, "contract-other", "a1")
+	assert.ErrorIs(t, err, errors.ErrFileNotFound)
+}
+
+func testFileGetMissing(t *testing.T, repo service.FileRepository) {
+	_, err := repo.GetFile(context.Background(), "contract-files", "missing")
+	assert.ErrorIs(t, err, errors.ErrFileNotFound)
+
+	assert.ErrorIs(t, repo.DeleteFile(context.Background(), "contract-files", "missing"), errors.ErrFileNotFound)
+}
+
+func testFileQuota(t *testing.T, repo service.FileRepository) {
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreateFile(ctx, newFile("contract-quota", "a1", 60), 100))
+	require.NoError(t, repo.CreateFile(ctx, newFile("contract-quota", "a2", 40), 100))
+	assert.ErrorIs(t, repo.CreateFile(ctx, newFile("contract-quota", "a3", 1), 100), errors.ErrQuotaExceeded)
+
+	// A rejected file is not recorded
+	_, err := repo.GetFile(ctx, "contract-quota", "a3")
+	assert.ErrorIs(t, err, errors.ErrFileNotFound)
+
+	used, err := repo.GetUsage(ctx, "contract-quota")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), used)
+
+	// Quotas are per user
+	assert.NoError(t, repo.CreateFile(ctx, newFile("contract-quota-other", "a1", 100), 100))
+}
+
+func testFileDeleteReleasesQuota(t *testing.T, repo service.FileRepository) {
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreateFile(ctx, newFile("contract-release", "a1", 80), 100))
+	require.NoError(t, repo.DeleteFile(ctx, "contract-release", "a1"))
+
+	used, err := repo.GetUsage(ctx, "contract-release")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), used)
+
+	assert.NoError(t, repo.CreateFile(ctx, newFile("contract-release", "a2", 100), 100))
+}
+
+func testFilePagination(t *testing.T, repo service.FileRepository) {
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, repo.CreateFile(ctx, newFile("contract-page", fmt.Sprintf("f%d", i), 1), 0))
+	}
+
+	seen := map[string]bool{}
+	token := ""
+	for pages := 0; pages < 10; pages++ {
+		files, next, err := repo.ListFiles(ctx, "contract-page", 2, token)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(files), 2)
+
+		for _, file := range files {
+			assert.False(t, seen[file.ID], "file %s listed twice", file.ID)
+			seen[file.ID] = true
+		}
+
+		if next == "" {
+			break
+		}
+		token = next
+	}
+
+	assert.Len(t, seen, 5)
+
+	_, _, err := repo.ListFiles(ctx, "contract-page", 2, "not a token!")
+	assert.ErrorIs(t, err, errors.ErrInvalidPageToken)
+}
+
+func testUploadLifecycle(t *testing.T, repo service.FileRepository) {
+	ctx := context.Background()
+
+	upload := newUpload("contract-uploads", "u1", 30, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	require.NoError(t, repo.CreateUpload(ctx, upload, 0))
+
+	got, err := repo.GetUpload(ctx, "contract-uploads", "u1")
+	require.NoError(t, err)
+	assert.Equal(t, upload, got)
+
+	// Uploads are not listed as files until completed
+	files, _, err := repo.ListFiles(ctx, "contract-uploads", 10, "")
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	file := newFile("contract-uploads", "u1", 30)
+	require.NoError(t, repo.CompleteUpload(ctx, file))
+
+	_, err = repo.GetUpload(ctx, "contract-uploads", "u1")
+	assert.ErrorIs(t, err, errors.ErrUploadNotFound)
+
+	gotFile, err := repo.GetFile(ctx, "contract-uploads", "u1")
+	require.NoError(t, err)
+	assert.Equal(t, file, gotFile)
+
+	// The reservation carries over to the file
+	used, err := repo.GetUsage(ctx, "contract-uploads")
+	require.NoError(t, err)
+	assert.Equal(t, int64(30), used)
+
+	assert.ErrorIs(t, repo.CompleteUpload(ctx, file), errors.ErrUploadNotFound)
+	assert.ErrorIs(t, repo.DeleteUpload(ctx, "contract-uploads", "u1"), errors.ErrUploadNotFound)
+}
+
+func testUploadQuota(t *testing.T, repo service.FileRepository) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	require.NoError(t, repo.CreateFile(ctx, newFile("contract-upload-quota", "a1", 40), 100))
+	require.NoError(t, repo.CreateUpload(ctx, newUpload("contract-upload-quota", "u1", 60, now), 100))
+	assert.ErrorIs(t, repo.CreateUpload(ctx, newUpload("contract-upload-quota", "u2", 1, now), 100), errors.ErrQuotaExceeded)
+	assert.ErrorIs(t, repo.CreateFile(ctx, newFile("contract-upload-quota", "a2", 1), 100), errors.ErrQuotaExceeded)
+
+	// Aborting releases the reservation
+	require.NoError(t, repo.DeleteUpload(ctx, "contract-upload-quota", "u1"))
+
+	used, err := repo.GetUsage(ctx, "contract-upload-quota")
+	require.NoError(t, err)
+	assert.Equal(t, int64(40), used)
+}
+
+func testStaleUploads(t *testing.T, repo service.FileRepository) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	require.NoError(t, repo.CreateUpload(ctx, newUpload("contract-stale", "old", 1, now.Add(-48*time.Hour)), 0))
+	require.NoError(t, repo.CreateUpload(ctx, newUpload("contract-stale", "new", 1, now), 0))
+
+	stale, err := repo.ListStaleUploads(ctx, now.Add(-24*time.Hour))
+	require.NoError(t, err)
+
+	// Other tests may share a DynamoDB table, so only look at this user
+	var ids []string
+	for _, upload := range stale {
+		if upload.Username == "contract-stale" {
+			ids = append(ids, upload.ID)
+		}
+	}
+	assert.Equal(t, []string{"old"}, ids)
+}
+
+func testAssembledUploads(t *testing.T, repo service.FileRepository) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	require.NoError(t, repo.CreateUpload(ctx, newUpload("contract-assembled", "old", 1, now), 0))
+	require.NoError(t, repo.CreateUpload(ctx, newUpload("contract-assembled", "new", 1, now), 0))
+	require.NoError(t, repo.CreateUpload(ctx, newUpload("contract-assembled", "parts", 1, now), 0))
+
+	require.NoError(t, repo.MarkUploadAssembled(ctx, "contract-assembled", "old", "checksum-of-old", now.Add(-2*time.Hour)))
+	require.NoError(t, repo.MarkUploadAssembled(ctx, "contract-assembled", "new", "", now))
+	assert.ErrorIs(t, repo.MarkUploadAssembled(ctx, "contract-assembled", "missing", "", now), errors.ErrUploadNotFound)
+
+	got, err := repo.GetUpload(ctx, "contract-assembled", "old")
+	require.NoError(t, err)
+	require.NotNil(t, got.AssembledAt)
+	assert.True(t, now.Add(-2*time.Hour).Equal(*got.AssembledAt))
+	assert.Equal(t, "checksum-of-old", got.Checksum)
+
+	assembled, err := repo.ListAssembledUploads(ctx, now.Add(-time.Hour))
+	require.NoError(t, err)
+
+	// Other tests may share a DynamoDB table, so only look at this user
+	var ids []string
+	for _, upload := range assembled {
+		if upload.Username == "contract-assembled" {
+			ids = append(ids, upload.ID)
+		}
+	}
+	assert.Equal(t, []string{"old"}, ids)
+}
+
+func testBlobReferences(t *testing.T, repo service.FileRepository) {
+	ctx := context.Background()
+	checksum := "contract-blob-references"
+
+	// The first reference creates the blob with the proposed key
+	blob, err := repo.AcquireBlob(ctx, checksum, "blobs/first")
+	require.NoError(t, err)
+	assert.Equal(t, "blobs/first", blob.Key)
+	assert.Equal(t, int64(0), blob.Refs)
+	assert.False(t, blob.Stored)
+	require.NoError(t, repo.MarkBlobStored(ctx, checksum))
+
+	// Later references share the existing object
+	blob, err = repo.AcquireBlob(ctx, checksum, "blobs/second")
+	require.NoError(t, err)
+	assert.Equal(t, "blobs/first", blob.Key)
+	assert.Equal(t, int64(1), blob.Refs)
+	assert.True(t, blob.Stored)
+
+	require.NoError(t, repo.ReleaseBlob(ctx, checksum))
+	require.NoError(t, repo.ReleaseBlob(ctx, checksum))
+
+	unreferenced, err := repo.ListUnreferencedBlobs(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Contains(t, blobChecksums(unreferenced), checksum)
+
+	// Blobs released within the grace period are kept
+	unreferenced, err = repo.ListUnreferencedBlobs(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.NotContains(t, blobChecksums(unreferenced), checksum)
+
+	assert.ErrorIs(t, repo.ReleaseBlob(ctx, "contract-missing-blob"), errors.ErrBlobNotFound)
+}
+
+func testBlobClaim(t *testing.T, repo service.FileRepository) {
+	ctx := context.Background()
+	checksum := "contract-blob-claim"
+
+	_, err := repo.AcquireBlob(ctx, checksum, "blobs/claim")
+	require.NoError(t, err)
+	assert.ErrorIs(t, repo.ClaimBlob(ctx, checksum), errors.ErrBlobInUse)
+
+	require.NoError(t, repo.ReleaseBlob(ctx, checksum))
+	require.NoError(t, repo.ClaimBlob(ctx, checksum))
+
+	// A claimed blob is being deleted and cannot be shared again
+	_, err = repo.AcquireBlob(ctx, checksum, "blobs/claim")
+	assert.ErrorIs(t, err, errors.ErrBlobDeleted)
+
+	require.NoError(t, repo.DeleteBlob(ctx, checksum))
+	blob, err := repo.AcquireBlob(ctx, checksum, "blobs/claim-again")
+	require.NoError(t, err)
+	assert.Equal(t, "blobs/claim-again", blob.Key)
+}
+
+func blobChecksums(blobs []domain.Blob) []string {
+	checksums := make([]string, 0, len(blobs))
+	for _, blob := range blobs {
+		checksums = append(checksums, blob.Checksum)
+	}
+	return checksums
+}
@@ -0,0 +1,73 @@
+package contract
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/filesystem"
+	handlers "github.com/zzenonn/go-zenon-api-aws/internal/transport/http"
+	"github.com/zzenonn/go-zenon-api-aws/internal/urlsign"
+)
+
+func TestFilesystemRejectsPathTraversal(t *testing.T) {
+	dir := t.TempDir()
+	signer := urlsign.NewHMACSigner([]byte("secret"), "http://localhost", time.Minute)
+	repo, err := filesystem.NewObjectRepository(filepath.Join(dir, "root"), signer)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for _, key := range []string{"../escape", "user/../../escape", "/etc/passwd", "user\\..\\escape", "", "."} {
+		assert.ErrorIs(t, repo.Upload(ctx, key, strings.NewReader("x"), "image/png", ""), errors.ErrInvalidObjectKey, "key %q", key)
+		assert.ErrorIs(t, repo.Delete(ctx, key), errors.ErrInvalidObjectKey, "key %q", key)
+	}
+
+	// A symlink inside the root must not lead outside of it
+	require.NoError(t, os.Symlink(dir, filepath.Join(dir, "root", "link")))
+	assert.Error(t, repo.Upload(ctx, "link/escape", strings.NewReader("x"), "image/png", ""))
+
+	_, err = os.Stat(filepath.Join(dir, "escape"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFilesystemSignedURL(t *testing.T) {
+	signer := urlsign.NewHMACSigner([]byte("secret"), "", time.Minute)
+	repo, err := filesystem.NewObjectRepository(t.TempDir(), signer)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, repo.Upload(ctx, "alice/profile/profile.png", strings.NewReader("image data"), "image/png", ""))
+
+	mainHandler := handlers.NewMainHandler(&config.Config{})
+	mainHandler.AddHandler(handlers.NewStorageHandler(&repo, signer))
+	mainHandler.MapRoutes()
+	server := httptest.NewServer(mainHandler.Router)
+	defer server.Close()
+
+	url, err := repo.GetPresignedUrl(ctx, "alice/profile/profile.png")
+	require.NoError(t, err)
+
+	resp, err := http.Get(server.URL + url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image data", string(body))
+
+	// Changing the key invalidates the signature
+	tampered := strings.Replace(url, "alice", "bob", 1)
+	resp, err = http.Get(server.URL + tampered)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
@@ -0,0 +1,150 @@
+package contract
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/idempotency"
+)
+
+// IdempotencyStoreFactory returns an empty store for a single test
+type IdempotencyStoreFactory func(t *testing.T) idempotency.Store
+
+// RunIdempotencyStoreTests checks that an idempotency.Store implementation
+// behaves like every other backend
+func RunIdempotencyStoreTests(t *testing.T, newStore IdempotencyStoreFactory) {
+	t.Run("ReserveThenSave", func(t *testing.T) { testIdempotencyReserveThenSave(t, newStore(t)) })
+	t.Run("Expired", func(t *testing.T) { testIdempotencyExpired(t, newStore(t)) })
+	t.Run("Release", func(t *testing.T) { testIdempotencyRelease(t, newStore(t)) })
+	t.Run("ReclaimedLock", func(t *testing.T) { testIdempotencyReclaimedLock(t, newStore(t)) })
+	t.Run("ConcurrentReserve", func(t *testing.T) { testIdempotencyConcurrentReserve(t, newStore(t)) })
+}
+
+func testIdempotencyReserveThenSave(t *testing.T, store idempotency.Store) {
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	existing, err := store.Reserve(ctx, "sub:alice#key-1", idempotency.Record{Fingerprint: "a", Token: "t1", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+	assert.Nil(t, existing)
+
+	// The key is held by the request in progress
+	existing, err = store.Reserve(ctx, "sub:alice#key-1", idempotency.Record{Fingerprint: "b", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+	require.NotNil(t, existing)
+	assert.Equal(t, "a", existing.Fingerprint)
+	assert.Equal(t, "t1", existing.Token)
+	assert.Nil(t, existing.Response)
+
+	response := idempotency.Response{
+		Status: http.StatusCreated,
+		Header: http.Header{"Content-Type": {"application/json"}, "Location": {"/api/v1/users/alice"}},
+		Body:   []byte(`{"username":"alice"}`),
+	}
+	require.NoError(t, store.Save(ctx, "sub:alice#key-1", "t1", idempotency.Record{Fingerprint: "a", Token: "t1", Response: &response, ExpiresAt: expiresAt}))
+
+	existing, err = store.Reserve(ctx, "sub:alice#key-1", idempotency.Record{Fingerprint: "a", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+	require.NotNil(t, existing)
+	assert.Equal(t, "a", existing.Fingerprint)
+	assert.Equal(t, &response, existing.Response)
+	assert.True(t, expiresAt.Equal(existing.ExpiresAt))
+
+	// Keys are independent
+	existing, err = store.Reserve(ctx, "sub:bob#key-1", idempotency.Record{Fingerprint: "a", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+	assert.Nil(t, existing)
+}
+
+func testIdempotencyExpired(t *testing.T, store idempotency.Store) {
+	ctx := context.Background()
+
+	_, err := store.Reserve(ctx, "sub:alice#key-1", idempotency.Record{Fingerprint: "a", ExpiresAt: time.Now().Add(-time.Second)})
+	require.NoError(t, err)
+
+	existing, err := store.Reserve(ctx, "sub:alice#key-1", idempotency.Record{Fingerprint: "b", ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	assert.Nil(t, existing)
+}
+
+func testIdempotencyRelease(t *testing.T, store idempotency.Store) {
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+
+	_, err := store.Reserve(ctx, "sub:alice#key-1", idempotency.Record{Fingerprint: "a", Token: "t1", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+	require.NoError(t, store.Release(ctx, "sub:alice#key-1", "t1"))
+
+	existing, err := store.Reserve(ctx, "sub:alice#key-1", idempotency.Record{Fingerprint: "b", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+	assert.Nil(t, existing)
+
+	// Releasing a key that is not held is not an error
+	assert.NoError(t, store.Release(ctx, "sub:alice#key-2", "t1"))
+}
+
+func testIdempotencyReclaimedLock(t *testing.T, store idempotency.Store) {
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	// The first request's lock expires and a second request reclaims the key
+	_, err := store.Reserve(ctx, "sub:alice#key-1", idempotency.Record{Fingerprint: "a", Token: "t1", ExpiresAt: time.Now().Add(-time.Second)})
+	require.NoError(t, err)
+	existing, err := store.Reserve(ctx, "sub:alice#key-1", idempotency.Record{Fingerprint: "a", Token: "t2", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+	require.Nil(t, existing)
+
+	// The first request can neither overwrite nor release the key
+	stale := idempotency.Response{Status: http.StatusCreated, Body: []byte(`{"request":1}`)}
+	err = store.Save(ctx, "sub:alice#key-1", "t1", idempotency.Record{Fingerprint: "a", Token: "t1", Response: &stale, ExpiresAt: expiresAt})
+	assert.ErrorIs(t, err, idempotency.ErrLockLost)
+	require.NoError(t, store.Release(ctx, "sub:alice#key-1", "t1"))
+
+	existing, err = store.Reserve(ctx, "sub:alice#key-1", idempotency.Record{Fingerprint: "a", Token: "t3", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+	require.NotNil(t, existing)
+	assert.Equal(t, "t2", existing.Token)
+	assert.Nil(t, existing.Response)
+
+	// The second request keeps its response
+	response := idempotency.Response{Status: http.StatusCreated, Body: []byte(`{"request":2}`)}
+	require.NoError(t, store.Save(ctx, "sub:alice#key-1", "t2", idempotency.Record{Fingerprint: "a", Token: "t2", Response: &response, ExpiresAt: expiresAt}))
+
+	existing, err = store.Reserve(ctx, "sub:alice#key-1", idempotency.Record{Fingerprint: "a", Token: "t3", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+	require.NotNil(t, existing)
+	assert.Equal(t, &response, existing.Response)
+
+	// A key that is not held cannot be saved
+	err = store.Save(ctx, "sub:alice#key-2", "t1", idempotency.Record{Fingerprint: "a", Token: "t1", Response: &response, ExpiresAt: expiresAt})
+	assert.ErrorIs(t, err, idempotency.ErrLockLost)
+}
+
+func testIdempotencyConcurrentReserve(t *testing.T, store idempotency.Store) {
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+
+	const requests = 20
+	var reserved atomic.Int64
+	var wg sync.WaitGroup
+	for range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			existing, err := store.Reserve(ctx, "anonymous#key-1", idempotency.Record{Fingerprint: "a", ExpiresAt: expiresAt})
+			assert.NoError(t, err)
+			if err == nil && existing == nil {
+				reserved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(1), reserved.Load())
+}
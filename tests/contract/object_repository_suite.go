@@ -0,0 +1,119 @@
+package contract
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+	"github.com/zzenonn/go-zenon-api-aws/internal/service"
+)
+
+// ObjectRepositoryFactory returns an empty repository for a single test
+type ObjectRepositoryFactory func(t *testing.T) service.ObjectRepository
+
+// RunObjectRepositoryTests checks that a service.ObjectRepository
+// implementation behaves like every other backend
+func RunObjectRepositoryTests(t *testing.T, newRepo ObjectRepositoryFactory) {
+	t.Run("UploadThenPresign", func(t *testing.T) { testUploadThenPresign(t, newRepo(t)) })
+	t.Run("UploadOverwrite", func(t *testing.T) { testUploadOverwrite(t, newRepo(t)) })
+	t.Run("DeleteIdempotent", func(t *testing.T) { testObjectDeleteIdempotent(t, newRepo(t)) })
+	t.Run("UploadVerifiesChecksum", func(t *testing.T) { testUploadVerifiesChecksum(t, newRepo(t)) })
+	t.Run("List", func(t *testing.T) { testObjectList(t, newRepo(t)) })
+	t.Run("QuarantineCopy", func(t *testing.T) { testQuarantineCopy(t, newRepo(t)) })
+}
+
+func testUploadThenPresign(t *testing.T, repo service.ObjectRepository) {
+	ctx := context.Background()
+	key := "contract-user/profile/profile.png"
+
+	require.NoError(t, repo.Upload(ctx, key, strings.NewReader("image data"), "image/png", ""))
+
+	url, err := repo.GetPresignedUrl(ctx, key)
+	require.NoError(t, err)
+	assert.Contains(t, url, "contract-user/profile/profile.png")
+}
+
+func testUploadOverwrite(t *testing.T, repo service.ObjectRepository) {
+	ctx := context.Background()
+	key := "contract-user/profile/overwrite.png"
+
+	require.NoError(t, repo.Upload(ctx, key, strings.NewReader("first"), "image/png", ""))
+	assert.NoError(t, repo.Upload(ctx, key, strings.NewReader("second"), "image/png", ""))
+}
+
+func testObjectDeleteIdempotent(t *testing.T, repo service.ObjectRepository) {
+	ctx := context.Background()
+	key := "contract-user/profile/delete.png"
+
+	require.NoError(t, repo.Upload(ctx, key, strings.NewReader("image data"), "image/png", ""))
+	require.NoError(t, repo.Delete(ctx, key))
+
+	assert.NoError(t, repo.Delete(ctx, key))
+	assert.NoError(t, repo.Delete(ctx, "contract-user/profile/never-existed.png"))
+}
+
+func testUploadVerifiesChecksum(t *testing.T, repo service.ObjectRepository) {
+	ctx := context.Background()
+	key := "contract-user/files/checksum.txt"
+
+	// sha256("image data")
+	checksum := "b41b86dcfdc6219bc2fb987591ad9995bcf3a1e40c2bdd3fdbec622371e6e1af"
+	assert.ErrorIs(t, repo.Upload(ctx, key, strings.NewReader("other data"), "text/plain", checksum), errors.ErrChecksumMismatch)
+	assert.NoError(t, repo.Upload(ctx, key, strings.NewReader("image data"), "text/plain", checksum))
+}
+
+func testObjectList(t *testing.T, repo service.ObjectRepository) {
+	lister, ok := repo.(service.ObjectLister)
+	if !ok {
+		t.Skip("object store cannot list objects")
+	}
+	ctx := context.Background()
+
+	for _, key := range []string{"contract-list/a/1.txt", "contract-list/a/2.txt", "contract-list/ab.txt", "contract-list/b/1.txt"} {
+		require.NoError(t, repo.Upload(ctx, key, strings.NewReader(key), "text/plain", ""))
+	}
+
+	objects, err := lister.List(ctx, "contract-list/a")
+	require.NoError(t, err)
+
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+		assert.Equal(t, int64(len(object.Key)), object.Size)
+		assert.False(t, object.LastModified.IsZero())
+	}
+	assert.Equal(t, []string{"contract-list/a/1.txt", "contract-list/a/2.txt", "contract-list/ab.txt"}, keys)
+
+	objects, err = lister.List(ctx, "contract-list/missing/")
+	require.NoError(t, err)
+	assert.Empty(t, objects)
+}
+
+func testQuarantineCopy(t *testing.T, repo service.ObjectRepository) {
+	quarantine, ok := repo.(service.QuarantineRepository)
+	if !ok {
+		t.Skip("object store cannot copy objects")
+	}
+	ctx := context.Background()
+	source := "quarantine/contract-user/files/copy.txt"
+	destination := "contract-user/files/copy.txt"
+
+	require.NoError(t, repo.Upload(ctx, source, strings.NewReader("file data"), "text/plain", ""))
+	require.NoError(t, quarantine.Copy(ctx, source, destination))
+
+	for _, key := range []string{source, destination} {
+		body, err := quarantine.Download(ctx, key)
+		require.NoError(t, err)
+		data, err := io.ReadAll(body)
+		body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "file data", string(data), key)
+	}
+
+	err := quarantine.Copy(ctx, "quarantine/contract-user/files/missing.txt", destination)
+	assert.ErrorIs(t, err, errors.ErrObjectNotFound)
+}
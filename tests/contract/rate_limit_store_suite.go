@@ -0,0 +1,79 @@
+package contract
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/ratelimit"
+)
+
+// RateLimitStoreFactory returns an empty store for a single test
+type RateLimitStoreFactory func(t *testing.T) ratelimit.Store
+
+// RunRateLimitStoreTests checks that a ratelimit.Store implementation
+// behaves like every other backend
+func RunRateLimitStoreTests(t *testing.T, newStore RateLimitStoreFactory) {
+	t.Run("IncrementThenCount", func(t *testing.T) { testRateLimitIncrementThenCount(t, newStore(t)) })
+	t.Run("Expired", func(t *testing.T) { testRateLimitExpired(t, newStore(t)) })
+	t.Run("ConcurrentIncrements", func(t *testing.T) { testRateLimitConcurrentIncrements(t, newStore(t)) })
+}
+
+func testRateLimitIncrementThenCount(t *testing.T, store ratelimit.Store) {
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+
+	count, err := store.Count(ctx, "login#ip:192.0.2.1#0")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	for want := int64(1); want <= 3; want++ {
+		count, err := store.Increment(ctx, "login#ip:192.0.2.1#0", expiresAt)
+		require.NoError(t, err)
+		assert.Equal(t, want, count)
+	}
+
+	count, err = store.Count(ctx, "login#ip:192.0.2.1#0")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	// Counters are independent
+	count, err = store.Increment(ctx, "login#ip:192.0.2.2#0", expiresAt)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func testRateLimitExpired(t *testing.T, store ratelimit.Store) {
+	ctx := context.Background()
+
+	_, err := store.Increment(ctx, "login#ip:192.0.2.1#0", time.Now().Add(-time.Second))
+	require.NoError(t, err)
+
+	count, err := store.Count(ctx, "login#ip:192.0.2.1#0")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+}
+
+func testRateLimitConcurrentIncrements(t *testing.T, store ratelimit.Store) {
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+
+	const requests = 20
+	var wg sync.WaitGroup
+	for range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.Increment(ctx, "default#sub:alice#0", expiresAt)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	count, err := store.Count(ctx, "default#sub:alice#0")
+	require.NoError(t, err)
+	assert.Equal(t, int64(requests), count)
+}
@@ -0,0 +1,200 @@
+package contract
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+	"github.com/zzenonn/go-zenon-api-aws/internal/service"
+)
+
+// UserRepositoryFactory returns an empty repository for a single test
+type UserRepositoryFactory func(t *testing.T) service.UserRepository
+
+// RunUserRepositoryTests checks that a service.UserRepository implementation
+// behaves like every other backend
+func RunUserRepositoryTests(t *testing.T, newRepo UserRepositoryFactory) {
+	t.Run("CreateThenGet", func(t *testing.T) { testCreateThenGet(t, newRepo(t)) })
+	t.Run("CreateConflict", func(t *testing.T) { testCreateConflict(t, newRepo(t)) })
+	t.Run("GetMissing", func(t *testing.T) { testGetMissing(t, newRepo(t)) })
+	t.Run("PartialUpdate", func(t *testing.T) { testPartialUpdate(t, newRepo(t)) })
+	t.Run("UpdateMissing", func(t *testing.T) { testUpdateMissing(t, newRepo(t)) })
+	t.Run("ClearProfilePath", func(t *testing.T) { testClearProfilePath(t, newRepo(t)) })
+	t.Run("DeleteIdempotent", func(t *testing.T) { testDeleteIdempotent(t, newRepo(t)) })
+	t.Run("Pagination", func(t *testing.T) { testPagination(t, newRepo(t)) })
+	t.Run("InvalidPageToken", func(t *testing.T) { testInvalidPageToken(t, newRepo(t)) })
+}
+
+func newUser(username string) domain.User {
+	return domain.User{
+		Username:       &username,
+		HashedPassword: []byte("hash-of-" + username),
+	}
+}
+
+func testCreateThenGet(t *testing.T, repo service.UserRepository) {
+	ctx := context.Background()
+
+	_, err := repo.CreateUser(ctx, newUser("contract-create"))
+	require.NoError(t, err)
+
+	user, err := repo.GetUser(ctx, "contract-create")
+	require.NoError(t, err)
+	require.NotNil(t, user.Username)
+	assert.Equal(t, "contract-create", *user.Username)
+	assert.Equal(t, []byte("hash-of-contract-create"), user.HashedPassword)
+	assert.Nil(t, user.ProfilePath)
+}
+
+func testCreateConflict(t *testing.T, repo service.UserRepository) {
+	ctx := context.Background()
+
+	_, err := repo.CreateUser(ctx, newUser("contract-conflict"))
+	require.NoError(t, err)
+
+	duplicate := newUser("contract-conflict")
+	duplicate.HashedPassword = []byte("other-hash")
+	_, err = repo.CreateUser(ctx, duplicate)
+	assert.ErrorIs(t, err, errors.ErrUserAlreadyExists)
+
+	// The original user must not have been overwritten
+	user, err := repo.GetUser(ctx, "contract-conflict")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hash-of-contract-conflict"), user.HashedPassword)
+}
+
+func testGetMissing(t *testing.T, repo service.UserRepository) {
+	_, err := repo.GetUser(context.Background(), "contract-missing")
+	assert.ErrorIs(t, err, errors.ErrUserNotFound)
+}
+
+func testPartialUpdate(t *testing.T, repo service.UserRepository) {
+	ctx := context.Background()
+
+	user := newUser("contract-update")
+	role := domain.RoleAdmin
+	user.Role = &role
+	_, err := repo.CreateUser(ctx, user)
+	require.NoError(t, err)
+
+	profilePath := "contract-update/profile/profile.png"
+	updated, err := repo.UpdateUser(ctx, "contract-update", domain.User{ProfilePath: &profilePath})
+	require.NoError(t, err)
+
+	// The returned user is the full stored user, not just the changed fields
+	for _, u := range []domain.User{updated, mustGet(t, repo, "contract-update")} {
+		require.NotNil(t, u.Username)
+		assert.Equal(t, "contract-update", *u.Username)
+		require.NotNil(t, u.ProfilePath)
+		assert.Equal(t, profilePath, *u.ProfilePath)
+		require.NotNil(t, u.Role)
+		assert.Equal(t, domain.RoleAdmin, *u.Role)
+		assert.Equal(t, []byte("hash-of-contract-update"), u.HashedPassword)
+	}
+
+	visibility := domain.VisibilityPublic
+	updated, err = repo.UpdateUser(ctx, "contract-update", domain.User{ProfileVisibility: &visibility})
+	require.NoError(t, err)
+	require.NotNil(t, updated.ProfileVisibility)
+	assert.Equal(t, domain.VisibilityPublic, *updated.ProfileVisibility)
+	require.NotNil(t, updated.ProfilePath)
+	assert.Equal(t, profilePath, *updated.ProfilePath)
+}
+
+func testUpdateMissing(t *testing.T, repo service.UserRepository) {
+	role := domain.RoleUser
+	_, err := repo.UpdateUser(context.Background(), "contract-update-missing", domain.User{Role: &role})
+	assert.ErrorIs(t, err, errors.ErrUserNotFound)
+
+	// Updating must not create the user
+	_, err = repo.GetUser(context.Background(), "contract-update-missing")
+	assert.ErrorIs(t, err, errors.ErrUserNotFound)
+}
+
+func testClearProfilePath(t *testing.T, repo service.UserRepository) {
+	ctx := context.Background()
+
+	user := newUser("contract-clear-profile")
+	profilePath := "contract-clear-profile/profile/profile.png"
+	user.ProfilePath = &profilePath
+	_, err := repo.CreateUser(ctx, user)
+	require.NoError(t, err)
+
+	require.NoError(t, repo.ClearProfilePath(ctx, "contract-clear-profile"))
+
+	cleared, err := repo.GetUser(ctx, "contract-clear-profile")
+	require.NoError(t, err)
+	assert.Nil(t, cleared.ProfilePath)
+	assert.Equal(t, []byte("hash-of-contract-clear-profile"), cleared.HashedPassword)
+
+	assert.ErrorIs(t, repo.ClearProfilePath(ctx, "contract-clear-missing"), errors.ErrUserNotFound)
+}
+
+func testDeleteIdempotent(t *testing.T, repo service.UserRepository) {
+	ctx := context.Background()
+
+	_, err := repo.CreateUser(ctx, newUser("contract-delete"))
+	require.NoError(t, err)
+
+	require.NoError(t, repo.DeleteUser(ctx, "contract-delete"))
+
+	_, err = repo.GetUser(ctx, "contract-delete")
+	assert.ErrorIs(t, err, errors.ErrUserNotFound)
+
+	assert.NoError(t, repo.DeleteUser(ctx, "contract-delete"))
+	assert.NoError(t, repo.DeleteUser(ctx, "contract-delete-never-existed"))
+}
+
+func testPagination(t *testing.T, repo service.UserRepository) {
+	ctx := context.Background()
+	const total = 7
+	const pageSize = 3
+
+	expected := make(map[string]bool, total)
+	for i := 0; i < total; i++ {
+		username := fmt.Sprintf("contract-page-%02d", i)
+		_, err := repo.CreateUser(ctx, newUser(username))
+		require.NoError(t, err)
+		expected[username] = true
+	}
+
+	seen := make(map[string]int)
+	token := ""
+	for pages := 0; ; pages++ {
+		// Backends may return a trailing empty page, but never loop forever
+		require.Less(t, pages, total+2, "pagination did not terminate")
+
+		users, next, err := repo.GetAllUsers(ctx, pageSize, token)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(users), pageSize)
+
+		for _, u := range users {
+			require.NotNil(t, u.Username)
+			seen[*u.Username]++
+		}
+
+		if next == "" {
+			break
+		}
+		token = next
+	}
+
+	for username := range expected {
+		assert.Equal(t, 1, seen[username], "user %s should be returned exactly once", username)
+	}
+}
+
+func testInvalidPageToken(t *testing.T, repo service.UserRepository) {
+	_, _, err := repo.GetAllUsers(context.Background(), 10, "not a valid token!")
+	assert.ErrorIs(t, err, errors.ErrInvalidPageToken)
+}
+
+func mustGet(t *testing.T, repo service.UserRepository, username string) domain.User {
+	user, err := repo.GetUser(context.Background(), username)
+	require.NoError(t, err)
+	return user
+}
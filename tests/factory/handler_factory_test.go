@@ -0,0 +1,22 @@
+package factory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	"github.com/zzenonn/go-zenon-api-aws/internal/factory"
+)
+
+func TestFileServiceIsShared(t *testing.T) {
+	t.Setenv("STORAGE_BACKEND", "memory")
+	cfg, err := config.LoadConfig()
+	require.NoError(t, err)
+
+	handlerFactory, err := factory.NewHandlerFactory(cfg)
+	require.NoError(t, err)
+
+	// The sweeper and the handlers must see the same uploads being verified
+	assert.Same(t, handlerFactory.CreateFileService(), handlerFactory.CreateFileService())
+}
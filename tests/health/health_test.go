@@ -0,0 +1,153 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	handlers "github.com/zzenonn/go-zenon-api-aws/internal/transport/http"
+)
+
+func newServer(t *testing.T, health *handlers.HealthHandler) *httptest.Server {
+	mainHandler := handlers.NewMainHandler(&config.Config{})
+	mainHandler.AddHandler(health)
+	mainHandler.MapRoutes()
+
+	server := httptest.NewServer(mainHandler.Router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func get(t *testing.T, url string) (int, handlers.HealthResponse) {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var body handlers.HealthResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return resp.StatusCode, body
+}
+
+func TestLiveness(t *testing.T) {
+	failing := handlers.HealthCheck{Name: "dynamodb.users", Check: func(ctx context.Context) error {
+		return errors.New("table not found")
+	}}
+	server := newServer(t, handlers.NewHealthHandler([]handlers.HealthCheck{failing}, time.Second, 0))
+
+	// Liveness does not depend on the dependencies
+	status, body := get(t, server.URL+"/healthz")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, handlers.HealthOK, body.Status)
+}
+
+func TestReadiness(t *testing.T) {
+	var calls atomic.Int32
+	checks := []handlers.HealthCheck{
+		{Name: "signing_keys", Check: func(ctx context.Context) error {
+			calls.Add(1)
+			return nil
+		}},
+		{Name: "s3", Check: func(ctx context.Context) error {
+			return errors.New("bucket not found")
+		}},
+	}
+	server := newServer(t, handlers.NewHealthHandler(checks, time.Second, time.Minute))
+
+	status, body := get(t, server.URL+"/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, handlers.HealthFailing, body.Status)
+	assert.Equal(t, handlers.HealthOK, body.Checks["signing_keys"].Status)
+	assert.Equal(t, handlers.HealthFailing, body.Checks["s3"].Status)
+	require.NotNil(t, body.CheckedAt)
+
+	// Results are reused until the cache expires
+	_, again := get(t, server.URL+"/readyz")
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, body.CheckedAt, again.CheckedAt)
+}
+
+func TestReadinessCheckTimeout(t *testing.T) {
+	checks := []handlers.HealthCheck{
+		{Name: "fast", Check: func(ctx context.Context) error { return nil }},
+		// A check that ignores its context is abandoned at the timeout
+		{Name: "hanging", Check: func(ctx context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		}},
+	}
+	server := newServer(t, handlers.NewHealthHandler(checks, 50*time.Millisecond, 0))
+
+	start := time.Now()
+	status, body := get(t, server.URL+"/readyz")
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, handlers.HealthOK, body.Checks["fast"].Status)
+	assert.Equal(t, handlers.HealthFailing, body.Checks["hanging"].Status)
+}
+
+func TestReadinessOnlyLogsErrors(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	checks := []handlers.HealthCheck{
+		{Name: "dynamodb.users", Check: func(ctx context.Context) error {
+			return errors.New("arn:aws:dynamodb:us-east-1:123456789012:table/prod-users not found")
+		}},
+	}
+	server := newServer(t, handlers.NewHealthHandler(checks, time.Second, 0))
+
+	resp, err := http.Get(server.URL + "/readyz")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.NotContains(t, string(body), "123456789012")
+
+	// Each check reports nothing beyond its status and duration
+	var response struct {
+		Checks map[string]map[string]any `json:"checks"`
+	}
+	require.NoError(t, json.Unmarshal(body, &response))
+	check := response.Checks["dynamodb.users"]
+	assert.Equal(t, handlers.HealthFailing, check["status"])
+	assert.ElementsMatch(t, []string{"status", "duration_ms"}, slices.Collect(maps.Keys(check)))
+
+	assert.Contains(t, logs.String(), "Readiness check dynamodb.users failed")
+	assert.Contains(t, logs.String(), "123456789012")
+}
+
+func TestReadinessWhileDraining(t *testing.T) {
+	health := handlers.NewHealthHandler(nil, time.Second, 0)
+	server := newServer(t, health)
+
+	status, body := get(t, server.URL+"/readyz")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, handlers.HealthOK, body.Status)
+
+	health.Drain()
+
+	status, body = get(t, server.URL+"/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, handlers.HealthDraining, body.Status)
+
+	// The process is still alive while it drains
+	status, _ = get(t, server.URL+"/healthz")
+	assert.Equal(t, http.StatusOK, status)
+}
@@ -0,0 +1,116 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/idempotency"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/memory"
+)
+
+func newKeeper() (*idempotency.Keeper, *time.Time) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	store := memory.NewIdempotencyRepository()
+	store.Now = clock
+	keeper := idempotency.NewKeeper(&store, 24*time.Hour, time.Minute)
+	keeper.Now = clock
+	return keeper, &now
+}
+
+func TestKeeper(t *testing.T) {
+	keeper, _ := newKeeper()
+	ctx := context.Background()
+
+	token, replay, err := keeper.Begin(ctx, "sub:alice#key-1", "a")
+	require.NoError(t, err)
+	assert.Nil(t, replay)
+	assert.NotEmpty(t, token)
+
+	_, _, err = keeper.Begin(ctx, "sub:alice#key-1", "a")
+	assert.ErrorIs(t, err, idempotency.ErrInProgress)
+
+	response := idempotency.Response{Status: http.StatusCreated, Body: []byte(`{}`)}
+	require.NoError(t, keeper.Finish(ctx, "sub:alice#key-1", token, "a", response))
+
+	_, replay, err = keeper.Begin(ctx, "sub:alice#key-1", "a")
+	require.NoError(t, err)
+	assert.Equal(t, &response, replay)
+
+	_, _, err = keeper.Begin(ctx, "sub:alice#key-1", "b")
+	assert.ErrorIs(t, err, idempotency.ErrMismatch)
+}
+
+func TestKeeperAbandon(t *testing.T) {
+	keeper, _ := newKeeper()
+	ctx := context.Background()
+
+	token, _, err := keeper.Begin(ctx, "sub:alice#key-1", "a")
+	require.NoError(t, err)
+	require.NoError(t, keeper.Abandon(ctx, "sub:alice#key-1", token))
+
+	// The request is handled again, even with a different payload
+	_, replay, err := keeper.Begin(ctx, "sub:alice#key-1", "b")
+	require.NoError(t, err)
+	assert.Nil(t, replay)
+}
+
+func TestKeeperExpiry(t *testing.T) {
+	keeper, now := newKeeper()
+	ctx := context.Background()
+
+	// A request that never finishes holds its key until the lock times out
+	_, _, err := keeper.Begin(ctx, "sub:alice#key-1", "a")
+	require.NoError(t, err)
+	*now = now.Add(time.Minute)
+	token, replay, err := keeper.Begin(ctx, "sub:alice#key-1", "a")
+	require.NoError(t, err)
+	assert.Nil(t, replay)
+
+	// Responses are replayed until the TTL has passed
+	require.NoError(t, keeper.Finish(ctx, "sub:alice#key-1", token, "a", idempotency.Response{Status: http.StatusOK}))
+	*now = now.Add(24*time.Hour - time.Second)
+	_, replay, err = keeper.Begin(ctx, "sub:alice#key-1", "a")
+	require.NoError(t, err)
+	assert.NotNil(t, replay)
+
+	*now = now.Add(time.Second)
+	_, replay, err = keeper.Begin(ctx, "sub:alice#key-1", "a")
+	require.NoError(t, err)
+	assert.Nil(t, replay)
+}
+
+func TestKeeperReclaimedLock(t *testing.T) {
+	keeper, now := newKeeper()
+	ctx := context.Background()
+
+	// The first request outlives its lock and a retry reclaims the key
+	first, _, err := keeper.Begin(ctx, "sub:alice#key-1", "a")
+	require.NoError(t, err)
+	*now = now.Add(time.Minute)
+	second, replay, err := keeper.Begin(ctx, "sub:alice#key-1", "a")
+	require.NoError(t, err)
+	assert.Nil(t, replay)
+	assert.NotEqual(t, first, second)
+
+	// The first request neither overwrites nor releases the retry's claim
+	err = keeper.Finish(ctx, "sub:alice#key-1", first, "a", idempotency.Response{Status: http.StatusOK, Body: []byte(`1`)})
+	assert.ErrorIs(t, err, idempotency.ErrLockLost)
+	require.NoError(t, keeper.Abandon(ctx, "sub:alice#key-1", first))
+
+	_, _, err = keeper.Begin(ctx, "sub:alice#key-1", "a")
+	assert.ErrorIs(t, err, idempotency.ErrInProgress)
+
+	// The retry's response is the one replayed
+	response := idempotency.Response{Status: http.StatusOK, Body: []byte(`2`)}
+	require.NoError(t, keeper.Finish(ctx, "sub:alice#key-1", second, "a", response))
+
+	_, replay, err = keeper.Begin(ctx, "sub:alice#key-1", "a")
+	require.NoError(t, err)
+	assert.Equal(t, &response, replay)
+}
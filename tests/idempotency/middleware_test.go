@@ -0,0 +1,60 @@
+package idempotency
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	handlers "github.com/zzenonn/go-zenon-api-aws/internal/transport/http"
+)
+
+func TestIdempotencyKeepsAnonymousClientsApart(t *testing.T) {
+	keeper, _ := newKeeper()
+	mainHandler := handlers.NewMainHandler(&config.Config{})
+	mainHandler.Idempotency = &handlers.Idempotency{Keeper: keeper, TrustedProxies: 1}
+	mainHandler.MapRoutes()
+
+	var handled atomic.Int64
+	mainHandler.Router.Post("/count", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"count": %d}`, handled.Add(1))
+	})
+	server := httptest.NewServer(mainHandler.Router)
+	defer server.Close()
+
+	post := func(forwardedFor string) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/count", strings.NewReader(`{}`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(handlers.IdempotencyKeyHeader, "same-key")
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+
+	resp, body := post("192.0.2.1")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"count": 1}`, body)
+
+	// The same client gets its own response back
+	resp, body = post("192.0.2.1")
+	assert.Equal(t, "true", resp.Header.Get(handlers.IdempotentReplayedHeader))
+	assert.JSONEq(t, `{"count": 1}`, body)
+
+	// Another anonymous client using the same key is handled on its own
+	resp, body = post("192.0.2.2")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get(handlers.IdempotentReplayedHeader))
+	assert.JSONEq(t, `{"count": 2}`, body)
+}
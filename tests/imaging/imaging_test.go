@@ -0,0 +1,125 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apperrors "github.com/zzenonn/go-zenon-api-aws/internal/errors"
+	"github.com/zzenonn/go-zenon-api-aws/internal/imaging"
+)
+
+func newImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 64, A: 255})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// encodeJPEGWithOrientation encodes img as a JPEG carrying an EXIF APP1
+// segment with the given orientation and a GPS marker string
+func encodeJPEGWithOrientation(t *testing.T, img image.Image, orientation uint16) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, nil))
+	data := buf.Bytes()
+
+	// Little-endian TIFF header with one IFD entry holding the orientation
+	tiff := []byte("II*\x00\x08\x00\x00\x00\x01\x00")
+	entry := make([]byte, 12)
+	binary.LittleEndian.PutUint16(entry[0:2], 0x0112)
+	binary.LittleEndian.PutUint16(entry[2:4], 3)
+	binary.LittleEndian.PutUint32(entry[4:8], 1)
+	binary.LittleEndian.PutUint16(entry[8:10], orientation)
+	tiff = append(tiff, entry...)
+	tiff = append(tiff, 0, 0, 0, 0)
+	tiff = append(tiff, []byte("GPS 51.5007N 0.1246W")...)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func decodeSize(t *testing.T, data []byte) (int, int) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	require.NoError(t, err)
+	return cfg.Width, cfg.Height
+}
+
+func TestDetectContentType(t *testing.T) {
+	assert.Equal(t, imaging.ContentTypePNG, imaging.DetectContentType(encodePNG(t, newImage(2, 2))))
+	assert.Equal(t, imaging.ContentTypeJPEG, imaging.DetectContentType(encodeJPEGWithOrientation(t, newImage(2, 2), 1)))
+	assert.Equal(t, imaging.ContentTypeWebP, imaging.DetectContentType([]byte("RIFF\x00\x00\x00\x00WEBPVP8 ")))
+	assert.Empty(t, imaging.DetectContentType([]byte("GIF89a")))
+	assert.Empty(t, imaging.DetectContentType([]byte("<svg></svg>")))
+}
+
+func TestProcessRenditions(t *testing.T) {
+	opts := imaging.Options{MaxDimension: 400, MaxPixels: 1 << 20, Sizes: []int{64, 256, 1024}}
+
+	renditions, err := imaging.Process(encodePNG(t, newImage(800, 400)), opts)
+	require.NoError(t, err)
+	require.Len(t, renditions, 4)
+
+	// The original is capped and thumbnails are never upscaled
+	expected := [][2]int{{400, 200}, {64, 32}, {256, 128}, {400, 200}}
+	for i, rendition := range renditions {
+		assert.Equal(t, imaging.ContentTypePNG, rendition.ContentType)
+		assert.Equal(t, ".png", rendition.Ext)
+
+		width, height := decodeSize(t, rendition.Data)
+		assert.Equal(t, expected[i], [2]int{width, height}, "rendition %d", rendition.Size)
+	}
+}
+
+func TestProcessStripsMetadataAndAppliesOrientation(t *testing.T) {
+	data := encodeJPEGWithOrientation(t, newImage(120, 60), 6)
+	require.Contains(t, string(data), "GPS")
+
+	renditions, err := imaging.Process(data, imaging.DefaultOptions())
+	require.NoError(t, err)
+
+	original := renditions[0]
+	assert.Equal(t, imaging.ContentTypeJPEG, original.ContentType)
+	assert.NotContains(t, string(original.Data), "Exif")
+	assert.NotContains(t, string(original.Data), "GPS")
+
+	// Orientation 6 is a quarter turn, so width and height swap
+	width, height := decodeSize(t, original.Data)
+	assert.Equal(t, 60, width)
+	assert.Equal(t, 120, height)
+}
+
+func TestProcessRejectsInvalidImages(t *testing.T) {
+	opts := imaging.DefaultOptions()
+
+	_, err := imaging.Process([]byte("not an image"), opts)
+	assert.ErrorIs(t, err, apperrors.ErrUnsupportedMediaType)
+
+	// Correct magic bytes but a corrupt body
+	_, err = imaging.Process([]byte("\x89PNG\r\n\x1a\ngarbage"), opts)
+	assert.ErrorIs(t, err, apperrors.ErrInvalidUpload)
+
+	opts.MaxPixels = 100
+	_, err = imaging.Process(encodePNG(t, newImage(20, 20)), opts)
+	assert.ErrorIs(t, err, apperrors.ErrImageTooLarge)
+}
@@ -0,0 +1,104 @@
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const AuditEndpoint = "/api/v1/audit"
+
+// auditEvents lists the audit events matching the query, newest first
+func (ts *UserTestSuite) auditEvents(t *testing.T, token, query string) []map[string]any {
+	resp, err := ts.makeAuthenticatedRequest("GET", AuditEndpoint+"?"+query, token, nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var events []map[string]any
+	for _, event := range unmarshalResponse(resp)["events"].([]any) {
+		events = append(events, event.(map[string]any))
+	}
+	return events
+}
+
+func TestAuditLog(t *testing.T) {
+	defer func() { RecordTest("Audit Log", !t.Failed()) }()
+	ts := setupUserTestServer(t)
+
+	username := "audituser"
+	require.NoError(t, ts.createTestUser(username, TestPassword))
+	adminToken := ts.getUserToken(AdminUsername, AdminPassword)
+	userToken := ts.getUserToken(username, TestPassword)
+
+	t.Run("records changes by the admin", func(t *testing.T) {
+		events := ts.auditEvents(t, adminToken, "action=user.create&target="+username)
+		require.Len(t, events, 1)
+		assert.Equal(t, AdminUsername, events[0]["actor"])
+		assert.Equal(t, "success", events[0]["outcome"])
+		assert.NotEmpty(t, events[0]["client_ip"])
+		assert.NotEmpty(t, events[0]["request_id"])
+		assert.Len(t, events[0]["hash"], 64)
+	})
+
+	t.Run("records failed logins", func(t *testing.T) {
+		resp, err := ts.makeAuthenticatedRequest("POST", LoginEndpoint, "",
+			[]byte(fmt.Sprintf(`{"username": %q, "password": "wrong"}`, username)))
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		events := ts.auditEvents(t, adminToken, "action=login&outcome=failure&actor="+username)
+		require.NotEmpty(t, events)
+		assert.Equal(t, username, events[0]["target"])
+	})
+
+	t.Run("records access denied by the auth middleware", func(t *testing.T) {
+		endpoint := fmt.Sprintf(UserEndpoint, AdminUsername) + "/files"
+		resp, err := ts.makeAuthenticatedRequest("GET", endpoint, userToken, nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		events := ts.auditEvents(t, adminToken, "action=access.denied&actor="+username)
+		require.NotEmpty(t, events)
+		assert.Equal(t, endpoint, events[0]["target"])
+		assert.Equal(t, "GET", events[0]["detail"])
+		assert.Equal(t, "denied", events[0]["outcome"])
+
+		resp, err = ts.makeAuthenticatedRequest("GET", endpoint, "not-a-token", nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		events = ts.auditEvents(t, adminToken, "action=access.denied&actor=anonymous")
+		require.NotEmpty(t, events)
+		assert.Equal(t, "invalid token", events[0]["reason"])
+	})
+
+	t.Run("is only readable by admins", func(t *testing.T) {
+		for _, endpoint := range []string{AuditEndpoint, AuditEndpoint + "/verify"} {
+			resp, err := ts.makeAuthenticatedRequest("GET", endpoint, userToken, nil)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusForbidden, resp.StatusCode, endpoint)
+
+			resp, err = ts.makeAuthenticatedRequest("GET", endpoint, "", nil)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, endpoint)
+		}
+	})
+
+	t.Run("verifies", func(t *testing.T) {
+		resp, err := ts.makeAuthenticatedRequest("GET", AuditEndpoint+"/verify", adminToken, nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		result := unmarshalResponse(resp)
+		assert.Equal(t, true, result["valid"], "result: %v", result)
+		assert.Greater(t, result["events"], float64(0))
+	})
+}
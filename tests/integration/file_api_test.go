@@ -0,0 +1,168 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const FilesEndpoint = "/api/v1/users/%s/files"
+
+func (ts *UserTestSuite) uploadFile(username, token, filename string, data []byte) (*http.Response, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	fileWriter, _ := writer.CreateFormFile("file", filename)
+	fileWriter.Write(data)
+	writer.Close()
+
+	req, err := http.NewRequest("POST", ts.server.URL+fmt.Sprintf(FilesEndpoint, username), &buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return ts.client.Do(req)
+}
+
+func TestFileLifecycle(t *testing.T) {
+	defer func() { RecordTest("FileLifecycle", !t.Failed()) }()
+	ts := setupUserTestServer(t)
+	username := "fileuser"
+
+	ts.createTestUser(username, TestPassword)
+	token := ts.getUserToken(username, TestPassword)
+
+	// Directories in the file name are dropped
+	resp, err := ts.uploadFile(username, token, "../../notes.txt", []byte("hello attachments"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var created map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	id, _ := created["id"].(string)
+	require.NotEmpty(t, id)
+	assert.Equal(t, "notes.txt", created["name"])
+	assert.EqualValues(t, len("hello attachments"), created["size"])
+	// sha256("hello attachments")
+	assert.Equal(t, "42d3a7895ec8c25e9cda3e1ea5377a512db52029fadc49d34e70c57a1a54ebaf", created["checksum"])
+
+	listResp, err := ts.makeAuthenticatedRequest("GET", fmt.Sprintf(FilesEndpoint, username), token, nil)
+	require.NoError(t, err)
+	defer listResp.Body.Close()
+	require.Equal(t, http.StatusOK, listResp.StatusCode)
+
+	var list struct {
+		Files     []map[string]any `json:"files"`
+		UsedBytes int64            `json:"used_bytes"`
+	}
+	require.NoError(t, json.NewDecoder(listResp.Body).Decode(&list))
+	require.Len(t, list.Files, 1)
+	assert.Equal(t, id, list.Files[0]["id"])
+	assert.EqualValues(t, len("hello attachments"), list.UsedBytes)
+
+	getResp, err := ts.makeAuthenticatedRequest("GET", fmt.Sprintf(FilesEndpoint, username)+"/"+id, token, nil)
+	require.NoError(t, err)
+	defer getResp.Body.Close()
+	require.Equal(t, http.StatusOK, getResp.StatusCode)
+	assert.NotEmpty(t, unmarshalResponse(getResp)["download_url"])
+
+	deleteResp, err := ts.makeAuthenticatedRequest("DELETE", fmt.Sprintf(FilesEndpoint, username)+"/"+id, token, nil)
+	require.NoError(t, err)
+	defer deleteResp.Body.Close()
+	assert.Equal(t, http.StatusOK, deleteResp.StatusCode)
+
+	missingResp, err := ts.makeAuthenticatedRequest("GET", fmt.Sprintf(FilesEndpoint, username)+"/"+id, token, nil)
+	require.NoError(t, err)
+	defer missingResp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, missingResp.StatusCode)
+}
+
+func TestFilesRequireOwner(t *testing.T) {
+	defer func() { RecordTest("FilesRequireOwner", !t.Failed()) }()
+	ts := setupUserTestServer(t)
+
+	ts.createTestUser("fileowner", TestPassword)
+	ts.createTestUser("fileintruder", TestPassword)
+	token := ts.getUserToken("fileintruder", TestPassword)
+
+	resp, err := ts.makeAuthenticatedRequest("GET", fmt.Sprintf(FilesEndpoint, "fileowner"), token, nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	uploadResp, err := ts.uploadFile("fileowner", token, "notes.txt", []byte("data"))
+	require.NoError(t, err)
+	defer uploadResp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, uploadResp.StatusCode)
+}
+
+func TestMultipartUploadLifecycle(t *testing.T) {
+	defer func() { RecordTest("MultipartUploadLifecycle", !t.Failed()) }()
+	ts := setupUserTestServer(t)
+	username := "uploaduser"
+	data := []byte("hello attachments")
+
+	ts.createTestUser(username, TestPassword)
+	token := ts.getUserToken(username, TestPassword)
+
+	body, _ := json.Marshal(map[string]any{
+		"name":     "large.txt",
+		"size":     len(data),
+		"checksum": "42d3a7895ec8c25e9cda3e1ea5377a512db52029fadc49d34e70c57a1a54ebaf",
+	})
+	resp, err := ts.makeAuthenticatedRequest("POST", fmt.Sprintf(FilesEndpoint, username)+"/uploads", token, body)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotImplemented {
+		t.Skip("profile storage does not support multipart uploads")
+	}
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	created := unmarshalResponse(resp)
+	id, _ := created["id"].(string)
+	require.NotEmpty(t, id)
+	assert.EqualValues(t, 1, created["part_count"])
+	uploadEndpoint := fmt.Sprintf(FilesEndpoint, username) + "/uploads/" + id
+
+	urlResp, err := ts.makeAuthenticatedRequest("GET", uploadEndpoint+"/parts/1/url", token, nil)
+	require.NoError(t, err)
+	defer urlResp.Body.Close()
+	require.Equal(t, http.StatusOK, urlResp.StatusCode)
+	assert.NotEmpty(t, unmarshalResponse(urlResp)["url"])
+
+	partResp, err := ts.makeAuthenticatedRequest("PUT", uploadEndpoint+"/parts/1", token, data)
+	require.NoError(t, err)
+	defer partResp.Body.Close()
+	require.Equal(t, http.StatusOK, partResp.StatusCode)
+
+	getResp, err := ts.makeAuthenticatedRequest("GET", uploadEndpoint, token, nil)
+	require.NoError(t, err)
+	defer getResp.Body.Close()
+	require.Equal(t, http.StatusOK, getResp.StatusCode)
+	parts, _ := unmarshalResponse(getResp)["parts"].([]any)
+	assert.Len(t, parts, 1)
+
+	completeResp, err := ts.makeAuthenticatedRequest("POST", uploadEndpoint+"/complete", token, nil)
+	require.NoError(t, err)
+	defer completeResp.Body.Close()
+	require.Equal(t, http.StatusCreated, completeResp.StatusCode)
+	assert.Equal(t, id, unmarshalResponse(completeResp)["id"])
+
+	fileResp, err := ts.makeAuthenticatedRequest("GET", fmt.Sprintf(FilesEndpoint, username)+"/"+id, token, nil)
+	require.NoError(t, err)
+	defer fileResp.Body.Close()
+	assert.Equal(t, http.StatusOK, fileResp.StatusCode)
+
+	// The upload is gone once completed
+	abortResp, err := ts.makeAuthenticatedRequest("DELETE", uploadEndpoint, token, nil)
+	require.NoError(t, err)
+	defer abortResp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, abortResp.StatusCode)
+}
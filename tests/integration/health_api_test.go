@@ -0,0 +1,28 @@
+package integration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The factory checks every configured dependency for readiness
+func TestReadiness(t *testing.T) {
+	defer func() { RecordTest("Readiness", !t.Failed()) }()
+	ts := setupUserTestServer(t)
+
+	resp, err := ts.client.Get(ts.server.URL + "/readyz")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	result := unmarshalResponse(resp)
+	assert.Equal(t, "ok", result["status"], "checks: %v", result["checks"])
+
+	checks, ok := result["checks"].(map[string]any)
+	require.True(t, ok)
+	assert.Contains(t, checks, "signing_keys")
+	for name, check := range checks {
+		assert.Equal(t, "ok", check.(map[string]any)["status"], name)
+	}
+}
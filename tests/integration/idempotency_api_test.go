@@ -0,0 +1,123 @@
+package integration
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// idempotentRequest sends a request with an Idempotency-Key and returns the
+// response with its body read
+func (ts *UserTestSuite) idempotentRequest(t *testing.T, method, endpoint, token, key, contentType string, body []byte) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, ts.server.URL+endpoint, bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Idempotency-Key", key)
+
+	resp, err := ts.client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, data
+}
+
+// profileForm builds a profile upload; each call picks a new boundary
+func profileForm(data []byte) (string, []byte) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	fileWriter, _ := writer.CreateFormFile("file", ProfileFilename)
+	fileWriter.Write(data)
+	writer.Close()
+	return writer.FormDataContentType(), buf.Bytes()
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	defer func() { RecordTest("Idempotency Key", !t.Failed()) }()
+	ts := setupUserTestServer(t)
+	adminToken := ts.getUserToken(AdminUsername, AdminPassword)
+
+	t.Run("replays the response to a repeated request", func(t *testing.T) {
+		body := []byte(`{"username": "idempotentuser", "password": "password123"}`)
+
+		first, firstBody := ts.idempotentRequest(t, "POST", UsersEndpoint, adminToken, "create-idempotentuser", "application/json", body)
+		require.Equal(t, http.StatusOK, first.StatusCode)
+		assert.Empty(t, first.Header.Get("Idempotent-Replayed"))
+
+		// Without the key, creating the user again fails
+		resp, err := ts.makeAuthenticatedRequest("POST", UsersEndpoint, adminToken, body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+
+		repeat, repeatBody := ts.idempotentRequest(t, "POST", UsersEndpoint, adminToken, "create-idempotentuser", "application/json", body)
+		assert.Equal(t, http.StatusOK, repeat.StatusCode)
+		assert.Equal(t, "true", repeat.Header.Get("Idempotent-Replayed"))
+		assert.Equal(t, first.Header.Get("Content-Type"), repeat.Header.Get("Content-Type"))
+		assert.Equal(t, firstBody, repeatBody)
+		assert.NotEqual(t, first.Header.Get("X-Request-ID"), repeat.Header.Get("X-Request-ID"))
+	})
+
+	t.Run("rejects a key reused for a different request", func(t *testing.T) {
+		body := []byte(`{"username": "idempotentother", "password": "password123"}`)
+		resp, _ := ts.idempotentRequest(t, "POST", UsersEndpoint, adminToken, "create-idempotentuser", "application/json", body)
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	})
+
+	t.Run("keeps the keys of each user apart", func(t *testing.T) {
+		require.NoError(t, ts.createTestUser("idempotentkeys", TestPassword))
+		userToken := ts.getUserToken("idempotentkeys", TestPassword)
+
+		body := []byte(`{"username": "idempotentkeys", "password": "newpassword123"}`)
+		resp, _ := ts.idempotentRequest(t, "PUT", fmt.Sprintf(UserEndpoint, "idempotentkeys"), userToken, "create-idempotentuser", "application/json", body)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Idempotent-Replayed"))
+	})
+
+	t.Run("matches uploads regardless of multipart boundary", func(t *testing.T) {
+		username := "idempotentupload"
+		require.NoError(t, ts.createTestUser(username, TestPassword))
+		token := ts.getUserToken(username, TestPassword)
+		endpoint := fmt.Sprintf(ProfileEndpoint, username)
+		image := testImage(t)
+
+		contentType, body := profileForm(image)
+		first, _ := ts.idempotentRequest(t, "PUT", endpoint, token, "profile-1", contentType, body)
+		require.Equal(t, http.StatusCreated, first.StatusCode)
+
+		contentType, body = profileForm(image)
+		repeat, _ := ts.idempotentRequest(t, "PUT", endpoint, token, "profile-1", contentType, body)
+		assert.Equal(t, http.StatusCreated, repeat.StatusCode)
+		assert.Equal(t, "true", repeat.Header.Get("Idempotent-Replayed"))
+
+		contentType, body = profileForm(append(bytes.Clone(image), 0))
+		other, _ := ts.idempotentRequest(t, "PUT", endpoint, token, "profile-1", contentType, body)
+		assert.Equal(t, http.StatusUnprocessableEntity, other.StatusCode)
+	})
+
+	t.Run("does not keep refused requests", func(t *testing.T) {
+		require.NoError(t, ts.createTestUser("idempotentrefused", TestPassword))
+		userToken := ts.getUserToken("idempotentrefused", TestPassword)
+		body := []byte(`{"username": "idempotentrefused", "password": "newpassword123"}`)
+
+		resp, _ := ts.idempotentRequest(t, "PUT", fmt.Sprintf(UserEndpoint, "idempotentrefused"), "invalid", "refused-1", "application/json", body)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		resp, _ = ts.idempotentRequest(t, "PUT", fmt.Sprintf(UserEndpoint, "idempotentrefused"), userToken, "refused-1", "application/json", body)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("rejects invalid keys", func(t *testing.T) {
+		body := []byte(`{"username": "idempotentinvalid", "password": "password123"}`)
+		resp, _ := ts.idempotentRequest(t, "POST", UsersEndpoint, adminToken, "has spaces", "application/json", body)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
@@ -0,0 +1,48 @@
+package integration
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestBodyLimits(t *testing.T) {
+	defer func() { RecordTest("Request Body Limits", !t.Failed()) }()
+	ts := setupUserTestServer(t)
+
+	// Larger than the default limit of 1 MB
+	large := `{"username": "` + strings.Repeat("a", 2<<20) + `", "password": "password123"}`
+
+	t.Run("rejects bodies declared too large", func(t *testing.T) {
+		resp, err := ts.client.Post(ts.server.URL+LoginEndpoint, "application/json", strings.NewReader(large))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	})
+
+	t.Run("rejects streamed bodies once they pass the limit", func(t *testing.T) {
+		// Hiding the length makes the client send the body chunked
+		body := io.MultiReader(strings.NewReader(large))
+		resp, err := ts.client.Post(ts.server.URL+LoginEndpoint, "application/json", body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	})
+
+	t.Run("accepts uploads larger than the default limit", func(t *testing.T) {
+		username := "limitsupload"
+		require.NoError(t, ts.createTestUser(username, TestPassword))
+		token := ts.getUserToken(username, TestPassword)
+
+		data := bytes.Repeat([]byte("x"), 2<<20)
+		resp, err := ts.uploadFile(username, token, "large.bin", data)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	})
+}
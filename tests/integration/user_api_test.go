@@ -3,6 +3,9 @@ package integration
 import (
 	"bytes"
 	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
@@ -113,6 +116,20 @@ func (ts *UserTestSuite) createMultipartRequest(endpoint, token, filename string
 	return ts.client.Do(req)
 }
 
+// testImage returns a small PNG that passes profile image validation
+func testImage(t *testing.T) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 320, 200))
+	for x := 0; x < 320; x++ {
+		for y := 0; y < 200; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
 func unmarshalResponse(resp *http.Response) map[string]any {
 	var result map[string]any
 	json.NewDecoder(resp.Body).Decode(&result)
@@ -169,6 +186,26 @@ func TestLogin(t *testing.T) {
 	assert.NotEmpty(t, result["token"])
 }
 
+// Requests that do not match the OpenAPI document are rejected with the
+// invalid fields before they reach the handler
+func TestLoginRejectsInvalidBody(t *testing.T) {
+	defer func() { RecordTest("LoginRejectsInvalidBody", !t.Failed()) }()
+	ts := setupUserTestServer(t)
+
+	resp, err := ts.client.Post(ts.server.URL+LoginEndpoint, "application/json",
+		bytes.NewBufferString(`{"username":"loginuser","pasword":"password123"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	result := unmarshalResponse(resp)
+	assert.Equal(t, "Invalid request", result["message"])
+	assert.ElementsMatch(t, []any{
+		map[string]any{"field": "password", "in": "body", "message": "is required"},
+		map[string]any{"field": "pasword", "in": "body", "message": "is not a known field"},
+	}, result["errors"])
+}
+
 // Test Get User
 func TestGetUser(t *testing.T) {
 	defer func() { RecordTest("GetUser", !t.Failed()) }()
@@ -297,13 +334,43 @@ func TestUploadProfile(t *testing.T) {
 	ts.createTestUser(username, TestPassword)
 	token := ts.getUserToken(username, TestPassword)
 
-	resp, err := ts.createMultipartRequest("/api/v1/users/"+username+"/profile", token, ProfileFilename, []byte(FakeImageData))
+	resp, err := ts.createMultipartRequest("/api/v1/users/"+username+"/profile", token, ProfileFilename, testImage(t))
 	require.NoError(t, err)
 	defer resp.Body.Close()
 
 	assert.Equal(t, http.StatusCreated, resp.StatusCode)
 	result := unmarshalResponse(resp)
 	assert.Equal(t, "Profile uploaded successfully", result["message"])
+
+	// Thumbnails can be requested by size
+	for _, size := range []string{"64", "256"} {
+		sizeResp, err := ts.makeAuthenticatedRequest("GET", "/api/v1/users/"+username+"/profile?size="+size, token, nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, sizeResp.StatusCode)
+		assert.NotEmpty(t, unmarshalResponse(sizeResp)["profile_url"])
+		sizeResp.Body.Close()
+	}
+
+	sizeResp, err := ts.makeAuthenticatedRequest("GET", "/api/v1/users/"+username+"/profile?size=100", token, nil)
+	require.NoError(t, err)
+	defer sizeResp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, sizeResp.StatusCode)
+}
+
+func TestUploadProfileRejectsNonImage(t *testing.T) {
+	defer func() { RecordTest("UploadProfileRejectsNonImage", !t.Failed()) }()
+	ts := setupUserTestServer(t)
+	username := "nonimageuser"
+
+	ts.createTestUser(username, TestPassword)
+	token := ts.getUserToken(username, TestPassword)
+
+	// The file name claims a JPEG but the content is not an image
+	resp, err := ts.createMultipartRequest("/api/v1/users/"+username+"/profile", token, ProfileFilename, []byte(FakeImageData))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
 }
 
 func TestGetProfile(t *testing.T) {
@@ -314,6 +381,17 @@ func TestGetProfile(t *testing.T) {
 	ts.createTestUser(username, TestPassword)
 	token := ts.getUserToken(username, TestPassword)
 
+	// A user without a profile image has nothing to return
+	missingResp, err := ts.makeAuthenticatedRequest("GET", "/api/v1/users/"+username+"/profile", token, nil)
+	require.NoError(t, err)
+	defer missingResp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, missingResp.StatusCode)
+
+	uploadResp, err := ts.createMultipartRequest("/api/v1/users/"+username+"/profile", token, ProfileFilename, testImage(t))
+	require.NoError(t, err)
+	defer uploadResp.Body.Close()
+	require.Equal(t, http.StatusCreated, uploadResp.StatusCode)
+
 	resp, err := ts.makeAuthenticatedRequest("GET", "/api/v1/users/"+username+"/profile", token, nil)
 	require.NoError(t, err)
 	defer resp.Body.Close()
@@ -321,6 +399,10 @@ func TestGetProfile(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 	result := unmarshalResponse(resp)
 	assert.NotEmpty(t, result["profile_url"])
+
+	// The URL points at the stored PNG, whatever the uploaded file was called.
+	// Without bucket versioning, each upload is kept under its own version key.
+	assert.Regexp(t, username+`/profile/(versions/[0-9a-f]+/)?profile\.png`, result["profile_url"])
 }
 
 func TestDeleteProfile(t *testing.T) {
@@ -331,6 +413,11 @@ func TestDeleteProfile(t *testing.T) {
 	ts.createTestUser(username, TestPassword)
 	token := ts.getUserToken(username, TestPassword)
 
+	uploadResp, err := ts.createMultipartRequest("/api/v1/users/"+username+"/profile", token, ProfileFilename, testImage(t))
+	require.NoError(t, err)
+	defer uploadResp.Body.Close()
+	require.Equal(t, http.StatusCreated, uploadResp.StatusCode)
+
 	resp, err := ts.makeAuthenticatedRequest("DELETE", "/api/v1/users/"+username+"/profile", token, nil)
 	require.NoError(t, err)
 	defer resp.Body.Close()
@@ -338,6 +425,17 @@ func TestDeleteProfile(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 	result := unmarshalResponse(resp)
 	assert.Equal(t, "Profile deleted successfully", result["message"])
+
+	// Once deleted, the profile can neither be fetched nor deleted again
+	getResp, err := ts.makeAuthenticatedRequest("GET", "/api/v1/users/"+username+"/profile", token, nil)
+	require.NoError(t, err)
+	defer getResp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, getResp.StatusCode)
+
+	againResp, err := ts.makeAuthenticatedRequest("DELETE", "/api/v1/users/"+username+"/profile", token, nil)
+	require.NoError(t, err)
+	defer againResp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, againResp.StatusCode)
 }
 
 // Table-driven tests for unauthorized operations
@@ -402,6 +500,10 @@ func TestUnauthorizedOperations(t *testing.T) {
 }
 
 func TestMain(m *testing.M) {
+	// Every test logs in from the same address, which the login rate limit
+	// would soon reject; rate limiting is tested in tests/ratelimit
+	os.Setenv("RATE_LIMIT_STORE", "none")
+
 	code := m.Run()
 	PrintTestSummary()
 	if globalTestServer != nil {
@@ -409,3 +511,113 @@ func TestMain(m *testing.M) {
 	}
 	os.Exit(code)
 }
+
+func TestProfileVersions(t *testing.T) {
+	defer func() { RecordTest("ProfileVersions", !t.Failed()) }()
+	ts := setupUserTestServer(t)
+	username := "versionuser"
+	profileEndpoint := "/api/v1/users/" + username + "/profile"
+
+	ts.createTestUser(username, TestPassword)
+	token := ts.getUserToken(username, TestPassword)
+
+	var paths []string
+	for i := 0; i < 2; i++ {
+		uploadResp, err := ts.createMultipartRequest(profileEndpoint, token, ProfileFilename, testImage(t))
+		require.NoError(t, err)
+		defer uploadResp.Body.Close()
+		require.Equal(t, http.StatusCreated, uploadResp.StatusCode)
+
+		userResp, err := ts.makeAuthenticatedRequest("GET", "/api/v1/users/"+username, token, nil)
+		require.NoError(t, err)
+		defer userResp.Body.Close()
+		paths = append(paths, unmarshalResponse(userResp)["profile_path"].(string))
+	}
+
+	resp, err := ts.makeAuthenticatedRequest("GET", profileEndpoint+"/versions", token, nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var list struct {
+		Versions []map[string]any `json:"versions"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	require.Len(t, list.Versions, 2)
+	assert.Equal(t, true, list.Versions[0]["current"])
+	assert.Equal(t, false, list.Versions[1]["current"])
+
+	id, _ := list.Versions[1]["id"].(string)
+	restoreResp, err := ts.makeAuthenticatedRequest("POST", profileEndpoint+"/versions/"+id+"/restore", token, nil)
+	require.NoError(t, err)
+	defer restoreResp.Body.Close()
+	require.Equal(t, http.StatusOK, restoreResp.StatusCode)
+	assert.Equal(t, paths[0], unmarshalResponse(restoreResp)["profile_path"])
+
+	missingResp, err := ts.makeAuthenticatedRequest("POST", profileEndpoint+"/versions/missing/restore", token, nil)
+	require.NoError(t, err)
+	defer missingResp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, missingResp.StatusCode)
+}
+
+func TestProfileVisibility(t *testing.T) {
+	defer func() { RecordTest("ProfileVisibility", !t.Failed()) }()
+	ts := setupUserTestServer(t)
+	owner := "visibleuser"
+	viewer := "vieweruser"
+	profileEndpoint := "/api/v1/users/" + owner + "/profile"
+	avatarEndpoint := "/avatars/" + owner
+
+	ts.createTestUser(owner, TestPassword)
+	ts.createTestUser(viewer, TestPassword)
+	ownerToken := ts.getUserToken(owner, TestPassword)
+	viewerToken := ts.getUserToken(viewer, TestPassword)
+	adminToken := ts.getUserToken(AdminUsername, AdminPassword)
+
+	uploadResp, err := ts.createMultipartRequest(profileEndpoint, ownerToken, ProfileFilename, testImage(t))
+	require.NoError(t, err)
+	defer uploadResp.Body.Close()
+	require.Equal(t, http.StatusCreated, uploadResp.StatusCode)
+
+	// Redirects are checked, not followed
+	noRedirect := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	getAvatar := func() *http.Response {
+		resp, err := noRedirect.Get(ts.server.URL + avatarEndpoint)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+	getProfile := func(token string) int {
+		resp, err := ts.makeAuthenticatedRequest("GET", profileEndpoint, token, nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	setVisibility := func(visibility string) int {
+		body, _ := json.Marshal(map[string]string{"visibility": visibility})
+		resp, err := ts.makeAuthenticatedRequest("PUT", profileEndpoint+"/visibility", ownerToken, body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Profiles are visible to signed-in users until a visibility is chosen
+	assert.Equal(t, http.StatusOK, getProfile(viewerToken))
+	assert.Equal(t, http.StatusNotFound, getAvatar().StatusCode)
+
+	require.Equal(t, http.StatusOK, setVisibility("public"))
+	resp := getAvatar()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("Location"))
+	assert.Contains(t, resp.Header.Get("Cache-Control"), "max-age")
+
+	require.Equal(t, http.StatusOK, setVisibility("private"))
+	assert.Equal(t, http.StatusNotFound, getAvatar().StatusCode)
+	assert.Equal(t, http.StatusNotFound, getProfile(viewerToken))
+	assert.Equal(t, http.StatusOK, getProfile(ownerToken))
+	assert.Equal(t, http.StatusOK, getProfile(adminToken))
+
+	assert.Equal(t, http.StatusBadRequest, setVisibility("friends"))
+}
@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/metrics"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/memory"
+	"github.com/zzenonn/go-zenon-api-aws/internal/service"
+	handlers "github.com/zzenonn/go-zenon-api-aws/internal/transport/http"
+)
+
+func TestHTTPMetrics(t *testing.T) {
+	mainHandler := handlers.NewMainHandler(&config.Config{})
+	mainHandler.MapRoutes()
+	server := httptest.NewServer(mainHandler.Router)
+	defer server.Close()
+
+	for _, path := range []string{"/", "/", "/no/such/route"} {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	resp, err := http.Get(server.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain"))
+
+	body, _ := io.ReadAll(resp.Body)
+	exposition := string(body)
+	assert.Contains(t, exposition, `zenon_http_requests_total{method="GET",route="/",status="200"} 2`)
+	// Unknown paths share one series
+	assert.Contains(t, exposition, `zenon_http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	assert.Contains(t, exposition, `zenon_http_request_duration_seconds_bucket{method="GET",route="/",status="200",le="+Inf"} 2`)
+	// The request for /metrics itself is in flight
+	assert.Contains(t, exposition, `zenon_http_requests_in_flight{method="GET",route="/metrics"} 1`)
+	assert.Contains(t, exposition, "go_goroutines")
+}
+
+// throttlingTransport rejects the first request with a throttling error
+type throttlingTransport struct {
+	requests int
+}
+
+func (t *throttlingTransport) Do(req *http.Request) (*http.Response, error) {
+	t.requests++
+
+	status, body := http.StatusOK, `{"Item":{"username":{"S":"alice"}}}`
+	if t.requests == 1 {
+		status, body = http.StatusBadRequest, `{"__type":"com.amazonaws.dynamodb.v20120810#ThrottlingException","message":"Rate exceeded"}`
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": {"application/x-amz-json-1.0"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func TestAWSMiddleware(t *testing.T) {
+	transport := &throttlingTransport{}
+	client := dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String("http://localhost:8000"),
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+		HTTPClient: transport,
+		Retryer: retry.NewStandard(func(o *retry.StandardOptions) {
+			o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) { return 0, nil })
+		}),
+		APIOptions: []func(*middleware.Stack) error{metrics.AddAWSMiddleware},
+	})
+
+	retries := testutil.ToFloat64(metrics.AWSCallRetries.WithLabelValues("DynamoDB", "GetItem"))
+	throttles := testutil.ToFloat64(metrics.AWSThrottles.WithLabelValues("DynamoDB", "GetItem"))
+
+	_, err := client.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName: aws.String("users"),
+		Key:       map[string]types.AttributeValue{"username": &types.AttributeValueMemberS{Value: "alice"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, transport.requests)
+
+	assert.Equal(t, retries+1, testutil.ToFloat64(metrics.AWSCallRetries.WithLabelValues("DynamoDB", "GetItem")))
+	assert.Equal(t, throttles+1, testutil.ToFloat64(metrics.AWSThrottles.WithLabelValues("DynamoDB", "GetItem")))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.AWSCallDuration.WithLabelValues("DynamoDB", "GetItem", "success").(prometheus.Histogram)))
+}
+
+func TestBusinessMetrics(t *testing.T) {
+	users := memory.NewUserRepository()
+	profiles := memory.NewObjectRepository()
+	svc := service.NewUserService(&users, &profiles)
+	ctx := context.Background()
+
+	signups := testutil.ToFloat64(metrics.Signups)
+	succeeded := testutil.ToFloat64(metrics.Logins.WithLabelValues(metrics.LoginSucceeded))
+	failed := testutil.ToFloat64(metrics.Logins.WithLabelValues(metrics.LoginFailed))
+
+	username := "metrics-user"
+	_, err := svc.CreateUser(ctx, domain.User{Username: &username, Password: "password123"})
+	require.NoError(t, err)
+	require.NoError(t, svc.Login(ctx, username, "password123"))
+	require.Error(t, svc.Login(ctx, username, "wrong"))
+	require.Error(t, svc.Login(ctx, "nobody", "password123"))
+
+	assert.Equal(t, signups+1, testutil.ToFloat64(metrics.Signups))
+	assert.Equal(t, succeeded+1, testutil.ToFloat64(metrics.Logins.WithLabelValues(metrics.LoginSucceeded)))
+	assert.Equal(t, failed+2, testutil.ToFloat64(metrics.Logins.WithLabelValues(metrics.LoginFailed)))
+
+	files := memory.NewFileRepository()
+	fileSvc := service.NewFileService(&files, &users, &profiles)
+
+	uploads := testutil.ToFloat64(metrics.Uploads.WithLabelValues(metrics.UploadFile))
+	stored := testutil.ToFloat64(metrics.BytesStored.WithLabelValues(metrics.UploadFile))
+
+	_, err = fileSvc.UploadFile(ctx, username, "notes.txt", "text/plain", strings.NewReader("hello world"))
+	require.NoError(t, err)
+
+	assert.Equal(t, uploads+1, testutil.ToFloat64(metrics.Uploads.WithLabelValues(metrics.UploadFile)))
+	assert.Equal(t, stored+11, testutil.ToFloat64(metrics.BytesStored.WithLabelValues(metrics.UploadFile)))
+}
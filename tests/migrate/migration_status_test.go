@@ -0,0 +1,151 @@
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/db"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/migrate"
+)
+
+const arnPrefix = "arn:aws:dynamodb:us-east-1:123456789012:table/"
+
+// taggingServer fakes GetResources, serving one page of table names per
+// request for each migration version
+type taggingServer struct {
+	mu       sync.Mutex
+	pages    map[string][][]string
+	requests map[string]int
+}
+
+func (s *taggingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TagFilters []struct {
+			Key    string
+			Values []string
+		}
+		PaginationToken string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	version := input.TagFilters[0].Values[0]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[version]++
+
+	// The pagination token is the index of the next page
+	page, _ := strconv.Atoi(input.PaginationToken)
+
+	type mapping struct {
+		ResourceARN string
+	}
+	output := struct {
+		ResourceTagMappingList []mapping
+		PaginationToken        string `json:",omitempty"`
+	}{ResourceTagMappingList: []mapping{}}
+
+	pages := s.pages[version]
+	if page < len(pages) {
+		for _, table := range pages[page] {
+			output.ResourceTagMappingList = append(output.ResourceTagMappingList, mapping{ResourceARN: arnPrefix + table})
+		}
+		if page+1 < len(pages) {
+			output.PaginationToken = strconv.Itoa(page + 1)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	json.NewEncoder(w).Encode(output)
+}
+
+func newDatabase(t *testing.T, tagging *taggingServer) *db.DynamoDb {
+	server := httptest.NewServer(tagging)
+	t.Cleanup(server.Close)
+
+	cfg := &config.Config{
+		TablePrefix:      "prod-",
+		DynamoDBTable:    "users",
+		FilesTable:       "files",
+		AuditTable:       "audit",
+		RateLimitTable:   "rate-limits",
+		IdempotencyTable: "idempotency-keys",
+		AwsConfig: aws.Config{
+			Region:       "us-east-1",
+			Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+			BaseEndpoint: aws.String(server.URL),
+		},
+	}
+
+	database, err := db.NewDatabase(cfg)
+	require.NoError(t, err)
+	return database
+}
+
+func TestMigrationStatusMatchesPrefixedTablesAcrossPages(t *testing.T) {
+	tagging := &taggingServer{
+		pages: map[string][][]string{
+			// Another environment's table is on the first page, ours on the second
+			migrate.Version: {{"staging-users"}, {"prod-users"}},
+			// Only another environment has applied this migration
+			migrate.FilesTableVersion: {{"staging-files"}, {"prod-files-archive"}},
+			// Empty pages don't stop the scan
+			migrate.AuditTableVersion: {{}, {}, {"prod-audit"}},
+		},
+		requests: make(map[string]int),
+	}
+	database := newDatabase(t, tagging)
+
+	states, err := database.MigrationStatus(context.Background())
+	require.NoError(t, err)
+
+	applied := make(map[string]bool)
+	for _, state := range states {
+		applied[state.TableName] = state.Applied
+	}
+	assert.Equal(t, map[string]bool{
+		"prod-users":            true,
+		"prod-files":            false,
+		"prod-audit":            true,
+		"prod-rate-limits":      false,
+		"prod-idempotency-keys": false,
+	}, applied)
+
+	assert.Equal(t, 2, tagging.requests[migrate.Version])
+	assert.Equal(t, 2, tagging.requests[migrate.FilesTableVersion])
+	assert.Equal(t, 3, tagging.requests[migrate.AuditTableVersion])
+}
+
+func TestMigrationStatusReportsTaggingErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type":"InvalidParameterException","message":"bad filter"}`))
+	}))
+	defer server.Close()
+
+	database, err := db.NewDatabase(&config.Config{
+		DynamoDBTable: "users",
+		AwsConfig: aws.Config{
+			Region:       "us-east-1",
+			Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+			BaseEndpoint: aws.String(server.URL),
+		},
+	})
+	require.NoError(t, err)
+
+	_, err = database.MigrationStatus(context.Background())
+	assert.ErrorContains(t, err, "could not check migration status")
+}
@@ -0,0 +1,131 @@
+package migrate
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/migrate"
+)
+
+func loadConfig(t *testing.T, env map[string]string) (*config.Config, error) {
+	t.Setenv("STORAGE_BACKEND", "memory")
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	return config.LoadConfig()
+}
+
+func TestLoadConfigParsesTags(t *testing.T) {
+	cfg, err := loadConfig(t, map[string]string{
+		"DYNAMODB_TAGS": "team=platform, env=prod,,novalue,=orphan,empty=",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"team":  "platform",
+		"env":   "prod",
+		"empty": "",
+	}, cfg.DynamoDBTags)
+}
+
+func TestLoadConfigPrefixesTableNames(t *testing.T) {
+	cfg, err := loadConfig(t, map[string]string{
+		"TABLE_PREFIX":         "staging-",
+		"DYNAMODB_FILES_TABLE": "files",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "staging-files", cfg.TableName(cfg.FilesTable))
+}
+
+func TestLoadConfigRejectsInvalidTableSettings(t *testing.T) {
+	tests := map[string]map[string]string{
+		"billing mode":  {"DYNAMODB_BILLING_MODE": "on_demand"},
+		"table class":   {"DYNAMODB_TABLE_CLASS": "glacier"},
+		"read capacity": {"DYNAMODB_READ_CAPACITY": "lots"},
+		"point in time": {"DYNAMODB_POINT_IN_TIME_RECOVERY": "maybe"},
+		"encryption":    {"DYNAMODB_SSE_ENABLED": "maybe"},
+	}
+
+	for name, env := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := loadConfig(t, env)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestTableSettingsDefaults(t *testing.T) {
+	cfg, err := loadConfig(t, nil)
+	require.NoError(t, err)
+
+	input := &dynamodb.CreateTableInput{}
+	migrate.NewTableSettings(cfg).Apply(input)
+
+	assert.Equal(t, types.BillingModeProvisioned, input.BillingMode)
+	require.NotNil(t, input.ProvisionedThroughput)
+	assert.Equal(t, int64(5), aws.ToInt64(input.ProvisionedThroughput.ReadCapacityUnits))
+	assert.Equal(t, int64(5), aws.ToInt64(input.ProvisionedThroughput.WriteCapacityUnits))
+	assert.Equal(t, types.TableClassStandard, input.TableClass)
+	assert.Nil(t, input.SSESpecification)
+	assert.Empty(t, input.Tags)
+}
+
+func TestTableSettingsFromConfig(t *testing.T) {
+	cfg, err := loadConfig(t, map[string]string{
+		"DYNAMODB_BILLING_MODE":           "pay_per_request",
+		"DYNAMODB_TABLE_CLASS":            "standard_infrequent_access",
+		"DYNAMODB_POINT_IN_TIME_RECOVERY": "true",
+		"DYNAMODB_SSE_ENABLED":            "true",
+		"DYNAMODB_SSE_KMS_KEY_ID":         "alias/tables",
+		"DYNAMODB_TAGS":                   "team=platform,env=prod,app=zenon",
+	})
+	require.NoError(t, err)
+
+	settings := migrate.NewTableSettings(cfg)
+	assert.True(t, settings.PointInTimeRecovery)
+
+	input := &dynamodb.CreateTableInput{}
+	settings.Apply(input)
+
+	assert.Equal(t, types.BillingModePayPerRequest, input.BillingMode)
+	assert.Nil(t, input.ProvisionedThroughput)
+	assert.Equal(t, types.TableClassStandardInfrequentAccess, input.TableClass)
+
+	require.NotNil(t, input.SSESpecification)
+	assert.True(t, aws.ToBool(input.SSESpecification.Enabled))
+	assert.Equal(t, types.SSETypeKms, input.SSESpecification.SSEType)
+	assert.Equal(t, "alias/tables", aws.ToString(input.SSESpecification.KMSMasterKeyId))
+
+	// Tags are sorted by key
+	var tags [][2]string
+	for _, tag := range input.Tags {
+		tags = append(tags, [2]string{aws.ToString(tag.Key), aws.ToString(tag.Value)})
+	}
+	assert.Equal(t, [][2]string{{"app", "zenon"}, {"env", "prod"}, {"team", "platform"}}, tags)
+}
+
+func TestTableSettingsUseProvisionedCapacity(t *testing.T) {
+	cfg, err := loadConfig(t, map[string]string{
+		"DYNAMODB_READ_CAPACITY":  "20",
+		"DYNAMODB_WRITE_CAPACITY": "10",
+		"DYNAMODB_SSE_ENABLED":    "true",
+	})
+	require.NoError(t, err)
+
+	input := &dynamodb.CreateTableInput{}
+	migrate.NewTableSettings(cfg).Apply(input)
+
+	require.NotNil(t, input.ProvisionedThroughput)
+	assert.Equal(t, int64(20), aws.ToInt64(input.ProvisionedThroughput.ReadCapacityUnits))
+	assert.Equal(t, int64(10), aws.ToInt64(input.ProvisionedThroughput.WriteCapacityUnits))
+
+	// Without a key id DynamoDB uses the AWS managed key
+	require.NotNil(t, input.SSESpecification)
+	assert.Nil(t, input.SSESpecification.KMSMasterKeyId)
+}
@@ -0,0 +1,114 @@
+package openapi
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	handlers "github.com/zzenonn/go-zenon-api-aws/internal/transport/http"
+	"github.com/zzenonn/go-zenon-api-aws/internal/urlsign"
+)
+
+// newMainHandler registers every handler the API can serve. Services are not
+// needed to map and document routes.
+func newMainHandler(t *testing.T) *handlers.MainHandler {
+	mainHandler, _ := newSigningMainHandler(t)
+	return mainHandler
+}
+
+// newSigningMainHandler returns a main handler with every handler mapped,
+// together with the key that signs the tokens it accepts
+func newSigningMainHandler(t *testing.T) (*handlers.MainHandler, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	cfg := &config.Config{ECDSAPrivateKey: key, ECDSAPublicKey: &key.PublicKey}
+
+	mainHandler := handlers.NewMainHandler(cfg)
+	mainHandler.AddHandler(handlers.NewUserHandler(nil, cfg))
+	mainHandler.AddHandler(handlers.NewFileHandler(nil, cfg))
+	mainHandler.AddHandler(handlers.NewStorageHandler(nil, urlsign.NewHMACSigner([]byte("secret"), "", time.Minute)))
+	mainHandler.AddHandler(handlers.NewSignedCookieHandler(nil, cfg))
+	mainHandler.MapRoutes()
+	return mainHandler, key
+}
+
+// TestRoutesMatchOperations fails when a route is added without documenting
+// it, or an operation is documented for a route that no longer exists
+func TestRoutesMatchOperations(t *testing.T) {
+	mainHandler := newMainHandler(t)
+
+	var routes []string
+	require.NoError(t, chi.Walk(mainHandler.Router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		routes = append(routes, method+" "+handlers.OpenAPIPath(route))
+		return nil
+	}))
+
+	var operations []string
+	for _, operation := range mainHandler.Operations() {
+		operations = append(operations, operation.Method+" "+operation.Path)
+	}
+
+	assert.ElementsMatch(t, routes, operations)
+
+	doc := mainHandler.OpenAPI()
+	documented := 0
+	for _, methods := range doc.Paths {
+		documented += len(methods)
+	}
+	assert.Equal(t, len(routes), documented)
+}
+
+func TestServeOpenAPI(t *testing.T) {
+	server := httptest.NewServer(newMainHandler(t).Router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/openapi.json")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var doc map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&doc))
+	assert.Equal(t, handlers.OpenAPIVersion, doc["openapi"])
+
+	components := doc["components"].(map[string]any)
+	bearer := components["securitySchemes"].(map[string]any)["bearerAuth"].(map[string]any)
+	assert.Equal(t, "bearer", bearer["scheme"])
+	assert.Equal(t, "JWT", bearer["bearerFormat"])
+
+	schemas := components["schemas"].(map[string]any)
+	for _, name := range []string{"PostUserRequest", "LoginRequest", "Token", "Response", "User"} {
+		assert.Contains(t, schemas, name)
+	}
+	postUser := schemas["PostUserRequest"].(map[string]any)
+	assert.ElementsMatch(t, []any{"password", "username"}, postUser["required"])
+	user := schemas["User"].(map[string]any)["properties"].(map[string]any)
+	assert.Contains(t, user, "username")
+	assert.NotContains(t, user, "Password")
+
+	// Login is the only user route that takes no token
+	paths := doc["paths"].(map[string]any)
+	login := paths["/api/v1/users/login"].(map[string]any)["post"].(map[string]any)
+	assert.Equal(t, []any{}, login["security"])
+	getUser := paths["/api/v1/users/{username}"].(map[string]any)["get"].(map[string]any)
+	assert.NotContains(t, getUser, "security")
+	assert.Contains(t, getUser["responses"], "401")
+
+	docs, err := http.Get(server.URL + "/docs")
+	require.NoError(t, err)
+	defer docs.Body.Close()
+	body, _ := io.ReadAll(docs.Body)
+	assert.True(t, strings.HasPrefix(docs.Header.Get("Content-Type"), "text/html"))
+	assert.Contains(t, string(body), "/openapi.json")
+}
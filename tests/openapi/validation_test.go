@@ -0,0 +1,188 @@
+package openapi
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	handlers "github.com/zzenonn/go-zenon-api-aws/internal/transport/http"
+)
+
+// TestValidationMiddleware sends requests that do not match the OpenAPI
+// document. The middleware rejects them before any handler, and so any
+// service, is reached.
+func TestValidationMiddleware(t *testing.T) {
+	mainHandler, key := newSigningMainHandler(t)
+	server := httptest.NewServer(mainHandler.Router)
+	defer server.Close()
+
+	token, err := handlers.MintJwtToken("alice", time.Minute, key)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		errors []handlers.FieldError
+	}{
+		{
+			name:   "unknown field",
+			method: http.MethodPost,
+			path:   "/api/v1/users",
+			body:   `{"username":"alice","password":"secret","admin":true}`,
+			errors: []handlers.FieldError{{Field: "admin", In: "body", Message: "is not a known field"}},
+		},
+		{
+			name:   "missing and empty fields",
+			method: http.MethodPost,
+			path:   "/api/v1/users/login",
+			body:   `{"username":""}`,
+			errors: []handlers.FieldError{
+				{Field: "password", In: "body", Message: "is required"},
+				{Field: "username", In: "body", Message: "must not be empty"},
+			},
+		},
+		{
+			name:   "wrong type",
+			method: http.MethodPost,
+			path:   "/api/v1/users/login",
+			body:   `{"username":"alice","password":42}`,
+			errors: []handlers.FieldError{{Field: "password", In: "body", Message: "must be a string"}},
+		},
+		{
+			name:   "missing body",
+			method: http.MethodPut,
+			path:   "/api/v1/users/alice",
+			errors: []handlers.FieldError{{In: "body", Message: "is required"}},
+		},
+		{
+			name:   "malformed body",
+			method: http.MethodPost,
+			path:   "/api/v1/users",
+			body:   `{"username":`,
+			errors: []handlers.FieldError{{In: "body", Message: "must be valid JSON"}},
+		},
+		{
+			name:   "enum",
+			method: http.MethodPut,
+			path:   "/api/v1/users/alice/profile/visibility",
+			body:   `{"visibility":"friends"}`,
+			errors: []handlers.FieldError{{Field: "visibility", In: "body", Message: "must be one of public, authenticated, private"}},
+		},
+		{
+			name:   "minimum",
+			method: http.MethodPost,
+			path:   "/api/v1/users/alice/files/uploads",
+			body:   `{"name":"video.mp4","size":0}`,
+			errors: []handlers.FieldError{{Field: "size", In: "body", Message: "must be greater than 0"}},
+		},
+		{
+			name:   "query parameter",
+			method: http.MethodGet,
+			path:   "/api/v1/users/alice/files?page_size=5000",
+			errors: []handlers.FieldError{{Field: "page_size", In: "query", Message: "must be at most 1000"}},
+		},
+		{
+			name:   "required query parameter",
+			method: http.MethodGet,
+			path:   "/storage/alice/profile/profile.png?signature=abc",
+			errors: []handlers.FieldError{{Field: "expires", In: "query", Message: "is required"}},
+		},
+		{
+			name:   "path parameter",
+			method: http.MethodPut,
+			path:   "/api/v1/users/alice/files/uploads/1/parts/first",
+			errors: []handlers.FieldError{{Field: "number", In: "path", Message: "must be an integer"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, server.URL+tt.path, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer "+token)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			var result handlers.ValidationErrorResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+			assert.Equal(t, "Invalid request", result.Message)
+			assert.Equal(t, tt.errors, result.Errors)
+		})
+	}
+}
+
+// TestValidationRunsAfterAuthentication checks that callers without a valid
+// token learn nothing about what protected operations expect
+func TestValidationRunsAfterAuthentication(t *testing.T) {
+	mainHandler, _ := newSigningMainHandler(t)
+	server := httptest.NewServer(mainHandler.Router)
+	defer server.Close()
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	forged, err := handlers.MintJwtToken("alice", time.Minute, otherKey)
+	require.NoError(t, err)
+
+	send := func(method string, path string, body string, authorization string) (int, string) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(data)
+	}
+
+	for _, authorization := range []string{"", "Bearer " + forged, "Basic YWxpY2U6c2VjcmV0"} {
+		status, body := send(http.MethodPut, "/api/v1/users/alice/profile/visibility", `{"visibility":"friends"}`, authorization)
+		assert.Equal(t, http.StatusUnauthorized, status)
+		assert.NotContains(t, body, "visibility")
+
+		status, body = send(http.MethodGet, "/api/v1/users/alice/files?page_size=5000", "", authorization)
+		assert.Equal(t, http.StatusUnauthorized, status)
+		assert.NotContains(t, body, "page_size")
+	}
+
+	// Public operations are still validated for anyone
+	status, body := send(http.MethodPost, "/api/v1/users/login", `{"username":""}`, "")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, body, "username")
+}
+
+func TestValidationErrorsAreDocumented(t *testing.T) {
+	doc := newMainHandler(t).OpenAPI()
+
+	login := doc.Paths["/api/v1/users/login"]["post"]
+	badRequest := login.Responses["400"]
+	require.NotNil(t, badRequest)
+	assert.Equal(t, "#/components/schemas/ValidationErrorResponse", badRequest.Content["application/json"].Schema.Ref)
+
+	loginRequest := doc.Components.Schemas["LoginRequest"]
+	assert.Equal(t, 1, *loginRequest.Properties["username"].MinLength)
+	assert.Equal(t, false, loginRequest.AdditionalProperties)
+
+	part := doc.Paths["/api/v1/users/{username}/files/uploads/{id}/parts/{number}"]["put"]
+	for _, param := range part.Parameters {
+		if param.Name == "number" {
+			assert.Equal(t, "integer", param.Schema.Type)
+			assert.Equal(t, 10000.0, *param.Schema.Maximum)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	"github.com/zzenonn/go-zenon-api-aws/internal/ratelimit"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/memory"
+	handlers "github.com/zzenonn/go-zenon-api-aws/internal/transport/http"
+)
+
+// clock is a time that only moves when told to
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newLimiter() (*ratelimit.Limiter, *clock) {
+	c := &clock{now: time.Date(2026, 1, 2, 3, 4, 0, 0, time.UTC)}
+	store := memory.NewRateLimitRepository()
+	store.Now = c.Now
+	limiter := ratelimit.NewLimiter(&store)
+	limiter.Now = c.Now
+	return limiter, c
+}
+
+type failingStore struct{}
+
+func (failingStore) Increment(ctx context.Context, key string, expiresAt time.Time) (int64, error) {
+	return 0, errors.New("table not found")
+}
+
+func (failingStore) Count(ctx context.Context, key string) (int64, error) {
+	return 0, errors.New("table not found")
+}
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		value  string
+		policy ratelimit.Policy
+		err    bool
+	}{
+		{"10/1m", ratelimit.Policy{Limit: 10, Window: time.Minute, Key: ratelimit.KeyIP}, false},
+		{"300/1h:subject", ratelimit.Policy{Limit: 300, Window: time.Hour, Key: ratelimit.KeySubject}, false},
+		{"5/30s:api_key", ratelimit.Policy{Limit: 5, Window: 30 * time.Second, Key: ratelimit.KeyAPIKey}, false},
+		{"off", ratelimit.Policy{}, false},
+		{"10", ratelimit.Policy{}, true},
+		{"0/1m", ratelimit.Policy{}, true},
+		{"10/1ms", ratelimit.Policy{}, true},
+		{"10/1m:cookie", ratelimit.Policy{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			policy, err := ratelimit.ParsePolicy(tt.value)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.policy, policy)
+		})
+	}
+
+	routes, err := ratelimit.ParseRoutePolicies("post /api/v1/users/login=10/1m:ip; PUT /api/v1/users/{username}/profile=off;")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]ratelimit.Policy{
+		"POST /api/v1/users/login":             {Limit: 10, Window: time.Minute, Key: ratelimit.KeyIP},
+		"PUT /api/v1/users/{username}/profile": {},
+	}, routes)
+
+	_, err = ratelimit.ParseRoutePolicies("/api/v1/users/login=10/1m")
+	assert.Error(t, err)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	limiter, clock := newLimiter()
+	policy := ratelimit.Policy{Limit: 4, Window: time.Minute, Key: ratelimit.KeyIP}
+	ctx := context.Background()
+
+	// Late in a window, the full limit is available
+	clock.Advance(45 * time.Second)
+	for i := range 4 {
+		decision, err := limiter.Allow(ctx, "login", policy, "ip:192.0.2.1")
+		require.NoError(t, err)
+		assert.True(t, decision.Allowed)
+		assert.Equal(t, int64(3-i), decision.Remaining)
+		assert.Equal(t, 15*time.Second, decision.Reset)
+	}
+
+	decision, err := limiter.Allow(ctx, "login", policy, "ip:192.0.2.1")
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, int64(0), decision.Remaining)
+	assert.Greater(t, decision.RetryAfter, decision.Reset)
+
+	// Other clients are not affected
+	decision, err = limiter.Allow(ctx, "login", policy, "ip:192.0.2.2")
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+
+	// The previous window still counts in the next one, so the client cannot
+	// send a second burst right at the window boundary
+	clock.Advance(15 * time.Second)
+	decision, err = limiter.Allow(ctx, "login", policy, "ip:192.0.2.1")
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+
+	// The next request is allowed once the client has waited as long as told
+	clock.Advance(decision.RetryAfter)
+	decision, err = limiter.Allow(ctx, "login", policy, "ip:192.0.2.1")
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+}
+
+func newServer(t *testing.T, limits *handlers.RateLimits) *httptest.Server {
+	mainHandler := handlers.NewMainHandler(&config.Config{})
+	mainHandler.RateLimits = limits
+	mainHandler.AddHandler(handlers.NewHealthHandler(nil, time.Second, 0))
+	mainHandler.MapRoutes()
+
+	server := httptest.NewServer(mainHandler.Router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func get(t *testing.T, url string, headers map[string]string) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limiter, _ := newLimiter()
+	server := newServer(t, &handlers.RateLimits{
+		Limiter: limiter,
+		Default: ratelimit.Policy{Limit: 100, Window: time.Minute, Key: ratelimit.KeyIP},
+		Routes: map[string]ratelimit.Policy{
+			"GET /openapi.json": {Limit: 2, Window: time.Minute, Key: ratelimit.KeyIP},
+		},
+	})
+
+	for _, remaining := range []string{"1", "0"} {
+		resp := get(t, server.URL+"/openapi.json", nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "2", resp.Header.Get("RateLimit-Limit"))
+		assert.Equal(t, remaining, resp.Header.Get("RateLimit-Remaining"))
+		assert.Equal(t, "2;w=60", resp.Header.Get("RateLimit-Policy"))
+		assert.Equal(t, "60", resp.Header.Get("RateLimit-Reset"))
+	}
+
+	resp := get(t, server.URL+"/openapi.json", nil)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+
+	// Other routes count against the default policy
+	resp = get(t, server.URL+"/", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "100", resp.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "99", resp.Header.Get("RateLimit-Remaining"))
+}
+
+func TestRateLimitSkipsProbesAndMetrics(t *testing.T) {
+	limiter, _ := newLimiter()
+	server := newServer(t, &handlers.RateLimits{
+		Limiter: limiter,
+		Default: ratelimit.Policy{Limit: 1, Window: time.Minute, Key: ratelimit.KeyIP},
+		Routes: map[string]ratelimit.Policy{
+			"GET /healthz": {Limit: 1, Window: time.Minute, Key: ratelimit.KeyIP},
+		},
+	})
+
+	// Even a client over its limit can be probed and scraped
+	assert.Equal(t, http.StatusOK, get(t, server.URL+"/", nil).StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, get(t, server.URL+"/", nil).StatusCode)
+
+	for _, path := range []string{"/healthz", "/readyz", "/metrics"} {
+		for range 3 {
+			resp := get(t, server.URL+path, nil)
+			assert.Equal(t, http.StatusOK, resp.StatusCode, path)
+			assert.Empty(t, resp.Header.Get("RateLimit-Limit"), path)
+		}
+	}
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	alice, err := handlers.MintJwtToken("alice", time.Hour, privateKey)
+	require.NoError(t, err)
+	bob, err := handlers.MintJwtToken("bob", time.Hour, privateKey)
+	require.NoError(t, err)
+
+	limiter, _ := newLimiter()
+	limits := &handlers.RateLimits{
+		Limiter: limiter,
+		Routes: map[string]ratelimit.Policy{
+			"GET /openapi.json": {Limit: 1, Window: time.Minute, Key: ratelimit.KeySubject},
+			"GET /":             {Limit: 1, Window: time.Minute, Key: ratelimit.KeyAPIKey},
+		},
+		PublicKey:      &privateKey.PublicKey,
+		APIKeys:        map[string]bool{"key-1": true, "key-2": true},
+		TrustedProxies: 1,
+	}
+	server := newServer(t, limits)
+
+	status := func(path string, headers map[string]string) int {
+		return get(t, server.URL+path, headers).StatusCode
+	}
+
+	// By subject, falling back to the client IP without a valid token
+	assert.Equal(t, http.StatusOK, status("/openapi.json", map[string]string{"Authorization": "Bearer " + alice}))
+	assert.Equal(t, http.StatusTooManyRequests, status("/openapi.json", map[string]string{"Authorization": "Bearer " + alice}))
+	assert.Equal(t, http.StatusOK, status("/openapi.json", map[string]string{"Authorization": "Bearer " + bob}))
+	assert.Equal(t, http.StatusOK, status("/openapi.json", map[string]string{"Authorization": "Bearer forged"}))
+	assert.Equal(t, http.StatusTooManyRequests, status("/openapi.json", nil))
+
+	// By API key
+	assert.Equal(t, http.StatusOK, status("/", map[string]string{handlers.APIKeyHeader: "key-1"}))
+	assert.Equal(t, http.StatusTooManyRequests, status("/", map[string]string{handlers.APIKeyHeader: "key-1"}))
+	assert.Equal(t, http.StatusOK, status("/", map[string]string{handlers.APIKeyHeader: "key-2"}))
+
+	// By the address the trusted proxy saw; entries before it can be forged
+	assert.Equal(t, http.StatusOK, status("/", map[string]string{"X-Forwarded-For": "1.1.1.1, 192.0.2.1"}))
+	assert.Equal(t, http.StatusTooManyRequests, status("/", map[string]string{"X-Forwarded-For": "2.2.2.2, 192.0.2.1"}))
+	assert.Equal(t, http.StatusOK, status("/", map[string]string{"X-Forwarded-For": "192.0.2.2"}))
+}
+
+func TestRateLimitAPIKeysFromConfig(t *testing.T) {
+	t.Setenv("STORAGE_BACKEND", "memory")
+	t.Setenv("RATE_LIMIT_API_KEYS", " key-1,key-2,, ")
+	cfg, err := config.LoadConfig()
+	require.NoError(t, err)
+
+	limits := handlers.NewRateLimits(nil, cfg)
+	assert.Equal(t, map[string]bool{"key-1": true, "key-2": true}, limits.APIKeys)
+}
+
+func TestRateLimitIgnoresUnknownAPIKeys(t *testing.T) {
+	limiter, _ := newLimiter()
+	server := newServer(t, &handlers.RateLimits{
+		Limiter: limiter,
+		Default: ratelimit.Policy{Limit: 2, Window: time.Minute, Key: ratelimit.KeyAPIKey},
+		APIKeys: map[string]bool{"known": true},
+	})
+
+	// A new key per request does not buy a new bucket
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		resp := get(t, server.URL+"/", map[string]string{handlers.APIKeyHeader: fmt.Sprintf("rotated-%d", i)})
+		assert.Equal(t, expected, resp.StatusCode)
+	}
+
+	// Known keys are counted on their own
+	resp := get(t, server.URL+"/", map[string]string{handlers.APIKeyHeader: "known"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestRateLimitFailsOpen(t *testing.T) {
+	server := newServer(t, &handlers.RateLimits{
+		Limiter: ratelimit.NewLimiter(failingStore{}),
+		Default: ratelimit.Policy{Limit: 1, Window: time.Minute, Key: ratelimit.KeyIP},
+	})
+
+	for range 3 {
+		resp := get(t, server.URL+"/", nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("RateLimit-Limit"))
+	}
+}
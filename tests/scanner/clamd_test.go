@@ -0,0 +1,122 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/scanner"
+)
+
+// fakeClamd serves the INSTREAM command, replying with reply(stream) for each
+// stream it receives
+func fakeClamd(t *testing.T, listener net.Listener, reply func(stream []byte) string) {
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveClamd(conn, reply)
+		}
+	}()
+}
+
+func serveClamd(conn net.Conn, reply func(stream []byte) string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	command, err := r.ReadString(0)
+	if err != nil || command != "zINSTREAM\x00" {
+		io.WriteString(conn, "UNKNOWN COMMAND\x00")
+		return
+	}
+
+	var stream bytes.Buffer
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(&stream, r, int64(size)); err != nil {
+			return
+		}
+	}
+
+	io.WriteString(conn, reply(stream.Bytes())+"\x00")
+}
+
+func eicarReply(stream []byte) string {
+	if bytes.Contains(stream, []byte("EICAR")) {
+		return "stream: Eicar-Test-Signature FOUND"
+	}
+	return "stream: OK"
+}
+
+func TestClamdScannerOverTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	fakeClamd(t, listener, eicarReply)
+
+	s, err := scanner.NewClamdScanner("tcp://"+listener.Addr().String(), time.Second)
+	require.NoError(t, err)
+
+	// Large files are sent in several chunks
+	result, err := s.Scan(context.Background(), strings.NewReader(strings.Repeat("clean ", 50000)))
+	require.NoError(t, err)
+	assert.False(t, result.Infected)
+
+	result, err = s.Scan(context.Background(), strings.NewReader("X5O!P%@AP EICAR"))
+	require.NoError(t, err)
+	assert.True(t, result.Infected)
+	assert.Equal(t, "Eicar-Test-Signature", result.Signature)
+}
+
+func TestClamdScannerOverUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "clamd.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	fakeClamd(t, listener, eicarReply)
+
+	s, err := scanner.NewClamdScanner("unix://"+socket, time.Second)
+	require.NoError(t, err)
+
+	result, err := s.Scan(context.Background(), strings.NewReader("EICAR"))
+	require.NoError(t, err)
+	assert.True(t, result.Infected)
+}
+
+func TestClamdScannerErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	fakeClamd(t, listener, func([]byte) string { return "INSTREAM size limit exceeded. ERROR" })
+
+	s, err := scanner.NewClamdScanner(listener.Addr().String(), time.Second)
+	require.NoError(t, err)
+
+	_, err = s.Scan(context.Background(), strings.NewReader("data"))
+	assert.ErrorContains(t, err, "size limit exceeded")
+
+	_, err = scanner.NewClamdScanner("clamd", time.Second)
+	assert.Error(t, err)
+}
+
+func TestNoopScannerReportsClean(t *testing.T) {
+	result, err := scanner.NewNoopScanner().Scan(context.Background(), strings.NewReader("EICAR"))
+	require.NoError(t, err)
+	assert.False(t, result.Infected)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	handlers "github.com/zzenonn/go-zenon-api-aws/internal/transport/http"
+)
+
+func newMainHandler(cfg *config.Config) *handlers.MainHandler {
+	mainHandler := handlers.NewMainHandler(cfg)
+	mainHandler.AddHandler(handlers.NewHealthHandler(nil, time.Second, 0))
+	mainHandler.MapRoutes()
+	return mainHandler
+}
+
+func listen(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	return listener
+}
+
+func status(t *testing.T, url string) int {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestServerTimeouts(t *testing.T) {
+	mainHandler := handlers.NewMainHandler(&config.Config{
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       time.Minute,
+		WriteTimeout:      2 * time.Minute,
+		IdleTimeout:       3 * time.Minute,
+		RequestTimeout:    30 * time.Second,
+	})
+
+	assert.Equal(t, 5*time.Second, mainHandler.Server.ReadHeaderTimeout)
+	assert.Equal(t, time.Minute, mainHandler.Server.ReadTimeout)
+	assert.Equal(t, 2*time.Minute, mainHandler.Server.WriteTimeout)
+	assert.Equal(t, 3*time.Minute, mainHandler.Server.IdleTimeout)
+	assert.Equal(t, 30*time.Second, mainHandler.RequestTimeout)
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	mainHandler := newMainHandler(&config.Config{
+		ShutdownDrain:   300 * time.Millisecond,
+		ShutdownTimeout: time.Second,
+	})
+	listener := listen(t)
+	url := "http://" + listener.Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() {
+		served <- mainHandler.ServeListener(ctx, listener)
+	}()
+
+	require.Equal(t, http.StatusOK, status(t, url+"/readyz"))
+
+	// While draining, requests are still served but readiness fails
+	cancel()
+	require.Eventually(t, func() bool {
+		return status(t, url+"/readyz") == http.StatusServiceUnavailable
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, http.StatusOK, status(t, url+"/healthz"))
+
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+
+	_, err := http.Get(url + "/healthz")
+	assert.Error(t, err)
+}
+
+func TestServeReturnsWhenListenerFails(t *testing.T) {
+	mainHandler := newMainHandler(&config.Config{})
+	listener := listen(t)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- mainHandler.ServeListener(context.Background(), listener)
+	}()
+
+	listener.Close()
+
+	select {
+	case err := <-served:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not return after its listener failed")
+	}
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/audit"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/memory"
+	"github.com/zzenonn/go-zenon-api-aws/internal/service"
+)
+
+// tamperedAudit changes events as they are read back, as someone editing
+// the table directly would
+type tamperedAudit struct {
+	*memory.AuditRepository
+	tamper func(events []domain.AuditEvent) []domain.AuditEvent
+}
+
+func (t *tamperedAudit) ChainEvents(ctx context.Context, after int64, limit int) ([]domain.AuditEvent, error) {
+	events, err := t.AuditRepository.ChainEvents(ctx, after, limit)
+	if err != nil || t.tamper == nil {
+		return events, err
+	}
+	return t.tamper(events), nil
+}
+
+// contextAudit fails appends whose context is done, as a remote store would,
+// and records whether they had a deadline
+type contextAudit struct {
+	*memory.AuditRepository
+	deadlines []bool
+}
+
+func (c *contextAudit) AppendEvent(ctx context.Context, event domain.AuditEvent) (domain.AuditEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return domain.AuditEvent{}, err
+	}
+	_, hasDeadline := ctx.Deadline()
+	c.deadlines = append(c.deadlines, hasDeadline)
+	return c.AuditRepository.AppendEvent(ctx, event)
+}
+
+func newAuditedUserService(t *testing.T, repo service.AuditRepository) (*service.UserService, *service.AuditService) {
+	users := memory.NewUserRepository()
+
+	username, role := "root", domain.RoleAdmin
+	_, err := users.CreateUser(context.Background(), domain.User{Username: &username, HashedPassword: []byte("hash"), Role: &role})
+	require.NoError(t, err)
+
+	auditor := service.NewAuditService(repo, &users)
+	objects := memory.NewObjectRepository()
+	return service.NewUserService(&users, &objects, service.WithAuditor(auditor)), auditor
+}
+
+func TestAuditRecordsUserChanges(t *testing.T) {
+	repo := memory.NewAuditRepository()
+	svc, auditor := newAuditedUserService(t, &repo)
+
+	ctx := audit.WithActor(context.Background(), "root")
+	ctx = audit.WithClient(ctx, audit.Client{IP: "192.0.2.1", UserAgent: "test-agent"})
+
+	username := "alice"
+	_, err := svc.CreateUser(ctx, domain.User{Username: &username, Password: "secret-password"})
+	require.NoError(t, err)
+	assert.Error(t, svc.Login(context.Background(), "alice", "wrong"))
+	require.NoError(t, svc.Login(context.Background(), "alice", "secret-password"))
+	require.NoError(t, svc.SetRole(ctx, "alice", domain.RoleAdmin))
+	require.NoError(t, svc.DeleteUser(ctx, "alice"))
+
+	events, nextToken, err := auditor.ListEvents(context.Background(), "root", domain.AuditFilter{Target: "alice"}, 0, "")
+	require.NoError(t, err)
+	assert.Empty(t, nextToken)
+	require.Len(t, events, 5)
+
+	// Newest first
+	assert.Equal(t, domain.AuditUserDelete, events[0].Action)
+	assert.Equal(t, domain.AuditRoleChange, events[1].Action)
+	assert.Equal(t, "role=admin", events[1].Detail)
+	assert.Equal(t, domain.AuditLogin, events[2].Action)
+	assert.Equal(t, domain.AuditSuccess, events[2].Outcome)
+	assert.Equal(t, domain.AuditLogin, events[3].Action)
+	assert.Equal(t, domain.AuditFailure, events[3].Outcome)
+	assert.Equal(t, "alice", events[3].Actor)
+	assert.NotEmpty(t, events[3].Reason)
+
+	created := events[4]
+	assert.Equal(t, domain.AuditUserCreate, created.Action)
+	assert.Equal(t, "root", created.Actor)
+	assert.Equal(t, domain.AuditSuccess, created.Outcome)
+	assert.Equal(t, "192.0.2.1", created.ClientIP)
+	assert.Equal(t, "test-agent", created.UserAgent)
+	assert.Equal(t, int64(1), created.Sequence)
+	assert.Equal(t, domain.AuditGenesisHash, created.PrevHash)
+
+	// Failed changes are recorded too
+	require.Error(t, svc.SetRole(ctx, "nobody", domain.RoleAdmin))
+	events, _, err = auditor.ListEvents(context.Background(), "root", domain.AuditFilter{Outcome: domain.AuditFailure}, 0, "")
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "nobody", events[0].Target)
+}
+
+func TestAuditRecordOutlivesRequest(t *testing.T) {
+	repo := memory.NewAuditRepository()
+	store := &contextAudit{AuditRepository: &repo}
+	auditor := service.NewAuditService(store, nil)
+
+	// The client went away before the event was recorded
+	ctx, cancel := context.WithCancel(audit.WithActor(context.Background(), "alice"))
+	cancel()
+	auditor.Record(ctx, domain.AuditEvent{Action: domain.AuditLogin, Outcome: domain.AuditFailure})
+
+	events, _, err := repo.ListEvents(context.Background(), domain.AuditFilter{}, 0, "")
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "alice", events[0].Actor)
+
+	// Recording is still bounded
+	assert.Equal(t, []bool{true}, store.deadlines)
+}
+
+func TestAuditListPages(t *testing.T) {
+	repo := memory.NewAuditRepository()
+	_, auditor := newAuditedUserService(t, &repo)
+	ctx := context.Background()
+
+	for range 5 {
+		auditor.Record(ctx, domain.AuditEvent{Action: domain.AuditLogin, Outcome: domain.AuditSuccess})
+	}
+
+	var sequences []int64
+	token := ""
+	for {
+		events, next, err := auditor.ListEvents(ctx, "root", domain.AuditFilter{}, 2, token)
+		require.NoError(t, err)
+		for _, event := range events {
+			sequences = append(sequences, event.Sequence)
+			assert.Equal(t, domain.AuditAnonymous, event.Actor)
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	assert.Equal(t, []int64{5, 4, 3, 2, 1}, sequences)
+
+	_, _, err := auditor.ListEvents(ctx, "root", domain.AuditFilter{}, 2, "not-a-token")
+	assert.ErrorIs(t, err, errors.ErrInvalidPageToken)
+}
+
+func TestAuditVerifyDetectsTampering(t *testing.T) {
+	repo := memory.NewAuditRepository()
+	tampered := &tamperedAudit{AuditRepository: &repo}
+	_, auditor := newAuditedUserService(t, tampered)
+	ctx := context.Background()
+
+	for _, target := range []string{"alice", "bob", "carol"} {
+		auditor.Record(ctx, domain.AuditEvent{Action: domain.AuditUserDelete, Target: target, Outcome: domain.AuditSuccess})
+	}
+
+	result, err := auditor.Verify(ctx, "root")
+	require.NoError(t, err)
+	assert.True(t, result.Valid)
+	assert.Equal(t, int64(3), result.Events)
+
+	tests := []struct {
+		name   string
+		tamper func(events []domain.AuditEvent) []domain.AuditEvent
+		broken int64
+	}{
+		{"changed", func(events []domain.AuditEvent) []domain.AuditEvent {
+			events[1].Target = "mallory"
+			return events
+		}, 2},
+		{"changed and rehashed", func(events []domain.AuditEvent) []domain.AuditEvent {
+			events[1].Target = "mallory"
+			events[1].Hash = events[1].ComputeHash()
+			return events
+		}, 3},
+		{"removed", func(events []domain.AuditEvent) []domain.AuditEvent {
+			return append(events[:1], events[2:]...)
+		}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered.tamper = tt.tamper
+			result, err := auditor.Verify(ctx, "root")
+			require.NoError(t, err)
+			assert.False(t, result.Valid)
+			assert.Equal(t, tt.broken, result.BrokenAt)
+			assert.NotEmpty(t, result.Reason)
+		})
+	}
+}
+
+func TestAuditRequiresAdmin(t *testing.T) {
+	repo := memory.NewAuditRepository()
+	svc, auditor := newAuditedUserService(t, &repo)
+	ctx := context.Background()
+
+	username := "alice"
+	_, err := svc.CreateUser(ctx, domain.User{Username: &username, Password: "secret-password"})
+	require.NoError(t, err)
+
+	_, _, err = auditor.ListEvents(ctx, "alice", domain.AuditFilter{}, 0, "")
+	assert.ErrorIs(t, err, errors.ErrForbidden)
+	_, err = auditor.Verify(ctx, "nobody")
+	assert.ErrorIs(t, err, errors.ErrForbidden)
+
+	// The attempts themselves are recorded
+	events, _, err := auditor.ListEvents(ctx, "root", domain.AuditFilter{Action: domain.AuditAccessDenied}, 0, "")
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "nobody", events[0].Actor)
+	assert.Equal(t, "alice", events[1].Actor)
+	assert.Equal(t, domain.AuditDenied, events[1].Outcome)
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/memory"
+	"github.com/zzenonn/go-zenon-api-aws/internal/service"
+)
+
+func TestContentAddressedFilesShareObjects(t *testing.T) {
+	objects := memory.NewObjectRepository()
+	svc, _ := newFileService(t, &objects, service.WithContentAddressing(true))
+	ctx := context.Background()
+
+	first, err := svc.UploadFile(ctx, "bob", "a.txt", "text/plain", strings.NewReader("same content"))
+	require.NoError(t, err)
+	second, err := svc.UploadFile(ctx, "bob", "b.txt", "text/plain", strings.NewReader("same content"))
+	require.NoError(t, err)
+
+	assert.NotEqual(t, first.ID, second.ID)
+	assert.Equal(t, first.Key, second.Key)
+	assert.Equal(t, "blobs/sha256/"+first.Checksum, first.Key)
+
+	// Both files count against the quota
+	used, _, err := svc.GetUsage(ctx, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2*len("same content")), used)
+
+	require.NoError(t, svc.DeleteFile(ctx, "bob", first.ID))
+	collected, err := svc.CollectBlobs(ctx, -time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 0, collected)
+	_, exists := objects.Get(second.Key)
+	assert.True(t, exists)
+
+	// The blob is kept for the grace period after its last file is deleted
+	require.NoError(t, svc.DeleteFile(ctx, "bob", second.ID))
+	collected, err = svc.CollectBlobs(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0, collected)
+
+	collected, err = svc.CollectBlobs(ctx, -time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 1, collected)
+	_, exists = objects.Get(second.Key)
+	assert.False(t, exists)
+
+	// The content can be stored again once collected
+	third, err := svc.UploadFile(ctx, "bob", "c.txt", "text/plain", strings.NewReader("same content"))
+	require.NoError(t, err)
+	_, exists = objects.Get(third.Key)
+	assert.True(t, exists)
+}
+
+func TestContentAddressedMultipartUploadDeduplicates(t *testing.T) {
+	objects := memory.NewObjectRepository()
+	svc, _ := newFileService(t, &objects, service.WithContentAddressing(true))
+	ctx := context.Background()
+	data := "multipart content"
+
+	existing, err := svc.UploadFile(ctx, "bob", "a.txt", "text/plain", strings.NewReader(data))
+	require.NoError(t, err)
+
+	upload, err := svc.CreateUpload(ctx, "bob", "b.txt", "text/plain", int64(len(data)), "")
+	require.NoError(t, err)
+	_, err = svc.UploadPart(ctx, "bob", upload.ID, 1, strings.NewReader(data))
+	require.NoError(t, err)
+
+	file, err := svc.CompleteUpload(ctx, "bob", upload.ID, "")
+	require.NoError(t, err)
+
+	// The uploaded copy is dropped in favour of the stored blob
+	assert.Equal(t, existing.Key, file.Key)
+	_, exists := objects.Get(upload.Key)
+	assert.False(t, exists)
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+	"github.com/zzenonn/go-zenon-api-aws/internal/imaging"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/memory"
+	"github.com/zzenonn/go-zenon-api-aws/internal/service"
+)
+
+// presignRequest holds the arguments of a PresignUpload call
+type presignRequest struct {
+	key         string
+	method      string
+	contentType string
+	size        int64
+	maxSize     int64
+	expires     time.Duration
+}
+
+// directUploads is a memory object store that clients can upload to directly
+type directUploads struct {
+	*memory.ObjectRepository
+	presigned []presignRequest
+}
+
+func (d *directUploads) PresignUpload(ctx context.Context, key string, method string, contentType string, size int64, maxSize int64, expires time.Duration) (domain.PresignedUpload, error) {
+	d.presigned = append(d.presigned, presignRequest{key, method, contentType, size, maxSize, expires})
+	return domain.PresignedUpload{Method: method, URL: "memory://" + key, Key: key, ExpiresAt: time.Now().Add(expires)}, nil
+}
+
+func (d *directUploads) Stat(ctx context.Context, key string) (domain.ObjectInfo, error) {
+	data, exists := d.Get(key)
+	if !exists {
+		return domain.ObjectInfo{}, errors.ErrObjectNotFound
+	}
+	return domain.ObjectInfo{Key: key, Size: int64(len(data)), ContentType: d.ContentType(key)}, nil
+}
+
+func newDirectUploadService(t *testing.T, opts ...service.Option) (*service.UserService, *directUploads) {
+	users := memory.NewUserRepository()
+	profiles := memory.NewObjectRepository()
+	uploads := &directUploads{ObjectRepository: &profiles}
+
+	username := "alice"
+	_, err := users.CreateUser(context.Background(), domain.User{Username: &username, HashedPassword: []byte("hash")})
+	require.NoError(t, err)
+
+	imageOpts := imaging.DefaultOptions()
+	imageOpts.Sizes = []int{64}
+	opts = append([]service.Option{
+		service.WithImageOptions(imageOpts),
+		service.WithMaxProfileSize(1 << 20),
+		service.WithUploadURLTTL(5 * time.Minute),
+	}, opts...)
+	return service.NewUserService(&users, uploads, opts...), uploads
+}
+
+func TestCreateProfileUpload(t *testing.T) {
+	svc, uploads := newDirectUploadService(t)
+	ctx := context.Background()
+
+	upload, err := svc.CreateProfileUpload(ctx, "alice", "", imaging.ContentTypePNG, 1024)
+	require.NoError(t, err)
+	assert.Equal(t, domain.UploadMethodPut, upload.Method)
+	assert.Regexp(t, `^pending/alice/profile/[0-9a-f]+\.png$`, upload.Key)
+
+	// Uploads are signed for the declared type and size, capped at the limit
+	require.Len(t, uploads.presigned, 1)
+	assert.Equal(t, presignRequest{
+		key:         upload.Key,
+		method:      domain.UploadMethodPut,
+		contentType: imaging.ContentTypePNG,
+		size:        1024,
+		maxSize:     1 << 20,
+		expires:     5 * time.Minute,
+	}, uploads.presigned[0])
+
+	// POST policies carry the limit instead of an exact size
+	upload, err = svc.CreateProfileUpload(ctx, "alice", domain.UploadMethodPost, imaging.ContentTypeJPEG, 0)
+	require.NoError(t, err)
+	assert.Regexp(t, `^pending/alice/profile/[0-9a-f]+\.jpg$`, upload.Key)
+	assert.Equal(t, int64(1<<20), uploads.presigned[1].maxSize)
+}
+
+func TestCreateProfileUploadRejectsInvalidRequests(t *testing.T) {
+	svc, uploads := newDirectUploadService(t)
+	ctx := context.Background()
+
+	_, err := svc.CreateProfileUpload(ctx, "alice", "", "application/pdf", 1024)
+	assert.ErrorIs(t, err, errors.ErrUnsupportedMediaType)
+
+	_, err = svc.CreateProfileUpload(ctx, "alice", domain.UploadMethodPut, imaging.ContentTypePNG, 0)
+	assert.ErrorIs(t, err, errors.ErrMissingRequiredFields)
+
+	_, err = svc.CreateProfileUpload(ctx, "alice", "", imaging.ContentTypePNG, 1<<20+1)
+	assert.ErrorIs(t, err, errors.ErrFileTooLarge)
+
+	_, err = svc.CreateProfileUpload(ctx, "nobody", "", imaging.ContentTypePNG, 1024)
+	assert.ErrorIs(t, err, errors.ErrUserNotFound)
+
+	assert.Empty(t, uploads.presigned)
+
+	// Stores that cannot presign uploads do not offer them
+	users := memory.NewUserRepository()
+	profiles := memory.NewObjectRepository()
+	_, err = service.NewUserService(&users, &profiles).CreateProfileUpload(ctx, "alice", "", imaging.ContentTypePNG, 1024)
+	assert.ErrorIs(t, err, errors.ErrNotImplemented)
+}
+
+func TestConfirmProfileUpload(t *testing.T) {
+	svc, uploads := newDirectUploadService(t)
+	ctx := context.Background()
+
+	upload, err := svc.CreateProfileUpload(ctx, "alice", "", imaging.ContentTypePNG, 1024)
+	require.NoError(t, err)
+	require.NoError(t, uploads.Upload(ctx, upload.Key, bytes.NewReader(encodeImage(t, imaging.ContentTypePNG)), imaging.ContentTypePNG, ""))
+
+	user, err := svc.ConfirmProfileUpload(ctx, "alice", upload.Key)
+	require.NoError(t, err)
+	require.NotNil(t, user.ProfilePath)
+	assert.Regexp(t, `^alice/profile/versions/[0-9a-f]+/profile\.png$`, *user.ProfilePath)
+
+	// The pending upload is gone; the profile and its thumbnail remain
+	thumbnail := strings.TrimSuffix(*user.ProfilePath, ".png") + "-64.png"
+	assert.ElementsMatch(t, []string{*user.ProfilePath, thumbnail}, uploads.Keys())
+}
+
+func TestConfirmProfileUploadRejectsInvalidUploads(t *testing.T) {
+	png := encodeImage(t, imaging.ContentTypePNG)
+	tests := []struct {
+		name        string
+		key         string
+		data        []byte
+		contentType string
+		err         error
+	}{
+		{"declared type differs", "pending/alice/profile/a.png", png, imaging.ContentTypeJPEG, errors.ErrInvalidUpload},
+		{"extension differs", "pending/alice/profile/a.jpg", png, imaging.ContentTypePNG, errors.ErrInvalidUpload},
+		{"not an image", "pending/alice/profile/a.png", []byte("not an image"), imaging.ContentTypePNG, errors.ErrInvalidUpload},
+		{"empty", "pending/alice/profile/a.png", []byte{}, imaging.ContentTypePNG, errors.ErrInvalidUpload},
+		{"too large", "pending/alice/profile/a.png", append(bytes.Clone(png), make([]byte, 1<<20)...), imaging.ContentTypePNG, errors.ErrFileTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, uploads := newDirectUploadService(t)
+			ctx := context.Background()
+			require.NoError(t, uploads.Upload(ctx, tt.key, bytes.NewReader(tt.data), tt.contentType, ""))
+
+			_, err := svc.ConfirmProfileUpload(ctx, "alice", tt.key)
+			assert.ErrorIs(t, err, tt.err)
+
+			// The pending upload is deleted and no profile was stored
+			assert.Empty(t, uploads.Keys())
+			_, err = svc.GetProfileURL(ctx, "alice", "alice", 0)
+			assert.ErrorIs(t, err, errors.ErrProfileNotFound)
+		})
+	}
+}
+
+func TestConfirmProfileUploadOnlyAcceptsPendingKeysOfTheUser(t *testing.T) {
+	svc, uploads := newDirectUploadService(t)
+	ctx := context.Background()
+	png := encodeImage(t, imaging.ContentTypePNG)
+
+	keys := []string{
+		"pending/bob/profile/a.png",
+		"pending/alice/profile/",
+		"pending/alice/profile/nested/a.png",
+		"alice/profile/profile.png",
+	}
+	for _, key := range keys {
+		require.NoError(t, uploads.Upload(ctx, key, bytes.NewReader(png), imaging.ContentTypePNG, ""))
+
+		_, err := svc.ConfirmProfileUpload(ctx, "alice", key)
+		assert.ErrorIs(t, err, errors.ErrInvalidObjectKey, key)
+	}
+
+	// Objects outside the user's pending uploads are left alone
+	assert.ElementsMatch(t, keys, uploads.Keys())
+
+	_, err := svc.ConfirmProfileUpload(ctx, "alice", "pending/alice/profile/missing.png")
+	assert.ErrorIs(t, err, errors.ErrObjectNotFound)
+}
+
+func TestConfirmProfileUploadScansUpload(t *testing.T) {
+	scanner := &stubScanner{}
+	svc, uploads := newDirectUploadService(t, service.WithScanner(scanner))
+	scanner.objects = uploads.ObjectRepository
+	ctx := context.Background()
+
+	key := "pending/alice/profile/a.png"
+	data := append(encodeImage(t, imaging.ContentTypePNG), []byte("MALWARE")...)
+	require.NoError(t, uploads.Upload(ctx, key, bytes.NewReader(data), imaging.ContentTypePNG, ""))
+
+	_, err := svc.ConfirmProfileUpload(ctx, "alice", key)
+	assert.ErrorIs(t, err, errors.ErrMalwareDetected)
+	assert.Empty(t, uploads.Keys())
+}
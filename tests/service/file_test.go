@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	stderrors "errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/memory"
+	"github.com/zzenonn/go-zenon-api-aws/internal/service"
+)
+
+// failingObjects is an object store whose uploads always fail
+type failingObjects struct {
+	memory.ObjectRepository
+}
+
+func (f *failingObjects) Upload(ctx context.Context, key string, r io.Reader, contentType string, checksum string) error {
+	return stderrors.New("storage unavailable")
+}
+
+func newFileService(t *testing.T, objects service.ObjectRepository, opts ...service.FileOption) (*service.FileService, *memory.FileRepository) {
+	users := memory.NewUserRepository()
+	files := memory.NewFileRepository()
+
+	username := "bob"
+	_, err := users.CreateUser(context.Background(), domain.User{Username: &username, HashedPassword: []byte("hash")})
+	require.NoError(t, err)
+
+	return service.NewFileService(&files, &users, objects, opts...), &files
+}
+
+func TestUploadFileEnforcesQuotaBeforeUpload(t *testing.T) {
+	objects := memory.NewObjectRepository()
+	svc, _ := newFileService(t, &objects, service.WithFileQuota(10))
+	ctx := context.Background()
+
+	first, err := svc.UploadFile(ctx, "bob", "a.txt", "text/plain", strings.NewReader("12345678"))
+	require.NoError(t, err)
+
+	_, err = svc.UploadFile(ctx, "bob", "b.txt", "text/plain", strings.NewReader("12345"))
+	assert.ErrorIs(t, err, errors.ErrQuotaExceeded)
+
+	// Only the first file reached object storage
+	_, exists := objects.Get(first.Key)
+	assert.True(t, exists)
+	used, quota, err := svc.GetUsage(ctx, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), used)
+	assert.Equal(t, int64(10), quota)
+}
+
+func TestUploadFileReleasesQuotaWhenUploadFails(t *testing.T) {
+	svc, files := newFileService(t, &failingObjects{memory.NewObjectRepository()})
+	ctx := context.Background()
+
+	_, err := svc.UploadFile(ctx, "bob", "a.txt", "text/plain", strings.NewReader("data"))
+	require.Error(t, err)
+
+	used, err := files.GetUsage(ctx, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), used)
+
+	listed, _, err := files.ListFiles(ctx, "bob", 10, "")
+	require.NoError(t, err)
+	assert.Empty(t, listed)
+}
+
+func TestUploadFileLimitsSize(t *testing.T) {
+	objects := memory.NewObjectRepository()
+	svc, _ := newFileService(t, &objects, service.WithMaxFileSize(4))
+
+	_, err := svc.UploadFile(context.Background(), "bob", "a.txt", "", strings.NewReader("12345"))
+	assert.ErrorIs(t, err, errors.ErrFileTooLarge)
+}
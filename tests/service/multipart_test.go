@@ -0,0 +1,319 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	stderrors "errors"
+	"io"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/memory"
+	"github.com/zzenonn/go-zenon-api-aws/internal/service"
+)
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// flakyDownloads is a memory object store whose downloads can be made to
+// fail partway through or to wait until released
+type flakyDownloads struct {
+	*memory.ObjectRepository
+
+	mu      sync.Mutex
+	err     error
+	release chan struct{}
+}
+
+func (f *flakyDownloads) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	err, release := f.err, f.release
+	f.mu.Unlock()
+
+	if release != nil {
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	body, downloadErr := f.ObjectRepository.Download(ctx, key)
+	if downloadErr != nil || err == nil {
+		return body, downloadErr
+	}
+	return io.NopCloser(io.MultiReader(io.LimitReader(body, 2), iotest.ErrReader(err))), nil
+}
+
+func (f *flakyDownloads) fail(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *flakyDownloads) hold(release chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.release = release
+}
+
+// startUpload creates an upload of data for bob and sends all of its parts
+func startUpload(t *testing.T, svc *service.FileService, data []byte) domain.MultipartUpload {
+	ctx := context.Background()
+
+	upload, err := svc.CreateUpload(ctx, "bob", "big.bin", "", int64(len(data)), checksum(data))
+	require.NoError(t, err)
+
+	for number := int32(1); number <= upload.PartCount; number++ {
+		start := int64(number-1) * upload.PartSize
+		end := min(start+upload.PartSize, int64(len(data)))
+		_, err := svc.UploadPart(ctx, "bob", upload.ID, number, bytes.NewReader(data[start:end]))
+		require.NoError(t, err)
+	}
+	return upload
+}
+
+func TestMultipartUploadResumes(t *testing.T) {
+	objects := memory.NewObjectRepository()
+	svc, _ := newFileService(t, &objects, service.WithUploadPartSize(4))
+	ctx := context.Background()
+	data := []byte("0123456789")
+
+	upload, err := svc.CreateUpload(ctx, "bob", "big.bin", "", int64(len(data)), checksum(data))
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), upload.PartCount)
+
+	// Parts may arrive out of order, and a short part is rejected
+	_, err = svc.UploadPart(ctx, "bob", upload.ID, 3, bytes.NewReader(data[8:]))
+	require.NoError(t, err)
+	_, err = svc.UploadPart(ctx, "bob", upload.ID, 1, bytes.NewReader(data[:3]))
+	assert.ErrorIs(t, err, errors.ErrInvalidPart)
+	_, err = svc.UploadPart(ctx, "bob", upload.ID, 4, bytes.NewReader(data[:2]))
+	assert.ErrorIs(t, err, errors.ErrInvalidPart)
+	_, err = svc.UploadPart(ctx, "bob", upload.ID, 1, bytes.NewReader(data[:4]))
+	require.NoError(t, err)
+
+	_, err = svc.CompleteUpload(ctx, "bob", upload.ID, "")
+	assert.ErrorIs(t, err, errors.ErrIncompleteUpload)
+
+	// A resuming client finds out which parts are missing
+	resumed, err := svc.GetUpload(ctx, "bob", upload.ID)
+	require.NoError(t, err)
+	require.Len(t, resumed.Parts, 2)
+	assert.Equal(t, int32(1), resumed.Parts[0].Number)
+	assert.Equal(t, int32(3), resumed.Parts[1].Number)
+
+	_, err = svc.UploadPart(ctx, "bob", upload.ID, 2, bytes.NewReader(data[4:8]))
+	require.NoError(t, err)
+
+	file, err := svc.CompleteUpload(ctx, "bob", upload.ID, "")
+	require.NoError(t, err)
+	assert.Equal(t, checksum(data), file.Checksum)
+
+	stored, exists := objects.Get(file.Key)
+	require.True(t, exists)
+	assert.Equal(t, data, stored)
+
+	_, _, err = svc.GetFile(ctx, "bob", upload.ID)
+	assert.NoError(t, err)
+	used, _, err := svc.GetUsage(ctx, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), used)
+}
+
+func TestMultipartUploadChecksumMismatch(t *testing.T) {
+	objects := memory.NewObjectRepository()
+	svc, _ := newFileService(t, &objects, service.WithUploadPartSize(4))
+	ctx := context.Background()
+
+	upload, err := svc.CreateUpload(ctx, "bob", "big.bin", "", 4, "")
+	require.NoError(t, err)
+
+	_, err = svc.UploadPart(ctx, "bob", upload.ID, 1, bytes.NewReader([]byte("abcd")))
+	require.NoError(t, err)
+
+	_, err = svc.CompleteUpload(ctx, "bob", upload.ID, checksum([]byte("abce")))
+	assert.ErrorIs(t, err, errors.ErrChecksumMismatch)
+
+	// The unverified object and the upload are discarded
+	_, exists := objects.Get(upload.Key)
+	assert.False(t, exists)
+	_, err = svc.GetUpload(ctx, "bob", upload.ID)
+	assert.ErrorIs(t, err, errors.ErrUploadNotFound)
+	used, _, err := svc.GetUsage(ctx, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), used)
+
+	_, err = svc.CompleteUpload(ctx, "bob", upload.ID, "not-a-checksum")
+	assert.ErrorIs(t, err, errors.ErrInvalidChecksum)
+}
+
+func TestMultipartUploadLimits(t *testing.T) {
+	objects := memory.NewObjectRepository()
+	svc, _ := newFileService(t, &objects, service.WithMaxUploadSize(100), service.WithFileQuota(50))
+	ctx := context.Background()
+
+	_, err := svc.CreateUpload(ctx, "bob", "big.bin", "", 101, "")
+	assert.ErrorIs(t, err, errors.ErrFileTooLarge)
+
+	_, err = svc.CreateUpload(ctx, "bob", "big.bin", "", 60, "")
+	assert.ErrorIs(t, err, errors.ErrQuotaExceeded)
+
+	// Rejected uploads do not linger in object storage
+	pending, err := objects.ListMultipartUploads(ctx, "")
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestAbortAndSweepUploads(t *testing.T) {
+	objects := memory.NewObjectRepository()
+	svc, _ := newFileService(t, &objects)
+	ctx := context.Background()
+
+	aborted, err := svc.CreateUpload(ctx, "bob", "a.bin", "", 10, "")
+	require.NoError(t, err)
+	require.NoError(t, svc.AbortUpload(ctx, "bob", aborted.ID))
+	assert.ErrorIs(t, svc.AbortUpload(ctx, "bob", aborted.ID), errors.ErrUploadNotFound)
+
+	_, err = svc.CreateUpload(ctx, "bob", "b.bin", "", 10, "")
+	require.NoError(t, err)
+
+	// Nothing is old enough yet
+	swept, err := svc.SweepUploads(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0, swept)
+
+	// An upload in object storage without a record is swept as well
+	_, err = objects.CreateMultipartUpload(ctx, "quarantine/bob/files/orphan.bin", "")
+	require.NoError(t, err)
+
+	// Uploads outside quarantine belong to someone else sharing the bucket
+	foreign, err := objects.CreateMultipartUpload(ctx, "backups/db.tar", "")
+	require.NoError(t, err)
+
+	swept, err = svc.SweepUploads(ctx, -time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 2, swept)
+
+	pending, err := objects.ListMultipartUploads(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, foreign, pending[0].UploadID)
+	used, _, err := svc.GetUsage(ctx, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), used)
+}
+
+func TestCompleteUploadResumesAfterInspectionFails(t *testing.T) {
+	memoryObjects := memory.NewObjectRepository()
+	objects := &flakyDownloads{ObjectRepository: &memoryObjects}
+	svc, _ := newFileService(t, objects, service.WithUploadPartSize(4))
+	ctx := context.Background()
+	data := []byte("0123456789")
+	upload := startUpload(t, svc, data)
+
+	// Reading the assembled file back fails partway through
+	objects.fail(stderrors.New("connection reset by peer"))
+	_, err := svc.CompleteUpload(ctx, "bob", upload.ID, "")
+	require.Error(t, err)
+
+	// The parts are gone, but the upload remembers that they were assembled
+	pending, err := memoryObjects.ListMultipartUploads(ctx, "")
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+	assembled, err := svc.GetUpload(ctx, "bob", upload.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, assembled.AssembledAt)
+	assert.Empty(t, assembled.Parts)
+	_, _, err = svc.GetFile(ctx, "bob", upload.ID)
+	assert.ErrorIs(t, err, errors.ErrFileNotFound)
+
+	// The file is verified against the checksum it was assembled with
+	_, err = svc.CompleteUpload(ctx, "bob", upload.ID, checksum([]byte("other")))
+	assert.ErrorIs(t, err, errors.ErrChecksumMismatch)
+
+	// Completing again picks up at verification
+	objects.fail(nil)
+	file, err := svc.CompleteUpload(ctx, "bob", upload.ID, "")
+	require.NoError(t, err)
+	assert.Equal(t, checksum(data), file.Checksum)
+	stored, exists := memoryObjects.Get(file.Key)
+	require.True(t, exists)
+	assert.Equal(t, data, stored)
+
+	// Once completed, the file is returned to further attempts
+	again, err := svc.CompleteUpload(ctx, "bob", upload.ID, "")
+	require.NoError(t, err)
+	assert.Equal(t, file, again)
+	used, _, err := svc.GetUsage(ctx, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), used)
+}
+
+func TestCompleteUploadVerifiesInBackground(t *testing.T) {
+	memoryObjects := memory.NewObjectRepository()
+	objects := &flakyDownloads{ObjectRepository: &memoryObjects}
+	svc, _ := newFileService(t, objects, service.WithUploadPartSize(4))
+	data := []byte("0123456789")
+	upload := startUpload(t, svc, data)
+
+	release := make(chan struct{})
+	objects.hold(release)
+
+	// Requests that end before the file is read back leave it verifying
+	for range 2 {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err := svc.CompleteUpload(ctx, "bob", upload.ID, "")
+		cancel()
+		assert.ErrorIs(t, err, errors.ErrUploadVerifying)
+	}
+
+	close(release)
+	file, err := svc.CompleteUpload(context.Background(), "bob", upload.ID, "")
+	require.NoError(t, err)
+	assert.Equal(t, checksum(data), file.Checksum)
+}
+
+func TestVerifyUploads(t *testing.T) {
+	memoryObjects := memory.NewObjectRepository()
+	objects := &flakyDownloads{ObjectRepository: &memoryObjects}
+	svc, _ := newFileService(t, objects, service.WithUploadPartSize(4))
+	ctx := context.Background()
+	data := []byte("0123456789")
+	upload := startUpload(t, svc, data)
+
+	objects.fail(stderrors.New("connection reset by peer"))
+	_, err := svc.CompleteUpload(ctx, "bob", upload.ID, "")
+	require.Error(t, err)
+	objects.fail(nil)
+
+	// Sweeping does not abort assembled uploads
+	swept, err := svc.SweepUploads(ctx, -time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 0, swept)
+
+	// Uploads that may still be verifying elsewhere are left alone
+	verified, err := svc.VerifyUploads(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0, verified)
+
+	verified, err = svc.VerifyUploads(ctx, -time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 1, verified)
+
+	file, _, err := svc.GetFile(ctx, "bob", upload.ID)
+	require.NoError(t, err)
+	assert.Equal(t, checksum(data), file.Checksum)
+	_, err = svc.GetUpload(ctx, "bob", upload.ID)
+	assert.ErrorIs(t, err, errors.ErrUploadNotFound)
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+	"github.com/zzenonn/go-zenon-api-aws/internal/imaging"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/memory"
+	"github.com/zzenonn/go-zenon-api-aws/internal/service"
+)
+
+func newProfileService(t *testing.T) (*service.UserService, *memory.ObjectRepository) {
+	users := memory.NewUserRepository()
+	profiles := memory.NewObjectRepository()
+
+	username := "alice"
+	_, err := users.CreateUser(context.Background(), domain.User{Username: &username, HashedPassword: []byte("hash")})
+	require.NoError(t, err)
+
+	opts := imaging.DefaultOptions()
+	opts.Sizes = []int{64}
+	return service.NewUserService(&users, &profiles, service.WithImageOptions(opts)), &profiles
+}
+
+func encodeImage(t *testing.T, contentType string) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 80))
+	for x := 0; x < 100; x++ {
+		img.Set(x, x%80, color.RGBA{R: 255, A: 255})
+	}
+
+	var buf bytes.Buffer
+	if contentType == imaging.ContentTypeJPEG {
+		require.NoError(t, jpeg.Encode(&buf, img, nil))
+	} else {
+		require.NoError(t, png.Encode(&buf, img))
+	}
+	return buf.Bytes()
+}
+
+func TestUploadProfileRecordsPathAndContentType(t *testing.T) {
+	svc, profiles := newProfileService(t)
+	ctx := context.Background()
+
+	user, err := svc.UploadProfile(ctx, "alice", bytes.NewReader(encodeImage(t, imaging.ContentTypePNG)))
+	require.NoError(t, err)
+	require.NotNil(t, user.ProfilePath)
+	assert.Regexp(t, `^alice/profile/versions/[0-9a-f]+/profile\.png$`, *user.ProfilePath)
+
+	thumbnail := strings.TrimSuffix(*user.ProfilePath, ".png") + "-64.png"
+	assert.ElementsMatch(t, []string{*user.ProfilePath, thumbnail}, profiles.Keys())
+	for _, key := range profiles.Keys() {
+		assert.Equal(t, imaging.ContentTypePNG, profiles.ContentType(key))
+	}
+
+	// The checksum is that of the stored full-size image
+	data, _ := profiles.Get(*user.ProfilePath)
+	sum := sha256.Sum256(data)
+	require.NotNil(t, user.ProfileChecksum)
+	assert.Equal(t, hex.EncodeToString(sum[:]), *user.ProfileChecksum)
+}
+
+func TestUploadProfileReplacesUnversionedProfile(t *testing.T) {
+	users := memory.NewUserRepository()
+	profiles := memory.NewObjectRepository()
+	ctx := context.Background()
+
+	// A profile stored before versioning sits at the fixed keys
+	username := "alice"
+	profilePath := "alice/profile/profile.jpg"
+	_, err := users.CreateUser(ctx, domain.User{Username: &username, HashedPassword: []byte("hash"), ProfilePath: &profilePath})
+	require.NoError(t, err)
+	for _, key := range []string{"alice/profile/profile.jpg", "alice/profile/profile-64.jpg"} {
+		require.NoError(t, profiles.Upload(ctx, key, strings.NewReader("old"), imaging.ContentTypeJPEG, ""))
+	}
+
+	opts := imaging.DefaultOptions()
+	opts.Sizes = []int{64}
+	svc := service.NewUserService(&users, &profiles, service.WithImageOptions(opts))
+
+	user, err := svc.UploadProfile(ctx, "alice", bytes.NewReader(encodeImage(t, imaging.ContentTypePNG)))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(*user.ProfilePath, "alice/profile/versions/"))
+
+	// The JPEG and its thumbnail are gone
+	for _, key := range []string{"alice/profile/profile.jpg", "alice/profile/profile-64.jpg"} {
+		_, exists := profiles.Get(key)
+		assert.False(t, exists, key)
+	}
+}
+
+func TestDeleteProfileRemovesEveryRendition(t *testing.T) {
+	svc, profiles := newProfileService(t)
+	ctx := context.Background()
+
+	assert.ErrorIs(t, svc.DeleteProfile(ctx, "alice"), errors.ErrProfileNotFound)
+
+	_, err := svc.UploadProfile(ctx, "alice", bytes.NewReader(encodeImage(t, imaging.ContentTypeJPEG)))
+	require.NoError(t, err)
+	_, err = svc.UploadProfile(ctx, "alice", bytes.NewReader(encodeImage(t, imaging.ContentTypePNG)))
+	require.NoError(t, err)
+	require.NoError(t, svc.DeleteProfile(ctx, "alice"))
+
+	// Earlier versions are deleted too
+	assert.Empty(t, profiles.Keys())
+
+	_, err = svc.GetProfileURL(ctx, "alice", "alice", 0)
+	assert.ErrorIs(t, err, errors.ErrProfileNotFound)
+}
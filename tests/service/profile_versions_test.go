@@ -0,0 +1,232 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+	"github.com/zzenonn/go-zenon-api-aws/internal/imaging"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/memory"
+	"github.com/zzenonn/go-zenon-api-aws/internal/service"
+)
+
+// versionedObjects behaves like an S3 bucket with versioning enabled
+type versionedObjects struct {
+	memory.ObjectRepository
+
+	mu       sync.Mutex
+	versions []domain.ObjectVersion
+	data     map[string][]byte
+	clock    time.Time
+}
+
+func newVersionedObjects() *versionedObjects {
+	return &versionedObjects{
+		ObjectRepository: memory.NewObjectRepository(),
+		data:             make(map[string][]byte),
+		clock:            time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func (v *versionedObjects) Upload(ctx context.Context, key string, r io.Reader, contentType string, checksum string) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if err := v.ObjectRepository.Upload(ctx, key, bytes.NewReader(data), contentType, checksum); err != nil {
+		return err
+	}
+
+	id := v.add(domain.ObjectVersion{Key: key, Size: int64(len(data))})
+	v.mu.Lock()
+	v.data[id] = data
+	v.mu.Unlock()
+	return nil
+}
+
+func (v *versionedObjects) Delete(ctx context.Context, key string) error {
+	if err := v.ObjectRepository.Delete(ctx, key); err != nil {
+		return err
+	}
+	v.add(domain.ObjectVersion{Key: key, DeleteMarker: true})
+	return nil
+}
+
+// add records a new latest version of a key and returns its ID
+func (v *versionedObjects) add(version domain.ObjectVersion) string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for i := range v.versions {
+		if v.versions[i].Key == version.Key {
+			v.versions[i].IsLatest = false
+		}
+	}
+
+	v.clock = v.clock.Add(time.Second)
+	version.VersionID = fmt.Sprintf("v%d", len(v.data)+len(v.versions))
+	version.LastModified = v.clock
+	version.IsLatest = true
+	v.versions = append(v.versions, version)
+	return version.VersionID
+}
+
+func (v *versionedObjects) VersioningEnabled(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+func (v *versionedObjects) ListVersions(ctx context.Context, prefix string) ([]domain.ObjectVersion, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var versions []domain.ObjectVersion
+	for _, version := range v.versions {
+		if strings.HasPrefix(version.Key, prefix) {
+			versions = append(versions, version)
+		}
+	}
+	return versions, nil
+}
+
+func (v *versionedObjects) DownloadVersion(ctx context.Context, key string, versionID string) (io.ReadCloser, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	data, exists := v.data[versionID]
+	if !exists {
+		return nil, errors.ErrObjectNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (v *versionedObjects) DeleteVersion(ctx context.Context, key string, versionID string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for i, version := range v.versions {
+		if version.Key == key && version.VersionID == versionID {
+			v.versions = append(v.versions[:i], v.versions[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func newVersionedProfileService(t *testing.T, objects service.UserProfileRepository, opts ...service.Option) *service.UserService {
+	users := memory.NewUserRepository()
+
+	username := "alice"
+	_, err := users.CreateUser(context.Background(), domain.User{Username: &username, HashedPassword: []byte("hash")})
+	require.NoError(t, err)
+
+	imageOptions := imaging.DefaultOptions()
+	imageOptions.Sizes = []int{64}
+	opts = append([]service.Option{service.WithImageOptions(imageOptions)}, opts...)
+	return service.NewUserService(&users, objects, opts...)
+}
+
+func TestProfileVersionsByKey(t *testing.T) {
+	objects := memory.NewObjectRepository()
+	svc := newVersionedProfileService(t, &objects, service.WithProfileVersions(2))
+	ctx := context.Background()
+
+	first, err := svc.UploadProfile(ctx, "alice", bytes.NewReader(encodeImage(t, imaging.ContentTypeJPEG)))
+	require.NoError(t, err)
+	second, err := svc.UploadProfile(ctx, "alice", bytes.NewReader(encodeImage(t, imaging.ContentTypePNG)))
+	require.NoError(t, err)
+
+	versions, err := svc.ListProfileVersions(ctx, "alice")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.True(t, versions[0].Current)
+	assert.Equal(t, *second.ProfilePath, versions[0].Key)
+	assert.False(t, versions[1].Current)
+
+	// Restoring points the user back at the earlier version and its thumbnails
+	restored, err := svc.RestoreProfileVersion(ctx, "alice", versions[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, *first.ProfilePath, *restored.ProfilePath)
+	assert.Equal(t, *first.ProfileChecksum, *restored.ProfileChecksum)
+
+	url, err := svc.GetProfileURL(ctx, "alice", "alice", 64)
+	require.NoError(t, err)
+	assert.Contains(t, url, strings.TrimSuffix(*first.ProfilePath, ".jpg")+"-64.jpg")
+
+	// Only the newest versions are kept once the retention count is exceeded
+	third, err := svc.UploadProfile(ctx, "alice", bytes.NewReader(encodeImage(t, imaging.ContentTypePNG)))
+	require.NoError(t, err)
+
+	versions, err = svc.ListProfileVersions(ctx, "alice")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, *third.ProfilePath, versions[0].Key)
+	assert.Equal(t, *second.ProfilePath, versions[1].Key)
+
+	for _, key := range []string{*first.ProfilePath, strings.TrimSuffix(*first.ProfilePath, ".jpg") + "-64.jpg"} {
+		_, exists := objects.Get(key)
+		assert.False(t, exists, key)
+	}
+
+	_, err = svc.RestoreProfileVersion(ctx, "alice", "missing")
+	assert.ErrorIs(t, err, errors.ErrVersionNotFound)
+}
+
+func TestProfileVersionsWithObjectVersioning(t *testing.T) {
+	objects := newVersionedObjects()
+	svc := newVersionedProfileService(t, objects, service.WithObjectVersioning(true), service.WithProfileVersions(2))
+	ctx := context.Background()
+
+	first, err := svc.UploadProfile(ctx, "alice", bytes.NewReader(encodeImage(t, imaging.ContentTypePNG)))
+	require.NoError(t, err)
+	firstData, _ := objects.Get("alice/profile/profile.png")
+
+	// Keys stay the same; the bucket keeps the earlier version
+	second, err := svc.UploadProfile(ctx, "alice", bytes.NewReader(encodeImage(t, imaging.ContentTypeJPEG)))
+	require.NoError(t, err)
+	assert.Equal(t, "alice/profile/profile.png", *first.ProfilePath)
+	assert.Equal(t, "alice/profile/profile.jpg", *second.ProfilePath)
+
+	versions, err := svc.ListProfileVersions(ctx, "alice")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.True(t, versions[0].Current)
+	assert.Equal(t, "alice/profile/profile.png", versions[1].Key)
+
+	// Restoring copies the old original back byte for byte
+	restored, err := svc.RestoreProfileVersion(ctx, "alice", versions[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "alice/profile/profile.png", *restored.ProfilePath)
+	current, exists := objects.Get("alice/profile/profile.png")
+	require.True(t, exists)
+	assert.Equal(t, firstData, current)
+	_, exists = objects.Get("alice/profile/profile-64.png")
+	assert.True(t, exists)
+
+	// The restore is a new version, so the oldest one is pruned
+	versions, err = svc.ListProfileVersions(ctx, "alice")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.True(t, versions[0].Current)
+	assert.Equal(t, "alice/profile/profile.jpg", versions[1].Key)
+
+	// Earlier thumbnail versions are never restored and are not kept
+	all, err := objects.ListVersions(ctx, "alice/profile/profile-64")
+	require.NoError(t, err)
+	for _, version := range all {
+		assert.True(t, version.IsLatest, version.Key)
+	}
+
+	require.NoError(t, svc.DeleteProfile(ctx, "alice"))
+	all, err = objects.ListVersions(ctx, "alice/profile/")
+	require.NoError(t, err)
+	assert.Empty(t, all)
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	stderrors "errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/errors"
+	"github.com/zzenonn/go-zenon-api-aws/internal/imaging"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/memory"
+	"github.com/zzenonn/go-zenon-api-aws/internal/service"
+)
+
+// stubScanner reports content containing "MALWARE" as infected and records
+// which objects were stored while it scanned
+type stubScanner struct {
+	objects *memory.ObjectRepository
+	err     error
+	seen    []string
+}
+
+func (s *stubScanner) Scan(ctx context.Context, r io.Reader) (domain.ScanResult, error) {
+	if s.err != nil {
+		return domain.ScanResult{}, s.err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return domain.ScanResult{}, err
+	}
+
+	s.seen = s.objects.Keys()
+	if bytes.Contains(data, []byte("MALWARE")) {
+		return domain.ScanResult{Infected: true, Signature: "Test-Signature"}, nil
+	}
+	return domain.ScanResult{}, nil
+}
+
+// recordingObjects is a memory object store that records which keys were
+// uploaded and downloaded
+type recordingObjects struct {
+	*memory.ObjectRepository
+	uploaded   []string
+	downloaded []string
+}
+
+// malwareEvents returns the rejected infected uploads recorded in repo
+func malwareEvents(t *testing.T, repo *memory.AuditRepository) []domain.AuditEvent {
+	events, _, err := repo.ListEvents(context.Background(), domain.AuditFilter{Action: domain.AuditMalware}, 0, "")
+	require.NoError(t, err)
+	return events
+}
+
+func (r *recordingObjects) Upload(ctx context.Context, key string, reader io.Reader, contentType string, checksum string) error {
+	r.uploaded = append(r.uploaded, key)
+	return r.ObjectRepository.Upload(ctx, key, reader, contentType, checksum)
+}
+
+func (r *recordingObjects) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	r.downloaded = append(r.downloaded, key)
+	return r.ObjectRepository.Download(ctx, key)
+}
+
+func TestUploadFileScansInQuarantine(t *testing.T) {
+	objects := memory.NewObjectRepository()
+	scanner := &stubScanner{objects: &objects}
+	audits := memory.NewAuditRepository()
+	svc, files := newFileService(t, &objects, service.WithFileScanner(scanner), service.WithFileAuditor(service.NewAuditService(&audits, nil)))
+	ctx := context.Background()
+
+	file, err := svc.UploadFile(ctx, "bob", "clean.txt", "text/plain", strings.NewReader("clean data"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"quarantine/" + file.Key}, scanner.seen)
+	assert.Equal(t, []string{file.Key}, objects.Keys())
+
+	_, err = svc.UploadFile(ctx, "bob", "bad.txt", "text/plain", strings.NewReader("MALWARE"))
+	assert.ErrorIs(t, err, errors.ErrMalwareDetected)
+
+	// The infected file was neither stored nor recorded
+	assert.Equal(t, []string{file.Key}, objects.Keys())
+	used, err := files.GetUsage(ctx, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("clean data")), used)
+
+	// Only the rejection is audited
+	events := malwareEvents(t, &audits)
+	require.Len(t, events, 1)
+	assert.Equal(t, "bob", events[0].Actor)
+	assert.True(t, strings.HasPrefix(events[0].Target, "bob/files/"))
+	assert.Equal(t, domain.AuditDenied, events[0].Outcome)
+	assert.Equal(t, "Test-Signature", events[0].Detail)
+}
+
+func TestUploadFileScansStoredCopy(t *testing.T) {
+	objects := memory.NewObjectRepository()
+	recorded := &recordingObjects{ObjectRepository: &objects}
+	scanner := &stubScanner{objects: &objects}
+	svc, _ := newFileService(t, recorded, service.WithFileScanner(scanner))
+
+	file, err := svc.UploadFile(context.Background(), "bob", "clean.txt", "text/plain", strings.NewReader("clean data"))
+	require.NoError(t, err)
+
+	// The scanner reads the stored copy, which is then copied rather than
+	// uploaded again
+	quarantined := "quarantine/" + file.Key
+	assert.Equal(t, []string{quarantined}, recorded.downloaded)
+	assert.Equal(t, []string{quarantined}, recorded.uploaded)
+	stored, _ := objects.Get(file.Key)
+	assert.Equal(t, "clean data", string(stored))
+	assert.Equal(t, "text/plain", objects.ContentType(file.Key))
+}
+
+func TestUploadFileFailsWhenScannerIsUnavailable(t *testing.T) {
+	objects := memory.NewObjectRepository()
+	scanner := &stubScanner{objects: &objects, err: stderrors.New("connection refused")}
+	svc, _ := newFileService(t, &objects, service.WithFileScanner(scanner))
+
+	_, err := svc.UploadFile(context.Background(), "bob", "a.txt", "text/plain", strings.NewReader("data"))
+	assert.ErrorIs(t, err, errors.ErrScanFailed)
+	assert.Empty(t, objects.Keys())
+}
+
+func TestMultipartUploadRejectsMalware(t *testing.T) {
+	objects := memory.NewObjectRepository()
+	scanner := &stubScanner{objects: &objects}
+	audits := memory.NewAuditRepository()
+	svc, files := newFileService(t, &objects, service.WithFileScanner(scanner), service.WithFileAuditor(service.NewAuditService(&audits, nil)))
+	ctx := context.Background()
+	data := []byte("MALWARE")
+
+	upload, err := svc.CreateUpload(ctx, "bob", "big.bin", "", int64(len(data)), "")
+	require.NoError(t, err)
+	_, err = svc.UploadPart(ctx, "bob", upload.ID, 1, bytes.NewReader(data))
+	require.NoError(t, err)
+
+	_, err = svc.CompleteUpload(ctx, "bob", upload.ID, "")
+	assert.ErrorIs(t, err, errors.ErrMalwareDetected)
+
+	// The upload is discarded together with its assembled object
+	_, err = svc.GetUpload(ctx, "bob", upload.ID)
+	assert.ErrorIs(t, err, errors.ErrUploadNotFound)
+	assert.Empty(t, objects.Keys())
+	used, err := files.GetUsage(ctx, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), used)
+
+	events := malwareEvents(t, &audits)
+	require.Len(t, events, 1)
+	assert.Equal(t, "bob", events[0].Actor)
+	assert.Equal(t, domain.AuditDenied, events[0].Outcome)
+}
+
+func TestMultipartUploadIsAssembledInQuarantine(t *testing.T) {
+	objects := memory.NewObjectRepository()
+	scanner := &stubScanner{objects: &objects}
+	svc, _ := newFileService(t, &objects, service.WithFileScanner(scanner))
+	data := []byte("clean data")
+	upload := startUpload(t, svc, data)
+
+	file, err := svc.CompleteUpload(context.Background(), "bob", upload.ID, "")
+	require.NoError(t, err)
+
+	// Only the quarantined object existed while it was scanned
+	assert.Equal(t, []string{"quarantine/" + upload.Key}, scanner.seen)
+	assert.Equal(t, []string{file.Key}, objects.Keys())
+	stored, _ := objects.Get(file.Key)
+	assert.Equal(t, data, stored)
+}
+
+func TestMultipartUploadCanBeCompletedAgainAfterScanFails(t *testing.T) {
+	objects := memory.NewObjectRepository()
+	scanner := &stubScanner{objects: &objects, err: stderrors.New("connection refused")}
+	svc, files := newFileService(t, &objects, service.WithFileScanner(scanner))
+	ctx := context.Background()
+	data := []byte("clean data")
+	upload := startUpload(t, svc, data)
+
+	_, err := svc.CompleteUpload(ctx, "bob", upload.ID, "")
+	assert.ErrorIs(t, err, errors.ErrScanFailed)
+
+	// The assembled upload stays in quarantine with its reservation
+	pending, err := svc.GetUpload(ctx, "bob", upload.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, pending.AssembledAt)
+	assert.Equal(t, []string{"quarantine/" + upload.Key}, objects.Keys())
+	used, err := files.GetUsage(ctx, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), used)
+
+	scanner.err = nil
+	file, err := svc.CompleteUpload(ctx, "bob", upload.ID, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{file.Key}, objects.Keys())
+}
+
+func TestUploadProfileRejectsMalware(t *testing.T) {
+	users := memory.NewUserRepository()
+	objects := memory.NewObjectRepository()
+	scanner := &stubScanner{objects: &objects}
+
+	username := "alice"
+	_, err := users.CreateUser(context.Background(), domain.User{Username: &username, HashedPassword: []byte("hash")})
+	require.NoError(t, err)
+	audits := memory.NewAuditRepository()
+	svc := service.NewUserService(&users, &objects, service.WithScanner(scanner), service.WithAuditor(service.NewAuditService(&audits, &users)))
+
+	infected := append(encodeImage(t, imaging.ContentTypePNG), []byte("MALWARE")...)
+	_, err = svc.UploadProfile(context.Background(), "alice", bytes.NewReader(infected))
+	assert.ErrorIs(t, err, errors.ErrMalwareDetected)
+	require.Len(t, scanner.seen, 1)
+	assert.True(t, strings.HasPrefix(scanner.seen[0], "quarantine/alice/profile/"))
+	assert.Empty(t, objects.Keys())
+
+	user, err := svc.GetUser(context.Background(), "alice")
+	require.NoError(t, err)
+	assert.Nil(t, user.ProfilePath)
+
+	events := malwareEvents(t, &audits)
+	require.Len(t, events, 1)
+	assert.Equal(t, "alice", events[0].Actor)
+	assert.Equal(t, domain.AuditDenied, events[0].Outcome)
+}
@@ -0,0 +1,203 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/middleware"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/memory"
+	"github.com/zzenonn/go-zenon-api-aws/internal/service"
+	"github.com/zzenonn/go-zenon-api-aws/internal/tracing"
+	handlers "github.com/zzenonn/go-zenon-api-aws/internal/transport/http"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider that keeps finished spans in memory
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	_, err := tracing.Init(context.Background(), tracing.ExporterNone, "", nil)
+	require.NoError(t, err)
+
+	previous := otel.GetTracerProvider()
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func spanNamed(t *testing.T, spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	for _, span := range spans {
+		if span.Name() == name {
+			return span
+		}
+	}
+	require.Failf(t, "span not found", "no span named %q", name)
+	return nil
+}
+
+func attributeValue(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// TestRequestSpans logs in with a traceparent header and checks that the
+// server span continues the caller's trace and that the service and bcrypt
+// spans are its descendants
+func TestRequestSpans(t *testing.T) {
+	recorder := recordSpans(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	cfg := &config.Config{ECDSAPrivateKey: key, ECDSAPublicKey: &key.PublicKey}
+
+	users := memory.NewUserRepository()
+	profiles := memory.NewObjectRepository()
+	svc := service.NewUserService(&users, &profiles)
+	username := "alice"
+	_, err = svc.CreateUser(context.Background(), domain.User{Username: &username, Password: "password123"})
+	require.NoError(t, err)
+
+	mainHandler := handlers.NewMainHandler(cfg)
+	mainHandler.AddHandler(handlers.NewUserHandler(svc, cfg))
+	mainHandler.MapRoutes()
+	server := httptest.NewServer(mainHandler.Router)
+	defer server.Close()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/api/v1/users/login",
+		strings.NewReader(`{"username":"alice","password":"password123"}`))
+	require.NoError(t, err)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	spans := recorder.Ended()
+	serverSpan := spanNamed(t, spans, "POST /api/v1/users/login")
+	assert.Equal(t, trace.SpanKindServer, serverSpan.SpanKind())
+	assert.Equal(t, traceID, serverSpan.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", serverSpan.Parent().SpanID().String())
+	assert.Equal(t, "/api/v1/users/login", attributeValue(serverSpan, "http.route").AsString())
+	assert.Equal(t, int64(http.StatusOK), attributeValue(serverSpan, "http.response.status_code").AsInt64())
+
+	login := spanNamed(t, spans, "UserService.Login")
+	assert.Equal(t, serverSpan.SpanContext().SpanID(), login.Parent().SpanID())
+	compare := spanNamed(t, spans, "bcrypt.CompareHashAndPassword")
+	assert.Equal(t, login.SpanContext().SpanID(), compare.Parent().SpanID())
+	assert.Equal(t, traceID, compare.SpanContext().TraceID().String())
+}
+
+// failingTransport answers every request with a non-retryable error
+type failingTransport struct{}
+
+func (failingTransport) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusBadRequest,
+		Header: http.Header{
+			"Content-Type":     {"application/x-amz-json-1.0"},
+			"X-Amzn-Requestid": {"REQUEST123"},
+		},
+		Body:    io.NopCloser(strings.NewReader(`{"__type":"com.amazonaws.dynamodb.v20120810#ResourceNotFoundException","message":"Table not found"}`)),
+		Request: req,
+	}, nil
+}
+
+func TestAWSSpans(t *testing.T) {
+	recorder := recordSpans(t)
+
+	client := dynamodb.New(dynamodb.Options{
+		Region:       "eu-west-1",
+		BaseEndpoint: aws.String("http://localhost:8000"),
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+		HTTPClient: failingTransport{},
+		APIOptions: []func(*middleware.Stack) error{tracing.AddAWSMiddleware},
+	})
+
+	ctx, parent := tracing.Start(context.Background(), "UserService.GetUser")
+	_, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("users"),
+		Key:       map[string]types.AttributeValue{"username": &types.AttributeValueMemberS{Value: "alice"}},
+	})
+	parent.End()
+	require.Error(t, err)
+
+	span := spanNamed(t, recorder.Ended(), "DynamoDB.GetItem")
+	assert.Equal(t, trace.SpanKindClient, span.SpanKind())
+	assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+	assert.Equal(t, "DynamoDB", attributeValue(span, "rpc.service").AsString())
+	assert.Equal(t, "GetItem", attributeValue(span, "rpc.method").AsString())
+	assert.Equal(t, "eu-west-1", attributeValue(span, "cloud.region").AsString())
+	assert.Equal(t, "REQUEST123", attributeValue(span, "aws.request_id").AsString())
+	assert.Equal(t, codes.Error, span.Status().Code)
+}
+
+func TestLogHookAddsTraceIDs(t *testing.T) {
+	recordSpans(t)
+
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&buf)
+	logger.SetFormatter(&log.JSONFormatter{})
+	logger.AddHook(tracing.LogHook{})
+
+	ctx, span := tracing.Start(context.Background(), "request")
+	logger.WithContext(ctx).Info("with trace")
+	span.End()
+	logger.Info("without trace")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var withTrace, withoutTrace map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &withTrace))
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &withoutTrace))
+	assert.Equal(t, span.SpanContext().TraceID().String(), withTrace["trace_id"])
+	assert.Equal(t, span.SpanContext().SpanID().String(), withTrace["span_id"])
+	assert.NotContains(t, withoutTrace, "trace_id")
+}
+
+func TestStdoutExporter(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	var buf bytes.Buffer
+	shutdown, err := tracing.Init(context.Background(), tracing.ExporterStdout, "", &buf)
+	require.NoError(t, err)
+
+	_, span := tracing.Start(context.Background(), "UserService.Login")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	assert.Contains(t, buf.String(), `"Name":"UserService.Login"`)
+
+	_, err = tracing.Init(context.Background(), "zipkin", "", nil)
+	assert.Error(t, err)
+}
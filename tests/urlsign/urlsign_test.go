@@ -0,0 +1,313 @@
+package urlsign
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zzenonn/go-zenon-api-aws/internal/config"
+	"github.com/zzenonn/go-zenon-api-aws/internal/domain"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/memory"
+	"github.com/zzenonn/go-zenon-api-aws/internal/repository/objectstore"
+	"github.com/zzenonn/go-zenon-api-aws/internal/service"
+	handlers "github.com/zzenonn/go-zenon-api-aws/internal/transport/http"
+	"github.com/zzenonn/go-zenon-api-aws/internal/urlsign"
+)
+
+// cloudFrontDecode reverses the base64 variant CloudFront uses in URLs and cookies
+func cloudFrontDecode(t *testing.T, value string) []byte {
+	data, err := base64.StdEncoding.DecodeString(strings.NewReplacer("-", "+", "_", "=", "~", "/").Replace(value))
+	require.NoError(t, err)
+	return data
+}
+
+// verifyCloudFront checks a signature the way CloudFront does
+func verifyCloudFront(t *testing.T, key *rsa.PrivateKey, policy string, signature string) {
+	hash := sha1.Sum([]byte(policy))
+	assert.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA1, hash[:], cloudFrontDecode(t, signature)))
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+func TestCloudFrontSignedURL(t *testing.T) {
+	key := newRSAKey(t)
+	signer := urlsign.NewCloudFrontSigner("https://cdn.example.com/", "K2JCJMDEHXQW5F", key, 10*time.Minute, false)
+
+	signed, err := signer.Sign(context.Background(), "alice/files/1/my report.pdf")
+	require.NoError(t, err)
+
+	parsed, err := url.Parse(signed)
+	require.NoError(t, err)
+	assert.Equal(t, "cdn.example.com", parsed.Host)
+	assert.Equal(t, "/alice/files/1/my%20report.pdf", parsed.EscapedPath())
+
+	query := parsed.Query()
+	assert.Equal(t, "K2JCJMDEHXQW5F", query.Get("Key-Pair-Id"))
+
+	expires, err := strconv.ParseInt(query.Get("Expires"), 10, 64)
+	require.NoError(t, err)
+	assert.InDelta(t, time.Now().Add(10*time.Minute).Unix(), expires, 5)
+
+	// The canned policy is rebuilt from the URL without its query string
+	resource := "https://cdn.example.com/alice/files/1/my%20report.pdf"
+	policy := `{"Statement":[{"Resource":"` + resource + `","Condition":{"DateLessThan":{"AWS:EpochTime":` + query.Get("Expires") + `}}}]}`
+	verifyCloudFront(t, key, policy, query.Get("Signature"))
+}
+
+func TestCloudFrontSignedCookies(t *testing.T) {
+	key := newRSAKey(t)
+	signer := urlsign.NewCloudFrontSigner("https://cdn.example.com", "K2JCJMDEHXQW5F", key, time.Hour, true)
+
+	// With signed cookies, URLs carry no signature and stay cacheable
+	plain, err := signer.Sign(context.Background(), "alice/profile/profile.png")
+	require.NoError(t, err)
+	assert.Equal(t, "https://cdn.example.com/alice/profile/profile.png", plain)
+
+	cookies, err := signer.SignCookies("alice/")
+	require.NoError(t, err)
+
+	values := map[string]string{}
+	for _, cookie := range cookies {
+		values[cookie.Name] = cookie.Value
+		assert.Equal(t, "/alice/", cookie.Path)
+		assert.True(t, cookie.Secure)
+		assert.True(t, cookie.HttpOnly)
+	}
+	assert.Equal(t, "K2JCJMDEHXQW5F", values[urlsign.CloudFrontKeyPairIDCookie])
+
+	policy := string(cloudFrontDecode(t, values[urlsign.CloudFrontPolicyCookie]))
+	assert.Contains(t, policy, `"Resource":"https://cdn.example.com/alice/*"`)
+	verifyCloudFront(t, key, policy, values[urlsign.CloudFrontSignatureCookie])
+}
+
+func TestCloudFrontSignURLIgnoresCookies(t *testing.T) {
+	key := newRSAKey(t)
+	signer := urlsign.NewCloudFrontSigner("https://cdn.example.com", "K2JCJMDEHXQW5F", key, time.Hour, true)
+
+	signed, err := signer.SignURL(context.Background(), "alice/profile/profile.png")
+	require.NoError(t, err)
+
+	parsed, err := url.Parse(signed)
+	require.NoError(t, err)
+	query := parsed.Query()
+	assert.Equal(t, "K2JCJMDEHXQW5F", query.Get("Key-Pair-Id"))
+	policy := `{"Statement":[{"Resource":"https://cdn.example.com/alice/profile/profile.png","Condition":{"DateLessThan":{"AWS:EpochTime":` + query.Get("Expires") + `}}}]}`
+	verifyCloudFront(t, key, policy, query.Get("Signature"))
+}
+
+// TestPublicAvatarWithSignedCookies checks that profile images shown to
+// anyone but their owner get signed URLs, since the owner's cookies do not
+// reach other clients
+func TestPublicAvatarWithSignedCookies(t *testing.T) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	cfg := &config.Config{
+		ECDSAPrivateKey: ecdsaKey,
+		ECDSAPublicKey:  &ecdsaKey.PublicKey,
+		PresignTTL:      time.Hour,
+	}
+
+	users := memory.NewUserRepository()
+	for _, username := range []string{"alice", "bob"} {
+		visibility := domain.VisibilityPublic
+		profilePath := username + "/profile/profile.png"
+		_, err := users.CreateUser(context.Background(), domain.User{Username: &username, ProfilePath: &profilePath, ProfileVisibility: &visibility})
+		require.NoError(t, err)
+	}
+
+	// Signing never reaches S3, so the store needs no client
+	rsaKey := newRSAKey(t)
+	signer := urlsign.NewCloudFrontSigner("https://cdn.example.com", "K2JCJMDEHXQW5F", rsaKey, time.Hour, true)
+	objects := objectstore.NewObjectRepository(nil, "profiles", signer)
+
+	mainHandler := handlers.NewMainHandler(cfg)
+	mainHandler.AddHandler(handlers.NewUserHandler(service.NewUserService(&users, &objects), cfg))
+	mainHandler.MapRoutes()
+	server := httptest.NewServer(mainHandler.Router)
+	defer server.Close()
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	resp, err := client.Get(server.URL + "/avatars/alice")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "/alice/profile/profile.png", location.Path)
+	query := location.Query()
+	policy := `{"Statement":[{"Resource":"https://cdn.example.com/alice/profile/profile.png","Condition":{"DateLessThan":{"AWS:EpochTime":` + query.Get("Expires") + `}}}]}`
+	verifyCloudFront(t, rsaKey, policy, query.Get("Signature"))
+
+	token, err := handlers.MintJwtToken("alice", time.Minute, ecdsaKey)
+	require.NoError(t, err)
+	profileURL := func(username string) string {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/api/v1/users/"+username+"/profile", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var body handlers.ProfileURLResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return body.ProfileURL
+	}
+
+	// The owner's own cookies cover their profile, anyone else's do not
+	assert.Equal(t, "https://cdn.example.com/alice/profile/profile.png", profileURL("alice"))
+	assert.Contains(t, profileURL("bob"), "Signature=")
+}
+
+// TestContentAddressedFileWithSignedCookies checks that files stored outside
+// the owner's prefix get signed URLs, since the owner's cookies do not cover them
+func TestContentAddressedFileWithSignedCookies(t *testing.T) {
+	ctx := context.Background()
+	users := memory.NewUserRepository()
+	files := memory.NewFileRepository()
+
+	shared := domain.File{Username: "alice", ID: "shared", Name: "a.txt", Key: "blobs/sha256/ab/abcdef", ContentAddressed: true}
+	own := domain.File{Username: "alice", ID: "own", Name: "b.txt", Key: "alice/files/own.txt"}
+	for _, file := range []domain.File{shared, own} {
+		require.NoError(t, files.CreateFile(ctx, file, 0))
+	}
+
+	rsaKey := newRSAKey(t)
+	signer := urlsign.NewCloudFrontSigner("https://cdn.example.com", "K2JCJMDEHXQW5F", rsaKey, time.Hour, true)
+	objects := objectstore.NewObjectRepository(nil, "files", signer)
+	svc := service.NewFileService(&files, &users, &objects)
+
+	_, fileURL, err := svc.GetFile(ctx, "alice", "shared")
+	require.NoError(t, err)
+	location, err := url.Parse(fileURL)
+	require.NoError(t, err)
+	assert.Equal(t, "/blobs/sha256/ab/abcdef", location.Path)
+	query := location.Query()
+	policy := `{"Statement":[{"Resource":"https://cdn.example.com/blobs/sha256/ab/abcdef","Condition":{"DateLessThan":{"AWS:EpochTime":` + query.Get("Expires") + `}}}]}`
+	verifyCloudFront(t, rsaKey, policy, query.Get("Signature"))
+
+	// The owner's cookies cover their own prefix
+	_, fileURL, err = svc.GetFile(ctx, "alice", "own")
+	require.NoError(t, err)
+	assert.Equal(t, "https://cdn.example.com/alice/files/own.txt", fileURL)
+}
+
+func TestS3SignerUsesTTL(t *testing.T) {
+	client := s3.New(s3.Options{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+	})
+	signer := urlsign.NewS3Signer(client, "profiles", 90*time.Second)
+
+	signed, err := signer.Sign(context.Background(), "alice/profile/profile.png")
+	require.NoError(t, err)
+
+	parsed, err := url.Parse(signed)
+	require.NoError(t, err)
+	assert.Contains(t, parsed.Host+parsed.Path, "profiles")
+	assert.Equal(t, "90", parsed.Query().Get("X-Amz-Expires"))
+}
+
+// TestStorageHandlerStreamsObjects serves objects from a store that can only
+// stream them, as the API does for S3 with the hmac URL signer
+func TestStorageHandlerStreamsObjects(t *testing.T) {
+	objects := memory.NewObjectRepository()
+	ctx := context.Background()
+	require.NoError(t, objects.Upload(ctx, "alice/files/1/notes.txt", strings.NewReader("hello"), "text/plain", ""))
+
+	signer := urlsign.NewHMACSigner([]byte("secret"), "", time.Minute)
+	mainHandler := handlers.NewMainHandler(&config.Config{})
+	mainHandler.AddHandler(handlers.NewStorageHandler(&objects, signer))
+	mainHandler.MapRoutes()
+	server := httptest.NewServer(mainHandler.Router)
+	defer server.Close()
+
+	signed, err := signer.Sign(ctx, "alice/files/1/notes.txt")
+	require.NoError(t, err)
+
+	resp, err := http.Get(server.URL + signed)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello", string(body))
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain")
+
+	missing, err := signer.Sign(ctx, "alice/files/2/missing.txt")
+	require.NoError(t, err)
+	resp, err = http.Get(server.URL + missing)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestSignedCookieHandler(t *testing.T) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	cfg := &config.Config{
+		ECDSAPrivateKey:        ecdsaKey,
+		ECDSAPublicKey:         &ecdsaKey.PublicKey,
+		CloudFrontCookieDomain: "example.com",
+	}
+
+	signer := urlsign.NewCloudFrontSigner("https://cdn.example.com", "K2JCJMDEHXQW5F", newRSAKey(t), time.Hour, true)
+	mainHandler := handlers.NewMainHandler(cfg)
+	mainHandler.AddHandler(handlers.NewSignedCookieHandler(signer, cfg))
+	mainHandler.MapRoutes()
+	server := httptest.NewServer(mainHandler.Router)
+	defer server.Close()
+
+	token, err := handlers.MintJwtToken("alice", time.Minute, ecdsaKey)
+	require.NoError(t, err)
+
+	post := func(username string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/api/v1/users/"+username+"/storage-cookies", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := post("alice")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, resp.Cookies(), 3)
+	for _, cookie := range resp.Cookies() {
+		assert.Equal(t, "example.com", cookie.Domain)
+		assert.Equal(t, "/alice/", cookie.Path)
+	}
+
+	// Cookies are only issued for the caller's own objects
+	resp = post("bob")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Empty(t, resp.Cookies())
+}